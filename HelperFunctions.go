@@ -2,7 +2,7 @@ package main
 
 import (
 	"fmt"
-	"sort"
+	"strings"
 	"time"
 )
 
@@ -21,13 +21,27 @@ func LogCommand(cmd string, args []string) error {
 	resp := buildRESPCommand(cmd, args)
 
 	// Write to buffer
-	_, err := aofWriter.WriteString(resp)
+	n, err := aofWriter.WriteString(resp)
+	aofWrittenOffset += int64(n)
 	if err != nil {
+		aofLastWriteErr = err
 		fmt.Printf("[AOF] Error writing command: %v\n", err)
 		return err
 	}
 
-	// Don't flush here - let BackgroundFsync handle it
+	// appendfsync always: durable before the reply goes out
+	if appendFsync.Load() == "always" {
+		start := time.Now()
+		err := syncAOF()
+		latencyRecord("aof-fsync-always", time.Since(start))
+		if err != nil {
+			fmt.Printf("[AOF] Error syncing: %v\n", err)
+			return err
+		}
+		return nil
+	}
+
+	// Otherwise don't flush here - let BackgroundFsync handle it
 	return nil
 }
 
@@ -44,25 +58,36 @@ func getEntry(key string, selectedDB *int) (Entry, bool) {
 	if entry.ExpireAt != 0 && entry.ExpireAt <= time.Now().Unix() {
 		
 		mu.Lock()
-		delete(databases[*selectedDB], key)
+		if removeEntry(*selectedDB, key) {
+			statExpiredKeys.Add(1)
+		}
 		mu.Unlock()
 		return Entry{}, false
 	}
 	return entry, true
 }
 
+// lookupKeyRead is getEntry for read commands: it also feeds the
+// keyspace_hits / keyspace_misses counters reported by INFO
+func lookupKeyRead(key string, selectedDB *int) (Entry, bool) {
+	entry, exists := getEntry(key, selectedDB)
+	if exists {
+		statKeyspaceHits.Add(1)
+	} else {
+		statKeyspaceMisses.Add(1)
+	}
+	return entry, exists
+}
+
 func setEntry(key string, entry Entry, selectedDB *int) {
 	mu.Lock()
-	databases[*selectedDB][key] = entry
+	storeEntry(*selectedDB, key, entry)
 	mu.Unlock()
 }
 
 func deleteEntry(key string, selectedDB *int) bool {
     mu.Lock()
-    _, exists := databases[*selectedDB][key]
-    if exists {
-        delete(databases[*selectedDB], key)
-    }
+    exists := removeEntry(*selectedDB, key)
     mu.Unlock()
     return exists
 }
@@ -82,34 +107,110 @@ func PersistEntry(key string, selectedDB *int) bool {
     return true
 }
 
-func getHash(key string, selectedDB *int) (map[string]string) {
+func getHash(key string, selectedDB *int) (Hash) {
 	entry, exists := getEntry(key, selectedDB)
 	if exists {
 		if entry.Type != TypeHash {
-			return nil
+			return Hash{}
 		}
-		return entry.Value.(map[string]string)
+		return entry.Value.(Hash)
 	}
 
-	h := make(map[string]string)
+	h := newHash()
 	setEntry(key, Entry{Type: TypeHash, Value: h}, selectedDB)
 	return h
 }
 
-func zsetUpdate(z *ZSet, member string, score float64 ) {
-    // Remove old entry
-    for i, item := range z.List {
-        if item.Member == member {
-            z.List = append(z.List[:i], z.List[i+1:]...)
-            break
-        }
-    }
+// stringMatch reports whether s matches the glob-style pattern, supporting
+// '*', '?', '[...]' classes (with '^' negation and ranges) and '\' escapes
+func stringMatch(pattern, s string, nocase bool) bool {
+	if nocase {
+		pattern = strings.ToLower(pattern)
+		s = strings.ToLower(s)
+	}
 
-    // Insert new item
-    z.List = append(z.List, ZItem{Member: member, Score: score})
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if stringMatch(pattern[1:], s[i:], false) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == s[0] {
+						match = true
+					}
+					pattern = pattern[1:]
+				} else if len(pattern) >= 3 && pattern[1] == '-' && pattern[2] != ']' {
+					lo, hi := pattern[0], pattern[2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					if s[0] >= lo && s[0] <= hi {
+						match = true
+					}
+					pattern = pattern[3:]
+				} else {
+					if pattern[0] == s[0] {
+						match = true
+					}
+					pattern = pattern[1:]
+				}
+			}
+			if len(pattern) > 0 {
+				pattern = pattern[1:] // skip ']'
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			s = s[1:]
+
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
 
-    // Sort by score
-    sort.Slice(z.List, func(i, j int) bool {
-        return z.List[i].Score < z.List[j].Score
-    })
+	return len(s) == 0
 }
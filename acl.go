@@ -0,0 +1,799 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	aclFile      = ""                // users are loaded from / saved to this file when set
+	aclLogMaxLen = newAtomicInt(128) // entries kept by ACL LOG
+)
+
+// aclUser is a named set of credentials and permissions. Fields are
+// guarded by aclMu; connected clients keep a pointer to their user so
+// ACL SETUSER applies to them immediately.
+type aclUser struct {
+	name      string
+	enabled   bool
+	nopass    bool
+	passwords map[string]bool // sha256 hex digests
+
+	// commands maps a lowercase command ("get") or subcommand
+	// ("config|get") to whether it may run. Subcommand entries override
+	// the command entry; commands without an entry fall back to
+	// allCommands (+@all).
+	commands    map[string]bool
+	allCommands bool
+	cmdRules    []string // normalized command rules, for GETUSER / LIST
+
+	keyPatterns     []string
+	channelPatterns []string
+
+	deleted bool // removed by DELUSER / LOAD; its clients get disconnected
+}
+
+var (
+	aclMu    sync.RWMutex
+	aclUsers map[string]*aclUser
+)
+
+func init() {
+	aclUsers = map[string]*aclUser{"default": newDefaultUser()}
+}
+
+// newDefaultUser returns "default" as it is without any configuration:
+// on nopass ~* &* +@all
+func newDefaultUser() *aclUser {
+	u := newACLUser("default")
+	for _, rule := range []string{"on", "nopass", "allkeys", "allchannels", "allcommands"} {
+		u.applyRule(rule)
+	}
+	return u
+}
+
+// newACLUser returns a user that can't do anything: off, no password,
+// no keys, no channels, -@all
+func newACLUser(name string) *aclUser {
+	return &aclUser{
+		name:      name,
+		passwords: map[string]bool{},
+		commands:  map[string]bool{},
+		cmdRules:  []string{"-@all"},
+	}
+}
+
+func (u *aclUser) clone() *aclUser {
+	c := *u
+	c.passwords = map[string]bool{}
+	for k, v := range u.passwords {
+		c.passwords[k] = v
+	}
+	c.commands = map[string]bool{}
+	for k, v := range u.commands {
+		c.commands[k] = v
+	}
+	c.cmdRules = append([]string(nil), u.cmdRules...)
+	c.keyPatterns = append([]string(nil), u.keyPatterns...)
+	c.channelPatterns = append([]string(nil), u.channelPatterns...)
+	return &c
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// aclCategories lists every category used in commandTable
+func aclCategories() []string {
+	seen := map[string]bool{}
+	for _, cmd := range commandTable {
+		for _, cat := range cmd.Categories {
+			seen[cat] = true
+		}
+	}
+
+	cats := make([]string, 0, len(seen))
+	for cat := range seen {
+		cats = append(cats, cat)
+	}
+	sort.Strings(cats)
+	return cats
+}
+
+// setCommand allows or denies a whole command, dropping any subcommand
+// exceptions recorded for it
+func (u *aclUser) setCommand(name string, allowed bool) {
+	for key := range u.commands {
+		if strings.HasPrefix(key, name+"|") {
+			delete(u.commands, key)
+		}
+	}
+	u.commands[name] = allowed
+}
+
+func (u *aclUser) setCategory(category string, allowed bool) error {
+	if category == "all" {
+		u.commands = map[string]bool{}
+		u.allCommands = allowed
+		if allowed {
+			u.cmdRules = []string{"+@all"}
+		} else {
+			u.cmdRules = []string{"-@all"}
+		}
+		return nil
+	}
+
+	found := false
+	for name, cmd := range commandTable {
+		for _, cat := range cmd.Categories {
+			if cat == category {
+				u.setCommand(strings.ToLower(name), allowed)
+				found = true
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("Unknown command category '%s'", category)
+	}
+
+	if allowed {
+		u.cmdRules = append(u.cmdRules, "+@"+category)
+	} else {
+		u.cmdRules = append(u.cmdRules, "-@"+category)
+	}
+	return nil
+}
+
+// applyRule applies a single ACL SETUSER rule to u
+func (u *aclUser) applyRule(rule string) error {
+	lower := strings.ToLower(rule)
+
+	switch {
+	case lower == "on":
+		u.enabled = true
+	case lower == "off":
+		u.enabled = false
+	case lower == "nopass":
+		u.nopass = true
+		u.passwords = map[string]bool{}
+	case lower == "resetpass":
+		u.nopass = false
+		u.passwords = map[string]bool{}
+	case strings.HasPrefix(rule, ">"):
+		u.passwords[hashPassword(rule[1:])] = true
+		u.nopass = false
+	case strings.HasPrefix(rule, "<"):
+		hash := hashPassword(rule[1:])
+		if !u.passwords[hash] {
+			return fmt.Errorf("no such password")
+		}
+		delete(u.passwords, hash)
+	case strings.HasPrefix(rule, "#"):
+		hash := strings.ToLower(rule[1:])
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 64 {
+			return fmt.Errorf("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.passwords[hash] = true
+		u.nopass = false
+	case strings.HasPrefix(rule, "!"):
+		hash := strings.ToLower(rule[1:])
+		if !u.passwords[hash] {
+			return fmt.Errorf("no such password")
+		}
+		delete(u.passwords, hash)
+
+	case lower == "allkeys":
+		u.keyPatterns = []string{"*"}
+	case lower == "resetkeys":
+		u.keyPatterns = nil
+	case strings.HasPrefix(rule, "~"):
+		u.keyPatterns = append(u.keyPatterns, rule[1:])
+
+	case lower == "allchannels":
+		u.channelPatterns = []string{"*"}
+	case lower == "resetchannels":
+		u.channelPatterns = nil
+	case strings.HasPrefix(rule, "&"):
+		u.channelPatterns = append(u.channelPatterns, rule[1:])
+
+	case lower == "allcommands":
+		return u.setCategory("all", true)
+	case lower == "nocommands":
+		return u.setCategory("all", false)
+	case strings.HasPrefix(lower, "+@"):
+		return u.setCategory(lower[2:], true)
+	case strings.HasPrefix(lower, "-@"):
+		return u.setCategory(lower[2:], false)
+	case strings.HasPrefix(lower, "+") || strings.HasPrefix(lower, "-"):
+		allowed := lower[0] == '+'
+		name, sub, hasSub := strings.Cut(lower[1:], "|")
+		if _, ok := commandTable[strings.ToUpper(name)]; !ok {
+			return fmt.Errorf("Unknown command '%s'", name)
+		}
+		if hasSub {
+			if sub == "" {
+				return fmt.Errorf("Syntax error")
+			}
+			u.commands[name+"|"+sub] = allowed
+		} else {
+			u.setCommand(name, allowed)
+		}
+		u.cmdRules = append(u.cmdRules, lower)
+
+	case lower == "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "nocommands"} {
+			u.applyRule(r)
+		}
+
+	default:
+		return fmt.Errorf("Syntax error")
+	}
+
+	return nil
+}
+
+// isDeleted reads deleted, which ACL DELUSER and ACL LOAD set under aclMu
+func (u *aclUser) isDeleted() bool {
+	aclMu.RLock()
+	defer aclMu.RUnlock()
+	return u.deleted
+}
+
+// describe renders u in ACL LIST / ACL file syntax, without the name
+func (u *aclUser) describe() string {
+	parts := []string{"off"}
+	if u.enabled {
+		parts[0] = "on"
+	}
+	if u.nopass {
+		parts = append(parts, "nopass")
+	}
+
+	hashes := make([]string, 0, len(u.passwords))
+	for hash := range u.passwords {
+		hashes = append(hashes, "#"+hash)
+	}
+	sort.Strings(hashes)
+	parts = append(parts, hashes...)
+
+	if keys := u.describeKeys(); keys != "" {
+		parts = append(parts, keys)
+	}
+	if channels := u.describeChannels(); channels != "" {
+		parts = append(parts, channels)
+	}
+	parts = append(parts, strings.Join(u.cmdRules, " "))
+	return strings.Join(parts, " ")
+}
+
+func (u *aclUser) describeKeys() string {
+	patterns := make([]string, len(u.keyPatterns))
+	for i, p := range u.keyPatterns {
+		patterns[i] = "~" + p
+	}
+	return strings.Join(patterns, " ")
+}
+
+func (u *aclUser) describeChannels() string {
+	patterns := make([]string, len(u.channelPatterns))
+	for i, p := range u.channelPatterns {
+		patterns[i] = "&" + p
+	}
+	return strings.Join(patterns, " ")
+}
+
+func (u *aclUser) canRun(name, sub string) bool {
+	if sub != "" {
+		if allowed, ok := u.commands[name+"|"+sub]; ok {
+			return allowed
+		}
+	}
+	if allowed, ok := u.commands[name]; ok {
+		return allowed
+	}
+	return u.allCommands
+}
+
+func (u *aclUser) canAccessKey(key string) bool {
+	for _, p := range u.keyPatterns {
+		if stringMatch(p, key, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessChannel checks a Pub/Sub channel against the user's & patterns.
+// There are no Pub/Sub commands yet; the rules are stored and persisted so
+// they apply as soon as there are.
+func (u *aclUser) canAccessChannel(channel string) bool {
+	for _, p := range u.channelPatterns {
+		if stringMatch(p, channel, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// aclAutoLoginUser returns the default user for new connections when it
+// needs no password, nil otherwise
+func aclAutoLoginUser() *aclUser {
+	aclMu.RLock()
+	defer aclMu.RUnlock()
+
+	u := aclUsers["default"]
+	if u != nil && u.enabled && u.nopass {
+		return u
+	}
+	return nil
+}
+
+// aclDefaultUserNoPass reports whether the default user has no password
+func aclDefaultUserNoPass() bool {
+	aclMu.RLock()
+	defer aclMu.RUnlock()
+	u := aclUsers["default"]
+	return u != nil && u.nopass
+}
+
+// aclSetDefaultPassword implements requirepass: "" means nopass
+func aclSetDefaultPassword(password string) {
+	aclMu.Lock()
+	defer aclMu.Unlock()
+
+	u := aclUsers["default"]
+	if u == nil {
+		return
+	}
+	u.applyRule("resetpass")
+	if password == "" {
+		u.applyRule("nopass")
+	} else {
+		u.applyRule(">" + password)
+	}
+}
+
+// aclAuthenticate returns the user if the credentials are valid
+func aclAuthenticate(username, password string) *aclUser {
+	aclMu.RLock()
+	defer aclMu.RUnlock()
+
+	u, ok := aclUsers[username]
+	if !ok || !u.enabled {
+		return nil
+	}
+	if u.nopass {
+		return u
+	}
+
+	hash := hashPassword(password)
+	for stored := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			return u
+		}
+	}
+	return nil
+}
+
+// aclCheckCommand verifies that c's user may run the command with these
+// arguments. It returns a -NOPERM reply, or "" when allowed.
+func aclCheckCommand(c *Client, command string, def *Command, args []string) string {
+	name := strings.ToLower(command)
+	sub := ""
+	if len(args) > 1 {
+		sub = strings.ToLower(args[1])
+	}
+
+	aclMu.RLock()
+	u := c.user
+	allowed := u.canRun(name, sub)
+
+	// Report "config|set" rather than "config" when a subcommand rule
+	// is what denied it
+	object := name
+	if _, exact := u.commands[name+"|"+sub]; sub != "" && exact {
+		object = name + "|" + sub
+	}
+
+	deniedKey := ""
+	if allowed {
+		for _, key := range keyArgs(def, args) {
+			if !u.canAccessKey(key) {
+				deniedKey = key
+				break
+			}
+		}
+	}
+	aclMu.RUnlock()
+
+	if !allowed {
+		aclLogDenial(c, "command", "toplevel", object, c.username())
+		return "-NOPERM User " + c.username() + " has no permissions to run the '" + object + "' command\r\n"
+	}
+	if deniedKey != "" {
+		aclLogDenial(c, "key", "toplevel", deniedKey, c.username())
+		return "-NOPERM No permissions to access a key\r\n"
+	}
+	return ""
+}
+
+// aclLogEntry is one line of ACL LOG. Repeated identical denials from the
+// same client are folded into a single entry with a count.
+type aclLogEntry struct {
+	id         int64
+	count      int
+	reason     string // command | key | channel | auth
+	context    string
+	object     string
+	username   string
+	clientInfo string
+	created    time.Time
+	updated    time.Time
+}
+
+var (
+	aclLogMu     sync.Mutex
+	aclLog       []*aclLogEntry // newest first
+	aclLogNextID int64
+)
+
+func aclLogDenial(c *Client, reason, context, object, username string) {
+	info := fmt.Sprintf("id=%d addr=%s name=%s user=%s db=%d", c.id, c.conn.RemoteAddr(), c.name, c.username(), c.db)
+	now := time.Now()
+
+	aclLogMu.Lock()
+	defer aclLogMu.Unlock()
+
+	for _, e := range aclLog {
+		if e.reason == reason && e.context == context && e.object == object &&
+			e.username == username && e.clientInfo == info && now.Sub(e.updated) < time.Minute {
+			e.count++
+			e.updated = now
+			return
+		}
+	}
+
+	entry := &aclLogEntry{
+		id:         aclLogNextID,
+		count:      1,
+		reason:     reason,
+		context:    context,
+		object:     object,
+		username:   username,
+		clientInfo: info,
+		created:    now,
+		updated:    now,
+	}
+	aclLogNextID++
+
+	aclLog = append([]*aclLogEntry{entry}, aclLog...)
+	if maxLen := int(aclLogMaxLen.Load()); len(aclLog) > maxLen {
+		aclLog = aclLog[:maxLen]
+	}
+}
+
+// aclParseFile reads "user <name> <rules...>" lines
+func aclParseFile(path string) (map[string]*aclUser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := map[string]*aclUser{}
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		fields, err := splitConfigLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		if fields == nil {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: line should start with user keyword", path, lineNum)
+		}
+		if _, dup := users[fields[1]]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate user '%s'", path, lineNum, fields[1])
+		}
+
+		u := newACLUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := u.applyRule(rule); err != nil {
+				return nil, fmt.Errorf("%s:%d: '%s': %v", path, lineNum, rule, err)
+			}
+		}
+		users[u.name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := users["default"]; !ok {
+		users["default"] = newDefaultUser()
+	}
+	return users, nil
+}
+
+// aclLoadFile replaces every user with the content of the ACL file. Users
+// that survive keep their identity so their clients stay logged in; the
+// others are flagged deleted.
+func aclLoadFile() error {
+	users, err := aclParseFile(aclFile)
+	if err != nil {
+		return err
+	}
+
+	aclMu.Lock()
+	defer aclMu.Unlock()
+
+	for name, old := range aclUsers {
+		if fresh, ok := users[name]; ok {
+			*old = *fresh
+			users[name] = old
+		} else {
+			old.deleted = true
+		}
+	}
+	aclUsers = users
+	return nil
+}
+
+func aclSaveFile() error {
+	aclMu.RLock()
+	var sb strings.Builder
+	for _, name := range sortedUserNames() {
+		sb.WriteString("user " + name + " " + aclUsers[name].describe() + "\n")
+	}
+	aclMu.RUnlock()
+
+	tmp := aclFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, aclFile)
+}
+
+// sortedUserNames returns user names in order. Caller holds aclMu.
+func sortedUserNames() []string {
+	names := make([]string, 0, len(aclUsers))
+	for name := range aclUsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func bulkString(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+func bulkArray(items []string) string {
+	var sb strings.Builder
+	sb.WriteString("*" + strconv.Itoa(len(items)) + "\r\n")
+	for _, item := range items {
+		sb.WriteString(bulkString(item))
+	}
+	return sb.String()
+}
+
+func cmdACL(c *Client, args []string) (string, error) {
+	sub := strings.ToUpper(args[1])
+	wrongArgs := "-ERR wrong number of arguments for 'ACL|" + strings.ToLower(sub) + "' command\r\n"
+
+	switch sub {
+	case "WHOAMI":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		return bulkString(c.username()), nil
+
+	case "SETUSER":
+		if len(args) < 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+
+		aclMu.Lock()
+		defer aclMu.Unlock()
+
+		// Rules are applied to a copy so an invalid one changes nothing
+		existing, exists := aclUsers[args[2]]
+		var u *aclUser
+		if exists {
+			u = existing.clone()
+		} else {
+			u = newACLUser(args[2])
+		}
+		for _, rule := range args[3:] {
+			if err := u.applyRule(rule); err != nil {
+				return "-ERR Error in ACL SETUSER modifier '" + rule + "': " + err.Error() + "\r\n", err
+			}
+		}
+
+		if exists {
+			*existing = *u
+		} else {
+			aclUsers[u.name] = u
+		}
+		return "+OK\r\n", nil
+
+	case "GETUSER":
+		if len(args) != 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+
+		aclMu.RLock()
+		defer aclMu.RUnlock()
+
+		u, ok := aclUsers[args[2]]
+		if !ok {
+			return "*-1\r\n", nil
+		}
+
+		flags := []string{"off"}
+		if u.enabled {
+			flags[0] = "on"
+		}
+		if u.nopass {
+			flags = append(flags, "nopass")
+		}
+		hashes := make([]string, 0, len(u.passwords))
+		for hash := range u.passwords {
+			hashes = append(hashes, hash)
+		}
+		sort.Strings(hashes)
+
+		var resp strings.Builder
+		resp.WriteString("*10\r\n")
+		resp.WriteString(bulkString("flags") + bulkArray(flags))
+		resp.WriteString(bulkString("passwords") + bulkArray(hashes))
+		resp.WriteString(bulkString("commands") + bulkString(strings.Join(u.cmdRules, " ")))
+		resp.WriteString(bulkString("keys") + bulkString(u.describeKeys()))
+		resp.WriteString(bulkString("channels") + bulkString(u.describeChannels()))
+		return resp.String(), nil
+
+	case "DELUSER":
+		if len(args) < 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+
+		aclMu.Lock()
+		defer aclMu.Unlock()
+
+		deleted := 0
+		for _, name := range args[2:] {
+			if name == "default" {
+				return "-ERR The 'default' user cannot be removed\r\n", fmt.Errorf("default user")
+			}
+		}
+		for _, name := range args[2:] {
+			if u, ok := aclUsers[name]; ok {
+				u.deleted = true
+				delete(aclUsers, name)
+				deleted++
+			}
+		}
+		return ":" + strconv.Itoa(deleted) + "\r\n", nil
+
+	case "LIST", "USERS":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+
+		aclMu.RLock()
+		defer aclMu.RUnlock()
+
+		names := sortedUserNames()
+		if sub == "USERS" {
+			return bulkArray(names), nil
+		}
+		lines := make([]string, len(names))
+		for i, name := range names {
+			lines[i] = "user " + name + " " + aclUsers[name].describe()
+		}
+		return bulkArray(lines), nil
+
+	case "CAT":
+		if len(args) == 2 {
+			return bulkArray(aclCategories()), nil
+		}
+		if len(args) != 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+
+		category := strings.ToLower(args[2])
+		var names []string
+		for name, cmd := range commandTable {
+			for _, cat := range cmd.Categories {
+				if cat == category {
+					names = append(names, strings.ToLower(name))
+				}
+			}
+		}
+		if len(names) == 0 {
+			return "-ERR Unknown category '" + args[2] + "'\r\n", fmt.Errorf("unknown category")
+		}
+		sort.Strings(names)
+		return bulkArray(names), nil
+
+	case "LOG":
+		if len(args) > 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+
+		aclLogMu.Lock()
+		defer aclLogMu.Unlock()
+
+		count := len(aclLog)
+		if len(args) == 3 {
+			if strings.ToUpper(args[2]) == "RESET" {
+				aclLog = nil
+				return "+OK\r\n", nil
+			}
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 0 {
+				return "-ERR value is out of range, must be positive\r\n", fmt.Errorf("bad count")
+			}
+			count = min(n, count)
+		}
+
+		now := time.Now()
+		var resp strings.Builder
+		resp.WriteString("*" + strconv.Itoa(count) + "\r\n")
+		for _, e := range aclLog[:count] {
+			age := strconv.FormatFloat(now.Sub(e.created).Seconds(), 'f', 3, 64)
+			resp.WriteString("*20\r\n")
+			resp.WriteString(bulkString("count") + ":" + strconv.Itoa(e.count) + "\r\n")
+			resp.WriteString(bulkString("reason") + bulkString(e.reason))
+			resp.WriteString(bulkString("context") + bulkString(e.context))
+			resp.WriteString(bulkString("object") + bulkString(e.object))
+			resp.WriteString(bulkString("username") + bulkString(e.username))
+			resp.WriteString(bulkString("age-seconds") + bulkString(age))
+			resp.WriteString(bulkString("client-info") + bulkString(e.clientInfo))
+			resp.WriteString(bulkString("entry-id") + ":" + strconv.FormatInt(e.id, 10) + "\r\n")
+			resp.WriteString(bulkString("timestamp-created") + ":" + strconv.FormatInt(e.created.UnixMilli(), 10) + "\r\n")
+			resp.WriteString(bulkString("timestamp-last-updated") + ":" + strconv.FormatInt(e.updated.UnixMilli(), 10) + "\r\n")
+		}
+		return resp.String(), nil
+
+	case "LOAD":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		if aclFile == "" {
+			return "-ERR This Redis instance is not configured to use an ACL file.\r\n", fmt.Errorf("no acl file")
+		}
+		if err := aclLoadFile(); err != nil {
+			return "-ERR Error loading ACLs: " + err.Error() + "\r\n", err
+		}
+		return "+OK\r\n", nil
+
+	case "SAVE":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		if aclFile == "" {
+			return "-ERR This Redis instance is not configured to use an ACL file.\r\n", fmt.Errorf("no acl file")
+		}
+		if err := aclSaveFile(); err != nil {
+			return "-ERR There was an error trying to save the ACLs: " + err.Error() + "\r\n", err
+		}
+		return "+OK\r\n", nil
+
+	default:
+		return "-ERR unknown subcommand '" + args[1] + "'. Try ACL SETUSER, GETUSER, DELUSER, LIST, USERS, WHOAMI, CAT, LOG, LOAD, SAVE.\r\n",
+			fmt.Errorf("unknown subcommand")
+	}
+}
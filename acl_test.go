@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// resetACL restores the built-in default user and an empty ACL log once
+// the test is over
+func resetACL(t *testing.T) {
+	t.Helper()
+	oldFile := aclFile
+	t.Cleanup(func() {
+		aclMu.Lock()
+		for _, u := range aclUsers {
+			if u.name != "default" {
+				u.deleted = true
+			}
+		}
+		aclUsers = map[string]*aclUser{"default": newDefaultUser()}
+		aclMu.Unlock()
+
+		aclLogMu.Lock()
+		aclLog = nil
+		aclLogMu.Unlock()
+
+		aclFile = oldFile
+	})
+}
+
+func TestACLSetUserAndGetUser(t *testing.T) {
+	resetACL(t)
+	c := dialTestClient(t, startTestServer(t))
+
+	if got := c.do("ACL", "SETUSER", "alice", "on", ">pw", "~cache:*", "+@read", "-hgetall"); got != "+OK\r\n" {
+		t.Fatalf("SETUSER: %q", got)
+	}
+	got := c.do("ACL", "GETUSER", "alice")
+	for _, want := range []string{"$2\r\non\r\n", "-@all +@read -hgetall", "~cache:*", hashPassword("pw")} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("GETUSER missing %q: %q", want, got)
+		}
+	}
+	if got := c.do("ACL", "GETUSER", "nobody"); got != "*-1\r\n" {
+		t.Fatalf("GETUSER unknown: %q", got)
+	}
+	if got := c.do("ACL", "SETUSER", "alice", "+nosuchcommand"); !strings.HasPrefix(got, "-ERR Error in ACL SETUSER modifier '+nosuchcommand'") {
+		t.Fatalf("bad rule: %q", got)
+	}
+	if got := c.do("ACL", "USERS"); got != "*2\r\n$5\r\nalice\r\n$7\r\ndefault\r\n" {
+		t.Fatalf("USERS: %q", got)
+	}
+	if got := c.do("ACL", "LIST"); !strings.Contains(got, "user default on nopass ~* &* +@all") {
+		t.Fatalf("LIST: %q", got)
+	}
+}
+
+func TestACLPermissions(t *testing.T) {
+	resetACL(t)
+	addr := startTestServer(t)
+	admin := dialTestClient(t, addr)
+	admin.do("ACL", "SETUSER", "reader", "on", ">pw", "~cache:*", "+@read", "+config|get")
+
+	c := dialTestClient(t, addr)
+	if got := c.do("AUTH", "reader", "pw"); got != "+OK\r\n" {
+		t.Fatalf("AUTH: %q", got)
+	}
+	if got := c.do("ACL", "WHOAMI"); !strings.HasPrefix(got, "-NOPERM") {
+		t.Fatalf("ACL WHOAMI without @admin: %q", got)
+	}
+
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GET", "cache:1"}, "$-1\r\n"},
+		{[]string{"GET", "secret"}, "-NOPERM No permissions to access a key\r\n"},
+		{[]string{"MGET", "cache:1", "secret"}, "-NOPERM No permissions to access a key\r\n"},
+		{[]string{"SET", "cache:1", "v"}, "-NOPERM User reader has no permissions to run the 'set' command\r\n"},
+		{[]string{"CONFIG", "SET", "port", "1"}, "-NOPERM User reader has no permissions to run the 'config' command\r\n"},
+	}
+	for _, tc := range cases {
+		if got := c.do(tc.args...); got != tc.want {
+			t.Fatalf("%v: got %q, want %q", tc.args, got, tc.want)
+		}
+	}
+	if got := c.do("CONFIG", "GET", "port"); !strings.HasPrefix(got, "*2\r\n") {
+		t.Fatalf("CONFIG GET: %q", got)
+	}
+
+	// Permissions change for connected clients as soon as SETUSER runs
+	admin.do("ACL", "SETUSER", "reader", "+set")
+	if got := c.do("SET", "cache:1", "v"); got != "+OK\r\n" {
+		t.Fatalf("SET after +set: %q", got)
+	}
+
+	log := admin.do("ACL", "LOG", "2")
+	for _, want := range []string{"$7\r\ncommand\r\n", "$6\r\nconfig\r\n"} {
+		if !strings.Contains(log, want) {
+			t.Fatalf("ACL LOG missing %q: %q", want, log)
+		}
+	}
+	if got := admin.do("ACL", "LOG", "RESET"); got != "+OK\r\n" {
+		t.Fatalf("LOG RESET: %q", got)
+	}
+	if got := admin.do("ACL", "LOG"); got != "*0\r\n" {
+		t.Fatalf("LOG after reset: %q", got)
+	}
+}
+
+func TestACLDisabledUserCannotAuth(t *testing.T) {
+	resetACL(t)
+	c := dialTestClient(t, startTestServer(t))
+
+	c.do("ACL", "SETUSER", "bob", "off", ">pw", "+@all")
+	if got := c.do("AUTH", "bob", "pw"); !strings.HasPrefix(got, "-WRONGPASS") {
+		t.Fatalf("AUTH disabled user: %q", got)
+	}
+	if got := c.do("ACL", "LOG", "1"); !strings.Contains(got, "$4\r\nauth\r\n") {
+		t.Fatalf("auth failure not logged: %q", got)
+	}
+}
+
+func TestACLDelUserDisconnectsClients(t *testing.T) {
+	resetACL(t)
+	addr := startTestServer(t)
+	admin := dialTestClient(t, addr)
+	admin.do("ACL", "SETUSER", "temp", "on", "nopass", "+@all", "~*")
+
+	c := dialTestClient(t, addr)
+	c.do("AUTH", "temp", "anything")
+	if got := c.do("ACL", "WHOAMI"); got != "$4\r\ntemp\r\n" {
+		t.Fatalf("WHOAMI: %q", got)
+	}
+
+	if got := admin.do("ACL", "DELUSER", "temp", "missing"); got != ":1\r\n" {
+		t.Fatalf("DELUSER: %q", got)
+	}
+	if got := admin.do("ACL", "DELUSER", "default"); !strings.HasPrefix(got, "-ERR") {
+		t.Fatalf("DELUSER default: %q", got)
+	}
+
+	c.send("PING")
+	if _, err := readReply(c.reader); err == nil {
+		t.Fatal("client of a deleted user is still connected")
+	}
+}
+
+func TestACLSaveAndLoad(t *testing.T) {
+	resetACL(t)
+	c := dialTestClient(t, startTestServer(t))
+
+	if got := c.do("ACL", "SAVE"); !strings.HasPrefix(got, "-ERR This Redis instance is not configured") {
+		t.Fatalf("SAVE without aclfile: %q", got)
+	}
+
+	aclFile = filepath.Join(t.TempDir(), "users.acl")
+	c.do("ACL", "SETUSER", "alice", "on", ">pw", "~app:*", "+get", "+set")
+	if got := c.do("ACL", "SAVE"); got != "+OK\r\n" {
+		t.Fatalf("SAVE: %q", got)
+	}
+	data, err := os.ReadFile(aclFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "user alice on #" + hashPassword("pw") + " ~app:* -@all +get +set\n"
+	if !strings.Contains(string(data), want) {
+		t.Fatalf("saved file:\n%s\nwant line %q", data, want)
+	}
+
+	c.do("ACL", "DELUSER", "alice")
+	c.do("ACL", "SETUSER", "mallory", "on", "nopass")
+	if got := c.do("ACL", "LOAD"); got != "+OK\r\n" {
+		t.Fatalf("LOAD: %q", got)
+	}
+	if got := c.do("ACL", "USERS"); got != "*2\r\n$5\r\nalice\r\n$7\r\ndefault\r\n" {
+		t.Fatalf("USERS after LOAD: %q", got)
+	}
+
+	// A broken file leaves the current users alone
+	os.WriteFile(aclFile, []byte("user bad on +nosuchcommand\n"), 0600)
+	if got := c.do("ACL", "LOAD"); !strings.HasPrefix(got, "-ERR Error loading ACLs") {
+		t.Fatalf("LOAD broken file: %q", got)
+	}
+	if got := c.do("ACL", "USERS"); got != "*2\r\n$5\r\nalice\r\n$7\r\ndefault\r\n" {
+		t.Fatalf("USERS after failed LOAD: %q", got)
+	}
+}
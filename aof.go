@@ -9,16 +9,68 @@ import (
 	"time"
 )
 
-const AOFFileName = "appendonly.aof"
+// AOFFileName is set by the "appendfilename" config directive
+var AOFFileName = "appendonly.aof"
 
 var (
 	aofFile   *os.File
 	aofWriter *bufio.Writer
 )
 
+// Outcome of the latest AOF write and fsync, reported by INFO persistence.
+// Guarded by aofMu.
+var (
+	aofLastWriteErr  error
+	aofLastFsyncErr  error
+	aofLastFsyncTime int64
+)
+
+// How far the AOF got, guarded by aofMu: bytes appended and bytes made
+// durable by the latest successful fsync. On a replica the matching
+// replication offsets are kept too, for REPLCONF ACK ... FACK.
+var (
+	aofWrittenOffset     int64
+	aofFsyncedOffset     int64
+	aofWrittenReplOffset int64
+	aofFsyncedReplOffset int64
+)
+
+// syncAOF flushes the write buffer and fsyncs the file, recording the
+// result. Caller holds aofMu.
+func syncAOF() error {
+	if err := aofWriter.Flush(); err != nil {
+		aofLastWriteErr = err
+		return err
+	}
+	aofLastWriteErr = nil
+
+	start := time.Now()
+	err := aofFile.Sync()
+	aofFsyncLatency.observe(time.Since(start))
+	aofLastFsyncErr = err
+	aofLastFsyncTime = time.Now().Unix()
+
+	if err == nil {
+		progressed := aofFsyncedOffset != aofWrittenOffset
+		aofFsyncedOffset = aofWrittenOffset
+		aofFsyncedReplOffset = aofWrittenReplOffset
+		if progressed {
+			notifyWaiters()
+		}
+	}
+	return err
+}
+
+// aofOffsets returns the appended and fsynced AOF offsets
+func aofOffsets() (written, fsynced int64) {
+	aofMu.Lock()
+	defer aofMu.Unlock()
+	return aofWrittenOffset, aofFsyncedOffset
+}
+
 // InitAOF opens or creates the AOF file
 func InitAOF() error {
-	f, err := os.OpenFile("appendonly.aof",
+	f, err := os.OpenFile(AOFFileName,
 		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		return err
@@ -52,6 +104,32 @@ func buildRESPCommand(cmd string, args []string) string {
 	return sb.String()
 }
 
+// logSnapshot replaces the dataset recorded in the AOF with payload, a
+// snapshot received from the master
+func logSnapshot(payload []byte) {
+	if isReplayingAOF {
+		return
+	}
+
+	aofMu.Lock()
+	defer aofMu.Unlock()
+
+	if aofWriter == nil {
+		return
+	}
+
+	// Logged commands carry no SELECT, so the file goes back to DB 0
+	n, _ := aofWriter.WriteString(buildRESPCommand("FLUSHALL", nil))
+	aofWrittenOffset += int64(n)
+	n, _ = aofWriter.Write(payload)
+	aofWrittenOffset += int64(n)
+	n, _ = aofWriter.WriteString(buildRESPCommand("SELECT", []string{"0"}))
+	aofWrittenOffset += int64(n)
+	if err := syncAOF(); err != nil {
+		fmt.Printf("[AOF] Error writing snapshot: %v\n", err)
+	}
+}
+
 // FlushAOF writes buffer to disk
 func FlushAOF() error {
 	aofMu.Lock()
@@ -61,16 +139,11 @@ func FlushAOF() error {
 		return nil
 	}
 
-	if err := aofWriter.Flush(); err != nil {
+	if err := syncAOF(); err != nil {
 		fmt.Printf("[AOF] Error flushing: %v\n", err)
 		return err
 	}
 
-	if err := aofFile.Sync(); err != nil {
-		fmt.Printf("[AOF] Error syncing: %v\n", err)
-		return err
-	}
-
 	return nil
 }
 
@@ -83,26 +156,31 @@ func CloseAOF() error {
 		return nil
 	}
 
-	if err := aofWriter.Flush(); err != nil {
+	if err := syncAOF(); err != nil {
 		return err
 	}
 
-	if err := aofFile.Sync(); err != nil {
-		return err
-	}
-
-	return aofFile.Close()
+	err := aofFile.Close()
+	aofFile, aofWriter = nil, nil
+	return err
 }
 
-// BackgroundFsync periodically flushes AOF to disk
+// BackgroundFsync periodically flushes AOF to disk. With appendfsync "no"
+// the buffer is handed to the OS but fsync is left to the kernel; with
+// "always" LogCommand already syncs every write.
 func BackgroundAOFFsync() {
 	ticker := time.NewTicker(time.Second)
 
 	for range ticker.C {
 		aofMu.Lock()
 		if aofWriter != nil {
-			aofWriter.Flush()
-			aofFile.Sync()
+			if appendFsync.Load() == "everysec" {
+				start := time.Now()
+				syncAOF()
+				latencyRecord("aof-fsync", time.Since(start))
+			} else if err := aofWriter.Flush(); err != nil {
+				aofLastWriteErr = err
+			}
 		}
 		aofMu.Unlock()
 	}
@@ -115,21 +193,34 @@ func ReplayAOF() {
 		return
 	}
 
-	file, err := os.Open(AOFFileName)
+	count, err := replayFile(AOFFileName)
 	if err != nil {
 		fmt.Printf("[Replay] Error opening AOF: %v\n", err)
 		return
 	}
-	defer file.Close()
 
-	reader := bufio.NewReader(file)
-	count := 0
+	fmt.Printf("[Replay] Replayed %d commands from AOF\n", count)
+}
+
+// replayFile applies every command stored in path, which is in AOF
+// format, and returns how many were read
+func replayFile(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
 
 	isReplayingAOF = true
 	defer func() { isReplayingAOF = false }()
 
-	currentDB := 0
+	count, _ := replayStream(bufio.NewReader(file))
+	return count, nil
+}
 
+// replayStream applies the commands read from reader until EOF and returns
+// how many there were and the database selected at the end
+func replayStream(reader *bufio.Reader) (count, currentDB int) {
 	for {
 		args, err := parseResp(reader)
 		if err != nil {
@@ -144,7 +235,7 @@ func ReplayAOF() {
 		count++
 	}
 
-	fmt.Printf("[Replay] Replayed %d commands from AOF\n", count)
+	return count, currentDB
 }
 
 // replayCommand executes a command during AOF replay
@@ -159,17 +250,17 @@ func replayCommand(args []string, currentDB int) int {
 	case "SET":
 		if len(args) == 3 {
 			mu.Lock()
-			databases[currentDB][args[1]] = Entry{
+			storeEntry(currentDB, args[1], Entry{
 				Type:  TypeString,
 				Value: args[2],
-			}
+			})
 			mu.Unlock()
 		}
 
 	case "DEL":
 		if len(args) == 2 {
 			mu.Lock()
-			delete(databases[currentDB], args[1])
+			removeEntry(currentDB, args[1])
 			mu.Unlock()
 		}
 
@@ -177,12 +268,12 @@ func replayCommand(args []string, currentDB int) int {
     if len(args)==2 {
         entry, exists := databases[currentDB][args[1]]
         if !exists {
-            databases[currentDB][args[1]] = Entry{Type: TypeString, Value:"1"}
+            storeEntry(currentDB, args[1], Entry{Type: TypeString, Value:"1"})
         } else {
             v,_ := strconv.Atoi(entry.Value.(string))
             v++
             entry.Value=strconv.Itoa(v)
-            databases[currentDB][args[1]]=entry
+            storeEntry(currentDB, args[1], entry)
         }
     }
 
@@ -190,12 +281,12 @@ func replayCommand(args []string, currentDB int) int {
 	if len(args)==2 {
 		entry, exists := databases[currentDB][args[1]]
 		if !exists {
-			databases[currentDB][args[1]] = Entry{Type: TypeString, Value:"-1"}
+			storeEntry(currentDB, args[1], Entry{Type: TypeString, Value:"-1"})
 		} else {
 			v,_ := strconv.Atoi(entry.Value.(string))
 			v--
 			entry.Value=strconv.Itoa(v)
-			databases[currentDB][args[1]]=entry
+			storeEntry(currentDB, args[1], entry)
 		}
 	}
 
@@ -203,10 +294,10 @@ func replayCommand(args []string, currentDB int) int {
 		if len(args) >= 3 && len(args[1:])%2 == 0 {
 			mu.Lock()
 			for i := 1; i < len(args); i += 2 {
-				databases[currentDB][args[i]] = Entry{
+				storeEntry(currentDB, args[i], Entry{
 					Type:  TypeString,
 					Value: args[i+1],
-				}
+				})
 			}
 			mu.Unlock()
 		}
@@ -217,7 +308,7 @@ func replayCommand(args []string, currentDB int) int {
 			if entry, exists := databases[currentDB][args[1]]; exists {
 				seconds, _ := strconv.ParseInt(args[2], 10, 64)
 				entry.ExpireAt = time.Now().Unix() + seconds
-				databases[currentDB][args[1]] = entry
+				storeEntry(currentDB, args[1], entry)
 			}
 			mu.Unlock()
 		}
@@ -227,18 +318,27 @@ func replayCommand(args []string, currentDB int) int {
 			mu.Lock()
 			if entry, exists := databases[currentDB][args[1]]; exists {
 				entry.ExpireAt = 0
-				databases[currentDB][args[1]] = entry
+				storeEntry(currentDB, args[1], entry)
 			}
 			mu.Unlock()
 		}
 
 	case "FLUSHALL":
 		mu.Lock()
-		for i := 0; i < NumDatabases; i++ {
-			databases[i] = make(map[string]Entry)
-		}
+		flushAllDatabases()
 		mu.Unlock()
 
+	// Absolute expiry, written by snapshots so TTLs survive the restart
+	case "EXPIREAT":
+		if len(args) == 3 {
+			mu.Lock()
+			if entry, exists := databases[currentDB][args[1]]; exists {
+				entry.ExpireAt, _ = strconv.ParseInt(args[2], 10, 64)
+				storeEntry(currentDB, args[1], entry)
+			}
+			mu.Unlock()
+		}
+
 	case "SELECT":
 		if len(args) == 2 {
 			dbNum, _ := strconv.Atoi(args[1])
@@ -246,6 +346,10 @@ func replayCommand(args []string, currentDB int) int {
 				return dbNum
 			}
 		}
+
+	// Everything else (HSET, ZADD, ...) goes through the command table
+	default:
+		execCommand(args, &currentDB)
 	}
 
 	return currentDB
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requirePass is the password of the default user, "" disables auth.
+// Set through the "requirepass" config so the default ACL user follows it.
+var requirePass = newAtomicString("")
+
+func requirePassConfig() *configParam {
+	return &configParam{
+		name:      "requirepass",
+		sensitive: true,
+		get:       requirePass.Load,
+		set: func(value string) error {
+			requirePass.Store(value)
+			aclSetDefaultPassword(value)
+			return nil
+		},
+	}
+}
+
+// Failed AUTH attempts are limited per remote host so a password can't be
+// brute forced over many connections.
+const (
+	authMaxFailures   = 10
+	authFailureWindow = time.Minute
+)
+
+type authFailureCount struct {
+	count       int
+	windowStart time.Time
+}
+
+var (
+	authFailuresMu sync.Mutex
+	authFailures   = map[string]*authFailureCount{}
+)
+
+func remoteHost(c *Client) string {
+	host, _, err := net.SplitHostPort(c.conn.RemoteAddr().String())
+	if err != nil {
+		return c.conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// authRateLimited reports whether host used up its failed attempts
+func authRateLimited(host string) bool {
+	authFailuresMu.Lock()
+	defer authFailuresMu.Unlock()
+
+	f, ok := authFailures[host]
+	if !ok {
+		return false
+	}
+	if time.Since(f.windowStart) > authFailureWindow {
+		delete(authFailures, host)
+		return false
+	}
+	return f.count >= authMaxFailures
+}
+
+func recordAuthFailure(host string) {
+	authFailuresMu.Lock()
+	defer authFailuresMu.Unlock()
+
+	f, ok := authFailures[host]
+	if !ok || time.Since(f.windowStart) > authFailureWindow {
+		f = &authFailureCount{windowStart: time.Now()}
+		authFailures[host] = f
+	}
+	f.count++
+}
+
+// sweepAuthFailures drops the windows that ran out. A host that never
+// tries again would otherwise stay in authFailures for good. Called by
+// the janitor.
+func sweepAuthFailures() {
+	authFailuresMu.Lock()
+	defer authFailuresMu.Unlock()
+
+	for host, f := range authFailures {
+		if time.Since(f.windowStart) > authFailureWindow {
+			delete(authFailures, host)
+		}
+	}
+}
+
+// checkPassword authenticates c as username and updates the rate limiter.
+// It returns an error reply, or "" on success.
+func checkPassword(c *Client, username, password string) string {
+	host := remoteHost(c)
+	if authRateLimited(host) {
+		return "-ERR too many failed authentication attempts, try again later\r\n"
+	}
+
+	user := aclAuthenticate(username, password)
+	if user == nil {
+		recordAuthFailure(host)
+		aclLogDenial(c, "auth", "toplevel", "AUTH", username)
+		return "-WRONGPASS invalid username-password pair or user is disabled.\r\n"
+	}
+
+	c.user = user
+	return ""
+}
+
+// AUTH [username] password
+func cmdAUTH(c *Client, args []string) (string, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return "-ERR wrong number of arguments for 'AUTH' command\r\n", fmt.Errorf("wrong args")
+	}
+
+	username, password := "default", args[1]
+	if len(args) == 3 {
+		username, password = args[1], args[2]
+	}
+
+	if len(args) == 2 && aclDefaultUserNoPass() {
+		return "-ERR AUTH <password> called without any password configured for the default user. " +
+			"Are you sure your configuration is correct?\r\n", fmt.Errorf("no password")
+	}
+
+	if errReply := checkPassword(c, username, password); errReply != "" {
+		return errReply, fmt.Errorf("auth failed")
+	}
+	return "+OK\r\n", nil
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func cmdHELLO(c *Client, args []string) (string, error) {
+	if len(args) >= 2 {
+		ver, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "-ERR Protocol version is not an integer or out of range\r\n", fmt.Errorf("bad protover")
+		}
+		// Only RESP2 replies are implemented
+		if ver != 2 {
+			return "-NOPROTO unsupported protocol version\r\n", fmt.Errorf("unsupported protover")
+		}
+	}
+
+	setName := ""
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "AUTH" && i+2 < len(args):
+			if errReply := checkPassword(c, args[i+1], args[i+2]); errReply != "" {
+				return errReply, fmt.Errorf("auth failed")
+			}
+			i += 2
+		case opt == "SETNAME" && i+1 < len(args):
+			if !validClientName(args[i+1]) {
+				return "-ERR Client names cannot contain spaces, newlines or special characters.\r\n", fmt.Errorf("bad name")
+			}
+			setName = args[i+1]
+			i++
+		default:
+			return "-ERR Syntax error in HELLO option '" + args[i] + "'\r\n", fmt.Errorf("syntax error")
+		}
+	}
+
+	if c.user == nil {
+		return "-NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+			"and select the RESP protocol version at the same time\r\n", fmt.Errorf("noauth")
+	}
+	if setName != "" {
+		c.name = setName
+	}
+
+	fields := []string{
+		"server", "redis",
+		"version", serverVersion,
+		"proto", "2",
+		"id", strconv.FormatInt(c.id, 10),
+		"mode", "standalone",
+		"role", "master",
+	}
+
+	var resp strings.Builder
+	resp.WriteString("*" + strconv.Itoa(len(fields)+2) + "\r\n")
+	for i, f := range fields {
+		// proto and id are integers
+		if i == 5 || i == 7 {
+			resp.WriteString(":" + f + "\r\n")
+			continue
+		}
+		resp.WriteString("$" + strconv.Itoa(len(f)) + "\r\n" + f + "\r\n")
+	}
+	resp.WriteString("$7\r\nmodules\r\n*0\r\n")
+	return resp.String(), nil
+}
+
+func cmdQUIT(c *Client, args []string) (string, error) {
+	c.closeAfter = true
+	return "+OK\r\n", nil
+}
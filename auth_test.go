@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withPassword sets requirepass for the duration of a test
+func withPassword(t *testing.T, password string) {
+	t.Helper()
+	param := configByName["requirepass"]
+	old := param.get()
+	param.set(password)
+	t.Cleanup(func() {
+		param.set(old)
+		authFailuresMu.Lock()
+		authFailures = map[string]*authFailureCount{}
+		authFailuresMu.Unlock()
+	})
+}
+
+func TestAuthRequired(t *testing.T) {
+	withPassword(t, "s3cret")
+	c := dialTestClient(t, startTestServer(t))
+
+	if got := c.do("GET", "k"); got != "-NOAUTH Authentication required.\r\n" {
+		t.Fatalf("GET before AUTH: %q", got)
+	}
+	if got := c.do("FLUSHALL"); got != "-NOAUTH Authentication required.\r\n" {
+		t.Fatalf("FLUSHALL before AUTH: %q", got)
+	}
+	if got := c.do("AUTH", "wrong"); !strings.HasPrefix(got, "-WRONGPASS") {
+		t.Fatalf("AUTH wrong: %q", got)
+	}
+	if got := c.do("AUTH", "s3cret"); got != "+OK\r\n" {
+		t.Fatalf("AUTH: %q", got)
+	}
+	if got := c.do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("PING after AUTH: %q", got)
+	}
+}
+
+func TestAuthWithUsername(t *testing.T) {
+	withPassword(t, "s3cret")
+	c := dialTestClient(t, startTestServer(t))
+
+	if got := c.do("AUTH", "someone", "s3cret"); !strings.HasPrefix(got, "-WRONGPASS") {
+		t.Fatalf("unknown user accepted: %q", got)
+	}
+	if got := c.do("AUTH", "default", "s3cret"); got != "+OK\r\n" {
+		t.Fatalf("AUTH default: %q", got)
+	}
+}
+
+func TestAuthWithoutPassword(t *testing.T) {
+	withPassword(t, "")
+	c := dialTestClient(t, startTestServer(t))
+
+	if got := c.do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("PING: %q", got)
+	}
+	if got := c.do("AUTH", "x"); !strings.HasPrefix(got, "-ERR AUTH <password> called without any password") {
+		t.Fatalf("AUTH: %q", got)
+	}
+}
+
+func TestAuthRateLimit(t *testing.T) {
+	withPassword(t, "s3cret")
+	c := dialTestClient(t, startTestServer(t))
+
+	for i := 0; i < authMaxFailures; i++ {
+		c.do("AUTH", "guess")
+	}
+
+	// Even the right password is refused while the host is locked out
+	got := c.do("AUTH", "s3cret")
+	if got != "-ERR too many failed authentication attempts, try again later\r\n" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestSweepAuthFailures(t *testing.T) {
+	withPassword(t, "s3cret")
+
+	authFailuresMu.Lock()
+	authFailures["10.0.0.1"] = &authFailureCount{count: 3, windowStart: time.Now().Add(-2 * authFailureWindow)}
+	authFailures["10.0.0.2"] = &authFailureCount{count: 3, windowStart: time.Now()}
+	authFailuresMu.Unlock()
+
+	sweepAuthFailures()
+
+	authFailuresMu.Lock()
+	defer authFailuresMu.Unlock()
+	if _, ok := authFailures["10.0.0.1"]; ok {
+		t.Error("expired window kept")
+	}
+	if _, ok := authFailures["10.0.0.2"]; !ok {
+		t.Error("current window dropped")
+	}
+}
+
+func TestHello(t *testing.T) {
+	withPassword(t, "s3cret")
+	c := dialTestClient(t, startTestServer(t))
+
+	if got := c.do("HELLO", "2"); !strings.HasPrefix(got, "-NOAUTH") {
+		t.Fatalf("HELLO unauthenticated: %q", got)
+	}
+	if got := c.do("HELLO", "3"); got != "-NOPROTO unsupported protocol version\r\n" {
+		t.Fatalf("HELLO 3: %q", got)
+	}
+
+	got := c.do("HELLO", "2", "AUTH", "default", "s3cret", "SETNAME", "worker")
+	if !strings.HasPrefix(got, "*14\r\n$6\r\nserver\r\n$5\r\nredis\r\n") || !strings.Contains(got, "$5\r\nproto\r\n:2\r\n") {
+		t.Fatalf("HELLO reply: %q", got)
+	}
+	if got := c.do("GET", "k"); got != "$-1\r\n" {
+		t.Fatalf("not authenticated by HELLO AUTH: %q", got)
+	}
+}
+
+func TestQuit(t *testing.T) {
+	withPassword(t, "s3cret")
+	c := dialTestClient(t, startTestServer(t))
+
+	if got := c.do("QUIT"); got != "+OK\r\n" {
+		t.Fatalf("QUIT: %q", got)
+	}
+	if _, err := c.reader.ReadByte(); err != io.EOF {
+		t.Fatalf("connection still open after QUIT: %v", err)
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client is the per-connection state owned by handleConnection
+type Client struct {
+	id     int64
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// Replies are accumulated here and only written to the socket once the
+	// pipelined batch sitting in the reader has been fully consumed.
+	writer *bufio.Writer
+	out    *outputWriter // what writer flushes into, see outputWriter
+
+	db         int      // selected database
+	name       string   // set by HELLO ... SETNAME / CLIENT SETNAME
+	user       *aclUser // authenticated user, nil until AUTH succeeds
+	closeAfter bool     // flush the pending replies, then hang up (QUIT)
+
+	monitor *monitorFeed // set while the connection is a MONITOR feed
+	replica *replicaFeed // set once the connection is a replica after PSYNC
+
+	replListeningPort int // sent by a replica with REPLCONF listening-port
+
+	asking bool // ASKING was sent, the next command may use an importing slot
+
+	// Where the latest write of this client ended in the replication
+	// stream and in the AOF, for WAIT and WAITAOF
+	woff    int64
+	aofWoff int64
+
+	created time.Time
+
+	// info is what other connections see of this one (CLIENT LIST, KILL).
+	// Only the owning goroutine writes it, under infoMu.
+	infoMu sync.Mutex
+	info   clientInfo
+}
+
+type clientInfo struct {
+	name       string
+	db         int
+	user       string
+	lastCmd    string
+	lastActive time.Time
+	qbuf       int // bytes read but not parsed yet
+	qbufFree   int
+	obl        int // reply bytes waiting to be written
+	flags      string
+	typ        string // what TYPE matches in CLIENT LIST and KILL
+}
+
+var nextClientID atomic.Int64
+
+// clients holds every connected client by id
+var (
+	clientsMu sync.Mutex
+	clients   = map[int64]*Client{}
+)
+
+func registerClient(c *Client) {
+	clientsMu.Lock()
+	clients[c.id] = c
+	clientsMu.Unlock()
+}
+
+func unregisterClient(c *Client) {
+	clientsMu.Lock()
+	delete(clients, c.id)
+	clientsMu.Unlock()
+}
+
+func newClient(conn net.Conn) *Client {
+	out := newOutputWriter(conn)
+	c := &Client{
+		id:     nextClientID.Add(1),
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(out),
+		out:    out,
+		// Without a password the default user is logged in right away
+		user:    aclAutoLoginUser(),
+		created: time.Now(),
+	}
+	c.info.lastActive = c.created
+	c.publishInfo()
+	return c
+}
+
+// beginCommand records command as the client's latest activity
+func (c *Client) beginCommand(command string) {
+	c.infoMu.Lock()
+	c.info.lastCmd = strings.ToLower(command)
+	c.info.lastActive = time.Now()
+	c.infoMu.Unlock()
+	c.publishInfo()
+}
+
+// publishInfo copies the connection state into info. Called by the
+// owning goroutine only.
+func (c *Client) publishInfo() {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+
+	c.info.name = c.name
+	c.info.db = c.db
+	c.info.user = c.username()
+	c.info.qbuf = c.reader.Buffered()
+	c.info.qbufFree = c.reader.Size() - c.info.qbuf
+	c.info.obl = c.writer.Buffered() + int(c.out.pending())
+	// Like in Redis, a MONITOR feed is still a normal client by type
+	c.info.flags, c.info.typ = "N", "normal"
+	if c.monitor != nil {
+		c.info.flags = "O"
+	}
+	if c.replica != nil {
+		c.info.flags, c.info.typ = "S", "replica"
+	}
+}
+
+// flushOutput writes every pending reply to the socket and waits until it
+// is there, before a feed or shutdown takes the connection over
+func (c *Client) flushOutput() error {
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+	return c.out.drain()
+}
+
+func (c *Client) snapshot() clientInfo {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	return c.info
+}
+
+// infoLine formats the client like a line of CLIENT LIST
+func (c *Client) infoLine() string {
+	info := c.snapshot()
+	now := time.Now()
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d qbuf=%d qbuf-free=%d obl=%d cmd=%s user=%s resp=2",
+		c.id, c.conn.RemoteAddr(), c.conn.LocalAddr(), info.name,
+		int64(now.Sub(c.created).Seconds()), int64(now.Sub(info.lastActive).Seconds()),
+		info.flags, info.db, info.qbuf, info.qbufFree, info.obl, orNULL(info.lastCmd), info.user)
+}
+
+func orNULL(s string) string {
+	if s == "" {
+		return "NULL"
+	}
+	return s
+}
+
+// sortedClients returns the registered clients in id order
+func sortedClients() []*Client {
+	clientsMu.Lock()
+	list := make([]*Client, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, c)
+	}
+	clientsMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+// validClientName rejects names that would break the CLIENT LIST format
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Client pause set by CLIENT PAUSE. While active, commands blocked by it
+// wait until done is closed by CLIENT UNPAUSE or the timeout.
+type clientPause struct {
+	writeOnly bool
+	until     time.Time
+	done      chan struct{}
+}
+
+var (
+	pauseMu     sync.Mutex
+	activePause *clientPause
+)
+
+// pauseClients starts or extends a pause. An existing pause is only made
+// longer or stricter, never shorter.
+func pauseClients(d time.Duration, writeOnly bool) {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+
+	p := &clientPause{writeOnly: writeOnly, until: time.Now().Add(d), done: make(chan struct{})}
+	if old := activePause; old != nil {
+		if old.until.After(p.until) {
+			p.until = old.until
+		}
+		p.writeOnly = p.writeOnly && old.writeOnly
+		close(old.done)
+	}
+	activePause = p
+
+	time.AfterFunc(time.Until(p.until), func() { endPause(p) })
+}
+
+// endPause lifts p if it is still the active pause
+func endPause(p *clientPause) {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+
+	if activePause == p {
+		activePause = nil
+		close(p.done)
+	}
+}
+
+func unpauseClients() {
+	pauseMu.Lock()
+	p := activePause
+	pauseMu.Unlock()
+
+	if p != nil {
+		endPause(p)
+	}
+}
+
+// waitWhilePaused blocks while a pause applies to def. CLIENT itself is
+// never paused so the pause can always be lifted.
+func waitWhilePaused(command string, def *Command) {
+	if command == "CLIENT" {
+		return
+	}
+	for {
+		pauseMu.Lock()
+		p := activePause
+		pauseMu.Unlock()
+
+		if p == nil || (p.writeOnly && !def.hasCategory("write")) {
+			return
+		}
+		<-p.done
+	}
+}
+
+// ClientCmdFunc implements a command that works on the connection itself
+// rather than on the keyspace (AUTH, HELLO, QUIT, ...)
+type ClientCmdFunc func(c *Client, args []string) (string, error)
+
+// username returns the name of the authenticated user, "" if none
+func (c *Client) username() string {
+	if c.user == nil {
+		return ""
+	}
+	// ACL SETUSER rewrites the user in place under aclMu
+	aclMu.RLock()
+	defer aclMu.RUnlock()
+	return c.user.name
+}
+
+func cmdCLIENT(c *Client, args []string) (string, error) {
+	sub := strings.ToUpper(args[1])
+	wrongArgs := "-ERR wrong number of arguments for 'CLIENT|" + strings.ToLower(sub) + "' command\r\n"
+
+	switch sub {
+	case "ID":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		return ":" + strconv.FormatInt(c.id, 10) + "\r\n", nil
+
+	case "GETNAME":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		if c.name == "" {
+			return "$-1\r\n", nil
+		}
+		return bulkString(c.name), nil
+
+	case "SETNAME":
+		if len(args) != 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		if !validClientName(args[2]) {
+			return "-ERR Client names cannot contain spaces, newlines or special characters.\r\n", fmt.Errorf("bad name")
+		}
+		c.name = args[2]
+		return "+OK\r\n", nil
+
+	case "INFO":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		return bulkString(c.infoLine() + "\n"), nil
+
+	case "LIST":
+		return clientList(args[2:])
+
+	case "KILL":
+		return clientKill(c, args[2:])
+
+	case "PAUSE":
+		if len(args) != 3 && len(args) != 4 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil || ms < 0 {
+			return "-ERR timeout is not an integer or out of range\r\n", fmt.Errorf("bad timeout")
+		}
+		writeOnly := false
+		if len(args) == 4 {
+			switch strings.ToUpper(args[3]) {
+			case "WRITE":
+				writeOnly = true
+			case "ALL":
+			default:
+				return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+			}
+		}
+		pauseClients(time.Duration(ms)*time.Millisecond, writeOnly)
+		return "+OK\r\n", nil
+
+	case "UNPAUSE":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		unpauseClients()
+		return "+OK\r\n", nil
+
+	default:
+		return "-ERR unknown subcommand '" + args[1] + "'. Try CLIENT ID, GETNAME, SETNAME, INFO, LIST, KILL, PAUSE, UNPAUSE.\r\n",
+			fmt.Errorf("unknown subcommand")
+	}
+}
+
+// CLIENT LIST [TYPE normal|master|replica|pubsub] [ID id [id ...]]
+func clientList(opts []string) (string, error) {
+	var ids map[int64]bool
+	var typeFilter string
+
+	for i := 0; i < len(opts); i++ {
+		switch opt := strings.ToUpper(opts[i]); {
+		case opt == "TYPE" && i+1 < len(opts):
+			typ, ok := parseClientType(opts[i+1])
+			if !ok {
+				return "-ERR Unknown client type '" + opts[i+1] + "'\r\n", fmt.Errorf("bad type")
+			}
+			typeFilter = typ
+			i++
+		case opt == "ID" && i+1 < len(opts):
+			ids = map[int64]bool{}
+			for i++; i < len(opts); i++ {
+				id, err := strconv.ParseInt(opts[i], 10, 64)
+				if err != nil || id <= 0 {
+					return "-ERR Invalid client ID\r\n", fmt.Errorf("bad id")
+				}
+				ids[id] = true
+			}
+		default:
+			return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+		}
+	}
+
+	var sb strings.Builder
+	for _, other := range sortedClients() {
+		if ids != nil && !ids[other.id] || typeFilter != "" && other.snapshot().typ != typeFilter {
+			continue
+		}
+		sb.WriteString(other.infoLine() + "\n")
+	}
+	return bulkString(sb.String()), nil
+}
+
+// parseClientType checks the argument of TYPE. slave is an alias of
+// replica. A replica's link to its master and pubsub connections are not
+// clients here, so master and pubsub are accepted but never match.
+func parseClientType(name string) (string, bool) {
+	switch typ := strings.ToLower(name); typ {
+	case "normal", "master", "replica", "pubsub":
+		return typ, true
+	case "slave":
+		return "replica", true
+	}
+	return "", false
+}
+
+// CLIENT KILL ip:port, or CLIENT KILL [ID id] [TYPE type] [ADDR ip:port]
+// [LADDR ip:port] [USER username] [SKIPME yes|no] [MAXAGE seconds]
+func clientKill(c *Client, opts []string) (string, error) {
+	if len(opts) == 0 {
+		return "-ERR wrong number of arguments for 'CLIENT|kill' command\r\n", fmt.Errorf("wrong args")
+	}
+
+	// Old form: a single address, +OK or an error
+	if len(opts) == 1 {
+		for _, other := range sortedClients() {
+			if other.conn.RemoteAddr().String() == opts[0] {
+				killClient(c, other)
+				return "+OK\r\n", nil
+			}
+		}
+		return "-ERR No such client\r\n", fmt.Errorf("no such client")
+	}
+
+	if len(opts)%2 != 0 {
+		return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+	}
+
+	var (
+		id                int64
+		addr, laddr, user string
+		typ               string
+		maxAge            int64
+		skipMe            = true
+	)
+	for i := 0; i < len(opts); i += 2 {
+		value := opts[i+1]
+		switch strings.ToUpper(opts[i]) {
+		case "ID":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				return "-ERR client-id should be greater than 0\r\n", fmt.Errorf("bad id")
+			}
+			id = n
+		case "TYPE":
+			t, ok := parseClientType(value)
+			if !ok {
+				return "-ERR Unknown client type '" + value + "'\r\n", fmt.Errorf("bad type")
+			}
+			typ = t
+		case "ADDR":
+			addr = value
+		case "LADDR":
+			laddr = value
+		case "USER":
+			user = value
+		case "MAXAGE":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				return "-ERR value is not an integer or out of range\r\n", fmt.Errorf("bad maxage")
+			}
+			maxAge = n
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+			}
+		default:
+			return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+		}
+	}
+
+	killed := 0
+	for _, other := range sortedClients() {
+		switch {
+		case id != 0 && other.id != id,
+			typ != "" && other.snapshot().typ != typ,
+			addr != "" && other.conn.RemoteAddr().String() != addr,
+			laddr != "" && other.conn.LocalAddr().String() != laddr,
+			user != "" && other.snapshot().user != user,
+			maxAge != 0 && time.Since(other.created) < time.Duration(maxAge)*time.Second,
+			skipMe && other == c:
+			continue
+		}
+		killClient(c, other)
+		killed++
+	}
+	return ":" + strconv.Itoa(killed) + "\r\n", nil
+}
+
+// killClient disconnects target. The calling client gets its reply first.
+func killClient(c, target *Client) {
+	if target == c {
+		c.closeAfter = true
+		return
+	}
+	target.conn.Close()
+}
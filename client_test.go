@@ -0,0 +1,218 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientID returns the id of c as the server knows it
+func clientID(c *testClient) string {
+	c.t.Helper()
+	reply := c.do("CLIENT", "ID")
+	return strings.TrimSuffix(strings.TrimPrefix(reply, ":"), "\r\n")
+}
+
+func TestClientNameAndInfo(t *testing.T) {
+	c := dialTestClient(t, startTestServer(t))
+
+	if got := c.do("CLIENT", "GETNAME"); got != "$-1\r\n" {
+		t.Fatalf("GETNAME before SETNAME: %q", got)
+	}
+	if got := c.do("CLIENT", "SETNAME", "bad name"); !strings.HasPrefix(got, "-ERR Client names cannot contain spaces") {
+		t.Fatalf("SETNAME with a space: %q", got)
+	}
+	if got := c.do("CLIENT", "SETNAME", "worker-1"); got != "+OK\r\n" {
+		t.Fatalf("SETNAME: %q", got)
+	}
+	if got := c.do("CLIENT", "GETNAME"); got != "$8\r\nworker-1\r\n" {
+		t.Fatalf("GETNAME: %q", got)
+	}
+
+	c.do("SELECT", "2")
+	info := c.do("CLIENT", "INFO")
+	id := clientID(c)
+	for _, want := range []string{"id=" + id + " ", "name=worker-1 ", "db=2 ", "cmd=client ", "user=default ", "laddr=127.0.0.1:"} {
+		if !strings.Contains(info, want) {
+			t.Fatalf("CLIENT INFO missing %q: %q", want, info)
+		}
+	}
+}
+
+func TestClientList(t *testing.T) {
+	addr := startTestServer(t)
+	a := dialTestClient(t, addr)
+	b := dialTestClient(t, addr)
+
+	b.do("CLIENT", "SETNAME", "bee")
+	b.do("GET", "k")
+	bID := clientID(b)
+
+	list := a.do("CLIENT", "LIST")
+	if !strings.Contains(list, "id="+bID+" ") || !strings.Contains(list, "name=bee ") {
+		t.Fatalf("CLIENT LIST doesn't show b: %q", list)
+	}
+	if strings.Count(list, "id=") < 2 {
+		t.Fatalf("CLIENT LIST should have a line per client: %q", list)
+	}
+
+	only := a.do("CLIENT", "LIST", "ID", bID)
+	if strings.Count(only, "id=") != 1 || !strings.Contains(only, "name=bee ") {
+		t.Fatalf("CLIENT LIST ID: %q", only)
+	}
+	// A MONITOR feed is still a normal client, flagged O
+	mon := dialTestClient(t, addr)
+	monID := clientID(mon)
+	mon.do("MONITOR")
+	if got := a.do("CLIENT", "LIST", "TYPE", "normal"); !strings.Contains(got, "id="+monID+" ") || !strings.Contains(got, "flags=O ") || !strings.Contains(got, "id="+bID+" ") {
+		t.Fatalf("CLIENT LIST TYPE normal: %q", got)
+	}
+	for _, typ := range []string{"replica", "slave"} {
+		if got := a.do("CLIENT", "LIST", "TYPE", typ); got != "$0\r\n\r\n" {
+			t.Fatalf("CLIENT LIST TYPE %s: %q", typ, got)
+		}
+	}
+	if got := a.do("CLIENT", "LIST", "TYPE", "pubsub"); got != "$0\r\n\r\n" {
+		t.Fatalf("CLIENT LIST TYPE pubsub: %q", got)
+	}
+	if got := a.do("CLIENT", "LIST", "TYPE", "bogus"); !strings.HasPrefix(got, "-ERR Unknown client type") {
+		t.Fatalf("CLIENT LIST TYPE bogus: %q", got)
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	addr := startTestServer(t)
+	admin := dialTestClient(t, addr)
+	victim := dialTestClient(t, addr)
+	bystander := dialTestClient(t, addr)
+
+	if got := admin.do("CLIENT", "KILL", "ID", clientID(victim)); got != ":1\r\n" {
+		t.Fatalf("KILL ID: %q", got)
+	}
+	if reply, err := readReply(victim.reader); err == nil {
+		t.Fatalf("killed client still connected: %q", reply)
+	}
+	if got := bystander.do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("bystander: %q", got)
+	}
+
+	// Old form takes the address as CLIENT LIST shows it
+	if got := admin.do("CLIENT", "KILL", bystander.conn.LocalAddr().String()); got != "+OK\r\n" {
+		t.Fatalf("KILL addr: %q", got)
+	}
+	if got := admin.do("CLIENT", "KILL", "127.0.0.1:1"); got != "-ERR No such client\r\n" {
+		t.Fatalf("KILL unknown addr: %q", got)
+	}
+
+	// SKIPME yes (the default) spares the caller
+	if got := admin.do("CLIENT", "KILL", "USER", "default"); got != ":0\r\n" {
+		t.Fatalf("KILL USER with SKIPME: %q", got)
+	}
+	if got := admin.do("CLIENT", "KILL", "USER", "default", "SKIPME", "no"); got != ":1\r\n" {
+		t.Fatalf("KILL USER SKIPME no: %q", got)
+	}
+	if _, err := admin.reader.ReadByte(); err != io.EOF {
+		t.Fatalf("caller not disconnected after killing itself: %v", err)
+	}
+}
+
+func TestClientKillType(t *testing.T) {
+	resetReplication(t)
+	addr := startTestServer(t)
+	admin := dialTestClient(t, addr)
+	normal := dialTestClient(t, addr)
+	mon := dialTestClient(t, addr)
+	mon.do("MONITOR")
+	replica := dialTestClient(t, addr)
+	replica.send("PSYNC", "?", "-1")
+	if header, _ := replica.reader.ReadString('\n'); !strings.HasPrefix(header, "+FULLRESYNC ") {
+		t.Fatalf("PSYNC: %q", header)
+	}
+
+	if got := admin.do("CLIENT", "KILL", "TYPE", "bogus"); !strings.HasPrefix(got, "-ERR Unknown client type") {
+		t.Fatalf("KILL TYPE bogus: %q", got)
+	}
+	if got := admin.do("CLIENT", "KILL", "TYPE", "master"); got != ":0\r\n" {
+		t.Fatalf("KILL TYPE master: %q", got)
+	}
+
+	// Only the replica goes, the monitor is a normal client
+	if got := admin.do("CLIENT", "KILL", "TYPE", "replica"); got != ":1\r\n" {
+		t.Fatalf("KILL TYPE replica: %q", got)
+	}
+	if _, err := io.ReadAll(replica.reader); err != nil {
+		t.Fatalf("replica not disconnected: %v", err)
+	}
+	if got := normal.do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("normal client: %q", got)
+	}
+
+	if got := admin.do("CLIENT", "KILL", "TYPE", "normal"); got != ":2\r\n" {
+		t.Fatalf("KILL TYPE normal: %q", got)
+	}
+	for {
+		if _, err := readReply(mon.reader); err != nil {
+			break
+		}
+	}
+}
+
+func TestClientPauseWrite(t *testing.T) {
+	t.Cleanup(unpauseClients)
+	addr := startTestServer(t)
+	admin := dialTestClient(t, addr)
+	writer := dialTestClient(t, addr)
+
+	if got := admin.do("CLIENT", "PAUSE", "10000", "WRITE"); got != "+OK\r\n" {
+		t.Fatalf("PAUSE: %q", got)
+	}
+
+	// Reads go through, writes wait
+	if got := writer.do("GET", "k"); got != "$-1\r\n" {
+		t.Fatalf("GET during PAUSE WRITE: %q", got)
+	}
+	writer.send("SET", "k", "v")
+	replied := make(chan string)
+	go func() { replied <- writer.read() }()
+
+	select {
+	case got := <-replied:
+		t.Fatalf("SET ran during PAUSE WRITE: %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	admin.do("CLIENT", "UNPAUSE")
+	select {
+	case got := <-replied:
+		if got != "+OK\r\n" {
+			t.Fatalf("SET after UNPAUSE: %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SET still blocked after UNPAUSE")
+	}
+}
+
+func TestClientPauseAllTimesOut(t *testing.T) {
+	t.Cleanup(unpauseClients)
+	addr := startTestServer(t)
+	admin := dialTestClient(t, addr)
+	reader := dialTestClient(t, addr)
+
+	start := time.Now()
+	admin.do("CLIENT", "PAUSE", strconv.Itoa(150))
+	if got := reader.do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("PING after pause: %q", got)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("PING wasn't paused (took %v)", elapsed)
+	}
+
+	if got := admin.do("CLIENT", "PAUSE", "-1"); !strings.HasPrefix(got, "-ERR timeout") {
+		t.Fatalf("negative timeout: %q", got)
+	}
+	if got := admin.do("CLIENT", "PAUSE", "10", "SOMETIMES"); got != "-ERR syntax error\r\n" {
+		t.Fatalf("bad mode: %q", got)
+	}
+}
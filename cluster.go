@@ -0,0 +1,1498 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// clusterSlots is the number of hash slots the keyspace is split into
+const clusterSlots = 16384
+
+// Set by the cluster-* config directives
+var (
+	clusterEnabled     bool
+	clusterConfigFile  = "nodes.conf"
+	clusterNodeTimeout = newAtomicInt(15000) // milliseconds, changed live by CONFIG SET
+	clusterPort        int                   // the bus port, 0 means port + 10000
+)
+
+const (
+	clusterPortIncr   = 10000
+	clusterCronPeriod = 100 * time.Millisecond
+)
+
+var crc16Table [256]uint16
+
+func init() {
+	for i := range crc16Table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+// crc16 is CRC16-CCITT (XMODEM), the checksum keys are hashed with
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// keyHashSlot maps key to its slot. When the key has a non empty
+// {hashtag} only the tag is hashed, so related keys can share a slot.
+func keyHashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) & (clusterSlots - 1)
+}
+
+// clusterNode is a member of the cluster, myself included
+type clusterNode struct {
+	id      string
+	host    string // empty until known
+	port    int
+	busPort int
+
+	myself    bool
+	handshake bool // met by address, id is a placeholder until it answers
+
+	configEpoch int64
+
+	pingSent     time.Time // oldest ping still waiting for a pong
+	pongReceived time.Time
+	pingNow      bool // ping at the next tick, the config changed
+	connected    bool // the outgoing bus link is up
+
+	fail        bool
+	failTime    time.Time
+	failReports map[string]time.Time // reporting master id -> when
+
+	outbox chan []string // messages besides the periodic ping
+	done   chan struct{} // closed once the node is forgotten
+}
+
+func (n *clusterNode) addr() string {
+	return net.JoinHostPort(n.host, strconv.Itoa(n.port))
+}
+
+func (n *clusterNode) busAddr() string {
+	return net.JoinHostPort(n.host, strconv.Itoa(n.busPort))
+}
+
+// pfail tells whether the node missed its pong for longer than the node
+// timeout, which only makes it a suspect until a majority agrees
+func (n *clusterNode) pfail(now time.Time) bool {
+	return !n.pingSent.IsZero() && now.Sub(n.pingSent) > nodeTimeout()
+}
+
+// Cluster state, guarded by clusterMu
+var (
+	clusterMu           sync.Mutex
+	clusterMyself       *clusterNode
+	clusterNodes        = map[string]*clusterNode{}
+	clusterCurrentEpoch int64
+	clusterStateOK      bool
+
+	clusterSlotOwner [clusterSlots]*clusterNode
+	clusterMigrating [clusterSlots]*clusterNode // slots handed over, by destination
+	clusterImporting [clusterSlots]*clusterNode // slots taken over, by source
+
+	// clusterStarted is set once the bus runs; nodes added before that,
+	// by the config file or by tests, get no link
+	clusterStarted bool
+)
+
+var (
+	clusterMessagesSent     atomic.Int64
+	clusterMessagesReceived atomic.Int64
+)
+
+func nodeTimeout() time.Duration {
+	return time.Duration(clusterNodeTimeout.Load()) * time.Millisecond
+}
+
+// clusterPingPeriod is how often every node is pinged
+func clusterPingPeriod() time.Duration {
+	return min(time.Second, nodeTimeout()/2)
+}
+
+func clusterBusPort() int {
+	if clusterPort != 0 {
+		return clusterPort
+	}
+	return serverPort + clusterPortIncr
+}
+
+// startCluster loads or creates the node configuration, then opens the
+// cluster bus and starts gossiping with the known nodes
+func startCluster() error {
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+
+	if err := clusterLoadConfig(); err != nil {
+		return err
+	}
+	if clusterMyself == nil {
+		clusterMyself = &clusterNode{id: newRunID(), myself: true}
+		clusterNodes[clusterMyself.id] = clusterMyself
+		fmt.Printf("[Cluster] No cluster configuration found, I'm %s\n", clusterMyself.id)
+	}
+	clusterMyself.port = serverPort
+	clusterMyself.busPort = clusterBusPort()
+	if err := clusterSaveConfig(); err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(serverBind, strconv.Itoa(clusterMyself.busPort))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[Cluster] Bus is listening on %s ...\n", addr)
+
+	clusterStarted = true
+	for _, n := range clusterNodes {
+		if !n.myself {
+			go clusterNodeLink(n)
+		}
+	}
+	clusterUpdateState()
+
+	go clusterServeBus(listener)
+	go clusterCron()
+	return nil
+}
+
+// clusterAddNode registers a node and starts its link. Caller holds
+// clusterMu.
+func clusterAddNode(id, host string, port, busPort int) *clusterNode {
+	n := &clusterNode{
+		id:          id,
+		host:        host,
+		port:        port,
+		busPort:     busPort,
+		failReports: map[string]time.Time{},
+		outbox:      make(chan []string, 16),
+		done:        make(chan struct{}),
+	}
+	clusterNodes[id] = n
+	if clusterStarted {
+		go clusterNodeLink(n)
+	}
+	return n
+}
+
+// clusterForgetNode drops a node and every slot it served. Caller holds
+// clusterMu.
+func clusterForgetNode(n *clusterNode) {
+	delete(clusterNodes, n.id)
+	for slot := 0; slot < clusterSlots; slot++ {
+		if clusterSlotOwner[slot] == n {
+			clusterSlotOwner[slot] = nil
+		}
+		if clusterMigrating[slot] == n {
+			clusterMigrating[slot] = nil
+		}
+		if clusterImporting[slot] == n {
+			clusterImporting[slot] = nil
+		}
+	}
+	for _, other := range clusterNodes {
+		delete(other.failReports, n.id)
+	}
+	close(n.done)
+}
+
+// clusterBroadcast queues msg for every other node. Caller holds
+// clusterMu.
+func clusterBroadcast(msg []string) {
+	for _, n := range clusterNodes {
+		if n.myself || n.handshake {
+			continue
+		}
+		select {
+		case n.outbox <- msg:
+		default: // the link is stuck, it'll catch up with the next ping
+		}
+	}
+}
+
+// clusterPingAll makes every link ping at its next tick, spreading a
+// config change right away. Caller holds clusterMu.
+func clusterPingAll() {
+	for _, n := range clusterNodes {
+		n.pingNow = true
+	}
+}
+
+// clusterNodeSlots returns the slots n serves. Caller holds clusterMu.
+func clusterNodeSlots(n *clusterNode) []int {
+	var slots []int
+	for slot, owner := range clusterSlotOwner {
+		if owner == n {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// clusterSize is the number of masters serving slots, the ones that vote
+// on failures. Caller holds clusterMu.
+func clusterSize() int {
+	serving := map[*clusterNode]bool{}
+	for _, owner := range clusterSlotOwner {
+		if owner != nil {
+			serving[owner] = true
+		}
+	}
+	return len(serving)
+}
+
+// clusterUpdateState sets the cluster ok when every slot is served by a
+// node that isn't failing. Caller holds clusterMu.
+func clusterUpdateState() {
+	ok := true
+	for _, owner := range clusterSlotOwner {
+		if owner == nil || owner.fail {
+			ok = false
+			break
+		}
+	}
+	if ok != clusterStateOK {
+		fmt.Printf("[Cluster] Cluster state changed: %s\n", clusterStateName(ok))
+	}
+	clusterStateOK = ok
+}
+
+func clusterStateName(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "fail"
+}
+
+// slotRanges renders slots, which are sorted, as "0-5460,5461"
+func slotRanges(slots []int) string {
+	var parts []string
+	for i := 0; i < len(slots); {
+		j := i
+		for j+1 < len(slots) && slots[j+1] == slots[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(slots[i]))
+		} else {
+			parts = append(parts, strconv.Itoa(slots[i])+"-"+strconv.Itoa(slots[j]))
+		}
+		i = j + 1
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseSlotRanges reads what slotRanges wrote
+func parseSlotRanges(s string) ([]int, error) {
+	if s == "-" {
+		return nil, nil
+	}
+	var slots []int
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := parseSlot(first)
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if isRange {
+			if end, err = parseSlot(last); err != nil {
+				return nil, err
+			}
+		}
+		for slot := start; slot <= end; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	return slots, nil
+}
+
+func parseSlot(s string) (int, error) {
+	slot, err := strconv.Atoi(s)
+	if err != nil || slot < 0 || slot >= clusterSlots {
+		return 0, fmt.Errorf("invalid slot '%s'", s)
+	}
+	return slot, nil
+}
+
+// Bus messages are RESP arrays. PING, PONG and MEET describe the sender
+// and gossip about the nodes it knows:
+//
+//	type id port bus-port current-epoch config-epoch slots
+//	[id host port bus-port flags] ...
+//
+// flags is "pfail", "fail" or "-". The host of the sender is the address
+// its message came from. FAIL is "FAIL sender-id failed-id". Every message
+// is answered with a PONG.
+const clusterHeaderLen = 7
+
+// clusterMessage builds a message of kind about myself. Caller holds
+// clusterMu.
+func clusterMessage(kind string) []string {
+	me := clusterMyself
+	msg := []string{
+		kind,
+		me.id,
+		strconv.Itoa(me.port),
+		strconv.Itoa(me.busPort),
+		strconv.FormatInt(clusterCurrentEpoch, 10),
+		strconv.FormatInt(me.configEpoch, 10),
+		slotRanges(clusterNodeSlots(me)),
+	}
+
+	now := time.Now()
+	for _, n := range clusterNodes {
+		if n.myself || n.handshake || n.host == "" {
+			continue
+		}
+		flags := "-"
+		if n.fail {
+			flags = "fail"
+		} else if n.pfail(now) {
+			flags = "pfail"
+		}
+		msg = append(msg, n.id, n.host, strconv.Itoa(n.port), strconv.Itoa(n.busPort), flags)
+	}
+	return msg
+}
+
+// clusterServeBus accepts connections from the other nodes
+func clusterServeBus(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go clusterHandleBusConn(conn)
+	}
+}
+
+// clusterHandleBusConn answers the messages a node sends over its link
+func clusterHandleBusConn(conn net.Conn) {
+	defer conn.Close()
+
+	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	localIP, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	reader := bufio.NewReader(conn)
+
+	for {
+		conn.SetDeadline(time.Now().Add(2 * nodeTimeout()))
+		msg, err := parseResp(reader)
+		if err != nil {
+			return
+		}
+		clusterMessagesReceived.Add(1)
+
+		clusterMu.Lock()
+		clusterProcessMessage(msg, remoteIP, localIP)
+		reply := clusterMessage("PONG")
+		clusterMu.Unlock()
+
+		if _, err := io.WriteString(conn, buildRESPCommand(reply[0], reply[1:])); err != nil {
+			return
+		}
+		clusterMessagesSent.Add(1)
+	}
+}
+
+// clusterNodeLink pings n every ping period, sends it what is queued in
+// its outbox and handles its pongs, until the node is forgotten
+func clusterNodeLink(n *clusterNode) {
+	var (
+		conn     net.Conn
+		reader   *bufio.Reader
+		lastPing time.Time
+	)
+	disconnect := func() {
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+		clusterMu.Lock()
+		n.connected = false
+		clusterMu.Unlock()
+	}
+	defer disconnect()
+
+	ticker := time.NewTicker(clusterCronPeriod)
+	defer ticker.Stop()
+
+	for {
+		var msg []string
+		select {
+		case <-n.done:
+			return
+		case msg = <-n.outbox:
+		case <-ticker.C:
+			now := time.Now()
+			clusterMu.Lock()
+			if n.pingNow || now.Sub(lastPing) >= clusterPingPeriod() {
+				n.pingNow = false
+				lastPing = now
+				// A node that never answered may not know us: MEET makes
+				// it add us
+				kind := "PING"
+				if n.pongReceived.IsZero() {
+					kind = "MEET"
+				}
+				msg = clusterMessage(kind)
+				if n.pingSent.IsZero() {
+					n.pingSent = now
+				}
+			}
+			clusterMu.Unlock()
+		}
+		if msg == nil {
+			continue
+		}
+
+		if conn == nil {
+			clusterMu.Lock()
+			addr := n.busAddr()
+			clusterMu.Unlock()
+
+			c, err := net.DialTimeout("tcp", addr, nodeTimeout()/2)
+			if err != nil {
+				continue
+			}
+			conn, reader = c, bufio.NewReader(c)
+			clusterMu.Lock()
+			n.connected = true
+			clusterMu.Unlock()
+		}
+
+		conn.SetDeadline(time.Now().Add(nodeTimeout() / 2))
+		if _, err := io.WriteString(conn, buildRESPCommand(msg[0], msg[1:])); err != nil {
+			disconnect()
+			continue
+		}
+		clusterMessagesSent.Add(1)
+
+		reply, err := parseResp(reader)
+		if err != nil {
+			disconnect()
+			continue
+		}
+		clusterMessagesReceived.Add(1)
+
+		localIP, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+		clusterHandlePong(n, reply, localIP)
+	}
+}
+
+// clusterHandlePong records that n answered and applies what it said
+func clusterHandlePong(n *clusterNode, reply []string, localIP string) {
+	if len(reply) < clusterHeaderLen || reply[0] != "PONG" {
+		return
+	}
+
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+
+	if clusterNodes[n.id] != n {
+		return // forgotten meanwhile
+	}
+
+	id := reply[1]
+	if n.id != id {
+		if !n.handshake {
+			return // the node was reset, it isn't the one we knew anymore
+		}
+		// The handshake is over: the node gets its real id, unless it is
+		// already known under it
+		if known, ok := clusterNodes[id]; ok {
+			known.host, known.port, known.busPort = n.host, n.port, n.busPort
+			clusterForgetNode(n)
+			clusterSaveConfig()
+			return
+		}
+		delete(clusterNodes, n.id)
+		n.id, n.handshake = id, false
+		clusterNodes[id] = n
+		fmt.Printf("[Cluster] Handshake with %s completed, node id %s\n", n.addr(), id)
+	}
+
+	now := time.Now()
+	n.pingSent = time.Time{}
+	n.pongReceived = now
+
+	// No replica takes over here, so a failed master is trusted again
+	// once it answers for a while, and one without slots right away
+	if n.fail && (len(clusterNodeSlots(n)) == 0 || now.Sub(n.failTime) > 2*nodeTimeout()) {
+		n.fail = false
+		n.failReports = map[string]time.Time{}
+		fmt.Printf("[Cluster] Clear FAIL state for node %s: it is reachable again\n", n.id)
+		clusterUpdateState()
+		clusterSaveConfig()
+	}
+
+	clusterProcessMessage(reply, n.host, localIP)
+}
+
+// clusterProcessMessage applies a message received from remoteIP over a
+// connection to localIP. Caller holds clusterMu.
+func clusterProcessMessage(msg []string, remoteIP, localIP string) {
+	changed := false
+	defer func() {
+		if changed {
+			clusterUpdateState()
+			clusterSaveConfig()
+		}
+	}()
+
+	// Nodes don't know the address the others reach them on until they
+	// are contacted
+	if clusterMyself.host == "" && localIP != "" {
+		clusterMyself.host = localIP
+		changed = true
+	}
+
+	if len(msg) == 3 && msg[0] == "FAIL" {
+		if n, ok := clusterNodes[msg[2]]; ok && !n.myself && !n.fail {
+			n.fail, n.failTime = true, time.Now()
+			fmt.Printf("[Cluster] FAIL message received from %s about %s\n", msg[1], n.id)
+			changed = true
+		}
+		return
+	}
+
+	if len(msg) < clusterHeaderLen || (len(msg)-clusterHeaderLen)%5 != 0 {
+		return
+	}
+	id := msg[1]
+	port, err1 := strconv.Atoi(msg[2])
+	busPort, err2 := strconv.Atoi(msg[3])
+	currentEpoch, err3 := strconv.ParseInt(msg[4], 10, 64)
+	configEpoch, err4 := strconv.ParseInt(msg[5], 10, 64)
+	slots, err5 := parseSlotRanges(msg[6])
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil || id == clusterMyself.id {
+		return
+	}
+
+	sender := clusterNodes[id]
+	if sender == nil {
+		// Only a MEET lets an unknown node in, the others learn about it
+		// through gossip
+		if msg[0] != "MEET" || remoteIP == "" {
+			return
+		}
+		sender = clusterAddNode(id, remoteIP, port, busPort)
+		fmt.Printf("[Cluster] Node %s met us from %s\n", id, sender.addr())
+		changed = true
+	}
+
+	if remoteIP != "" && (sender.host != remoteIP || sender.port != port || sender.busPort != busPort) {
+		sender.host, sender.port, sender.busPort = remoteIP, port, busPort
+		changed = true
+	}
+	if currentEpoch > clusterCurrentEpoch {
+		clusterCurrentEpoch = currentEpoch
+		changed = true
+	}
+	if configEpoch > sender.configEpoch {
+		sender.configEpoch = configEpoch
+		changed = true
+	}
+
+	if clusterUpdateSlots(sender, slots) {
+		changed = true
+	}
+
+	// Two masters with the same config epoch could both win a slot: the
+	// one with the lower id moves on to a new epoch
+	if sender.configEpoch == clusterMyself.configEpoch && sender.id > clusterMyself.id {
+		clusterCurrentEpoch++
+		clusterMyself.configEpoch = clusterCurrentEpoch
+		fmt.Printf("[Cluster] Config epoch collision with %s, moved to epoch %d\n", sender.id, clusterCurrentEpoch)
+		changed = true
+	}
+
+	if clusterGossip(sender, msg[clusterHeaderLen:]) {
+		changed = true
+	}
+}
+
+// clusterUpdateSlots gives sender the slots it claims, unless a node
+// with a newer config epoch serves them. Slots being imported are left
+// alone, the migration decides where they end up. Caller holds clusterMu.
+func clusterUpdateSlots(sender *clusterNode, slots []int) bool {
+	changed := false
+	for _, slot := range slots {
+		owner := clusterSlotOwner[slot]
+		if owner == sender || clusterImporting[slot] != nil {
+			continue
+		}
+		if owner == nil || owner.configEpoch < sender.configEpoch {
+			clusterSlotOwner[slot] = sender
+			clusterMigrating[slot] = nil
+			changed = true
+		}
+	}
+	return changed
+}
+
+// clusterGossip applies the gossip section of a message from sender:
+// unknown nodes are added and failure reports are collected. Caller
+// holds clusterMu.
+func clusterGossip(sender *clusterNode, entries []string) bool {
+	changed := false
+	voter := len(clusterNodeSlots(sender)) > 0
+	now := time.Now()
+
+	for i := 0; i+5 <= len(entries); i += 5 {
+		id, host, flags := entries[i], entries[i+1], entries[i+4]
+		port, err1 := strconv.Atoi(entries[i+2])
+		busPort, err2 := strconv.Atoi(entries[i+3])
+		if err1 != nil || err2 != nil || id == clusterMyself.id {
+			continue
+		}
+
+		n := clusterNodes[id]
+		if n == nil {
+			if flags == "fail" || clusterHandshakeInProgress(host, port) {
+				continue
+			}
+			n = clusterAddNode(id, host, port, busPort)
+			fmt.Printf("[Cluster] Learned about node %s at %s from %s\n", id, n.addr(), sender.id)
+			changed = true
+			continue
+		}
+
+		if voter {
+			if flags == "pfail" || flags == "fail" {
+				n.failReports[sender.id] = now
+			} else {
+				delete(n.failReports, sender.id)
+			}
+		}
+	}
+	return changed
+}
+
+// clusterHandshakeInProgress tells whether a node at host:port was met
+// and hasn't answered yet. Caller holds clusterMu.
+func clusterHandshakeInProgress(host string, port int) bool {
+	for _, n := range clusterNodes {
+		if n.handshake && n.host == host && n.port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// clusterCron checks the other nodes every cron period
+func clusterCron() {
+	ticker := time.NewTicker(clusterCronPeriod)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		clusterMu.Lock()
+		clusterCheckNodes(time.Now())
+		clusterMu.Unlock()
+	}
+}
+
+// clusterCheckNodes drops stale handshakes and failure reports, and
+// marks the suspected nodes enough masters agree about as failing.
+// Caller holds clusterMu.
+func clusterCheckNodes(now time.Time) {
+	for _, n := range clusterNodes {
+		if n.myself {
+			continue
+		}
+
+		// A handshake that goes nowhere is dropped
+		if n.handshake && !n.pingSent.IsZero() && now.Sub(n.pingSent) > nodeTimeout() {
+			fmt.Printf("[Cluster] Handshake with %s timed out\n", n.addr())
+			clusterForgetNode(n)
+			continue
+		}
+
+		for reporter, at := range n.failReports {
+			if now.Sub(at) > 2*nodeTimeout() {
+				delete(n.failReports, reporter)
+			}
+		}
+
+		if n.fail || n.handshake || !n.pfail(now) {
+			continue
+		}
+		// Our own opinion counts as well
+		failures := len(n.failReports) + 1
+		if failures >= clusterSize()/2+1 {
+			n.fail, n.failTime = true, now
+			fmt.Printf("[Cluster] Marking node %s as failing (quorum reached)\n", n.id)
+			clusterBroadcast([]string{"FAIL", clusterMyself.id, n.id})
+			clusterUpdateState()
+			clusterSaveConfig()
+		}
+	}
+}
+
+// clusterMeet starts a handshake with the node at host:port. Caller holds
+// clusterMu.
+func clusterMeet(host string, port, busPort int) {
+	n := clusterAddNode(newRunID(), host, port, busPort)
+	n.handshake = true
+	n.pingNow = true
+}
+
+// clusterRedirect returns the reply for a command whose keys this node
+// doesn't serve: -MOVED to the owner of the slot, or -ASK to the node it
+// is migrating to once the keys are gone from here. Commands the cluster
+// can't serve at all get -CROSSSLOT or -CLUSTERDOWN. "" means the
+// command runs here. It is the last step before execCommand, which stays
+// unaware of the cluster since replay and replication go through it too.
+func clusterRedirect(c *Client, def *Command, args []string) string {
+	asking := c.asking
+	c.asking = false
+
+	keys := keyArgs(def, args)
+	if len(keys) == 0 {
+		return ""
+	}
+	slot := keyHashSlot(keys[0])
+	for _, key := range keys[1:] {
+		if keyHashSlot(key) != slot {
+			return "-CROSSSLOT Keys in request don't hash to the same slot\r\n"
+		}
+	}
+
+	clusterMu.Lock()
+	ok := clusterStateOK
+	owner, migrating, importing := clusterSlotOwner[slot], clusterMigrating[slot], clusterImporting[slot]
+	myself := clusterMyself
+	var target string
+	if owner != nil {
+		target = owner.addr()
+	}
+	if migrating != nil {
+		target = migrating.addr()
+	}
+	clusterMu.Unlock()
+
+	// MIGRATE only ever works on keys that are here
+	migrate := def.Func != nil && strings.EqualFold(args[0], "MIGRATE")
+
+	switch {
+	case !ok:
+		return "-CLUSTERDOWN The cluster is down\r\n"
+	case owner == nil:
+		return "-CLUSTERDOWN Hash slot not served\r\n"
+
+	case owner == myself && migrating != nil && !migrate:
+		missing := missingKeys(keys)
+		if missing == 0 {
+			return ""
+		}
+		if missing < len(keys) {
+			return "-TRYAGAIN Multiple keys request during rehashing of slot\r\n"
+		}
+		return "-ASK " + strconv.Itoa(slot) + " " + target + "\r\n"
+
+	case owner == myself:
+		return ""
+
+	case importing != nil && (asking || migrate || def.hasFlag("asking")):
+		if len(keys) > 1 && missingKeys(keys) > 0 {
+			return "-TRYAGAIN Multiple keys request during rehashing of slot\r\n"
+		}
+		return ""
+	}
+	return "-MOVED " + strconv.Itoa(slot) + " " + target + "\r\n"
+}
+
+// missingKeys counts the keys that don't exist in database 0
+func missingKeys(keys []string) int {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	now := time.Now().Unix()
+	missing := 0
+	for _, key := range keys {
+		entry, exists := databases[0][key]
+		if !exists || (entry.ExpireAt != 0 && entry.ExpireAt <= now) {
+			missing++
+		}
+	}
+	return missing
+}
+
+// ASKING lets the next command run in a slot being imported
+func cmdASKING(c *Client, args []string) (string, error) {
+	if !clusterEnabled {
+		return "-ERR This instance has cluster support disabled\r\n", fmt.Errorf("cluster disabled")
+	}
+	c.asking = true
+	return "+OK\r\n", nil
+}
+
+// clusterNodeFlags renders the flags column of CLUSTER NODES
+func clusterNodeFlags(n *clusterNode, now time.Time) string {
+	var flags []string
+	if n.myself {
+		flags = append(flags, "myself")
+	}
+	flags = append(flags, "master")
+	if n.fail {
+		flags = append(flags, "fail")
+	} else if n.pfail(now) {
+		flags = append(flags, "fail?")
+	}
+	if n.handshake {
+		flags = append(flags, "handshake")
+	}
+	if n.host == "" && !n.myself {
+		flags = append(flags, "noaddr")
+	}
+	return strings.Join(flags, ",")
+}
+
+// clusterNodeLine renders n as a CLUSTER NODES line:
+// id host:port@bus-port flags master ping-sent pong-recv config-epoch
+// link-state slot ...
+// Migrations are listed by the source as [slot->-id] and by the
+// destination as [slot-<-id]. Caller holds clusterMu.
+func clusterNodeLine(n *clusterNode, now time.Time) string {
+	link := "disconnected"
+	if n.myself || n.connected {
+		link = "connected"
+	}
+	fields := []string{
+		n.id,
+		n.host + ":" + strconv.Itoa(n.port) + "@" + strconv.Itoa(n.busPort),
+		clusterNodeFlags(n, now),
+		"-",
+		strconv.FormatInt(unixMillis(n.pingSent), 10),
+		strconv.FormatInt(unixMillis(n.pongReceived), 10),
+		strconv.FormatInt(n.configEpoch, 10),
+		link,
+	}
+	if ranges := slotRanges(clusterNodeSlots(n)); ranges != "-" {
+		fields = append(fields, strings.Split(ranges, ",")...)
+	}
+	if n.myself {
+		for slot := 0; slot < clusterSlots; slot++ {
+			if dest := clusterMigrating[slot]; dest != nil {
+				fields = append(fields, "["+strconv.Itoa(slot)+"->-"+dest.id+"]")
+			}
+			if source := clusterImporting[slot]; source != nil {
+				fields = append(fields, "["+strconv.Itoa(slot)+"-<-"+source.id+"]")
+			}
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+func unixMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// sortedClusterNodes returns the known nodes by id. Caller holds
+// clusterMu.
+func sortedClusterNodes() []*clusterNode {
+	nodes := make([]*clusterNode, 0, len(clusterNodes))
+	for _, n := range clusterNodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+	return nodes
+}
+
+// clusterNodesText is the CLUSTER NODES reply, and the content of the
+// cluster config file. Caller holds clusterMu.
+func clusterNodesText() string {
+	now := time.Now()
+	var sb strings.Builder
+	for _, n := range sortedClusterNodes() {
+		sb.WriteString(clusterNodeLine(n, now) + "\n")
+	}
+	return sb.String()
+}
+
+// clusterSaveConfig writes the nodes, their slots and the epochs to the
+// cluster config file, which is replaced atomically. Nodes still in
+// handshake are left out. Caller holds clusterMu.
+func clusterSaveConfig() error {
+	if clusterMyself == nil {
+		return nil
+	}
+
+	now := time.Now()
+	var sb strings.Builder
+	for _, n := range sortedClusterNodes() {
+		if !n.handshake {
+			sb.WriteString(clusterNodeLine(n, now) + "\n")
+		}
+	}
+	sb.WriteString("vars currentEpoch " + strconv.FormatInt(clusterCurrentEpoch, 10) + " lastVoteEpoch 0\n")
+
+	tmp := clusterConfigFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0644); err != nil {
+		fmt.Printf("[Cluster] Error saving %s: %v\n", clusterConfigFile, err)
+		return err
+	}
+	if err := os.Rename(tmp, clusterConfigFile); err != nil {
+		fmt.Printf("[Cluster] Error saving %s: %v\n", clusterConfigFile, err)
+		return err
+	}
+	return nil
+}
+
+// clusterLoadConfig restores the nodes saved in the cluster config file,
+// if there is one. Caller holds clusterMu.
+func clusterLoadConfig() error {
+	data, err := os.ReadFile(clusterConfigFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	type migration struct {
+		slot      int
+		importing bool
+		node      string
+	}
+	var migrations []migration
+
+	for lineNum, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		bad := func(reason string) error {
+			return fmt.Errorf("%s line %d: %s", clusterConfigFile, lineNum+1, reason)
+		}
+
+		if fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				if fields[i] == "currentEpoch" {
+					clusterCurrentEpoch, _ = strconv.ParseInt(fields[i+1], 10, 64)
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return bad("too few fields")
+		}
+
+		hostPort, bus, _ := strings.Cut(fields[1], "@")
+		sep := strings.LastIndexByte(hostPort, ':')
+		if sep < 0 {
+			return bad("invalid address")
+		}
+		port, err1 := strconv.Atoi(hostPort[sep+1:])
+		busPort, err2 := strconv.Atoi(bus)
+		configEpoch, err3 := strconv.ParseInt(fields[6], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return bad("invalid number")
+		}
+
+		n := clusterAddNode(fields[0], hostPort[:sep], port, busPort)
+		n.configEpoch = configEpoch
+		for _, flag := range strings.Split(fields[2], ",") {
+			switch flag {
+			case "myself":
+				n.myself = true
+				clusterMyself = n
+			case "fail":
+				n.fail, n.failTime = true, time.Now()
+			}
+		}
+
+		for _, field := range fields[8:] {
+			if strings.HasPrefix(field, "[") {
+				spec := strings.Trim(field, "[]")
+				imp := strings.Contains(spec, "-<-")
+				slotStr, node, found := strings.Cut(spec, "->-")
+				if imp {
+					slotStr, node, found = strings.Cut(spec, "-<-")
+				}
+				slot, err := parseSlot(slotStr)
+				if !found || err != nil {
+					return bad("invalid migration " + field)
+				}
+				migrations = append(migrations, migration{slot, imp, node})
+				continue
+			}
+			slots, err := parseSlotRanges(field)
+			if err != nil {
+				return bad(err.Error())
+			}
+			for _, slot := range slots {
+				clusterSlotOwner[slot] = n
+			}
+		}
+	}
+
+	if clusterMyself == nil {
+		return fmt.Errorf("%s has no myself node", clusterConfigFile)
+	}
+	for _, m := range migrations {
+		if n, ok := clusterNodes[m.node]; ok {
+			if m.importing {
+				clusterImporting[m.slot] = n
+			} else {
+				clusterMigrating[m.slot] = n
+			}
+		}
+	}
+	fmt.Printf("[Cluster] Loaded %d nodes from %s, I'm %s\n", len(clusterNodes), clusterConfigFile, clusterMyself.id)
+	return nil
+}
+
+// clusterSlotsReply renders CLUSTER SLOTS: one entry per range of slots
+// served by the same node, with its address and id. Caller holds
+// clusterMu.
+func clusterSlotsReply() string {
+	var entries []string
+	for slot := 0; slot < clusterSlots; {
+		owner := clusterSlotOwner[slot]
+		end := slot
+		for end+1 < clusterSlots && clusterSlotOwner[end+1] == owner {
+			end++
+		}
+		if owner != nil {
+			entries = append(entries, "*3\r\n:"+strconv.Itoa(slot)+"\r\n:"+strconv.Itoa(end)+"\r\n"+
+				"*4\r\n"+bulkString(owner.host)+":"+strconv.Itoa(owner.port)+"\r\n"+bulkString(owner.id)+"*0\r\n")
+		}
+		slot = end + 1
+	}
+	return "*" + strconv.Itoa(len(entries)) + "\r\n" + strings.Join(entries, "")
+}
+
+// clusterShardsReply renders CLUSTER SHARDS: every master with its slot
+// ranges and a description of the node. Caller holds clusterMu.
+func clusterShardsReply() string {
+	now := time.Now()
+	nodes := sortedClusterNodes()
+
+	var sb strings.Builder
+	shards := 0
+	for _, n := range nodes {
+		if n.handshake {
+			continue
+		}
+		shards++
+
+		slots := clusterNodeSlots(n)
+		var bounds []string
+		for i := 0; i < len(slots); {
+			j := i
+			for j+1 < len(slots) && slots[j+1] == slots[j]+1 {
+				j++
+			}
+			bounds = append(bounds, ":"+strconv.Itoa(slots[i])+"\r\n", ":"+strconv.Itoa(slots[j])+"\r\n")
+			i = j + 1
+		}
+
+		health := "online"
+		if n.fail || n.pfail(now) {
+			health = "fail"
+		}
+		offset := int64(0)
+		if n.myself {
+			replMu.Lock()
+			offset = replOffset
+			replMu.Unlock()
+		}
+
+		sb.WriteString("*4\r\n")
+		sb.WriteString(bulkString("slots") + "*" + strconv.Itoa(len(bounds)) + "\r\n" + strings.Join(bounds, ""))
+		sb.WriteString(bulkString("nodes") + "*1\r\n*14\r\n")
+		sb.WriteString(bulkString("id") + bulkString(n.id))
+		sb.WriteString(bulkString("port") + ":" + strconv.Itoa(n.port) + "\r\n")
+		sb.WriteString(bulkString("ip") + bulkString(n.host))
+		sb.WriteString(bulkString("endpoint") + bulkString(n.host))
+		sb.WriteString(bulkString("role") + bulkString("master"))
+		sb.WriteString(bulkString("replication-offset") + ":" + strconv.FormatInt(offset, 10) + "\r\n")
+		sb.WriteString(bulkString("health") + bulkString(health))
+	}
+	return "*" + strconv.Itoa(shards) + "\r\n" + sb.String()
+}
+
+// clusterInfoText is the CLUSTER INFO reply. Caller holds clusterMu.
+func clusterInfoText() string {
+	now := time.Now()
+	assigned, pfail, fail := 0, 0, 0
+	for _, owner := range clusterSlotOwner {
+		if owner == nil {
+			continue
+		}
+		assigned++
+		if owner.fail {
+			fail++
+		} else if owner.pfail(now) {
+			pfail++
+		}
+	}
+
+	lines := []string{
+		"cluster_state:" + clusterStateName(clusterStateOK),
+		"cluster_slots_assigned:" + strconv.Itoa(assigned),
+		"cluster_slots_ok:" + strconv.Itoa(assigned-pfail-fail),
+		"cluster_slots_pfail:" + strconv.Itoa(pfail),
+		"cluster_slots_fail:" + strconv.Itoa(fail),
+		"cluster_known_nodes:" + strconv.Itoa(len(clusterNodes)),
+		"cluster_size:" + strconv.Itoa(clusterSize()),
+		"cluster_current_epoch:" + strconv.FormatInt(clusterCurrentEpoch, 10),
+		"cluster_my_epoch:" + strconv.FormatInt(clusterMyself.configEpoch, 10),
+		"cluster_stats_messages_sent:" + strconv.FormatInt(clusterMessagesSent.Load(), 10),
+		"cluster_stats_messages_received:" + strconv.FormatInt(clusterMessagesReceived.Load(), 10),
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// countKeysInSlot returns how many keys of database 0 hash to slot
+func countKeysInSlot(slot int) int {
+	mu.RLock()
+	defer mu.RUnlock()
+	if slotKeys == nil {
+		return 0
+	}
+	return len(slotKeys[slot])
+}
+
+// slotArgs parses the slots of ADDSLOTS and DELSLOTS, or the start and
+// end pairs of their RANGE variants
+func slotArgs(args []string, ranges bool) ([]int, string) {
+	if ranges && len(args)%2 != 0 {
+		return nil, "-ERR wrong number of arguments for 'cluster|addslotsrange' command\r\n"
+	}
+
+	var slots []int
+	seen := map[int]bool{}
+	step := 1
+	if ranges {
+		step = 2
+	}
+	for i := 0; i < len(args); i += step {
+		start, err := parseSlot(args[i])
+		if err != nil {
+			return nil, "-ERR Invalid or out of range slot\r\n"
+		}
+		end := start
+		if ranges {
+			if end, err = parseSlot(args[i+1]); err != nil {
+				return nil, "-ERR Invalid or out of range slot\r\n"
+			}
+			if end < start {
+				return nil, "-ERR start slot number " + args[i] + " is greater than end slot number " + args[i+1] + "\r\n"
+			}
+		}
+		for slot := start; slot <= end; slot++ {
+			if seen[slot] {
+				return nil, "-ERR Slot " + strconv.Itoa(slot) + " specified multiple times\r\n"
+			}
+			seen[slot] = true
+			slots = append(slots, slot)
+		}
+	}
+	return slots, ""
+}
+
+// CLUSTER <subcommand> [arg ...]
+func cmdCLUSTER(args []string, selectedDB *int) (string, error) {
+	if !clusterEnabled {
+		return "-ERR This instance has cluster support disabled\r\n", fmt.Errorf("cluster disabled")
+	}
+
+	sub := strings.ToUpper(args[1])
+	wrongArgs := "-ERR wrong number of arguments for 'cluster|" + strings.ToLower(sub) + "' command\r\n"
+
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+
+	switch sub {
+	case "MYID":
+		return bulkString(clusterMyself.id), nil
+
+	case "INFO":
+		return bulkString(clusterInfoText()), nil
+
+	case "NODES":
+		return bulkString(clusterNodesText()), nil
+
+	case "SLOTS":
+		return clusterSlotsReply(), nil
+
+	case "SHARDS":
+		return clusterShardsReply(), nil
+
+	case "KEYSLOT":
+		if len(args) != 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		return ":" + strconv.Itoa(keyHashSlot(args[2])) + "\r\n", nil
+
+	case "COUNTKEYSINSLOT":
+		if len(args) != 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		slot, err := parseSlot(args[2])
+		if err != nil {
+			return "-ERR Invalid slot\r\n", err
+		}
+		return ":" + strconv.Itoa(countKeysInSlot(slot)) + "\r\n", nil
+
+	case "GETKEYSINSLOT":
+		if len(args) != 4 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		slot, err1 := parseSlot(args[2])
+		count, err2 := strconv.Atoi(args[3])
+		if err1 != nil || err2 != nil || count < 0 {
+			return "-ERR Invalid slot or number of keys\r\n", fmt.Errorf("invalid args")
+		}
+		mu.RLock()
+		var keys []string
+		for key := range slotKeys[slot] {
+			if len(keys) == count {
+				break
+			}
+			keys = append(keys, key)
+		}
+		mu.RUnlock()
+		sort.Strings(keys)
+		return bulkArray(keys), nil
+
+	case "MEET":
+		if len(args) != 4 && len(args) != 5 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		port, err := strconv.Atoi(args[3])
+		busPort := port + clusterPortIncr
+		if len(args) == 5 {
+			busPort, err = strconv.Atoi(args[4])
+		}
+		if err != nil || port <= 0 || port > 65535 || net.ParseIP(args[2]) == nil {
+			return "-ERR Invalid node address specified: " + args[2] + ":" + args[3] + "\r\n", fmt.Errorf("invalid address")
+		}
+		clusterMeet(args[2], port, busPort)
+		return "+OK\r\n", nil
+
+	case "FORGET":
+		if len(args) != 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		n, ok := clusterNodes[args[2]]
+		if !ok {
+			return "-ERR Unknown node " + args[2] + "\r\n", fmt.Errorf("unknown node")
+		}
+		if n.myself {
+			return "-ERR I tried hard but I can't forget myself...\r\n", fmt.Errorf("forget myself")
+		}
+		clusterForgetNode(n)
+		clusterUpdateState()
+		clusterSaveConfig()
+		return "+OK\r\n", nil
+
+	case "ADDSLOTS", "ADDSLOTSRANGE", "DELSLOTS", "DELSLOTSRANGE":
+		if len(args) < 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		slots, errReply := slotArgs(args[2:], strings.HasSuffix(sub, "RANGE"))
+		if errReply != "" {
+			return strings.Replace(errReply, "addslotsrange", strings.ToLower(sub), 1), fmt.Errorf("invalid slots")
+		}
+		add := strings.HasPrefix(sub, "ADD")
+		for _, slot := range slots {
+			if add && clusterSlotOwner[slot] != nil {
+				return "-ERR Slot " + strconv.Itoa(slot) + " is already busy\r\n", fmt.Errorf("slot busy")
+			}
+			if !add && clusterSlotOwner[slot] == nil {
+				return "-ERR Slot " + strconv.Itoa(slot) + " is already unassigned\r\n", fmt.Errorf("slot unassigned")
+			}
+		}
+		for _, slot := range slots {
+			if add {
+				clusterSlotOwner[slot] = clusterMyself
+				clusterImporting[slot] = nil
+			} else {
+				clusterSlotOwner[slot] = nil
+				clusterMigrating[slot] = nil
+			}
+		}
+		clusterUpdateState()
+		clusterSaveConfig()
+		clusterPingAll()
+		return "+OK\r\n", nil
+
+	case "SETSLOT":
+		return clusterSetSlot(args)
+
+	case "SAVECONFIG":
+		if err := clusterSaveConfig(); err != nil {
+			return "-ERR error saving the cluster node config: " + err.Error() + "\r\n", err
+		}
+		return "+OK\r\n", nil
+
+	case "HELP":
+		return bulkArray([]string{
+			"CLUSTER <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"ADDSLOTS <slot> [<slot> ...]",
+			"    Assign slots to current node.",
+			"ADDSLOTSRANGE <start slot> <end slot> [<start slot> <end slot> ...]",
+			"    Assign slots which are between <start-slot> and <end-slot> to current node.",
+			"COUNTKEYSINSLOT <slot>",
+			"    Return the number of keys in <slot>.",
+			"DELSLOTS <slot> [<slot> ...]",
+			"    Delete slots information from current node.",
+			"DELSLOTSRANGE <start slot> <end slot> [<start slot> <end slot> ...]",
+			"    Delete slots information which are between <start-slot> and <end-slot>.",
+			"FORGET <node-id>",
+			"    Remove a node from the cluster.",
+			"GETKEYSINSLOT <slot> <count>",
+			"    Return key names stored by current node in a slot.",
+			"INFO",
+			"    Return information about the cluster.",
+			"KEYSLOT <key>",
+			"    Return the hash slot for <key>.",
+			"MEET <ip> <port> [<bus-port>]",
+			"    Connect nodes into a working cluster.",
+			"MYID",
+			"    Return the node id.",
+			"NODES",
+			"    Return cluster configuration seen by node. Output format:",
+			"    <id> <ip:port@bus-port> <flags> <master> <pings> <pongs> <epoch> <link> <slot> ...",
+			"SAVECONFIG",
+			"    Force saving cluster configuration on disk.",
+			"SETSLOT <slot> (IMPORTING <node-id>|MIGRATING <node-id>|STABLE|NODE <node-id>)",
+			"    Set slot state.",
+			"SHARDS",
+			"    Return information about slot range mappings and the nodes associated with them.",
+			"SLOTS",
+			"    Return information about slots range mappings. Each range is made of:",
+			"    start, end, master and replicas IP addresses, ports and ids",
+			"HELP",
+			"    Print this help.",
+		}), nil
+
+	default:
+		return "-ERR unknown subcommand '" + args[1] + "'. Try CLUSTER HELP.\r\n", fmt.Errorf("unknown subcommand")
+	}
+}
+
+// clusterSetSlot handles CLUSTER SETSLOT slot IMPORTING|MIGRATING|NODE id
+// and CLUSTER SETSLOT slot STABLE. Caller holds clusterMu.
+func clusterSetSlot(args []string) (string, error) {
+	syntax := "-ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP\r\n"
+	if len(args) < 4 {
+		return syntax, fmt.Errorf("syntax error")
+	}
+	slot, err := parseSlot(args[2])
+	if err != nil {
+		return "-ERR Invalid or out of range slot\r\n", err
+	}
+	action := strings.ToUpper(args[3])
+
+	var n *clusterNode
+	if action == "STABLE" {
+		if len(args) != 4 {
+			return syntax, fmt.Errorf("syntax error")
+		}
+	} else {
+		if len(args) != 5 {
+			return syntax, fmt.Errorf("syntax error")
+		}
+		var ok bool
+		if n, ok = clusterNodes[args[4]]; !ok {
+			return "-ERR I don't know about node " + args[4] + "\r\n", fmt.Errorf("unknown node")
+		}
+	}
+	owner := clusterSlotOwner[slot]
+	slotName := strconv.Itoa(slot)
+
+	switch action {
+	case "MIGRATING":
+		if owner != clusterMyself {
+			return "-ERR I'm not the owner of hash slot " + slotName + "\r\n", fmt.Errorf("not owner")
+		}
+		if n.myself {
+			return "-ERR Target is myself\r\n", fmt.Errorf("target is myself")
+		}
+		clusterMigrating[slot] = n
+
+	case "IMPORTING":
+		if owner == clusterMyself {
+			return "-ERR I'm already the owner of hash slot " + slotName + "\r\n", fmt.Errorf("already owner")
+		}
+		if n.myself {
+			return "-ERR Source is myself\r\n", fmt.Errorf("source is myself")
+		}
+		clusterImporting[slot] = n
+
+	case "STABLE":
+		clusterMigrating[slot] = nil
+		clusterImporting[slot] = nil
+
+	case "NODE":
+		// The keys must be moved first, or they'd be lost to clients
+		if owner == clusterMyself && !n.myself && countKeysInSlot(slot) > 0 {
+			return "-ERR Can't assign hashslot " + slotName + " to a different node while I still hold keys for this hash slot.\r\n", fmt.Errorf("slot not empty")
+		}
+		if !n.myself {
+			clusterMigrating[slot] = nil
+		}
+		// Taking over an imported slot needs a new config epoch, so the
+		// rest of the cluster prefers this claim to the old owner's
+		if n.myself && clusterImporting[slot] != nil {
+			clusterImporting[slot] = nil
+			clusterCurrentEpoch++
+			clusterMyself.configEpoch = clusterCurrentEpoch
+			fmt.Printf("[Cluster] Slot %d imported, config epoch set to %d\n", slot, clusterCurrentEpoch)
+		}
+		clusterSlotOwner[slot] = n
+
+	default:
+		return syntax, fmt.Errorf("syntax error")
+	}
+
+	clusterUpdateState()
+	clusterSaveConfig()
+	clusterPingAll()
+	return "+OK\r\n", nil
+}
+
+func infoCluster() []string {
+	enabled := "0"
+	if clusterEnabled {
+		enabled = "1"
+	}
+	return []string{"cluster_enabled:" + enabled}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const otherNodeID = "0000000000000000000000000000000000000001"
+
+// setupCluster turns cluster mode on without the bus: this node serves
+// slots 0-8191 and otherNodeID, at 127.0.0.1:7001, serves the rest
+func setupCluster(t *testing.T) string {
+	t.Helper()
+
+	reset := func(enabled bool) {
+		clusterMu.Lock()
+		clusterEnabled = enabled
+		clusterNodes = map[string]*clusterNode{}
+		clusterMyself = nil
+		clusterCurrentEpoch = 0
+		clusterSlotOwner = [clusterSlots]*clusterNode{}
+		clusterMigrating = [clusterSlots]*clusterNode{}
+		clusterImporting = [clusterSlots]*clusterNode{}
+		clusterStateOK = false
+		clusterMu.Unlock()
+
+		mu.Lock()
+		flushAllDatabases()
+		mu.Unlock()
+	}
+	savedFile := clusterConfigFile
+	clusterConfigFile = filepath.Join(t.TempDir(), "nodes.conf")
+	reset(true)
+	t.Cleanup(func() {
+		reset(false)
+		clusterConfigFile = savedFile
+	})
+
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+	clusterMyself = clusterAddNode(strings.Repeat("f", 40), "127.0.0.1", 7000, 17000)
+	clusterMyself.myself = true
+	other := clusterAddNode(otherNodeID, "127.0.0.1", 7001, 17001)
+	for slot := 0; slot < clusterSlots; slot++ {
+		clusterSlotOwner[slot] = clusterMyself
+		if slot >= clusterSlots/2 {
+			clusterSlotOwner[slot] = other
+		}
+	}
+	clusterUpdateState()
+
+	return clusterMyself.id
+}
+
+func TestKeyHashSlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"123456789", 12739},
+		{"somekey", 11058},
+		{"foo{hash_tag}", 2515},
+		{"somekey{hash_tag}", 2515},
+		{"foo{}{bar}", keyHashSlot("foo{}{bar}")},
+		{"foo{{bar}}zap", keyHashSlot("{bar")},
+		{"foo{bar}{zap}", keyHashSlot("bar")},
+	}
+	for _, tt := range tests {
+		if got := keyHashSlot(tt.key); got != tt.slot {
+			t.Errorf("keyHashSlot(%q) = %d, want %d", tt.key, got, tt.slot)
+		}
+	}
+	if keyHashSlot("foo{}{bar}") == keyHashSlot("bar") {
+		t.Error("an empty hashtag was used")
+	}
+}
+
+// keyInSlots returns a key hashing to a slot in [from, to)
+func keyInSlots(t *testing.T, from, to int) (string, int) {
+	t.Helper()
+	for i := 0; ; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if slot := keyHashSlot(key); slot >= from && slot < to {
+			return key, slot
+		}
+	}
+}
+
+func TestClusterRedirects(t *testing.T) {
+	setupCluster(t)
+	c := dialTestClient(t, startTestServer(t))
+	mine, mySlot := keyInSlots(t, 0, clusterSlots/2)
+	theirs, theirSlot := keyInSlots(t, clusterSlots/2, clusterSlots)
+
+	if got := c.do("SET", mine, "v"); got != "+OK\r\n" {
+		t.Fatalf("SET on a local slot: %q", got)
+	}
+	moved := "-MOVED " + strconv.Itoa(theirSlot) + " 127.0.0.1:7001\r\n"
+	if got := c.do("GET", theirs); got != moved {
+		t.Fatalf("GET on a remote slot: %q", got)
+	}
+	if got := c.do("MGET", mine, theirs); got != "-CROSSSLOT Keys in request don't hash to the same slot\r\n" {
+		t.Fatalf("MGET across slots: %q", got)
+	}
+	if got := c.do("MSET", "{"+mine+"}a", "1", "{"+mine+"}b", "2"); got != "+OK\r\n" {
+		t.Fatalf("MSET with a hashtag: %q", got)
+	}
+	if got := c.do("SELECT", "1"); got != "-ERR SELECT is not allowed in cluster mode\r\n" {
+		t.Fatalf("SELECT: %q", got)
+	}
+
+	// A migrating slot still serves the keys it has, and sends clients
+	// to the destination for the others
+	if got := c.do("CLUSTER", "SETSLOT", strconv.Itoa(mySlot), "MIGRATING", otherNodeID); got != "+OK\r\n" {
+		t.Fatalf("SETSLOT MIGRATING: %q", got)
+	}
+	if got := c.do("GET", mine); got != "$1\r\nv\r\n" {
+		t.Fatalf("GET of a key still here: %q", got)
+	}
+	c.do("DEL", mine)
+	if got := c.do("GET", mine); got != "-ASK "+strconv.Itoa(mySlot)+" 127.0.0.1:7001\r\n" {
+		t.Fatalf("GET of a key gone: %q", got)
+	}
+
+	// An importing slot only serves clients that sent ASKING, for one
+	// command
+	if got := c.do("CLUSTER", "SETSLOT", strconv.Itoa(theirSlot), "IMPORTING", otherNodeID); got != "+OK\r\n" {
+		t.Fatalf("SETSLOT IMPORTING: %q", got)
+	}
+	if got := c.do("SET", theirs, "v"); got != moved {
+		t.Fatalf("SET without ASKING: %q", got)
+	}
+	c.do("ASKING")
+	if got := c.do("SET", theirs, "v"); got != "+OK\r\n" {
+		t.Fatalf("SET after ASKING: %q", got)
+	}
+	if got := c.do("GET", theirs); got != moved {
+		t.Fatalf("ASKING applied twice: %q", got)
+	}
+
+	if got := c.do("CLUSTER", "DELSLOTS", "0"); got != "+OK\r\n" {
+		t.Fatalf("DELSLOTS: %q", got)
+	}
+	if got := c.do("GET", mine); got != "-CLUSTERDOWN The cluster is down\r\n" {
+		t.Fatalf("GET with slot 0 unassigned: %q", got)
+	}
+}
+
+func TestClusterSlotCommands(t *testing.T) {
+	myID := setupCluster(t)
+	c := dialTestClient(t, startTestServer(t))
+
+	tag, tagSlot := keyInSlots(t, 0, clusterSlots/2)
+	for i := 1; i <= 3; i++ {
+		c.do("SET", "{"+tag+"}"+strconv.Itoa(i), "v")
+	}
+	slot := strconv.Itoa(tagSlot)
+
+	if got := c.do("CLUSTER", "KEYSLOT", "somekey"); got != ":11058\r\n" {
+		t.Errorf("KEYSLOT: %q", got)
+	}
+	if got := c.do("CLUSTER", "COUNTKEYSINSLOT", slot); got != ":3\r\n" {
+		t.Errorf("COUNTKEYSINSLOT: %q", got)
+	}
+	if got := c.do("CLUSTER", "GETKEYSINSLOT", slot, "2"); !strings.HasPrefix(got, "*2\r\n$") {
+		t.Errorf("GETKEYSINSLOT: %q", got)
+	}
+	if got := c.do("CLUSTER", "SETSLOT", slot, "NODE", otherNodeID); !strings.HasPrefix(got, "-ERR Can't assign hashslot") {
+		t.Errorf("SETSLOT NODE with keys left: %q", got)
+	}
+	if got := c.do("CLUSTER", "ADDSLOTS", "1"); got != "-ERR Slot 1 is already busy\r\n" {
+		t.Errorf("ADDSLOTS of a busy slot: %q", got)
+	}
+	if got := c.do("CLUSTER", "MYID"); got != bulkString(myID) {
+		t.Errorf("MYID: %q", got)
+	}
+
+	want := "*2\r\n" +
+		"*3\r\n:0\r\n:8191\r\n*4\r\n$9\r\n127.0.0.1\r\n:7000\r\n" + bulkString(myID) + "*0\r\n" +
+		"*3\r\n:8192\r\n:16383\r\n*4\r\n$9\r\n127.0.0.1\r\n:7001\r\n" + bulkString(otherNodeID) + "*0\r\n"
+	if got := c.do("CLUSTER", "SLOTS"); got != want {
+		t.Errorf("SLOTS:\n got %q\nwant %q", got, want)
+	}
+
+	nodes := c.do("CLUSTER", "NODES")
+	for _, want := range []string{
+		myID + " 127.0.0.1:7000@17000 myself,master - 0 0 0 connected 0-8191\n",
+		otherNodeID + " 127.0.0.1:7001@17001 master - 0 0 0 disconnected 8192-16383\n",
+	} {
+		if !strings.Contains(nodes, want) {
+			t.Errorf("NODES misses %q: %q", want, nodes)
+		}
+	}
+
+	info := c.do("CLUSTER", "INFO")
+	for _, want := range []string{"cluster_state:ok", "cluster_slots_assigned:16384", "cluster_known_nodes:2", "cluster_size:2"} {
+		if !strings.Contains(info, want) {
+			t.Errorf("INFO misses %q: %q", want, info)
+		}
+	}
+}
+
+func TestClusterGossip(t *testing.T) {
+	myID := setupCluster(t)
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+	other := clusterNodes[otherNodeID]
+
+	// A newer config epoch wins the slots it claims
+	newID := "0000000000000000000000000000000000000002"
+	clusterProcessMessage([]string{
+		"PING", otherNodeID, "7001", "17001", "5", "5", "0-99,8192-16383",
+		newID, "127.0.0.1", "7002", "17002", "-",
+		myID, "127.0.0.1", "7000", "17000", "-",
+	}, "127.0.0.1", "127.0.0.1")
+
+	if clusterSlotOwner[0] != other || clusterSlotOwner[99] != other || clusterSlotOwner[100] != clusterMyself {
+		t.Errorf("slots 0-99 weren't handed to the newer config")
+	}
+	if clusterCurrentEpoch != 5 {
+		t.Errorf("current epoch %d, want 5", clusterCurrentEpoch)
+	}
+	if n, ok := clusterNodes[newID]; !ok || n.addr() != "127.0.0.1:7002" {
+		t.Fatalf("gossiped node wasn't added")
+	}
+
+	// A claim that isn't newer changes nothing
+	clusterProcessMessage([]string{"PING", newID, "7002", "17002", "5", "0", "100-200"}, "127.0.0.1", "")
+	if clusterSlotOwner[100] != clusterMyself {
+		t.Errorf("the same config epoch took slot 100")
+	}
+
+	// Masters serving slots report the nodes they can't reach
+	clusterProcessMessage([]string{
+		"PING", otherNodeID, "7001", "17001", "5", "5", "0-99,8192-16383",
+		newID, "127.0.0.1", "7002", "17002", "pfail",
+	}, "127.0.0.1", "")
+	if _, ok := clusterNodes[newID].failReports[otherNodeID]; !ok {
+		t.Errorf("failure report wasn't recorded")
+	}
+
+	clusterProcessMessage([]string{"FAIL", otherNodeID, newID}, "127.0.0.1", "")
+	if !clusterNodes[newID].fail {
+		t.Errorf("FAIL message ignored")
+	}
+}
+
+func TestClusterConfigFile(t *testing.T) {
+	setupCluster(t)
+
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+	clusterMigrating[5] = clusterNodes[otherNodeID]
+	clusterCurrentEpoch = 7
+	clusterMyself.configEpoch = 7
+	if err := clusterSaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	before := clusterNodesText()
+
+	clusterNodes = map[string]*clusterNode{}
+	clusterMyself = nil
+	clusterCurrentEpoch = 0
+	clusterSlotOwner = [clusterSlots]*clusterNode{}
+	clusterMigrating = [clusterSlots]*clusterNode{}
+	if err := clusterLoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if after := clusterNodesText(); after != before {
+		t.Errorf("reloaded config differs:\n got %q\nwant %q", after, before)
+	}
+	if clusterCurrentEpoch != 7 {
+		t.Errorf("current epoch %d, want 7", clusterCurrentEpoch)
+	}
+	if !strings.Contains(clusterNodesText(), "[5->-"+otherNodeID+"]") {
+		t.Errorf("migrating slot lost: %q", clusterNodesText())
+	}
+}
+
+func TestClusterFailureNeedsMajority(t *testing.T) {
+	setupCluster(t)
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+
+	// Three masters: a node we can't reach needs one more report
+	third := clusterAddNode("0000000000000000000000000000000000000003", "127.0.0.1", 7002, 17002)
+	clusterSlotOwner[0] = third
+	clusterUpdateState()
+
+	now := time.Now()
+	third.pingSent = now.Add(-2 * nodeTimeout())
+	clusterCheckNodes(now)
+	if third.fail {
+		t.Fatal("failed on our own opinion alone")
+	}
+	if got := clusterNodeFlags(third, now); got != "master,fail?" {
+		t.Errorf("flags %q, want master,fail?", got)
+	}
+
+	third.failReports[otherNodeID] = now
+	clusterCheckNodes(now)
+	if !third.fail || clusterStateOK {
+		t.Fatalf("fail %v, cluster ok %v after a majority agreed", third.fail, clusterStateOK)
+	}
+	if msg := <-clusterNodes[otherNodeID].outbox; strings.Join(msg, " ") != "FAIL "+clusterMyself.id+" "+third.id {
+		t.Errorf("broadcast %q", msg)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// commandSummaries are the one line descriptions served by COMMAND DOCS
+var commandSummaries = map[string]string{
+	"GET":           "Returns the string value of a key.",
+	"SET":           "Sets the string value of a key.",
+	"DEL":           "Deletes a key.",
+	"PING":          "Returns the server's liveliness response.",
+	"ECHO":          "Returns the given string.",
+	"EXISTS":        "Determines whether one or more keys exist.",
+	"INCR":          "Increments the integer value of a key by one.",
+	"DECR":          "Decrements the integer value of a key by one.",
+	"MGET":          "Atomically returns the string values of one or more keys.",
+	"MSET":          "Atomically creates or modifies the string values of one or more keys.",
+	"FLUSHALL":      "Removes all keys from all databases.",
+	"EXPIRE":        "Sets the expiration time of a key in seconds.",
+	"PERSIST":       "Removes the expiration time of a key.",
+	"TTL":           "Returns the expiration time in seconds of a key.",
+	"HSET":          "Creates or modifies the value of a field in a hash.",
+	"HGET":          "Returns the value of a field in a hash.",
+	"HDEL":          "Deletes one or more fields and their values from a hash.",
+	"HGETALL":       "Returns all fields and values in a hash.",
+	"HEXISTS":       "Determines whether a field exists in a hash.",
+	"HLEN":          "Returns the number of fields in a hash.",
+	"ZADD":          "Adds one or more members to a sorted set, or updates their scores.",
+	"ZINCRBY":       "Increments the score of a member in a sorted set.",
+	"ZRANGE":        "Returns members in a sorted set within a range of indexes.",
+	"ZSCORE":        "Returns the score of a member in a sorted set.",
+	"ZRANK":         "Returns the index of a member in a sorted set ordered by ascending scores.",
+	"ZREM":          "Removes a member from a sorted set.",
+	"ZCARD":         "Returns the number of members in a sorted set.",
+	"ZRANGEBYSCORE": "Returns members in a sorted set within a range of scores.",
+	"SELECT":        "Changes the selected database.",
+	"CONFIG":        "Gets, sets, resets or rewrites configuration parameters.",
+	"INFO":          "Returns information and statistics about the server.",
+	"SLOWLOG":       "Inspects or resets the slow log.",
+	"LATENCY":       "Inspects or resets the latency samples of internal events.",
+	"AUTH":          "Authenticates the connection.",
+	"HELLO":         "Handshakes with the server.",
+	"QUIT":          "Closes the connection.",
+	"ACL":           "Inspects or changes access control users and rules.",
+	"SHUTDOWN":      "Synchronously saves the data and shuts down the server.",
+	"CLIENT":        "Inspects or changes client connections.",
+	"COMMAND":       "Returns detailed information about all commands.",
+	"MONITOR":       "Listens for all commands received by the server in real time.",
+	"REPLICAOF":     "Configures a server as replica of another, or promotes it to a master.",
+	"SLAVEOF":       "Sets a server as a replica of another, or promotes it to being a master.",
+	"REPLCONF":      "An internal command for configuring the replication stream.",
+	"PSYNC":         "An internal command used in replication.",
+	"SENTINEL":      "Monitors masters and fails them over, when running with --sentinel.",
+	"WAIT":          "Blocks until the asynchronous replication of all preceding write commands sent by the connection is completed.",
+	"CLUSTER":       "A container for Redis Cluster commands.",
+	"ASKING":        "Signals that a cluster client is following an -ASK redirect.",
+	"DUMP":          "Returns a serialized representation of the value stored at a key.",
+	"RESTORE":       "Creates a key from the serialized representation of a value.",
+	"MIGRATE":       "Atomically transfers a key from one instance to another.",
+	"WAITAOF":       "Blocks until all of the preceding write commands sent by the connection are written to the append-only file of the master and/or replicas.",
+
+	"RESTORE-ASKING": "An internal command for migrating keys in a cluster.",
+}
+
+// commandGroup maps a command to its documentation group by its ACL
+// categories
+func commandGroup(cmd *Command) string {
+	switch {
+	case cmd.hasCategory("string"):
+		return "string"
+	case cmd.hasCategory("hash"):
+		return "hash"
+	case cmd.hasCategory("sortedset"):
+		return "sorted-set"
+	case cmd.hasCategory("keyspace"):
+		return "generic"
+	case cmd.hasCategory("connection"):
+		return "connection"
+	default:
+		return "server"
+	}
+}
+
+// sortedCommandNames returns the names of commandTable in order
+func sortedCommandNames() []string {
+	names := make([]string, 0, len(commandTable))
+	for name := range commandTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func statusArray(items []string) string {
+	var sb strings.Builder
+	sb.WriteString("*" + strconv.Itoa(len(items)) + "\r\n")
+	for _, item := range items {
+		sb.WriteString("+" + item + "\r\n")
+	}
+	return sb.String()
+}
+
+// commandInfo renders one COMMAND INFO entry: name, arity, flags, first
+// key, last key, key step, ACL categories, tips, key specs, subcommands
+func commandInfo(name string, cmd *Command) string {
+	categories := make([]string, len(cmd.Categories))
+	for i, c := range cmd.Categories {
+		categories[i] = "@" + c
+	}
+
+	var sb strings.Builder
+	sb.WriteString("*10\r\n")
+	sb.WriteString(bulkString(strings.ToLower(name)))
+	sb.WriteString(":" + strconv.Itoa(cmd.Arity) + "\r\n")
+	sb.WriteString(statusArray(cmd.Flags))
+	sb.WriteString(":" + strconv.Itoa(cmd.FirstKey) + "\r\n")
+	sb.WriteString(":" + strconv.Itoa(cmd.LastKey) + "\r\n")
+	sb.WriteString(":" + strconv.Itoa(cmd.KeyStep) + "\r\n")
+	sb.WriteString(statusArray(categories))
+	sb.WriteString("*0\r\n*0\r\n*0\r\n")
+	return sb.String()
+}
+
+// commandDocs renders the COMMAND DOCS map of one command as name
+// followed by its doc fields
+func commandDocs(name string, cmd *Command) string {
+	return bulkString(strings.ToLower(name)) + bulkArray([]string{
+		"summary", commandSummaries[name],
+		"group", commandGroup(cmd),
+	})
+}
+
+// commandsNamed returns the upper case names args refers to, or all
+// commands when args is empty
+func commandsNamed(args []string) []string {
+	if len(args) == 0 {
+		return sortedCommandNames()
+	}
+	names := make([]string, len(args))
+	for i, arg := range args {
+		names[i] = strings.ToUpper(arg)
+	}
+	return names
+}
+
+// COMMAND [COUNT | INFO [name ...] | DOCS [name ...] | GETKEYS cmd [arg ...] |
+// LIST [FILTERBY MODULE name | ACLCAT category | PATTERN pattern] | HELP]
+func cmdCOMMAND(args []string, selectedDB *int) (string, error) {
+	if len(args) == 1 {
+		return cmdCOMMAND([]string{"COMMAND", "INFO"}, selectedDB)
+	}
+
+	sub := strings.ToUpper(args[1])
+	wrongArgs := "-ERR wrong number of arguments for 'COMMAND|" + strings.ToLower(sub) + "' command\r\n"
+
+	switch sub {
+	case "COUNT":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		return ":" + strconv.Itoa(len(commandTable)) + "\r\n", nil
+
+	case "INFO":
+		names := commandsNamed(args[2:])
+		var resp strings.Builder
+		resp.WriteString("*" + strconv.Itoa(len(names)) + "\r\n")
+		for _, name := range names {
+			if cmd, ok := commandTable[name]; ok {
+				resp.WriteString(commandInfo(name, cmd))
+			} else {
+				resp.WriteString("*-1\r\n")
+			}
+		}
+		return resp.String(), nil
+
+	case "DOCS":
+		var found []string
+		for _, name := range commandsNamed(args[2:]) {
+			if cmd, ok := commandTable[name]; ok {
+				found = append(found, commandDocs(name, cmd))
+			}
+		}
+		return "*" + strconv.Itoa(len(found)*2) + "\r\n" + strings.Join(found, ""), nil
+
+	case "GETKEYS":
+		if len(args) < 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		call := args[2:]
+		cmd, ok := commandTable[strings.ToUpper(call[0])]
+		if !ok {
+			return "-ERR Invalid command specified\r\n", fmt.Errorf("invalid command")
+		}
+		if arityError(call[0], cmd, call) != "" {
+			return "-ERR Invalid number of arguments specified for command\r\n", fmt.Errorf("invalid arity")
+		}
+		keys := keyArgs(cmd, call)
+		if len(keys) == 0 {
+			return "-ERR The command has no key arguments\r\n", fmt.Errorf("no keys")
+		}
+		return bulkArray(keys), nil
+
+	case "LIST":
+		names := sortedCommandNames()
+		switch {
+		case len(args) == 2:
+		case len(args) == 5 && strings.EqualFold(args[2], "FILTERBY"):
+			var filtered []string
+			for _, name := range names {
+				switch strings.ToUpper(args[3]) {
+				case "ACLCAT":
+					if commandTable[name].hasCategory(strings.ToLower(args[4])) {
+						filtered = append(filtered, name)
+					}
+				case "PATTERN":
+					if stringMatch(args[4], strings.ToLower(name), true) {
+						filtered = append(filtered, name)
+					}
+				case "MODULE":
+					// There are no modules
+				default:
+					return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+				}
+			}
+			names = filtered
+		default:
+			return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+		}
+		for i, name := range names {
+			names[i] = strings.ToLower(name)
+		}
+		return bulkArray(names), nil
+
+	case "HELP":
+		return bulkArray([]string{
+			"COMMAND <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"(no subcommand)",
+			"    Return details about all commands.",
+			"COUNT",
+			"    Return the total number of commands.",
+			"INFO [<command-name> ...]",
+			"    Return details about multiple commands.",
+			"    If no command names are given, documentation details for all",
+			"    commands are returned.",
+			"DOCS [<command-name> ...]",
+			"    Return documentation details about multiple commands.",
+			"    If no command names are given, documentation details for all",
+			"    commands are returned.",
+			"GETKEYS <full-command>",
+			"    Return the keys from a full command.",
+			"LIST [FILTERBY (MODULE <module-name>|ACLCAT <category>|PATTERN <pattern>)]",
+			"    Return a list of all commands in this server.",
+			"HELP",
+			"    Print this help.",
+		}), nil
+
+	default:
+		return "-ERR unknown subcommand '" + args[1] + "'. Try COMMAND HELP.\r\n", fmt.Errorf("unknown subcommand")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -9,46 +10,159 @@ import (
 
 type CmdFunc func(args []string, selectedDB *int) (string, error)
 
-var commandTable = map[string]CmdFunc{
-	"GET":      cmdGET,
-	"SET":      cmdSET,
-	"DEL":      cmdDEL,
-	"PING":     cmdPING,
-	"ECHO":     cmdECHO,
-	"EXISTS":   cmdEXISTS,
-	"INCR":     cmdINCR,
-	"DECR":     cmdDECR,
-	"MGET":     cmdMGET,
-	"MSET":     cmdMSET,
-	"FLUSHALL": cmdFLUSHALL,
-	"EXPIRE":   cmdEXPIRE,
-	"PERSIST":  cmdPERSIST,
-	"TTL":      cmdTTL,
-	"HSET":     cmdHSET,
-	"HGET":     cmdHGET,
-	"HDEL":     cmdHDEL,
-	"HGETALL":  cmdHGETALL,
-	"HEXISTS":  cmdHEXISTS,
-	"HLEN":     cmdHLEN,
-	//"TYPE":     	 cmdTYPE,
-	"ZADD":          cmdZADD,
-	"ZRANGE":        cmdZRANGE,
-	"ZSCORE":        cmdZSCORE,
-	"ZREM":          cmdZREM,
-	"ZCARD":         cmdZCARD,
-	"ZRANGEBYSCORE": cmdZRANGEBYSCORE,
+// Command describes an entry of commandTable
+type Command struct {
+	Func       CmdFunc       // keyspace commands
+	ClientFunc ClientCmdFunc // connection commands, they need the Client
+
+	// Arity counts the command name: N means exactly N arguments, -N at
+	// least N. It is checked before the handler runs.
+	Arity int
+
+	// Flags as reported by COMMAND INFO: write, readonly, denyoom, fast,
+	// admin, noscript, loading, stale, no_auth, asking and movablekeys.
+	// write commands are appended to the AOF, no_auth ones run before
+	// authentication, asking ones are served in slots being imported.
+	Flags []string
+
+	Categories []string // ACL categories, without the '@'
+
+	// Key arguments are args[FirstKey], args[FirstKey+KeyStep], ... up to
+	// args[LastKey]. A negative LastKey counts from the end, FirstKey 0
+	// means the command takes no keys.
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+
+	// KeysFunc finds the keys of commands that have them at variable
+	// positions, which are flagged movablekeys
+	KeysFunc func(args []string) []string
+
+	latency latencyHistogram // calls and their duration, for /metrics
 }
 
-//26 command + exit
+var commandTable map[string]*Command
+
+// commandTable is filled in init: ACL and CONFIG handlers refer back to
+// the table, which a package level initializer can't express.
+func init() {
+	commandTable = map[string]*Command{
+		"GET":      {Func: cmdGET, Arity: 2, Flags: []string{"readonly", "fast"}, Categories: []string{"read", "string", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"SET":      {Func: cmdSET, Arity: 3, Flags: []string{"write", "denyoom"}, Categories: []string{"write", "string", "slow"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"DEL":      {Func: cmdDEL, Arity: 2, Flags: []string{"write"}, Categories: []string{"keyspace", "write", "slow"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"PING":     {Func: cmdPING, Arity: -1, Flags: []string{"fast"}, Categories: []string{"fast", "connection"}},
+		"ECHO":     {Func: cmdECHO, Arity: 2, Flags: []string{"fast"}, Categories: []string{"fast", "connection"}},
+		"EXISTS":   {Func: cmdEXISTS, Arity: -2, Flags: []string{"readonly", "fast"}, Categories: []string{"keyspace", "read", "fast"}, FirstKey: 1, LastKey: -1, KeyStep: 1},
+		"INCR":     {Func: cmdINCR, Arity: 2, Flags: []string{"write", "denyoom", "fast"}, Categories: []string{"write", "string", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"DECR":     {Func: cmdDECR, Arity: 2, Flags: []string{"write", "denyoom", "fast"}, Categories: []string{"write", "string", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"MGET":     {Func: cmdMGET, Arity: -2, Flags: []string{"readonly", "fast"}, Categories: []string{"read", "string", "fast"}, FirstKey: 1, LastKey: -1, KeyStep: 1},
+		"MSET":     {Func: cmdMSET, Arity: -3, Flags: []string{"write", "denyoom"}, Categories: []string{"write", "string", "slow"}, FirstKey: 1, LastKey: -1, KeyStep: 2},
+		"FLUSHALL": {Func: cmdFLUSHALL, Arity: -1, Flags: []string{"write"}, Categories: []string{"keyspace", "write", "slow", "dangerous"}},
+		"EXPIRE":   {Func: cmdEXPIRE, Arity: -3, Flags: []string{"write", "fast"}, Categories: []string{"keyspace", "write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"PERSIST":  {Func: cmdPERSIST, Arity: 2, Flags: []string{"write", "fast"}, Categories: []string{"keyspace", "write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"TTL":      {Func: cmdTTL, Arity: 2, Flags: []string{"readonly", "fast"}, Categories: []string{"keyspace", "read", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"HSET":     {Func: cmdHSET, Arity: -4, Flags: []string{"write", "denyoom", "fast"}, Categories: []string{"write", "hash", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"HGET":     {Func: cmdHGET, Arity: 3, Flags: []string{"readonly", "fast"}, Categories: []string{"read", "hash", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"HDEL":     {Func: cmdHDEL, Arity: -3, Flags: []string{"write", "fast"}, Categories: []string{"write", "hash", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"HGETALL":  {Func: cmdHGETALL, Arity: 2, Flags: []string{"readonly"}, Categories: []string{"read", "hash", "slow"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"HEXISTS":  {Func: cmdHEXISTS, Arity: 3, Flags: []string{"readonly", "fast"}, Categories: []string{"read", "hash", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"HLEN":     {Func: cmdHLEN, Arity: 2, Flags: []string{"readonly", "fast"}, Categories: []string{"read", "hash", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		//"TYPE":     	 cmdTYPE,
+		"ZADD":          {Func: cmdZADD, Arity: -4, Flags: []string{"write", "denyoom", "fast"}, Categories: []string{"write", "sortedset", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"ZINCRBY":       {Func: cmdZINCRBY, Arity: 4, Flags: []string{"write", "denyoom", "fast"}, Categories: []string{"write", "sortedset", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"ZRANGE":        {Func: cmdZRANGE, Arity: 4, Flags: []string{"readonly"}, Categories: []string{"read", "sortedset", "slow"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"ZSCORE":        {Func: cmdZSCORE, Arity: 3, Flags: []string{"readonly", "fast"}, Categories: []string{"read", "sortedset", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"ZRANK":         {Func: cmdZRANK, Arity: -3, Flags: []string{"readonly", "fast"}, Categories: []string{"read", "sortedset", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"ZREM":          {Func: cmdZREM, Arity: 3, Flags: []string{"write", "fast"}, Categories: []string{"write", "sortedset", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"ZCARD":         {Func: cmdZCARD, Arity: 2, Flags: []string{"readonly", "fast"}, Categories: []string{"read", "sortedset", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"ZRANGEBYSCORE": {Func: cmdZRANGEBYSCORE, Arity: 4, Flags: []string{"readonly"}, Categories: []string{"read", "sortedset", "slow"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"SELECT":        {Func: cmdSELECT, Arity: 2, Flags: []string{"loading", "stale", "fast"}, Categories: []string{"fast", "connection"}},
+		"CONFIG":        {Func: cmdCONFIG, Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}, Categories: []string{"admin", "slow", "dangerous"}},
+		"INFO":          {Func: cmdINFO, Arity: -1, Flags: []string{"loading", "stale"}, Categories: []string{"slow", "dangerous"}},
+		"SLOWLOG":       {Func: cmdSLOWLOG, Arity: -2, Flags: []string{"admin", "loading", "stale"}, Categories: []string{"admin", "slow", "dangerous"}},
+		"LATENCY":       {Func: cmdLATENCY, Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}, Categories: []string{"admin", "slow", "dangerous"}},
+		"AUTH":          {ClientFunc: cmdAUTH, Arity: -2, Flags: []string{"noscript", "loading", "stale", "fast", "no_auth"}, Categories: []string{"fast", "connection"}},
+		"HELLO":         {ClientFunc: cmdHELLO, Arity: -1, Flags: []string{"noscript", "loading", "stale", "fast", "no_auth"}, Categories: []string{"fast", "connection"}},
+		"QUIT":          {ClientFunc: cmdQUIT, Arity: -1, Flags: []string{"noscript", "loading", "stale", "fast", "no_auth"}, Categories: []string{"fast", "connection"}},
+		"ACL":           {ClientFunc: cmdACL, Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}, Categories: []string{"admin", "slow", "dangerous"}},
+		"SHUTDOWN":      {ClientFunc: cmdSHUTDOWN, Arity: -1, Flags: []string{"admin", "noscript", "loading", "stale"}, Categories: []string{"admin", "slow", "dangerous"}},
+		"CLIENT":        {ClientFunc: cmdCLIENT, Arity: -2, Flags: []string{"noscript", "loading", "stale"}, Categories: []string{"slow", "connection"}},
+		"COMMAND":       {Func: cmdCOMMAND, Arity: -1, Flags: []string{"loading", "stale"}, Categories: []string{"slow", "connection"}},
+		"MONITOR":       {ClientFunc: cmdMONITOR, Arity: 1, Flags: []string{"admin", "noscript", "loading", "stale"}, Categories: []string{"admin", "slow", "dangerous"}},
+		"REPLICAOF":     {Func: cmdREPLICAOF, Arity: 3, Flags: []string{"admin", "noscript", "stale"}, Categories: []string{"admin", "slow", "dangerous"}},
+		"SLAVEOF":       {Func: cmdREPLICAOF, Arity: 3, Flags: []string{"admin", "noscript", "stale"}, Categories: []string{"admin", "slow", "dangerous"}},
+		"REPLCONF":      {ClientFunc: cmdREPLCONF, Arity: -1, Flags: []string{"admin", "noscript", "loading", "stale"}, Categories: []string{"admin", "slow", "dangerous"}},
+		"WAIT":          {ClientFunc: cmdWAIT, Arity: 3, Flags: []string{"noscript"}, Categories: []string{"slow", "connection"}},
+		"WAITAOF":       {ClientFunc: cmdWAITAOF, Arity: 4, Flags: []string{"noscript"}, Categories: []string{"slow", "connection"}},
+		"PSYNC":         {ClientFunc: cmdPSYNC, Arity: 3, Flags: []string{"admin", "noscript"}, Categories: []string{"admin", "slow", "dangerous"}},
+		"CLUSTER":       {Func: cmdCLUSTER, Arity: -2, Flags: []string{"stale"}, Categories: []string{"slow"}},
+		"ASKING":        {ClientFunc: cmdASKING, Arity: 1, Flags: []string{"fast"}, Categories: []string{"fast", "connection"}},
+		"DUMP":          {Func: cmdDUMP, Arity: 2, Flags: []string{"readonly"}, Categories: []string{"keyspace", "read", "slow"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"RESTORE":       {Func: cmdRESTORE, Arity: -4, Flags: []string{"write", "denyoom"}, Categories: []string{"keyspace", "write", "slow", "dangerous"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+		"MIGRATE":       {Func: cmdMIGRATE, Arity: -6, Flags: []string{"write", "movablekeys"}, Categories: []string{"keyspace", "write", "slow", "dangerous"}, FirstKey: 3, LastKey: 3, KeyStep: 1, KeysFunc: migrateKeys},
+
+		// What MIGRATE sends in cluster mode, served while the slot is
+		// being imported
+		"RESTORE-ASKING": {Func: cmdRESTORE, Arity: -4, Flags: []string{"write", "denyoom", "asking"}, Categories: []string{"keyspace", "write", "slow", "dangerous"}, FirstKey: 1, LastKey: 1, KeyStep: 1},
+	}
+}
 
-func cmdGET(args []string, selectedDB *int) (string, error) {
+// keyArgs returns the arguments of args that are keys according to cmd
+func keyArgs(cmd *Command, args []string) []string {
+	if cmd.KeysFunc != nil {
+		return cmd.KeysFunc(args)
+	}
+	if cmd.FirstKey == 0 || cmd.FirstKey >= len(args) {
+		return nil
+	}
+
+	last := cmd.LastKey
+	if last < 0 {
+		last = len(args) + last
+	}
+	if last >= len(args) {
+		last = len(args) - 1
+	}
+
+	var keys []string
+	for i := cmd.FirstKey; i <= last; i += cmd.KeyStep {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+func (cmd *Command) hasFlag(flag string) bool {
+	for _, f := range cmd.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
 
-	if len(args) != 2 {
-		return "-ERR wrong number of arguments for 'GET'\r\n", fmt.Errorf("wrong args")
+// arityError returns the error reply for a call to name with the wrong
+// number of arguments, or "" if args fit the command's arity
+func arityError(name string, cmd *Command, args []string) string {
+	if (cmd.Arity > 0 && len(args) != cmd.Arity) || (cmd.Arity < 0 && len(args) < -cmd.Arity) {
+		return "-ERR wrong number of arguments for '" + name + "' command\r\n"
 	}
+	return ""
+}
+
+func (cmd *Command) hasCategory(category string) bool {
+	for _, c := range cmd.Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
 
+//26 command + exit
+
+func cmdGET(args []string, selectedDB *int) (string, error) {
 	key := args[1]
-	entry, exists := getEntry(key, selectedDB)
+	entry, exists := lookupKeyRead(key, selectedDB)
 
 	if !exists {
 		return "$-1\r\n", nil
@@ -67,9 +181,6 @@ func cmdGET(args []string, selectedDB *int) (string, error) {
 }
 
 func cmdSET(args []string, selectedDB *int) (string, error) {
-	if len(args) != 3 {
-		return "-ERR wrong number of arguments for 'SET'\r\n", fmt.Errorf("wrong args")
-	}
 	key := args[1]
 	value := args[2]
 
@@ -84,10 +195,6 @@ func cmdSET(args []string, selectedDB *int) (string, error) {
 }
 
 func cmdDEL(args []string, selectedDB *int) (string, error) {
-	if len(args) != 2 {
-		return "-ERR wrong number of arguments for 'DEL'\r\n", fmt.Errorf("wrong args")
-	}
-
 	removed := deleteEntry(args[1], selectedDB)
 	if removed {
 		return ":1\r\n", nil
@@ -108,17 +215,11 @@ func cmdPING(args []string, selectedDB *int) (string, error) {
 }
 
 func cmdECHO(args []string, selectedDB *int) (string, error) {
-	if len(args) != 2 {
-		return "-ERR wrong number of arguments for 'ECHO' command\r\n", fmt.Errorf("wrong args")
-	}
 	message := args[1]
 	return "$" + strconv.Itoa(len(message)) + "\r\n" + message + "\r\n", nil
 }
 
 func cmdEXISTS(args []string, selectedDB *int) (string, error) {
-	if len(args) < 2 {
-		return "-ERR wrong number of arguments for 'EXISTS' command\r\n", fmt.Errorf("wrong args")
-	}
 	count := 0
 	for _, key := range args[1:] {
 		_, exists := getEntry(key, selectedDB)
@@ -131,10 +232,6 @@ func cmdEXISTS(args []string, selectedDB *int) (string, error) {
 }
 
 func cmdINCR(args []string, selectedDB *int) (string, error) {
-	if len(args) != 2 {
-		return "-ERR wrong number of arguments for 'INCR' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	key := args[1]
 	entry, exists := getEntry(key, selectedDB)
 
@@ -171,10 +268,6 @@ func cmdINCR(args []string, selectedDB *int) (string, error) {
 }
 
 func cmdDECR(args []string, selectedDB *int) (string, error) {
-	if len(args) != 2 {
-		return "-ERR wrong number of arguments for 'DECR' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	key := args[1]
 	entry, exists := getEntry(key, selectedDB)
 
@@ -211,19 +304,21 @@ func cmdDECR(args []string, selectedDB *int) (string, error) {
 }
 
 func cmdMGET(args []string, selectedDB *int) (string, error) {
-	if len(args) < 2 {
-		return "-ERR wrong number of arguments for 'MGET' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	var resp strings.Builder
 	resp.WriteString("*" + strconv.Itoa(len(args)-1) + "\r\n")
 	for _, key := range args[1:] {
-		entry, exists := getEntry(key, selectedDB)
+		entry, exists := lookupKeyRead(key, selectedDB)
 		if !exists || (entry.ExpireAt != 0 && entry.ExpireAt <= time.Now().Unix()) {
 			resp.WriteString("$-1\r\n")
 			continue
 		}
 
+		// Non-string keys read as nil, like Redis
+		if entry.Type != TypeString {
+			resp.WriteString("$-1\r\n")
+			continue
+		}
+
 		val := entry.Value.(string)
 		resp.WriteString("$" + strconv.Itoa(len(val)) + "\r\n" + val + "\r\n")
 	}
@@ -263,21 +358,21 @@ func cmdFLUSHALL(args []string, selectedDB *int) (string, error) {
 
 	switch mode {
 	case "SYNC":
+		start := time.Now()
 		mu.Lock()
 		// Clear ALL databases (FLUSHALL should clear everything)
-		for i := 0; i < NumDatabases; i++ {
-			databases[i] = make(map[string]Entry)
-		}
+		flushAllDatabases()
 		mu.Unlock()
+		latencyRecord("flushall", time.Since(start))
 		return "+OK\r\n", nil
 
 	case "ASYNC":
 		go func() {
+			start := time.Now()
 			mu.Lock()
-			for i := 0; i < NumDatabases; i++ {
-				databases[i] = make(map[string]Entry)
-			}
+			flushAllDatabases()
 			mu.Unlock()
+			latencyRecord("flushall", time.Since(start))
 		}()
 		return "+OK\r\n", nil
 
@@ -353,10 +448,6 @@ func cmdEXPIRE(args []string, selectedDB *int) (string, error) {
 }
 
 func cmdPERSIST(args []string, selectedDB *int) (string, error) {
-	if len(args) != 2 {
-		return "-ERR wrong number of arguments for 'PERSIST' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	success := PersistEntry(args[1], selectedDB)
 	if success {
 		return ":1\r\n", nil
@@ -366,10 +457,6 @@ func cmdPERSIST(args []string, selectedDB *int) (string, error) {
 }
 
 func cmdTTL(args []string, selectedDB *int) (string, error) {
-	if len(args) != 2 {
-		return "-ERR wrong number of arguments for 'TTL' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	// Check if key exists
 	entry, exists := getEntry(args[1], selectedDB)
 	if !exists {
@@ -395,18 +482,23 @@ func cmdHSET(args []string, selectedDB *int) (string, error) {
 	}
 
 	key := args[1]
-	entry, exists := getEntry(key, selectedDB)
 
-	var hash map[string]string
+	// The hash is changed in place: readers look at it under mu.RLock
+	mu.Lock()
+	defer mu.Unlock()
+
+	entry, exists := lookupEntryLocked(*selectedDB, key)
+
+	var hash Hash
 
 	if exists {
 		if entry.Type != TypeHash {
 			return "-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
 				fmt.Errorf("wrong type")
 		}
-		hash = entry.Value.(map[string]string)
+		hash = entry.Value.(Hash)
 	} else {
-		hash = make(map[string]string)
+		hash = newHash()
 	}
 
 	added := 0
@@ -416,10 +508,7 @@ func cmdHSET(args []string, selectedDB *int) (string, error) {
 		field := args[i]
 		value := args[i+1]
 
-		_, existedBefore := hash[field]
-		hash[field] = value
-
-		if !existedBefore {
+		if hash.Set(field, value) {
 			added++
 		}
 	}
@@ -434,20 +523,16 @@ func cmdHSET(args []string, selectedDB *int) (string, error) {
 		newEntry.ExpireAt = entry.ExpireAt // preserve TTL
 	}
 
-	setEntry(key, newEntry, selectedDB)
+	storeEntry(*selectedDB, key, newEntry)
 
 	// DO NOT LOG inside cmd-layer
 	return ":" + strconv.Itoa(added) + "\r\n", nil
 }
 
 func cmdHGET(args []string, selectedDB *int) (string, error) {
-	if len(args) != 3 {
-		return "-ERR wrong number of arguments for 'HGET' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	key := args[1]
 
-	entry, exists := getEntry(key, selectedDB)
+	entry, exists := lookupKeyRead(key, selectedDB)
 	if !exists {
 		return "$-1\r\n", nil
 	}
@@ -457,10 +542,12 @@ func cmdHGET(args []string, selectedDB *int) (string, error) {
 			fmt.Errorf("wrong type")
 	}
 
-	hash := entry.Value.(map[string]string)
+	hash := entry.Value.(Hash)
 	field := args[2]
 
-	value, fieldExists := hash[field]
+	mu.RLock()
+	value, fieldExists := hash.Fields[field]
+	mu.RUnlock()
 	if !fieldExists {
 		return "$-1\r\n", nil
 	}
@@ -470,12 +557,12 @@ func cmdHGET(args []string, selectedDB *int) (string, error) {
 }
 
 func cmdHDEL(args []string, selectedDB *int) (string, error) {
-	if len(args) < 3 {
-		return "-ERR wrong number of arguments for 'HDEL' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	key := args[1]
-	entry, exists := getEntry(key, selectedDB)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	entry, exists := lookupEntryLocked(*selectedDB, key)
 	if !exists {
 		return ":0\r\n", nil
 	}
@@ -485,31 +572,25 @@ func cmdHDEL(args []string, selectedDB *int) (string, error) {
 			fmt.Errorf("wrong type")
 	}
 
-	hash := entry.Value.(map[string]string)
+	hash := entry.Value.(Hash)
 	deleted := 0
 
 	for _, field := range args[2:] {
-		_, fieldExists := hash[field]
-		if fieldExists {
-			delete(hash, field)
+		if hash.Delete(field) {
 			deleted++
 		}
 	}
 
 	// Update the entry
 	entry.Value = hash
-	setEntry(key, entry, selectedDB)
+	storeEntry(*selectedDB, key, entry)
 
 	return ":" + strconv.Itoa(deleted) + "\r\n", nil
 }
 
 func cmdHGETALL(args []string, selectedDB *int) (string, error) {
-	if len(args) != 2 {
-		return "-ERR wrong number of arguments for 'HGETALL' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	key := args[1]
-	entry, exists := getEntry(key, selectedDB)
+	entry, exists := lookupKeyRead(key, selectedDB)
 	if !exists {
 		return "*0\r\n", nil
 	}
@@ -519,13 +600,18 @@ func cmdHGETALL(args []string, selectedDB *int) (string, error) {
 			fmt.Errorf("wrong type")
 	}
 
-	hash := entry.Value.(map[string]string)
-	count := len(hash) * 2
+	hash := entry.Value.(Hash)
+
+	// Writers change the hash in place, holding mu
+	mu.RLock()
+	defer mu.RUnlock()
+
+	count := hash.Len() * 2
 
 	var resp strings.Builder
 	resp.WriteString("*" + strconv.Itoa(count) + "\r\n")
 
-	for field, value := range hash {
+	for field, value := range hash.Fields {
 		resp.WriteString("$" + strconv.Itoa(len(field)) + "\r\n" + field + "\r\n")
 		resp.WriteString("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n")
 	}
@@ -534,12 +620,8 @@ func cmdHGETALL(args []string, selectedDB *int) (string, error) {
 }
 
 func cmdHEXISTS(args []string, selectedDB *int) (string, error) {
-	if len(args) != 3 {
-		return "-ERR wrong number of arguments for 'HEXISTS' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	key := args[1]
-	entry, exists := getEntry(key, selectedDB)
+	entry, exists := lookupKeyRead(key, selectedDB)
 	if !exists {
 		return ":0\r\n", nil
 	}
@@ -549,10 +631,12 @@ func cmdHEXISTS(args []string, selectedDB *int) (string, error) {
 			fmt.Errorf("wrong type")
 	}
 
-	hash := entry.Value.(map[string]string)
+	hash := entry.Value.(Hash)
 	field := args[2]
 
-	_, fieldExists := hash[field]
+	mu.RLock()
+	_, fieldExists := hash.Fields[field]
+	mu.RUnlock()
 	if fieldExists {
 		return ":1\r\n", nil
 	} else {
@@ -561,12 +645,8 @@ func cmdHEXISTS(args []string, selectedDB *int) (string, error) {
 }
 
 func cmdHLEN(args []string, selectedDB *int) (string, error) {
-	if len(args) != 2 {
-		return "-ERR wrong number of arguments for 'HLEN' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	key := args[1]
-	entry, exists := getEntry(key, selectedDB)
+	entry, exists := lookupKeyRead(key, selectedDB)
 	if !exists {
 		return ":0\r\n", nil
 	}
@@ -576,28 +656,83 @@ func cmdHLEN(args []string, selectedDB *int) (string, error) {
 			fmt.Errorf("wrong type")
 	}
 
-	hash := entry.Value.(map[string]string)
-	length := len(hash)
+	hash := entry.Value.(Hash)
+	mu.RLock()
+	length := hash.Len()
+	mu.RUnlock()
 
 	return ":" + strconv.Itoa(length) + "\r\n", nil
 }
 
+// zaddFlags are the options of ZADD, ZINCRBY is ZADD INCR
+type zaddFlags struct {
+	nx, xx, gt, lt, ch, incr bool
+}
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
 func cmdZADD(args []string, selectedDB *int) (string, error) {
-	if len(args) != 4 {
-		return "-ERR wrong number of arguments for 'ZADD' command\r\n", fmt.Errorf("wrong args")
+	var flags zaddFlags
+	i := 2
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			flags.nx = true
+		case "XX":
+			flags.xx = true
+		case "GT":
+			flags.gt = true
+		case "LT":
+			flags.lt = true
+		case "CH":
+			flags.ch = true
+		case "INCR":
+			flags.incr = true
+		default:
+			return zadd(args[1], flags, args[i:], selectedDB)
+		}
 	}
+	return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+}
 
-	key := args[1]
-	scoreStr := args[2]
-	member := args[3]
+// ZINCRBY key increment member
+func cmdZINCRBY(args []string, selectedDB *int) (string, error) {
+	return zadd(args[1], zaddFlags{incr: true}, args[2:], selectedDB)
+}
 
-	// parse score
-	score, err := strconv.ParseFloat(scoreStr, 64)
-	if err != nil {
-		return "-ERR value is not a valid float\r\n", err
+// zadd applies score/member pairs to key with Redis's ZADD rules: NX only
+// adds, XX only updates, GT and LT only update towards a higher or lower
+// score. The reply counts added members, or changed ones too with CH. With
+// INCR, the score is added to the current one and the reply is the new
+// score, nil when the flags prevented the update.
+func zadd(key string, flags zaddFlags, pairs []string, selectedDB *int) (string, error) {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+	}
+	if flags.incr && len(pairs) > 2 {
+		return "-ERR INCR option supports a single increment-element pair\r\n", fmt.Errorf("syntax error")
+	}
+	if flags.nx && flags.xx {
+		return "-ERR XX and NX options at the same time are not compatible\r\n", fmt.Errorf("syntax error")
+	}
+	if (flags.gt && flags.nx) || (flags.lt && flags.nx) || (flags.gt && flags.lt) {
+		return "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n", fmt.Errorf("syntax error")
 	}
 
-	entry, exists := getEntry(key, selectedDB)
+	// Every score is checked before anything is written
+	scores := make([]float64, len(pairs)/2)
+	for i := range scores {
+		score, err := strconv.ParseFloat(pairs[2*i], 64)
+		if err != nil || math.IsNaN(score) {
+			return "-ERR value is not a valid float\r\n", fmt.Errorf("invalid score")
+		}
+		scores[i] = score
+	}
+
+	// The set is changed in place: readers walk it under mu.RLock
+	mu.Lock()
+	defer mu.Unlock()
+
+	entry, exists := lookupEntryLocked(*selectedDB, key)
 
 	var z ZSet
 
@@ -609,42 +744,77 @@ func cmdZADD(args []string, selectedDB *int) (string, error) {
 		}
 		z = entry.Value.(ZSet)
 	} else {
-		// create new
-		z = ZSet{
-			Dict: make(map[string]float64),
-			List: []ZItem{},
+		if flags.xx {
+			// nothing can be updated, and no key is created
+			if flags.incr {
+				return "$-1\r\n", nil
+			}
+			return ":0\r\n", nil
 		}
+		z = newZSet()
 	}
 
-	_, alreadyExists := z.Dict[member]
+	added, changed := 0, 0
+	updated := true // whether the INCR member got its new score
+	var newScore float64
+	for i, score := range scores {
+		member := pairs[2*i+1]
+		old, memberExists := z.Dict[member]
 
-	// update dict + sorted list
-	z.Dict[member] = score
-	zsetUpdate(&z, member, score)
+		if memberExists {
+			if flags.nx {
+				updated = false
+				continue
+			}
+			if flags.incr {
+				score += old
+				if math.IsNaN(score) {
+					return "-ERR resulting score is not a number (NaN)\r\n", fmt.Errorf("nan score")
+				}
+			}
+			newScore = score
+			if (flags.gt && score <= old) || (flags.lt && score >= old) {
+				updated = false
+				continue
+			}
+			if score != old {
+				z.Add(member, score)
+				changed++
+			}
+		} else {
+			if flags.xx {
+				updated = false
+				continue
+			}
+			newScore = score
+			z.Add(member, score)
+			added++
+		}
+	}
 
-	// save entry
+	// save entry, keeping the TTL of an existing one
 	newEntry := Entry{
 		Type:  TypeZSet,
 		Value: z,
 	}
-
 	if exists {
-		newEntry.ExpireAt = entry.ExpireAt // preserve TTL
+		newEntry.ExpireAt = entry.ExpireAt
 	}
+	storeEntry(*selectedDB, key, newEntry)
 
-	setEntry(key, newEntry, selectedDB)
-
-	if alreadyExists {
-		return ":0\r\n", nil
+	if flags.incr {
+		if !updated {
+			return "$-1\r\n", nil
+		}
+		return bulkString(strconv.FormatFloat(newScore, 'f', -1, 64)), nil
 	}
-	return ":1\r\n", nil
+	if flags.ch {
+		added += changed
+	}
+	return ":" + strconv.Itoa(added) + "\r\n", nil
 }
 
 func cmdZRANGE(args []string, selectedDB *int) (string, error) {
-	if len(args) != 4 {
-		return "-ERR wrong number of arguments for 'ZRANGE' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	key := args[1]
 	start, err := strconv.Atoi(args[2])
 	if err != nil {
@@ -655,7 +825,7 @@ func cmdZRANGE(args []string, selectedDB *int) (string, error) {
 		return "-ERR end is not an integer\r\n", err
 	}
 
-	entry, exists := getEntry(key, selectedDB)
+	entry, exists := lookupKeyRead(key, selectedDB)
 	if !exists {
 		return "*0\r\n", nil
 	}
@@ -666,11 +836,10 @@ func cmdZRANGE(args []string, selectedDB *int) (string, error) {
 	}
 
 	z := entry.Value.(ZSet)
-	n := len(z.List)
 
-	if n == 0 {
-		return "*0\r\n", nil
-	}
+	// Writers change the set in place, holding mu
+	mu.RLock()
+	n := z.Len()
 
 	// handle negative indices
 	if start < 0 {
@@ -687,31 +856,23 @@ func cmdZRANGE(args []string, selectedDB *int) (string, error) {
 	if end >= n {
 		end = n - 1
 	}
-	if start > end {
-		return "*0\r\n", nil
-	}
-
-	count := end - start + 1
+	items := z.Range(start, end)
+	mu.RUnlock()
 
 	var resp strings.Builder
-	resp.WriteString("*" + strconv.Itoa(count) + "\r\n")
+	resp.WriteString("*" + strconv.Itoa(len(items)) + "\r\n")
 
-	for i := start; i <= end; i++ {
-		member := z.List[i]
+	for _, member := range items {
 		resp.WriteString("$" + strconv.Itoa(len(member.Member)) + "\r\n" + member.Member + "\r\n")
 	}
 	return resp.String(), nil
 }
 
 func cmdZSCORE(args []string, selectedDB *int) (string, error) {
-	if len(args) != 3 {
-		return "-ERR wrong number of arguments for 'ZSCORE' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	key := args[1]
 	member := args[2]
 
-	entry, exists := getEntry(key, selectedDB)
+	entry, exists := lookupKeyRead(key, selectedDB)
 	if !exists {
 		return "$-1\r\n", nil
 	}
@@ -723,7 +884,9 @@ func cmdZSCORE(args []string, selectedDB *int) (string, error) {
 
 	z := entry.Value.(ZSet)
 
+	mu.RLock()
 	score, memberExists := z.Dict[member]
+	mu.RUnlock()
 	if !memberExists {
 		return "$-1\r\n", nil
 	}
@@ -733,17 +896,26 @@ func cmdZSCORE(args []string, selectedDB *int) (string, error) {
 	return resp, nil
 }
 
-func cmdZREM(args []string, selectedDB *int) (string, error) {
-	if len(args) != 3 {
-		return "-ERR wrong number of arguments for 'ZREM' command\r\n", fmt.Errorf("wrong args")
-	}
-
+// ZRANK key member [WITHSCORE]
+func cmdZRANK(args []string, selectedDB *int) (string, error) {
 	key := args[1]
 	member := args[2]
 
-	entry, exists := getEntry(key, selectedDB)
+	withScore := false
+	if len(args) == 4 && strings.EqualFold(args[3], "WITHSCORE") {
+		withScore = true
+	} else if len(args) > 3 {
+		return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+	}
+
+	notFound := "$-1\r\n"
+	if withScore {
+		notFound = "*-1\r\n"
+	}
+
+	entry, exists := lookupKeyRead(key, selectedDB)
 	if !exists {
-		return ":0\r\n", nil
+		return notFound, nil
 	}
 
 	if entry.Type != TypeZSet {
@@ -753,33 +925,53 @@ func cmdZREM(args []string, selectedDB *int) (string, error) {
 
 	z := entry.Value.(ZSet)
 
-	_, memberExists := z.Dict[member]
+	mu.RLock()
+	rank, memberExists := z.Rank(member)
+	score := z.Dict[member]
+	mu.RUnlock()
 	if !memberExists {
+		return notFound, nil
+	}
+
+	if !withScore {
+		return ":" + strconv.Itoa(rank) + "\r\n", nil
+	}
+	scoreStr := strconv.FormatFloat(score, 'f', -1, 64)
+	return "*2\r\n:" + strconv.Itoa(rank) + "\r\n$" + strconv.Itoa(len(scoreStr)) + "\r\n" + scoreStr + "\r\n", nil
+}
+
+func cmdZREM(args []string, selectedDB *int) (string, error) {
+	key := args[1]
+	member := args[2]
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	entry, exists := lookupEntryLocked(*selectedDB, key)
+	if !exists {
 		return ":0\r\n", nil
 	}
 
-	// Remove from dict and list
-	delete(z.Dict, member)
-	for i, item := range z.List {
-		if item.Member == member {
-			z.List = append(z.List[:i], z.List[i+1:]...)
-			break
-		}
+	if entry.Type != TypeZSet {
+		return "-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
+			fmt.Errorf("wrong type")
+	}
+
+	z := entry.Value.(ZSet)
+
+	if !z.Remove(member) {
+		return ":0\r\n", nil
 	}
 
 	// Save updated entry
 	entry.Value = z
-	setEntry(key, entry, selectedDB)
+	storeEntry(*selectedDB, key, entry)
 	return ":1\r\n", nil
 }
 
 func cmdZCARD(args []string, selectedDB *int) (string, error) {
-	if len(args) != 2 {
-		return "-ERR wrong number of arguments for 'ZCARD' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	key := args[1]
-	entry, exists := getEntry(key, selectedDB)
+	entry, exists := lookupKeyRead(key, selectedDB)
 	if !exists {
 		return ":0\r\n", nil
 	}
@@ -790,16 +982,14 @@ func cmdZCARD(args []string, selectedDB *int) (string, error) {
 	}
 
 	z := entry.Value.(ZSet)
-	cardinality := len(z.Dict)
+	mu.RLock()
+	cardinality := z.Len()
+	mu.RUnlock()
 
 	return ":" + strconv.Itoa(cardinality) + "\r\n", nil
 }
 
 func cmdZRANGEBYSCORE(args []string, selectedDB *int) (string, error) {
-	if len(args) != 4 {
-		return "-ERR wrong number of arguments for 'ZRANGEBYSCORE' command\r\n", fmt.Errorf("wrong args")
-	}
-
 	key := args[1]
 	minStr := args[2]
 	maxStr := args[3]
@@ -814,7 +1004,7 @@ func cmdZRANGEBYSCORE(args []string, selectedDB *int) (string, error) {
 		return "-ERR max is not a valid float\r\n", err
 	}
 
-	entry, exists := getEntry(key, selectedDB)
+	entry, exists := lookupKeyRead(key, selectedDB)
 	if !exists {
 		return "*0\r\n", nil
 	}
@@ -826,21 +1016,28 @@ func cmdZRANGEBYSCORE(args []string, selectedDB *int) (string, error) {
 
 	z := entry.Value.(ZSet)
 
-	var resp strings.Builder
-	count := 0
-	for _, item := range z.List {
-		if item.Score >= min && item.Score <= max {
-			count++
-		}
-	}
+	mu.RLock()
+	items := z.RangeByScore(min, max)
+	mu.RUnlock()
 
-	resp.WriteString("*" + strconv.Itoa(count) + "\r\n")
+	var resp strings.Builder
+	resp.WriteString("*" + strconv.Itoa(len(items)) + "\r\n")
 
-	for _, item := range z.List {
-		if item.Score >= min && item.Score <= max {
-			resp.WriteString("$" + strconv.Itoa(len(item.Member)) + "\r\n" + item.Member + "\r\n")
-		}
+	for _, item := range items {
+		resp.WriteString("$" + strconv.Itoa(len(item.Member)) + "\r\n" + item.Member + "\r\n")
 	}
 
 	return resp.String(), nil
 }
+
+func cmdSELECT(args []string, selectedDB *int) (string, error) {
+	dbIndex, err := strconv.Atoi(args[1])
+	if err != nil || dbIndex < 0 || dbIndex >= NumDatabases {
+		return "-ERR invalid database index\r\n", fmt.Errorf("invalid db index")
+	}
+	if clusterEnabled && dbIndex != 0 {
+		return "-ERR SELECT is not allowed in cluster mode\r\n", fmt.Errorf("cluster mode")
+	}
+	*selectedDB = dbIndex
+	return "+OK\r\n", nil
+}
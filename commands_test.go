@@ -0,0 +1,423 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const wrongType = "-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+
+// resetKeyspace empties every database so tests don't leak into each other
+func resetKeyspace() {
+	mu.Lock()
+	flushAllDatabases()
+	mu.Unlock()
+}
+
+// cmd is a short way of writing a command line in the tables below
+func cmd(line string) []string {
+	return strings.Fields(line)
+}
+
+type commandCase struct {
+	name  string
+	setup []string // commands run first, their replies are ignored
+	args  []string
+	want  string
+}
+
+// commandCases has at least one entry for every command in commandTable;
+// TestCommandTableCoverage enforces that.
+var commandCases = map[string][]commandCase{
+	"GET": {
+		{"missing", nil, cmd("GET k"), "$-1\r\n"},
+		{"existing", []string{"SET k v"}, cmd("GET k"), "$1\r\nv\r\n"},
+		{"arity", nil, cmd("GET"), "-ERR wrong number of arguments for 'GET' command\r\n"},
+		{"wrong type", []string{"HSET k f v"}, cmd("GET k"), wrongType},
+	},
+	"SET": {
+		{"new", nil, cmd("SET k v"), "+OK\r\n"},
+		{"overwrite other type", []string{"HSET k f v"}, cmd("SET k v"), "+OK\r\n"},
+		{"arity", nil, cmd("SET k"), "-ERR wrong number of arguments for 'SET' command\r\n"},
+	},
+	"DEL": {
+		{"existing", []string{"SET k v"}, cmd("DEL k"), ":1\r\n"},
+		{"missing", nil, cmd("DEL k"), ":0\r\n"},
+		{"arity", nil, cmd("DEL"), "-ERR wrong number of arguments for 'DEL' command\r\n"},
+	},
+	"PING": {
+		{"plain", nil, cmd("PING"), "+PONG\r\n"},
+		{"message", nil, cmd("PING hello"), "$5\r\nhello\r\n"},
+		{"arity", nil, cmd("PING a b"), "-ERR wrong number of arguments for 'PING' command\r\n"},
+	},
+	"ECHO": {
+		{"message", nil, cmd("ECHO hi"), "$2\r\nhi\r\n"},
+		{"empty", nil, []string{"ECHO", ""}, "$0\r\n\r\n"},
+		{"arity", nil, cmd("ECHO"), "-ERR wrong number of arguments for 'ECHO' command\r\n"},
+	},
+	"EXISTS": {
+		{"counts keys", []string{"SET a 1", "HSET b f v"}, cmd("EXISTS a b c a"), ":3\r\n"},
+		{"arity", nil, cmd("EXISTS"), "-ERR wrong number of arguments for 'EXISTS' command\r\n"},
+	},
+	"INCR": {
+		{"new", nil, cmd("INCR n"), ":1\r\n"},
+		{"existing", []string{"SET n 41"}, cmd("INCR n"), ":42\r\n"},
+		{"not an integer", []string{"SET n abc"}, cmd("INCR n"), "-ERR value is not an integer\r\n"},
+		{"wrong type", []string{"HSET n f v"}, cmd("INCR n"), "-ERR value is not an integer\r\n"},
+		{"arity", nil, cmd("INCR"), "-ERR wrong number of arguments for 'INCR' command\r\n"},
+	},
+	"DECR": {
+		{"new", nil, cmd("DECR n"), ":-1\r\n"},
+		{"existing", []string{"SET n 43"}, cmd("DECR n"), ":42\r\n"},
+		{"not an integer", []string{"SET n 1.5"}, cmd("DECR n"), "-ERR value is not an integer\r\n"},
+		{"arity", nil, cmd("DECR"), "-ERR wrong number of arguments for 'DECR' command\r\n"},
+	},
+	"MGET": {
+		{"mixed", []string{"SET a 1", "HSET h f v"}, cmd("MGET a missing h"), "*3\r\n$1\r\n1\r\n$-1\r\n$-1\r\n"},
+		{"arity", nil, cmd("MGET"), "-ERR wrong number of arguments for 'MGET' command\r\n"},
+	},
+	"MSET": {
+		{"pairs", nil, cmd("MSET a 1 b 2"), "+OK\r\n"},
+		{"odd", nil, cmd("MSET a 1 b"), "-ERR wrong number of arguments for 'MSET' command\r\n"},
+	},
+	"FLUSHALL": {
+		{"sync", []string{"SET a 1"}, cmd("FLUSHALL"), "+OK\r\n"},
+		{"async", nil, cmd("FLUSHALL ASYNC"), "+OK\r\n"},
+		{"bad mode", nil, cmd("FLUSHALL LATER"), "-ERR unknown mode for 'FLUSHALL'\r\n"},
+		{"arity", nil, cmd("FLUSHALL SYNC x"), "-ERR wrong number of arguments for 'FLUSHALL'\r\n"},
+	},
+	"EXPIRE": {
+		{"existing", []string{"SET k v"}, cmd("EXPIRE k 100"), ":1\r\n"},
+		{"missing", nil, cmd("EXPIRE k 100"), ":0\r\n"},
+		{"NX with ttl", []string{"SET k v", "EXPIRE k 100"}, cmd("EXPIRE k 50 NX"), ":0\r\n"},
+		{"XX without ttl", []string{"SET k v"}, cmd("EXPIRE k 50 XX"), ":0\r\n"},
+		{"GT smaller", []string{"SET k v", "EXPIRE k 100"}, cmd("EXPIRE k 50 GT"), ":0\r\n"},
+		{"LT smaller", []string{"SET k v", "EXPIRE k 100"}, cmd("EXPIRE k 50 LT"), ":1\r\n"},
+		{"negative", []string{"SET k v"}, cmd("EXPIRE k -1"), "-ERR invalid expire time\r\n"},
+		{"bad option", []string{"SET k v"}, cmd("EXPIRE k 10 YY"), "-ERR invalid expire option\r\n"},
+		{"arity", nil, cmd("EXPIRE k"), "-ERR wrong number of arguments for 'EXPIRE' command\r\n"},
+	},
+	"PERSIST": {
+		{"with ttl", []string{"SET k v", "EXPIRE k 100"}, cmd("PERSIST k"), ":1\r\n"},
+		{"missing", nil, cmd("PERSIST k"), ":0\r\n"},
+		{"arity", nil, cmd("PERSIST"), "-ERR wrong number of arguments for 'PERSIST' command\r\n"},
+	},
+	"TTL": {
+		{"missing", nil, cmd("TTL k"), ":-2\r\n"},
+		{"no ttl", []string{"SET k v"}, cmd("TTL k"), ":-1\r\n"},
+		{"expired", []string{"SET k v", "EXPIRE k 0"}, cmd("TTL k"), ":-2\r\n"},
+		{"arity", nil, cmd("TTL"), "-ERR wrong number of arguments for 'TTL' command\r\n"},
+	},
+	"HSET": {
+		{"new fields", nil, cmd("HSET h a 1 b 2"), ":2\r\n"},
+		{"update", []string{"HSET h a 1"}, cmd("HSET h a 2 b 3"), ":1\r\n"},
+		{"wrong type", []string{"SET h v"}, cmd("HSET h a 1"), wrongType},
+		{"arity", nil, cmd("HSET h a"), "-ERR wrong number of arguments for 'HSET' command\r\n"},
+	},
+	"HGET": {
+		{"field", []string{"HSET h a 1"}, cmd("HGET h a"), "$1\r\n1\r\n"},
+		{"missing field", []string{"HSET h a 1"}, cmd("HGET h b"), "$-1\r\n"},
+		{"missing key", nil, cmd("HGET h a"), "$-1\r\n"},
+		{"wrong type", []string{"SET h v"}, cmd("HGET h a"), wrongType},
+		{"arity", nil, cmd("HGET h"), "-ERR wrong number of arguments for 'HGET' command\r\n"},
+	},
+	"HDEL": {
+		{"fields", []string{"HSET h a 1 b 2"}, cmd("HDEL h a b c"), ":2\r\n"},
+		{"missing key", nil, cmd("HDEL h a"), ":0\r\n"},
+		{"wrong type", []string{"SET h v"}, cmd("HDEL h a"), wrongType},
+		{"arity", nil, cmd("HDEL h"), "-ERR wrong number of arguments for 'HDEL' command\r\n"},
+	},
+	"HGETALL": {
+		{"single field", []string{"HSET h a 1"}, cmd("HGETALL h"), "*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{"missing", nil, cmd("HGETALL h"), "*0\r\n"},
+		{"wrong type", []string{"SET h v"}, cmd("HGETALL h"), wrongType},
+		{"arity", nil, cmd("HGETALL"), "-ERR wrong number of arguments for 'HGETALL' command\r\n"},
+	},
+	"HEXISTS": {
+		{"present", []string{"HSET h a 1"}, cmd("HEXISTS h a"), ":1\r\n"},
+		{"absent", []string{"HSET h a 1"}, cmd("HEXISTS h b"), ":0\r\n"},
+		{"wrong type", []string{"SET h v"}, cmd("HEXISTS h a"), wrongType},
+		{"arity", nil, cmd("HEXISTS h"), "-ERR wrong number of arguments for 'HEXISTS' command\r\n"},
+	},
+	"HLEN": {
+		{"fields", []string{"HSET h a 1 b 2"}, cmd("HLEN h"), ":2\r\n"},
+		{"missing", nil, cmd("HLEN h"), ":0\r\n"},
+		{"wrong type", []string{"SET h v"}, cmd("HLEN h"), wrongType},
+		{"arity", nil, cmd("HLEN"), "-ERR wrong number of arguments for 'HLEN' command\r\n"},
+	},
+	"ZADD": {
+		{"new", nil, cmd("ZADD z 1 a"), ":1\r\n"},
+		{"update", []string{"ZADD z 1 a"}, cmd("ZADD z 2 a"), ":0\r\n"},
+		{"bad score", nil, cmd("ZADD z x a"), "-ERR value is not a valid float\r\n"},
+		{"nan score", nil, cmd("ZADD z nan a"), "-ERR value is not a valid float\r\n"},
+		{"wrong type", []string{"SET z v"}, cmd("ZADD z 1 a"), wrongType},
+		{"arity", nil, cmd("ZADD z 1"), "-ERR wrong number of arguments for 'ZADD' command\r\n"},
+		{"pairs", []string{"ZADD z 1 a"}, cmd("ZADD z 2 a 2 b 3 c"), ":2\r\n"},
+		{"odd pairs", nil, cmd("ZADD z 1 a 2"), "-ERR syntax error\r\n"},
+		{"only flags", nil, cmd("ZADD z NX CH"), "-ERR syntax error\r\n"},
+		{"bad later score", nil, cmd("ZADD z 1 a x b"), "-ERR value is not a valid float\r\n"},
+		{"ch", []string{"ZADD z 1 a 2 b"}, cmd("ZADD z CH 5 a 2 b 3 c"), ":2\r\n"},
+		{"nx", []string{"ZADD z 1 a"}, cmd("ZADD z NX CH 5 a 2 b"), ":1\r\n"},
+		{"xx", []string{"ZADD z 1 a"}, cmd("ZADD z XX CH 5 a 2 b"), ":1\r\n"},
+		{"xx missing key", nil, cmd("ZADD z XX 1 a"), ":0\r\n"},
+		{"gt", []string{"ZADD z 5 a 5 b"}, cmd("ZADD z GT CH 6 a 4 b 1 c"), ":2\r\n"},
+		{"lt", []string{"ZADD z 5 a 5 b"}, cmd("ZADD z LT CH 6 a 4 b"), ":1\r\n"},
+		{"incr", []string{"ZADD z 1.5 a"}, cmd("ZADD z INCR 2 a"), "$3\r\n3.5\r\n"},
+		{"incr new", nil, cmd("ZADD z INCR 2 a"), "$1\r\n2\r\n"},
+		{"incr nx existing", []string{"ZADD z 1 a"}, cmd("ZADD z NX INCR 2 a"), "$-1\r\n"},
+		{"incr gt lower", []string{"ZADD z 1 a"}, cmd("ZADD z GT INCR -2 a"), "$-1\r\n"},
+		{"incr xx missing", nil, cmd("ZADD z XX INCR 2 a"), "$-1\r\n"},
+		{"incr nan", []string{"ZADD z inf a"}, cmd("ZADD z INCR -inf a"), "-ERR resulting score is not a number (NaN)\r\n"},
+		{"incr pairs", nil, cmd("ZADD z INCR 1 a 2 b"), "-ERR INCR option supports a single increment-element pair\r\n"},
+		{"nx xx", nil, cmd("ZADD z NX XX 1 a"), "-ERR XX and NX options at the same time are not compatible\r\n"},
+		{"gt lt", nil, cmd("ZADD z GT LT 1 a"), "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n"},
+		{"gt nx", nil, cmd("ZADD z GT NX 1 a"), "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n"},
+	},
+	"ZINCRBY": {
+		{"existing", []string{"ZADD z 1 a"}, cmd("ZINCRBY z 2.5 a"), "$3\r\n3.5\r\n"},
+		{"new", nil, cmd("ZINCRBY z -1 a"), "$2\r\n-1\r\n"},
+		{"bad increment", nil, cmd("ZINCRBY z x a"), "-ERR value is not a valid float\r\n"},
+		{"wrong type", []string{"SET z v"}, cmd("ZINCRBY z 1 a"), wrongType},
+		{"arity", nil, cmd("ZINCRBY z 1"), "-ERR wrong number of arguments for 'ZINCRBY' command\r\n"},
+	},
+	"ZRANGE": {
+		{"all", []string{"ZADD z 2 b", "ZADD z 1 a", "ZADD z 3 c"}, cmd("ZRANGE z 0 -1"), "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{"negative", []string{"ZADD z 2 b", "ZADD z 1 a", "ZADD z 3 c"}, cmd("ZRANGE z -2 -1"), "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{"out of range", []string{"ZADD z 1 a"}, cmd("ZRANGE z 5 10"), "*0\r\n"},
+		{"ties by member", []string{"ZADD z 1 c", "ZADD z 1 a", "ZADD z 1 b"}, cmd("ZRANGE z 0 -1"), "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{"missing", nil, cmd("ZRANGE z 0 -1"), "*0\r\n"},
+		{"not an integer", nil, cmd("ZRANGE z a 1"), "-ERR start is not an integer\r\n"},
+		{"wrong type", []string{"SET z v"}, cmd("ZRANGE z 0 1"), wrongType},
+		{"arity", nil, cmd("ZRANGE z 0"), "-ERR wrong number of arguments for 'ZRANGE' command\r\n"},
+	},
+	"ZSCORE": {
+		{"member", []string{"ZADD z 1.5 a"}, cmd("ZSCORE z a"), "$3\r\n1.5\r\n"},
+		{"missing member", []string{"ZADD z 1 a"}, cmd("ZSCORE z b"), "$-1\r\n"},
+		{"missing key", nil, cmd("ZSCORE z a"), "$-1\r\n"},
+		{"wrong type", []string{"SET z v"}, cmd("ZSCORE z a"), wrongType},
+		{"arity", nil, cmd("ZSCORE z"), "-ERR wrong number of arguments for 'ZSCORE' command\r\n"},
+	},
+	"ZRANK": {
+		{"member", []string{"ZADD z 2 b", "ZADD z 1 a", "ZADD z 2 c"}, cmd("ZRANK z c"), ":2\r\n"},
+		{"tie by member", []string{"ZADD z 1 b", "ZADD z 1 a"}, cmd("ZRANK z a"), ":0\r\n"},
+		{"with score", []string{"ZADD z 1 a", "ZADD z 2.5 b"}, cmd("ZRANK z b WITHSCORE"), "*2\r\n:1\r\n$3\r\n2.5\r\n"},
+		{"missing member", []string{"ZADD z 1 a"}, cmd("ZRANK z b"), "$-1\r\n"},
+		{"missing with score", nil, cmd("ZRANK z a WITHSCORE"), "*-1\r\n"},
+		{"syntax", []string{"ZADD z 1 a"}, cmd("ZRANK z a NOPE"), "-ERR syntax error\r\n"},
+		{"wrong type", []string{"SET z v"}, cmd("ZRANK z a"), wrongType},
+		{"arity", nil, cmd("ZRANK z"), "-ERR wrong number of arguments for 'ZRANK' command\r\n"},
+	},
+	"ZREM": {
+		{"member", []string{"ZADD z 1 a"}, cmd("ZREM z a"), ":1\r\n"},
+		{"missing member", []string{"ZADD z 1 a"}, cmd("ZREM z b"), ":0\r\n"},
+		{"missing key", nil, cmd("ZREM z a"), ":0\r\n"},
+		{"wrong type", []string{"SET z v"}, cmd("ZREM z a"), wrongType},
+		{"arity", nil, cmd("ZREM z"), "-ERR wrong number of arguments for 'ZREM' command\r\n"},
+	},
+	"ZCARD": {
+		{"members", []string{"ZADD z 1 a", "ZADD z 2 b"}, cmd("ZCARD z"), ":2\r\n"},
+		{"missing", nil, cmd("ZCARD z"), ":0\r\n"},
+		{"wrong type", []string{"SET z v"}, cmd("ZCARD z"), wrongType},
+		{"arity", nil, cmd("ZCARD"), "-ERR wrong number of arguments for 'ZCARD' command\r\n"},
+	},
+	"ZRANGEBYSCORE": {
+		{"range", []string{"ZADD z 1 a", "ZADD z 2 b", "ZADD z 3 c"}, cmd("ZRANGEBYSCORE z 1.5 3"), "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{"empty", []string{"ZADD z 1 a"}, cmd("ZRANGEBYSCORE z 5 6"), "*0\r\n"},
+		{"bad min", nil, cmd("ZRANGEBYSCORE z x 1"), "-ERR min is not a valid float\r\n"},
+		{"wrong type", []string{"SET z v"}, cmd("ZRANGEBYSCORE z 0 1"), wrongType},
+		{"arity", nil, cmd("ZRANGEBYSCORE z 0"), "-ERR wrong number of arguments for 'ZRANGEBYSCORE' command\r\n"},
+	},
+	"CONFIG": {
+		{"get", nil, cmd("CONFIG GET appendfsync"), "*2\r\n$11\r\nappendfsync\r\n$8\r\neverysec\r\n"},
+		{"get glob", nil, cmd("CONFIG GET proto-max-*"), "*4\r\n$18\r\nproto-max-bulk-len\r\n$9\r\n536870912\r\n$23\r\nproto-max-multibulk-len\r\n$7\r\n1048576\r\n"},
+		{"get nothing", nil, cmd("CONFIG GET nope*"), "*0\r\n"},
+		{"set", nil, cmd("CONFIG SET janitor-interval 10"), "+OK\r\n"},
+		{"set out of range", nil, cmd("CONFIG SET janitor-interval 0"),
+			"-ERR CONFIG SET failed (possibly related to argument 'janitor-interval') - argument must be between 1 and 3600 inclusive\r\n"},
+		{"set immutable", nil, cmd("CONFIG SET port 7000"),
+			"-ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config\r\n"},
+		{"set unknown", nil, cmd("CONFIG SET nope 1"), "-ERR Unknown option or number of arguments for CONFIG SET - 'nope'\r\n"},
+		{"resetstat", nil, cmd("CONFIG RESETSTAT"), "+OK\r\n"},
+		{"bad subcommand", nil, cmd("CONFIG FOO"), "-ERR unknown subcommand 'FOO'. Try CONFIG GET, SET, REWRITE, RESETSTAT.\r\n"},
+		{"arity", nil, cmd("CONFIG"), "-ERR wrong number of arguments for 'CONFIG' command\r\n"},
+	},
+	"SELECT": {
+		{"valid", nil, cmd("SELECT 1"), "+OK\r\n"},
+		{"out of range", nil, cmd("SELECT 16"), "-ERR invalid database index\r\n"},
+		{"arity", nil, cmd("SELECT"), "-ERR wrong number of arguments for 'SELECT' command\r\n"},
+	},
+	"INFO": {
+		{"unknown section", nil, cmd("INFO nosuchsection"), "$0\r\n\r\n"},
+		{"keyspace", []string{"SET a 1", "SET b 2"}, cmd("INFO keyspace"), "$44\r\n# Keyspace\r\ndb0:keys=2,expires=0,avg_ttl=0\r\n\r\n"},
+	},
+	"SLOWLOG": {
+		{"len after reset", []string{"SLOWLOG RESET"}, cmd("SLOWLOG LEN"), ":0\r\n"},
+		{"get after reset", []string{"SLOWLOG RESET"}, cmd("SLOWLOG GET"), "*0\r\n"},
+		{"bad count", nil, cmd("SLOWLOG GET -2"), "-ERR count should be greater than or equal to -1\r\n"},
+		{"unknown subcommand", nil, cmd("SLOWLOG NOPE"), "-ERR unknown subcommand 'NOPE'. Try SLOWLOG GET, LEN, RESET.\r\n"},
+	},
+	"COMMAND": {
+		{"count arity", nil, cmd("COMMAND COUNT x"), "-ERR wrong number of arguments for 'COMMAND|count' command\r\n"},
+		{"info", nil, cmd("COMMAND INFO get nope"), "*2\r\n*10\r\n$3\r\nget\r\n:2\r\n*2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n*3\r\n+@read\r\n+@string\r\n+@fast\r\n*0\r\n*0\r\n*0\r\n*-1\r\n"},
+		{"docs", nil, cmd("COMMAND DOCS ttl"), "*2\r\n$3\r\nttl\r\n*4\r\n$7\r\nsummary\r\n$48\r\nReturns the expiration time in seconds of a key.\r\n$5\r\ngroup\r\n$7\r\ngeneric\r\n"},
+		{"getkeys", nil, cmd("COMMAND GETKEYS MSET a 1 b 2"), "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"getkeys without keys", nil, cmd("COMMAND GETKEYS PING"), "-ERR The command has no key arguments\r\n"},
+		{"getkeys arity", nil, cmd("COMMAND GETKEYS GET"), "-ERR Invalid number of arguments specified for command\r\n"},
+		{"getkeys unknown", nil, cmd("COMMAND GETKEYS NOPE k"), "-ERR Invalid command specified\r\n"},
+		{"list by category", nil, cmd("COMMAND LIST FILTERBY ACLCAT hash"), "*6\r\n$4\r\nhdel\r\n$7\r\nhexists\r\n$4\r\nhget\r\n$7\r\nhgetall\r\n$4\r\nhlen\r\n$4\r\nhset\r\n"},
+		{"list by pattern", nil, cmd("COMMAND LIST FILTERBY PATTERN z*score"), "*2\r\n$13\r\nzrangebyscore\r\n$6\r\nzscore\r\n"},
+		{"list syntax", nil, cmd("COMMAND LIST FILTERBY"), "-ERR syntax error\r\n"},
+	},
+	"LATENCY": {
+		{"latest after reset", []string{"LATENCY RESET"}, cmd("LATENCY LATEST"), "*0\r\n"},
+		{"history of unknown event", nil, cmd("LATENCY HISTORY nope"), "*0\r\n"},
+		{"reset unknown event", nil, cmd("LATENCY RESET nope"), ":0\r\n"},
+		{"history arity", nil, cmd("LATENCY HISTORY"), "-ERR wrong number of arguments for 'LATENCY|history' command\r\n"},
+		{"unknown subcommand", nil, cmd("LATENCY NOPE"), "-ERR unknown subcommand 'NOPE'. Try LATENCY HELP.\r\n"},
+	},
+	"REPLICAOF": {
+		{"no one while master", nil, cmd("REPLICAOF NO ONE"), "+OK\r\n"},
+		{"bad port", nil, cmd("REPLICAOF localhost nope"), "-ERR Invalid master port\r\n"},
+	},
+	"SLAVEOF": {
+		{"no one while master", nil, cmd("SLAVEOF no one"), "+OK\r\n"},
+	},
+	"CLUSTER": {
+		{"disabled", nil, cmd("CLUSTER INFO"), "-ERR This instance has cluster support disabled\r\n"},
+	},
+	"MIGRATE": {
+		{"missing key", nil, cmd("MIGRATE 127.0.0.1 1 k 0 100"), "+NOKEY\r\n"},
+		{"bad port", nil, cmd("MIGRATE 127.0.0.1 nope k 0 100"), "-ERR value is not an integer or out of range\r\n"},
+		{"keys with a key", nil, cmd("MIGRATE 127.0.0.1 1 k 0 100 KEYS a b"), "-ERR When using MIGRATE KEYS option, the key argument must be set to the empty string\r\n"},
+		{"unknown option", nil, cmd("MIGRATE 127.0.0.1 1 k 0 100 NOPE"), "-ERR syntax error\r\n"},
+	},
+	"DUMP": {
+		{"missing", nil, cmd("DUMP k"), "$-1\r\n"},
+		{"arity", nil, cmd("DUMP"), "-ERR wrong number of arguments for 'DUMP' command\r\n"},
+	},
+	"RESTORE": {
+		{"bad payload", nil, cmd("RESTORE k 0 nope"), "-ERR DUMP payload version or checksum are wrong\r\n"},
+		{"negative ttl", nil, cmd("RESTORE k -1 nope"), "-ERR Invalid TTL value, must be >= 0\r\n"},
+		{"bad freq", nil, cmd("RESTORE k 0 nope FREQ 256"), "-ERR Invalid FREQ value, must be >= 0 and <= 255\r\n"},
+		{"unknown option", nil, cmd("RESTORE k 0 nope NOPE"), "-ERR syntax error\r\n"},
+	},
+	"RESTORE-ASKING": {
+		{"bad payload", nil, cmd("RESTORE-ASKING k 0 nope"), "-ERR DUMP payload version or checksum are wrong\r\n"},
+	},
+}
+
+func TestCommandTableCoverage(t *testing.T) {
+	var missing []string
+	for name, def := range commandTable {
+		// Connection commands need a Client and have their own tests
+		if def.Func == nil {
+			continue
+		}
+		if len(commandCases[name]) == 0 {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Fatalf("commands without test cases: %v", missing)
+	}
+}
+
+func TestCommandDescriptors(t *testing.T) {
+	if got, _ := cmdCOMMAND(cmd("COMMAND COUNT"), nil); got != ":"+strconv.Itoa(len(commandTable))+"\r\n" {
+		t.Errorf("COMMAND COUNT: %q", got)
+	}
+	for name, def := range commandTable {
+		if def.Arity == 0 {
+			t.Errorf("%s has no arity", name)
+		}
+		if commandSummaries[name] == "" {
+			t.Errorf("%s has no COMMAND DOCS summary", name)
+		}
+		if def.hasFlag("write") && def.hasFlag("readonly") {
+			t.Errorf("%s is flagged both write and readonly", name)
+		}
+		if def.hasFlag("write") != def.hasCategory("write") {
+			t.Errorf("%s: write flag and @write category disagree", name)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	for name, cases := range commandCases {
+		for _, tc := range cases {
+			t.Run(name+"/"+tc.name, func(t *testing.T) {
+				resetKeyspace()
+				selectedDB := 0
+
+				for _, line := range tc.setup {
+					execCommand(cmd(line), &selectedDB)
+				}
+
+				got, _ := execCommand(tc.args, &selectedDB)
+				if got != tc.want {
+					t.Fatalf("%q: got %q, want %q", tc.args, got, tc.want)
+				}
+			})
+		}
+	}
+}
+
+func TestExecCommandSelect(t *testing.T) {
+	resetKeyspace()
+	selectedDB := 0
+
+	execCommand(cmd("SET k db0"), &selectedDB)
+	if got, _ := execCommand(cmd("SELECT 3"), &selectedDB); got != "+OK\r\n" || selectedDB != 3 {
+		t.Fatalf("SELECT 3: got %q, db %d", got, selectedDB)
+	}
+	if got, _ := execCommand(cmd("GET k"), &selectedDB); got != "$-1\r\n" {
+		t.Fatalf("keys leaked across databases: %q", got)
+	}
+
+	for _, bad := range []string{"SELECT -1", "SELECT 16", "SELECT x"} {
+		if got, err := execCommand(cmd(bad), &selectedDB); err == nil || got != "-ERR invalid database index\r\n" {
+			t.Fatalf("%s: got %q", bad, got)
+		}
+	}
+	if got, _ := execCommand(cmd("SELECT"), &selectedDB); got != "-ERR wrong number of arguments for 'SELECT' command\r\n" {
+		t.Fatalf("SELECT arity: got %q", got)
+	}
+}
+
+func TestExecCommandUnknown(t *testing.T) {
+	selectedDB := 0
+	got, err := execCommand(cmd("NOPE a"), &selectedDB)
+	if err == nil || got != "-ERR unknown command 'NOPE'\r\n" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestExpiredKeysAreInvisible(t *testing.T) {
+	resetKeyspace()
+	selectedDB := 0
+
+	setEntry("k", Entry{Type: TypeString, Value: "v", ExpireAt: 1}, &selectedDB)
+	if got, _ := execCommand(cmd("GET k"), &selectedDB); got != "$-1\r\n" {
+		t.Fatalf("expired key still readable: %q", got)
+	}
+	if got, _ := execCommand(cmd("EXISTS k"), &selectedDB); got != ":0\r\n" {
+		t.Fatalf("expired key still exists: %q", got)
+	}
+}
+
+func TestTTLCountsDown(t *testing.T) {
+	resetKeyspace()
+	selectedDB := 0
+
+	execCommand(cmd("SET k v"), &selectedDB)
+	execCommand(cmd("EXPIRE k 100"), &selectedDB)
+
+	// A second boundary may pass between the two calls
+	got, _ := execCommand(cmd("TTL k"), &selectedDB)
+	if got != ":100\r\n" && got != ":99\r\n" {
+		t.Fatalf("unexpected TTL reply %q", got)
+	}
+}
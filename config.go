@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Server settings. Defaults match the values that used to be hardcoded.
+// The ones CONFIG SET can change are atomics, read without configMu.
+var (
+	serverBind      = ""
+	serverPort      = 6379
+	appendOnly      = true
+	appendFsync     = newAtomicString("everysec") // always | everysec | no
+	janitorInterval = newAtomicInt(10)            // seconds between expired key sweeps
+)
+
+// configFile is the file the server was started with, "" if none.
+// CONFIG REWRITE writes back to it.
+var configFile = ""
+
+var configMu sync.Mutex
+
+// configParam describes one setting reachable from the config file, the
+// command line and CONFIG GET/SET.
+type configParam struct {
+	name      string
+	immutable bool // only settable at startup
+	sensitive bool // holds a secret, hidden from MONITOR
+	get       func() string
+	set       func(value string) error
+}
+
+var configParams = []*configParam{
+	stringConfig("bind", &serverBind, true),
+	intConfig("port", &serverPort, 0, 65535, true),
+	intConfig("databases", &NumDatabases, 1, 1<<16, true),
+	boolConfig("appendonly", &appendOnly, true),
+	stringConfig("appendfilename", &AOFFileName, true),
+	enumConfig("appendfsync", appendFsync, []string{"always", "everysec", "no"}),
+	atomicIntConfig("janitor-interval", janitorInterval, 1, 3600),
+	memoryConfig("proto-max-bulk-len", protoMaxBulkLen, 1, 1<<40),
+	memoryConfig("proto-max-multibulk-len", protoMaxMultibulkLen, 1, 1<<31),
+	memoryConfig("client-query-buffer-limit", clientQueryBufferLimit, 1024, 1<<40),
+	requirePassConfig(),
+	stringConfig("aclfile", &aclFile, true),
+	atomicIntConfig("acllog-max-len", aclLogMaxLen, 0, 1<<20),
+	intConfig("tls-port", &tlsPort, 0, 65535, true),
+	stringConfig("tls-cert-file", &tlsCertFile, true),
+	stringConfig("tls-key-file", &tlsKeyFile, true),
+	stringConfig("tls-ca-cert-file", &tlsCACertFile, true),
+	tlsAuthClientsConfig(),
+	stringConfig("unixsocket", &unixSocket, true),
+	octalConfig("unixsocketperm", &unixSocketPerm, true),
+	atomicStringConfig("dbfilename", dbFilename),
+	atomicIntConfig("shutdown-timeout", shutdownTimeout, 0, 3600),
+	atomicIntConfig("timeout", clientTimeout, 0, 1<<31-1),
+	atomicIntConfig("tcp-keepalive", tcpKeepAlive, 0, 1<<31-1),
+	atomicIntConfig("maxclients", maxClients, 1, 1<<31-1),
+	outputLimitConfig(),
+	atomicIntConfig("slowlog-log-slower-than", slowlogSlowerThan, -1, 1<<62),
+	atomicIntConfig("slowlog-max-len", slowlogMaxLen, 0, 1<<20),
+	atomicIntConfig("latency-monitor-threshold", latencyMonitorThreshold, 0, 1<<62),
+	intConfig("metrics-port", &metricsPort, 0, 65535, true),
+	replicaOfConfig(),
+	sensitiveConfig(atomicStringConfig("masterauth", masterAuth)),
+	sensitiveConfig(atomicStringConfig("masteruser", masterUser)),
+	atomicBoolConfig("replica-read-only", replicaReadOnly),
+	memoryConfig("repl-backlog-size", replBacklogSize, 16*1024, 1<<40),
+	atomicIntConfig("repl-ping-replica-period", replPingPeriod, 1, 3600),
+	atomicIntConfig("repl-timeout", replTimeout, 1, 3600),
+	boolConfig("cluster-enabled", &clusterEnabled, true),
+	stringConfig("cluster-config-file", &clusterConfigFile, true),
+	atomicIntConfig("cluster-node-timeout", clusterNodeTimeout, 100, 1<<30),
+	intConfig("cluster-port", &clusterPort, 0, 65535, true),
+}
+
+var configByName = map[string]*configParam{}
+
+// configDefaults holds each parameter's value before any config was
+// loaded, so REWRITE only writes what actually differs.
+var configDefaults = map[string]string{}
+
+func init() {
+	for _, p := range configParams {
+		configByName[p.name] = p
+		configDefaults[p.name] = p.get()
+	}
+}
+
+// sensitiveConfig marks p as holding a secret
+func sensitiveConfig(p *configParam) *configParam {
+	p.sensitive = true
+	return p
+}
+
+func stringConfig(name string, ptr *string, immutable bool) *configParam {
+	return &configParam{
+		name:      name,
+		immutable: immutable,
+		get:       func() string { return *ptr },
+		set: func(value string) error {
+			*ptr = value
+			return nil
+		},
+	}
+}
+
+// atomicStringConfig is stringConfig for values CONFIG SET can change
+func atomicStringConfig(name string, ptr *atomicString) *configParam {
+	return &configParam{
+		name: name,
+		get:  ptr.Load,
+		set: func(value string) error {
+			ptr.Store(value)
+			return nil
+		},
+	}
+}
+
+func intConfig(name string, ptr *int, min, max int, immutable bool) *configParam {
+	return &configParam{
+		name:      name,
+		immutable: immutable,
+		get:       func() string { return strconv.Itoa(*ptr) },
+		set: func(value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("argument couldn't be parsed into an integer")
+			}
+			if n < min || n > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+			*ptr = n
+			return nil
+		},
+	}
+}
+
+// atomicString is a string setting read concurrently by connections, the
+// counterpart of atomic.Int64 for the values CONFIG SET can change
+type atomicString struct {
+	v atomic.Value
+}
+
+func newAtomicString(s string) *atomicString {
+	a := new(atomicString)
+	a.Store(s)
+	return a
+}
+
+func (a *atomicString) Load() string {
+	return a.v.Load().(string)
+}
+
+func (a *atomicString) Store(s string) {
+	a.v.Store(s)
+}
+
+// atomicIntConfig is intConfig for values read concurrently by connections
+func atomicIntConfig(name string, ptr *atomic.Int64, min, max int64) *configParam {
+	return &configParam{
+		name: name,
+		get:  func() string { return strconv.FormatInt(ptr.Load(), 10) },
+		set: func(value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("argument couldn't be parsed into an integer")
+			}
+			if n < min || n > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+			ptr.Store(n)
+			return nil
+		},
+	}
+}
+
+func boolConfig(name string, ptr *bool, immutable bool) *configParam {
+	return &configParam{
+		name:      name,
+		immutable: immutable,
+		get: func() string {
+			if *ptr {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(value string) error {
+			switch strings.ToLower(value) {
+			case "yes":
+				*ptr = true
+			case "no":
+				*ptr = false
+			default:
+				return fmt.Errorf("argument must be 'yes' or 'no'")
+			}
+			return nil
+		},
+	}
+}
+
+func atomicBoolConfig(name string, ptr *atomic.Bool) *configParam {
+	return &configParam{
+		name: name,
+		get: func() string {
+			if ptr.Load() {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(value string) error {
+			switch strings.ToLower(value) {
+			case "yes":
+				ptr.Store(true)
+			case "no":
+				ptr.Store(false)
+			default:
+				return fmt.Errorf("argument must be 'yes' or 'no'")
+			}
+			return nil
+		},
+	}
+}
+
+func enumConfig(name string, ptr *atomicString, values []string) *configParam {
+	return &configParam{
+		name: name,
+		get:  ptr.Load,
+		set: func(value string) error {
+			value = strings.ToLower(value)
+			for _, v := range values {
+				if v == value {
+					ptr.Store(value)
+					return nil
+				}
+			}
+			return fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(values, ", "))
+		},
+	}
+}
+
+// memoryConfig accepts plain byte counts as well as 1k / 5mb / 2gb units
+func memoryConfig(name string, ptr *atomic.Int64, min, max int64) *configParam {
+	return &configParam{
+		name: name,
+		get:  func() string { return strconv.FormatInt(ptr.Load(), 10) },
+		set: func(value string) error {
+			n, err := parseMemory(value)
+			if err != nil {
+				return err
+			}
+			if n < min || n > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+			ptr.Store(n)
+			return nil
+		},
+	}
+}
+
+func parseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	lower := strings.ToLower(value)
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower = strings.TrimSuffix(lower, u.suffix)
+			mul = u.mul
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n > math.MaxInt64/mul || n < math.MinInt64/mul {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+	return n * mul, nil
+}
+
+// octalConfig holds file modes such as unixsocketperm 700
+func octalConfig(name string, ptr *int, immutable bool) *configParam {
+	return &configParam{
+		name:      name,
+		immutable: immutable,
+		get:       func() string { return strconv.FormatInt(int64(*ptr), 8) },
+		set: func(value string) error {
+			n, err := strconv.ParseInt(value, 8, 32)
+			if err != nil || n < 0 || n > 0777 {
+				return fmt.Errorf("argument must be an octal file mode between 0 and 777")
+			}
+			*ptr = int(n)
+			return nil
+		},
+	}
+}
+
+// LoadConfig applies the optional config file and then the command line
+// overrides. Usage: mini-redis [/path/to/redis.conf] [--name value ...]
+func LoadConfig(args []string) error {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		configFile = args[0]
+		args = args[1:]
+
+		if err := loadConfigFile(configFile); err != nil {
+			return err
+		}
+	}
+
+	// --port 6380 --appendonly no ...
+	for i := 0; i < len(args); {
+		if !strings.HasPrefix(args[i], "--") {
+			return fmt.Errorf("unexpected argument '%s'", args[i])
+		}
+
+		directive := []string{strings.TrimPrefix(args[i], "--")}
+		i++
+		for i < len(args) && !strings.HasPrefix(args[i], "--") {
+			directive = append(directive, args[i])
+			i++
+		}
+
+		if err := applyConfigDirective(directive); err != nil {
+			return fmt.Errorf("command line: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func loadConfigFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		directive, err := splitConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		if directive == nil {
+			continue
+		}
+		if err := applyConfigDirective(directive); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// splitConfigLine tokenizes a config line with the inline command rules,
+// returning nil for blank lines and comments
+func splitConfigLine(line string) ([]string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return nil, nil
+	}
+
+	tokens, err := parseInline(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	return tokens, nil
+}
+
+func applyConfigDirective(directive []string) error {
+	name := strings.ToLower(directive[0])
+	if name == "sentinel" {
+		if err := sentinelDirective(directive[1:]); err != nil {
+			return fmt.Errorf("'sentinel': %v", err)
+		}
+		return nil
+	}
+
+	p, ok := configByName[name]
+	if !ok {
+		return fmt.Errorf("bad directive or wrong number of arguments '%s'", name)
+	}
+	if len(directive) < 2 {
+		return fmt.Errorf("missing value for '%s'", name)
+	}
+
+	if err := p.set(strings.Join(directive[1:], " ")); err != nil {
+		return fmt.Errorf("'%s': %v", name, err)
+	}
+	return nil
+}
+
+// rewriteConfig updates the config file in place: known directives get
+// their current value, comments and unknown lines are kept, and settings
+// that differ from their default but weren't in the file are appended.
+func rewriteConfig() error {
+	if configFile == "" {
+		return fmt.Errorf("The server is running without a config file")
+	}
+
+	var lines []string
+	if data, err := os.ReadFile(configFile); err == nil {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	written := map[string]bool{}
+	var out []string
+
+	for _, line := range lines {
+		directive, err := splitConfigLine(line)
+		if err != nil || directive == nil {
+			out = append(out, line)
+			continue
+		}
+
+		name := strings.ToLower(directive[0])
+		p, ok := configByName[name]
+		if !ok {
+			out = append(out, line)
+			continue
+		}
+
+		// Later duplicates of a directive are dropped
+		if written[name] {
+			continue
+		}
+		written[name] = true
+		out = append(out, formatConfigLine(p))
+	}
+
+	for _, p := range configParams {
+		if !written[p.name] && p.get() != configDefaults[p.name] {
+			out = append(out, formatConfigLine(p))
+		}
+	}
+
+	tmp := configFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(out, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, configFile)
+}
+
+func formatConfigLine(p *configParam) string {
+	value := p.get()
+	if value == "" || strings.ContainsAny(value, " \t\"'") {
+		value = strconv.Quote(value)
+	}
+	return p.name + " " + value
+}
+
+func cmdCONFIG(args []string, selectedDB *int) (string, error) {
+	sub := strings.ToUpper(args[1])
+
+	switch sub {
+	case "GET":
+		if len(args) < 3 {
+			return "-ERR wrong number of arguments for 'CONFIG|GET' command\r\n", fmt.Errorf("wrong args")
+		}
+
+		configMu.Lock()
+		matched := map[string]string{}
+		for _, pattern := range args[2:] {
+			for _, p := range configParams {
+				if stringMatch(pattern, p.name, true) {
+					matched[p.name] = p.get()
+				}
+			}
+		}
+		configMu.Unlock()
+
+		names := make([]string, 0, len(matched))
+		for name := range matched {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var resp strings.Builder
+		resp.WriteString("*" + strconv.Itoa(len(names)*2) + "\r\n")
+		for _, name := range names {
+			value := matched[name]
+			resp.WriteString("$" + strconv.Itoa(len(name)) + "\r\n" + name + "\r\n")
+			resp.WriteString("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n")
+		}
+		return resp.String(), nil
+
+	case "SET":
+		if len(args) < 4 || len(args[2:])%2 != 0 {
+			return "-ERR wrong number of arguments for 'CONFIG|SET' command\r\n", fmt.Errorf("wrong args")
+		}
+
+		configMu.Lock()
+		defer configMu.Unlock()
+
+		// Validate everything first so a bad pair doesn't leave a half
+		// applied change behind
+		type change struct {
+			p        *configParam
+			value    string
+			previous string
+		}
+		var changes []change
+		for i := 2; i < len(args); i += 2 {
+			name := strings.ToLower(args[i])
+			p, ok := configByName[name]
+			if !ok {
+				return "-ERR Unknown option or number of arguments for CONFIG SET - '" + name + "'\r\n",
+					fmt.Errorf("unknown option")
+			}
+			if p.immutable {
+				return "-ERR CONFIG SET failed (possibly related to argument '" + name + "') - can't set immutable config\r\n",
+					fmt.Errorf("immutable config")
+			}
+			changes = append(changes, change{p: p, value: args[i+1], previous: p.get()})
+		}
+
+		for i, c := range changes {
+			if err := c.p.set(c.value); err != nil {
+				for j := i - 1; j >= 0; j-- {
+					changes[j].p.set(changes[j].previous)
+				}
+				return "-ERR CONFIG SET failed (possibly related to argument '" + c.p.name + "') - " + err.Error() + "\r\n", err
+			}
+		}
+		return "+OK\r\n", nil
+
+	case "REWRITE":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'CONFIG|REWRITE' command\r\n", fmt.Errorf("wrong args")
+		}
+
+		configMu.Lock()
+		err := rewriteConfig()
+		configMu.Unlock()
+		if err != nil {
+			return "-ERR " + err.Error() + "\r\n", err
+		}
+		return "+OK\r\n", nil
+
+	case "RESETSTAT":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'CONFIG|RESETSTAT' command\r\n", fmt.Errorf("wrong args")
+		}
+		resetStats()
+		return "+OK\r\n", nil
+
+	default:
+		return "-ERR unknown subcommand '" + args[1] + "'. Try CONFIG GET, SET, REWRITE, RESETSTAT.\r\n",
+			fmt.Errorf("unknown subcommand")
+	}
+}
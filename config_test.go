@@ -0,0 +1,188 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// saveConfig snapshots every parameter and restores it when the test ends
+func saveConfig(t *testing.T) {
+	t.Helper()
+	saved := map[string]string{}
+	for _, p := range configParams {
+		saved[p.name] = p.get()
+	}
+	savedFile := configFile
+
+	t.Cleanup(func() {
+		for _, p := range configParams {
+			p.set(saved[p.name])
+		}
+		configFile = savedFile
+	})
+}
+
+func TestLoadConfigFileAndOverrides(t *testing.T) {
+	saveConfig(t)
+
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	conf := "# test config\n" +
+		"port 7001\n" +
+		"\n" +
+		"appendfilename \"my file.aof\"\n" +
+		"appendfsync always\n" +
+		"proto-max-bulk-len 1mb\n"
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadConfig([]string{path, "--port", "7002", "--appendonly", "no"}); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	if serverPort != 7002 {
+		t.Errorf("command line should override the file, port = %d", serverPort)
+	}
+	if AOFFileName != "my file.aof" {
+		t.Errorf("appendfilename = %q", AOFFileName)
+	}
+	if appendFsync.Load() != "always" || appendOnly {
+		t.Errorf("appendfsync = %q, appendonly = %v", appendFsync.Load(), appendOnly)
+	}
+	if protoMaxBulkLen.Load() != 1024*1024 {
+		t.Errorf("proto-max-bulk-len = %d", protoMaxBulkLen.Load())
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	saveConfig(t)
+	dir := t.TempDir()
+
+	for _, conf := range []string{"nope 1\n", "port\n", "port abc\n", "appendfsync sometimes\n", "bind \"open\n"} {
+		path := filepath.Join(dir, "bad.conf")
+		os.WriteFile(path, []byte(conf), 0644)
+		if err := LoadConfig([]string{path}); err == nil {
+			t.Errorf("%q: expected an error", conf)
+		}
+	}
+
+	if err := LoadConfig([]string{"--port", "1", "--nope", "x"}); err == nil {
+		t.Errorf("unknown command line option accepted")
+	}
+}
+
+func TestConfigRewrite(t *testing.T) {
+	saveConfig(t)
+
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	conf := "# keep me\nport 7003\n\nappendfsync everysec\nappendfsync no\n"
+	os.WriteFile(path, []byte(conf), 0644)
+
+	if err := LoadConfig([]string{path}); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	selectedDB := 0
+	execCommand(cmd("CONFIG SET appendfsync always janitor-interval 30"), &selectedDB)
+	if got, _ := execCommand(cmd("CONFIG REWRITE"), &selectedDB); got != "+OK\r\n" {
+		t.Fatalf("REWRITE: %q", got)
+	}
+
+	data, _ := os.ReadFile(path)
+	want := "# keep me\nport 7003\n\nappendfsync always\njanitor-interval 30\n"
+	if string(data) != want {
+		t.Fatalf("rewritten config:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestConfigRewriteWithoutFile(t *testing.T) {
+	saveConfig(t)
+	configFile = ""
+
+	selectedDB := 0
+	got, _ := execCommand(cmd("CONFIG REWRITE"), &selectedDB)
+	if got != "-ERR The server is running without a config file\r\n" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestConfigSetIsAtomic(t *testing.T) {
+	saveConfig(t)
+
+	selectedDB := 0
+	got, _ := execCommand(cmd("CONFIG SET janitor-interval 20 appendfsync sometimes"), &selectedDB)
+	if !strings.HasPrefix(got, "-ERR CONFIG SET failed") {
+		t.Fatalf("got %q", got)
+	}
+	if janitorInterval.Load() != 10 {
+		t.Fatalf("failed CONFIG SET left janitor-interval at %d", janitorInterval.Load())
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	tests := map[string]int64{"100": 100, "1k": 1000, "1kb": 1024, "2MB": 2 << 20, "1gb": 1 << 30, "5b": 5}
+	for in, want := range tests {
+		if got, err := parseMemory(in); err != nil || got != want {
+			t.Errorf("parseMemory(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"lots", "9999999999gb", "-9999999999gb"} {
+		if _, err := parseMemory(in); err == nil {
+			t.Errorf("parseMemory accepted %q", in)
+		}
+	}
+}
+
+// TestConfigSetWhileServing changes settings read on every request while
+// a client keeps writing; run with -race
+func TestConfigSetWhileServing(t *testing.T) {
+	saveConfig(t)
+	c := dialTestClient(t, startTestServer(t))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		selectedDB := 0
+		for i := 0; i < 50; i++ {
+			policy := []string{"always", "everysec", "no"}[i%3]
+			execCommand(cmd("CONFIG SET appendfsync "+policy+" proto-max-bulk-len "+strconv.Itoa(1024+i)), &selectedDB)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if got := c.do("SET", "k", "v"); got != "+OK\r\n" {
+			t.Fatalf("SET: %q", got)
+		}
+	}
+	<-done
+}
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "anything", true},
+		{"proto-*", "proto-max-bulk-len", true},
+		{"*len", "proto-max-bulk-len", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"cache:*", "cache:user:1", true},
+		{"cache:*", "session:1", false},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+	}
+	for _, tt := range tests {
+		if got := stringMatch(tt.pattern, tt.s, false); got != tt.want {
+			t.Errorf("stringMatch(%q, %q) = %v", tt.pattern, tt.s, got)
+		}
+	}
+	if !stringMatch("APPEND*", "appendfsync", true) {
+		t.Errorf("nocase match failed")
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dumpVersion is written in every DUMP payload. RESTORE refuses payloads
+// from a newer version, whose encoding it may not understand.
+const dumpVersion = 1
+
+// A DUMP payload is the entry type, the value, the version as 2 bytes and
+// a CRC64 of all that as 8 bytes, both little endian. Strings are
+// uvarint length prefixed, collections are a uvarint count of elements:
+//
+//	string, int  the value
+//	list         the items in order
+//	set, hash    members, or field and value pairs, sorted
+//	zset         member and score pairs in rank order, scores as 8 byte
+//	             IEEE 754 doubles
+const dumpFooterLen = 10
+
+var crc64Table [256]uint64
+
+func init() {
+	// Jones polynomial, reflected
+	const poly = 0x95ac9329ac4bc9b5
+	for i := range crc64Table {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ poly
+			} else {
+				crc >>= 1
+			}
+		}
+		crc64Table[i] = crc
+	}
+}
+
+// crc64 is the CRC-64/Jones checksum that protects DUMP payloads
+func crc64(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
+
+func appendDumpString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dumpEntry serializes the value of entry, without its TTL
+func dumpEntry(entry Entry) ([]byte, error) {
+	buf := []byte{byte(entry.Type)}
+	ok := true
+
+	switch entry.Type {
+	case TypeString, TypeInt:
+		var s string
+		if s, ok = entry.Value.(string); ok {
+			buf = appendDumpString(buf, s)
+		}
+
+	case TypeList:
+		var list []string
+		if list, ok = entry.Value.([]string); ok {
+			buf = binary.AppendUvarint(buf, uint64(len(list)))
+			for _, item := range list {
+				buf = appendDumpString(buf, item)
+			}
+		}
+
+	case TypeSet:
+		var set map[string]struct{}
+		if set, ok = entry.Value.(map[string]struct{}); ok {
+			buf = binary.AppendUvarint(buf, uint64(len(set)))
+			for _, member := range sortedKeys(set) {
+				buf = appendDumpString(buf, member)
+			}
+		}
+
+	case TypeHash:
+		var hash Hash
+		if hash, ok = entry.Value.(Hash); ok {
+			buf = binary.AppendUvarint(buf, uint64(hash.Len()))
+			for _, field := range sortedKeys(hash.Fields) {
+				buf = appendDumpString(buf, field)
+				buf = appendDumpString(buf, hash.Fields[field])
+			}
+		}
+
+	case TypeZSet:
+		var z ZSet
+		if z, ok = entry.Value.(ZSet); ok {
+			buf = binary.AppendUvarint(buf, uint64(z.Len()))
+			for _, item := range z.Items() {
+				buf = appendDumpString(buf, item.Member)
+				buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(item.Score))
+			}
+		}
+
+	default:
+		ok = false
+	}
+	if !ok {
+		return nil, fmt.Errorf("can't dump a %T value of type %d", entry.Value, entry.Type)
+	}
+
+	buf = binary.LittleEndian.AppendUint16(buf, dumpVersion)
+	return binary.LittleEndian.AppendUint64(buf, crc64(0, buf)), nil
+}
+
+// verifyPayload checks the footer of a DUMP payload
+func verifyPayload(payload []byte) bool {
+	if len(payload) < 1+dumpFooterLen {
+		return false
+	}
+	body := payload[:len(payload)-8]
+	if binary.LittleEndian.Uint16(body[len(body)-2:]) > dumpVersion {
+		return false
+	}
+	return crc64(0, body) == binary.LittleEndian.Uint64(payload[len(payload)-8:])
+}
+
+// dumpReader walks the body of a payload, remembering the first error
+type dumpReader struct {
+	buf []byte
+	err error
+}
+
+func (r *dumpReader) uvarint() int {
+	n, size := binary.Uvarint(r.buf)
+	if size <= 0 || n > uint64(len(r.buf)) {
+		r.err = fmt.Errorf("bad length")
+		return 0
+	}
+	r.buf = r.buf[size:]
+	return int(n)
+}
+
+func (r *dumpReader) string() string {
+	n := r.uvarint()
+	if r.err != nil || n > len(r.buf) {
+		r.err = fmt.Errorf("truncated string")
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+func (r *dumpReader) float() float64 {
+	if len(r.buf) < 8 {
+		r.err = fmt.Errorf("truncated score")
+		return 0
+	}
+	f := math.Float64frombits(binary.LittleEndian.Uint64(r.buf))
+	r.buf = r.buf[8:]
+	return f
+}
+
+// restoreEntry rebuilds the entry serialized in a verified payload
+func restoreEntry(payload []byte) (Entry, error) {
+	r := &dumpReader{buf: payload[1 : len(payload)-dumpFooterLen]}
+	entry := Entry{Type: EntryType(payload[0])}
+
+	switch entry.Type {
+	case TypeString, TypeInt:
+		entry.Value = r.string()
+
+	case TypeList:
+		list := make([]string, r.uvarint())
+		for i := range list {
+			list[i] = r.string()
+		}
+		entry.Value = list
+
+	case TypeSet:
+		n := r.uvarint()
+		set := make(map[string]struct{}, n)
+		for i := 0; i < n; i++ {
+			set[r.string()] = struct{}{}
+		}
+		entry.Value = set
+
+	case TypeHash:
+		n := r.uvarint()
+		hash := newHash()
+		for i := 0; i < n; i++ {
+			field := r.string()
+			hash.Set(field, r.string())
+		}
+		entry.Value = hash
+
+	case TypeZSet:
+		n := r.uvarint()
+		z := newZSet()
+		for i := 0; i < n && r.err == nil; i++ {
+			member, score := r.string(), r.float()
+			if math.IsNaN(score) || !z.Add(member, score) {
+				r.err = fmt.Errorf("bad sorted set member")
+			}
+		}
+		entry.Value = z
+
+	default:
+		return Entry{}, fmt.Errorf("unknown type %d", entry.Type)
+	}
+
+	if r.err != nil {
+		return Entry{}, r.err
+	}
+	if len(r.buf) != 0 {
+		return Entry{}, fmt.Errorf("trailing data")
+	}
+	return entry, nil
+}
+
+// DUMP key
+func cmdDUMP(args []string, selectedDB *int) (string, error) {
+	mu.RLock()
+	entry, exists := databases[*selectedDB][args[1]]
+	var payload []byte
+	var err error
+	if exists && (entry.ExpireAt == 0 || entry.ExpireAt > time.Now().Unix()) {
+		payload, err = dumpEntry(entry)
+	} else {
+		exists = false
+	}
+	mu.RUnlock()
+
+	if !exists {
+		return "$-1\r\n", nil
+	}
+	if err != nil {
+		return "-ERR " + err.Error() + "\r\n", err
+	}
+	return bulkString(string(payload)), nil
+}
+
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds]
+// [FREQ frequency]. IDLETIME and FREQ are checked and then ignored, no
+// access times are kept.
+func cmdRESTORE(args []string, selectedDB *int) (string, error) {
+	key := args[1]
+	ttl, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return "-ERR value is not an integer or out of range\r\n", err
+	}
+	if ttl < 0 {
+		return "-ERR Invalid TTL value, must be >= 0\r\n", fmt.Errorf("negative ttl")
+	}
+
+	replace, absTTL := false, false
+	idleTime, freq := int64(-1), int64(-1)
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		case "IDLETIME", "FREQ":
+			if i+1 >= len(args) || idleTime >= 0 || freq >= 0 {
+				return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return "-ERR value is not an integer or out of range\r\n", err
+			}
+			if strings.EqualFold(args[i], "IDLETIME") {
+				if n < 0 {
+					return "-ERR Invalid IDLETIME value, must be >= 0\r\n", fmt.Errorf("bad idletime")
+				}
+				idleTime = n
+			} else {
+				if n < 0 || n > 255 {
+					return "-ERR Invalid FREQ value, must be >= 0 and <= 255\r\n", fmt.Errorf("bad freq")
+				}
+				freq = n
+			}
+			i++
+		default:
+			return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+		}
+	}
+
+	payload := []byte(args[3])
+	if !verifyPayload(payload) {
+		return "-ERR DUMP payload version or checksum are wrong\r\n", fmt.Errorf("bad payload")
+	}
+	entry, err := restoreEntry(payload)
+	if err != nil {
+		return "-ERR Bad data format\r\n", err
+	}
+
+	// Expiry is kept in seconds here: a TTL in milliseconds is rounded up
+	nowMs := time.Now().UnixMilli()
+	if ttl > 0 {
+		expireMs := ttl
+		if !absTTL {
+			expireMs += nowMs
+		}
+		// A TTL this large wrapped around, or would when rounded up
+		if expireMs < ttl || expireMs > math.MaxInt64-999 {
+			return "-ERR invalid expire time\r\n", fmt.Errorf("invalid expire time")
+		}
+		entry.ExpireAt = (expireMs + 999) / 1000
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if old, exists := databases[*selectedDB][key]; exists && !replace {
+		if old.ExpireAt == 0 || old.ExpireAt > nowMs/1000 {
+			return "-BUSYKEY Target key name already exists.\r\n", fmt.Errorf("busy key")
+		}
+	}
+
+	// An absolute TTL in the past only deletes what was there
+	if ttl > 0 && absTTL && ttl <= nowMs {
+		removeEntry(*selectedDB, key)
+		return "+OK\r\n", nil
+	}
+	storeEntry(*selectedDB, key, entry)
+	return "+OK\r\n", nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCRC64(t *testing.T) {
+	if got := crc64(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("crc64 = %#x", got)
+	}
+}
+
+// dump returns the DUMP payload of key, failing the test when there is none
+func dump(t *testing.T, key string, selectedDB *int) string {
+	t.Helper()
+	got, err := execCommand(cmd("DUMP "+key), selectedDB)
+	if err != nil || !strings.HasPrefix(got, "$") || got == "$-1\r\n" {
+		t.Fatalf("DUMP %s: %q, %v", key, got, err)
+	}
+	return got[strings.Index(got, "\r\n")+2 : len(got)-2]
+}
+
+func TestDumpRestoreRoundTrip(t *testing.T) {
+	resetKeyspace()
+	selectedDB := 0
+
+	execCommand(cmd("SET s hello"), &selectedDB)
+	execCommand(cmd("HSET h f1 v1 f2 v2"), &selectedDB)
+	execCommand(cmd("ZADD z 2 b"), &selectedDB)
+	execCommand(cmd("ZADD z 1 a"), &selectedDB)
+	setEntry("l", Entry{Type: TypeList, Value: []string{"a", "b", "a"}}, &selectedDB)
+	setEntry("set", Entry{Type: TypeSet, Value: map[string]struct{}{"x": {}, "y": {}}}, &selectedDB)
+
+	for _, key := range []string{"s", "h", "z", "l", "set"} {
+		payload := dump(t, key, &selectedDB)
+		restored := "copy-" + key
+		if got, _ := execCommand([]string{"RESTORE", restored, "0", payload}, &selectedDB); got != "+OK\r\n" {
+			t.Fatalf("RESTORE %s: %q", key, got)
+		}
+
+		mu.RLock()
+		before, after := databases[0][key], databases[0][restored]
+		mu.RUnlock()
+		if z, ok := before.Value.(ZSet); ok {
+			before.Value = z.Items()
+			after.Value = after.Value.(ZSet).Items()
+		}
+		if before.Type != after.Type || !reflect.DeepEqual(before.Value, after.Value) {
+			t.Errorf("%s restored as %+v, was %+v", key, after, before)
+		}
+		if dump(t, restored, &selectedDB) != payload {
+			t.Errorf("%s: payload changed across a round trip", key)
+		}
+	}
+}
+
+func TestRestoreRules(t *testing.T) {
+	resetKeyspace()
+	selectedDB := 0
+
+	execCommand(cmd("SET k v"), &selectedDB)
+	payload := dump(t, "k", &selectedDB)
+
+	if got, _ := execCommand([]string{"RESTORE", "k", "0", payload}, &selectedDB); got != "-BUSYKEY Target key name already exists.\r\n" {
+		t.Fatalf("RESTORE onto an existing key: %q", got)
+	}
+	if got, _ := execCommand([]string{"RESTORE", "k", "5000", payload, "REPLACE"}, &selectedDB); got != "+OK\r\n" {
+		t.Fatalf("RESTORE REPLACE: %q", got)
+	}
+	if got, _ := execCommand(cmd("TTL k"), &selectedDB); got < ":4\r\n" || got > ":6\r\n" {
+		t.Errorf("TTL after RESTORE: %q", got)
+	}
+
+	past := time.Now().Add(-time.Minute).UnixMilli()
+	if got, _ := execCommand([]string{"RESTORE", "k", strconv.FormatInt(past, 10), payload, "REPLACE", "ABSTTL"}, &selectedDB); got != "+OK\r\n" {
+		t.Fatalf("RESTORE ABSTTL in the past: %q", got)
+	}
+	if got, _ := execCommand(cmd("EXISTS k"), &selectedDB); got != ":0\r\n" {
+		t.Errorf("key restored with a past ABSTTL exists: %q", got)
+	}
+
+	future := time.Now().Add(time.Hour).UnixMilli()
+	if got, _ := execCommand([]string{"RESTORE", "k", strconv.FormatInt(future, 10), payload, "ABSTTL", "IDLETIME", "10"}, &selectedDB); got != "+OK\r\n" {
+		t.Fatalf("RESTORE ABSTTL: %q", got)
+	}
+	if got, _ := execCommand(cmd("TTL k"), &selectedDB); got < ":3599\r\n" || got > ":3601\r\n" {
+		t.Errorf("TTL after RESTORE ABSTTL: %q", got)
+	}
+
+	// TTLs that don't fit once made absolute are refused
+	for _, args := range [][]string{
+		{"RESTORE", "big", strconv.FormatInt(math.MaxInt64, 10), payload},
+		{"RESTORE", "big", strconv.FormatInt(math.MaxInt64, 10), payload, "ABSTTL"},
+	} {
+		if got, _ := execCommand(args, &selectedDB); got != "-ERR invalid expire time\r\n" {
+			t.Errorf("RESTORE with TTL %s %v: %q", args[2], args[4:], got)
+		}
+	}
+	if got, _ := execCommand(cmd("EXISTS big"), &selectedDB); got != ":0\r\n" {
+		t.Errorf("key restored with an invalid TTL: %q", got)
+	}
+
+	// A flipped byte is caught by the checksum
+	corrupt := []byte(payload)
+	corrupt[1] ^= 0xff
+	if got, _ := execCommand([]string{"RESTORE", "c", "0", string(corrupt)}, &selectedDB); got != "-ERR DUMP payload version or checksum are wrong\r\n" {
+		t.Errorf("RESTORE of a corrupt payload: %q", got)
+	}
+}
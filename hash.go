@@ -0,0 +1,49 @@
+package main
+
+// Hash is the value of a hash key. It keeps the accounted size of its
+// fields up to date as they change, so storing it doesn't mean summing
+// them again.
+//
+// Like ZSet, it is stored by value in an Entry and its copies share the
+// same fields. It is changed in place with mu held, and read with mu
+// read-locked.
+type Hash struct {
+	Fields map[string]string
+	size   *int64 // accounted bytes of the fields, see hashFieldSize
+}
+
+// hashFieldSize estimates what a field costs: its map slot and strings
+func hashFieldSize(field, value string) int64 {
+	return int64(len(field)+len(value)) + 32
+}
+
+func newHash() Hash {
+	return Hash{Fields: make(map[string]string), size: new(int64)}
+}
+
+// Len is the number of fields
+func (h Hash) Len() int {
+	return len(h.Fields)
+}
+
+// Set sets field to value, reporting whether it is a new field
+func (h Hash) Set(field, value string) bool {
+	old, exists := h.Fields[field]
+	if exists {
+		*h.size -= hashFieldSize(field, old)
+	}
+	h.Fields[field] = value
+	*h.size += hashFieldSize(field, value)
+	return !exists
+}
+
+// Delete removes field, reporting whether it was there
+func (h Hash) Delete(field string) bool {
+	value, exists := h.Fields[field]
+	if !exists {
+		return false
+	}
+	delete(h.Fields, field)
+	*h.size -= hashFieldSize(field, value)
+	return true
+}
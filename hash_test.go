@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHashKeepsItsSize(t *testing.T) {
+	h := newHash()
+	h.Set("a", "1")
+	h.Set("b", "22")
+	h.Set("a", "333") // overwritten: the old value no longer counts
+	h.Delete("b")
+	h.Delete("missing")
+
+	var want int64
+	for field, value := range h.Fields {
+		want += hashFieldSize(field, value)
+	}
+	if *h.size != want {
+		t.Fatalf("size %d, want %d", *h.size, want)
+	}
+
+	// HSET and HDEL leave usedMemory as if the hash was measured anew
+	resetKeyspace()
+	selectedDB := 0
+	mu.RLock()
+	before := usedMemory
+	mu.RUnlock()
+	execCommand(cmd("HSET h f1 v1 f2 v2 f3 v3"), &selectedDB)
+	execCommand(cmd("HSET h f1 longer"), &selectedDB)
+	execCommand(cmd("HDEL h f2"), &selectedDB)
+	mu.RLock()
+	defer mu.RUnlock()
+	entry := databases[0]["h"]
+	want = int64(len("h")) + entryOverhead + hashFieldSize("f1", "longer") + hashFieldSize("f3", "v3")
+	if entry.size != want || usedMemory-before != want {
+		t.Errorf("entry size %d, usedMemory grew by %d, want %d", entry.size, usedMemory-before, want)
+	}
+}
+
+// Run with -race: readers look at a hash while writers change it
+func TestHashConcurrentAccess(t *testing.T) {
+	resetKeyspace()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		selectedDB := 0
+		for i := 0; i < 500; i++ {
+			execCommand(cmd("HSET h f"+strconv.Itoa(i%50)+" "+strconv.Itoa(i)), &selectedDB)
+			execCommand(cmd("HDEL h f"+strconv.Itoa((i+25)%50)), &selectedDB)
+		}
+	}()
+
+	selectedDB := 0
+	for i := 0; i < 500; i++ {
+		for _, line := range []string{"HGET h f1", "HGETALL h", "HEXISTS h f1", "HLEN h"} {
+			if got, err := execCommand(cmd(line), &selectedDB); err != nil {
+				t.Fatalf("%s: %q", line, got)
+			}
+		}
+	}
+	<-done
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const serverVersion = "7.0.0"
+
+// infoSection renders one "# Title" block of the INFO reply
+type infoSection struct {
+	name   string
+	title  string
+	render func() []string // "field:value" lines
+}
+
+// infoSections lists the sections in the order INFO prints them
+var infoSections = []infoSection{
+	{"server", "Server", infoServer},
+	{"clients", "Clients", infoClients},
+	{"memory", "Memory", infoMemory},
+	{"persistence", "Persistence", infoPersistence},
+	{"stats", "Stats", infoStats},
+	{"replication", "Replication", infoReplication},
+	{"cluster", "Cluster", infoCluster},
+	{"keyspace", "Keyspace", infoKeyspace},
+}
+
+// buildInfo returns the INFO text for the requested sections. No section,
+// "default", "all" and "everything" select every section.
+func buildInfo(requested []string) string {
+	wanted := map[string]bool{}
+	everything := len(requested) == 0
+	for _, name := range requested {
+		name = strings.ToLower(name)
+		if name == "default" || name == "all" || name == "everything" {
+			everything = true
+		}
+		wanted[name] = true
+	}
+
+	var sb strings.Builder
+	for _, section := range infoSections {
+		if !everything && !wanted[section.name] {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# " + section.title + "\r\n")
+		for _, line := range section.render() {
+			sb.WriteString(line + "\r\n")
+		}
+	}
+	return sb.String()
+}
+
+func infoServer() []string {
+	uptime := int64(time.Since(serverStartTime).Seconds())
+	executable, _ := os.Executable()
+
+	return []string{
+		"redis_version:" + serverVersion,
+		"redis_mode:" + serverMode(),
+		"os:" + runtime.GOOS,
+		"arch_bits:" + strconv.Itoa(strconv.IntSize),
+		"go_version:" + runtime.Version(),
+		"process_id:" + strconv.Itoa(os.Getpid()),
+		"run_id:" + serverRunID,
+		"tcp_port:" + strconv.Itoa(serverPort),
+		"uptime_in_seconds:" + strconv.FormatInt(uptime, 10),
+		"uptime_in_days:" + strconv.FormatInt(uptime/86400, 10),
+		"executable:" + executable,
+		"config_file:" + configFile,
+	}
+}
+
+func serverMode() string {
+	if sentinelMode {
+		return "sentinel"
+	}
+	if clusterEnabled {
+		return "cluster"
+	}
+	return "standalone"
+}
+
+func infoClients() []string {
+	return []string{
+		"connected_clients:" + strconv.FormatInt(connectedClients.Load(), 10),
+		"maxclients:" + strconv.FormatInt(maxClients.Load(), 10),
+		"blocked_clients:0",
+	}
+}
+
+func infoMemory() []string {
+	mu.RLock()
+	used := usedMemory
+	mu.RUnlock()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	rss := residentMemory(&ms)
+
+	return []string{
+		"used_memory:" + strconv.FormatInt(used, 10),
+		"used_memory_human:" + bytesToHuman(used),
+		"used_memory_rss:" + strconv.FormatInt(rss, 10),
+		"used_memory_rss_human:" + bytesToHuman(rss),
+		"used_memory_heap:" + strconv.FormatUint(ms.HeapAlloc, 10),
+		"mem_allocator:go",
+	}
+}
+
+// residentMemory is the resident set size of the process, read from
+// /proc/self/statm. Where that isn't available it falls back to what the
+// runtime obtained from the OS, which overstates it.
+func residentMemory(ms *runtime.MemStats) int64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err == nil {
+		// size resident shared text lib data dt, in pages
+		fields := strings.Fields(string(data))
+		if len(fields) > 1 {
+			if pages, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				return pages * int64(os.Getpagesize())
+			}
+		}
+	}
+	return int64(ms.Sys)
+}
+
+func infoPersistence() []string {
+	aofMu.Lock()
+	writeStatus := statusString(aofLastWriteErr)
+	fsyncStatus := statusString(aofLastFsyncErr)
+	fsyncTime := aofLastFsyncTime
+	aofMu.Unlock()
+
+	aofSize := int64(0)
+	if appendOnly {
+		if fi, err := os.Stat(AOFFileName); err == nil {
+			aofSize = fi.Size()
+		}
+	}
+
+	lines := []string{
+		"loading:0",
+		"aof_enabled:" + boolToInfo(appendOnly),
+		"aof_rewrite_in_progress:0",
+		"aof_last_write_status:" + writeStatus,
+		"aof_last_fsync_status:" + fsyncStatus,
+		"aof_last_fsync_time:" + strconv.FormatInt(fsyncTime, 10),
+	}
+	if appendOnly {
+		lines = append(lines,
+			"aof_fsync_policy:"+appendFsync.Load(),
+			"aof_current_size:"+strconv.FormatInt(aofSize, 10))
+	}
+	return lines
+}
+
+func infoStats() []string {
+	return []string{
+		"total_connections_received:" + strconv.FormatInt(statTotalConnections.Load(), 10),
+		"total_commands_processed:" + strconv.FormatInt(statTotalCommands.Load(), 10),
+		"rejected_connections:" + strconv.FormatInt(statRejectedConnections.Load(), 10),
+		"expired_keys:" + strconv.FormatInt(statExpiredKeys.Load(), 10),
+		"keyspace_hits:" + strconv.FormatInt(statKeyspaceHits.Load(), 10),
+		"keyspace_misses:" + strconv.FormatInt(statKeyspaceMisses.Load(), 10),
+		"client_output_buffer_limit_disconnections:" + strconv.FormatInt(statOutputLimitDisconnects.Load(), 10),
+	}
+}
+
+// infoKeyspace lists non-empty databases, e.g. db0:keys=3,expires=1,avg_ttl=5000
+func infoKeyspace() []string {
+	now := time.Now().Unix()
+	var lines []string
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for i := 0; i < NumDatabases; i++ {
+		keys := len(databases[i])
+		if keys == 0 {
+			continue
+		}
+
+		expires := 0
+		var ttlSum int64
+		for _, entry := range databases[i] {
+			if entry.ExpireAt != 0 {
+				expires++
+				if ttl := entry.ExpireAt - now; ttl > 0 {
+					ttlSum += ttl
+				}
+			}
+		}
+
+		// avg_ttl is in milliseconds, like Redis
+		avgTTL := int64(0)
+		if expires > 0 {
+			avgTTL = ttlSum * 1000 / int64(expires)
+		}
+
+		lines = append(lines, fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=%d", i, keys, expires, avgTTL))
+	}
+	return lines
+}
+
+func statusString(err error) string {
+	if err != nil {
+		return "err"
+	}
+	return "ok"
+}
+
+func boolToInfo(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// bytesToHuman formats n like Redis does: 1.50K, 12.00M, ...
+func bytesToHuman(n int64) string {
+	f := float64(n)
+	switch {
+	case n < 1024:
+		return strconv.FormatInt(n, 10) + "B"
+	case n < 1024*1024:
+		return strconv.FormatFloat(f/1024, 'f', 2, 64) + "K"
+	case n < 1024*1024*1024:
+		return strconv.FormatFloat(f/(1024*1024), 'f', 2, 64) + "M"
+	default:
+		return strconv.FormatFloat(f/(1024*1024*1024), 'f', 2, 64) + "G"
+	}
+}
+
+func cmdINFO(args []string, selectedDB *int) (string, error) {
+	info := buildInfo(args[1:])
+	return "$" + strconv.Itoa(len(info)) + "\r\n" + info + "\r\n", nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// infoField extracts "field:value" from an INFO reply
+func infoField(info, field string) string {
+	for _, line := range strings.Split(info, "\r\n") {
+		if value, ok := strings.CutPrefix(line, field+":"); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func TestInfoSectionFiltering(t *testing.T) {
+	all := buildInfo(nil)
+	for _, title := range []string{"# Server", "# Clients", "# Memory", "# Persistence", "# Stats", "# Keyspace"} {
+		if !strings.Contains(all, title) {
+			t.Errorf("INFO without arguments is missing %q", title)
+		}
+	}
+
+	some := buildInfo([]string{"STATS", "memory"})
+	if !strings.HasPrefix(some, "# Memory\r\n") || !strings.Contains(some, "# Stats\r\n") {
+		t.Fatalf("unexpected sections:\n%s", some)
+	}
+	if strings.Contains(some, "# Server") {
+		t.Fatalf("unrequested section included:\n%s", some)
+	}
+
+	if buildInfo([]string{"everything"}) == "" {
+		t.Fatalf("INFO everything is empty")
+	}
+}
+
+func TestInfoKeyspaceStats(t *testing.T) {
+	resetKeyspace()
+	resetStats()
+	selectedDB := 0
+
+	execCommand(cmd("SET a 1"), &selectedDB)
+	execCommand(cmd("SET b 2"), &selectedDB)
+	execCommand(cmd("EXPIRE b 100"), &selectedDB)
+	execCommand(cmd("GET a"), &selectedDB)
+	execCommand(cmd("GET missing"), &selectedDB)
+	execCommand(cmd("HGET missing f"), &selectedDB)
+
+	info := buildInfo(nil)
+	if got := infoField(info, "keyspace_hits"); got != "1" {
+		t.Errorf("keyspace_hits = %q", got)
+	}
+	if got := infoField(info, "keyspace_misses"); got != "2" {
+		t.Errorf("keyspace_misses = %q", got)
+	}
+	if got := infoField(info, "db0"); !strings.HasPrefix(got, "keys=2,expires=1,avg_ttl=") {
+		t.Errorf("db0 = %q", got)
+	}
+
+	setEntry("old", Entry{Type: TypeString, Value: "v", ExpireAt: 1}, &selectedDB)
+	execCommand(cmd("GET old"), &selectedDB)
+	if got := infoField(buildInfo([]string{"stats"}), "expired_keys"); got != "1" {
+		t.Errorf("expired_keys = %q", got)
+	}
+}
+
+func TestUsedMemoryAccounting(t *testing.T) {
+	resetKeyspace()
+	mu.Lock()
+	usedMemory = 0
+	mu.Unlock()
+	selectedDB := 0
+
+	execCommand(cmd("SET k "+strings.Repeat("x", 1000)), &selectedDB)
+	execCommand(cmd("HSET h a 1 b 2"), &selectedDB)
+	execCommand(cmd("ZADD z 1 member"), &selectedDB)
+
+	mu.RLock()
+	used := usedMemory
+	mu.RUnlock()
+	if used < 1000 {
+		t.Fatalf("used_memory %d does not account for the 1000 byte value", used)
+	}
+
+	// Overwriting and deleting must give the memory back
+	execCommand(cmd("SET k small"), &selectedDB)
+	execCommand(cmd("HDEL h a"), &selectedDB)
+	execCommand(cmd("DEL k"), &selectedDB)
+	execCommand(cmd("DEL h"), &selectedDB)
+	execCommand(cmd("DEL z"), &selectedDB)
+
+	mu.RLock()
+	used = usedMemory
+	mu.RUnlock()
+	if used != 0 {
+		t.Fatalf("used_memory is %d after deleting every key", used)
+	}
+
+	execCommand(cmd("SET k v"), &selectedDB)
+	execCommand(cmd("FLUSHALL"), &selectedDB)
+	if got := infoField(buildInfo([]string{"memory"}), "used_memory"); got != "0" {
+		t.Fatalf("used_memory after FLUSHALL = %q", got)
+	}
+}
+
+func TestExpiringKeysAccounting(t *testing.T) {
+	resetKeyspace()
+	selectedDB := 0
+	expiring := func() int {
+		mu.RLock()
+		defer mu.RUnlock()
+		return expiringKeys[0]
+	}
+
+	execCommand(cmd("SET a v"), &selectedDB)
+	execCommand(cmd("SET b v"), &selectedDB)
+	execCommand(cmd("EXPIRE a 100"), &selectedDB)
+	execCommand(cmd("EXPIRE b 100"), &selectedDB)
+	execCommand(cmd("EXPIRE b 200"), &selectedDB)
+	if n := expiring(); n != 2 {
+		t.Fatalf("%d expiring keys after two EXPIREs, want 2", n)
+	}
+
+	// Losing the TTL, or the key, must drop it from the count
+	execCommand(cmd("PERSIST a"), &selectedDB)
+	execCommand(cmd("DEL b"), &selectedDB)
+	if n := expiring(); n != 0 {
+		t.Fatalf("%d expiring keys after PERSIST and DEL, want 0", n)
+	}
+
+	execCommand(cmd("EXPIRE a 100"), &selectedDB)
+	execCommand(cmd("FLUSHALL"), &selectedDB)
+	if n := expiring(); n != 0 {
+		t.Fatalf("%d expiring keys after FLUSHALL, want 0", n)
+	}
+}
+
+func TestResidentMemory(t *testing.T) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	if rss := residentMemory(&ms); rss <= 0 {
+		t.Fatalf("resident memory %d", rss)
+	}
+	if got := infoField(buildInfo([]string{"memory"}), "used_memory_rss"); got == "" || got == "0" {
+		t.Fatalf("used_memory_rss = %q", got)
+	}
+}
+
+func TestBytesToHuman(t *testing.T) {
+	tests := map[int64]string{10: "10B", 1536: "1.50K", 5 * 1024 * 1024: "5.00M", 3 << 30: "3.00G"}
+	for n, want := range tests {
+		if got := bytesToHuman(n); got != want {
+			t.Errorf("bytesToHuman(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Internal events that take at least latencyMonitorThreshold milliseconds
+// are recorded per event name; 0 disables the monitor. The server has no
+// eviction, so there is no eviction-cycle event.
+var latencyMonitorThreshold = newAtomicInt(0)
+
+// latencyHistoryLen is how many samples are kept per event, as in Redis
+const latencyHistoryLen = 160
+
+type latencySample struct {
+	time    int64 // unix seconds
+	latency int64 // milliseconds
+}
+
+// latencyEvent keeps the latest samples of one event in a ring, at most one
+// per second: spikes within the same second keep the worst
+type latencyEvent struct {
+	samples [latencyHistoryLen]latencySample
+	next    int
+	count   int
+	max     int64 // worst latency ever seen, survives the ring wrapping
+}
+
+var (
+	latencyMu     sync.Mutex
+	latencyEvents = map[string]*latencyEvent{}
+)
+
+// latencyRecord adds a sample for event if d reaches the threshold
+func latencyRecord(event string, d time.Duration) {
+	threshold := latencyMonitorThreshold.Load()
+	ms := d.Milliseconds()
+	if threshold == 0 || ms < threshold {
+		return
+	}
+	now := time.Now().Unix()
+
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	e := latencyEvents[event]
+	if e == nil {
+		e = &latencyEvent{}
+		latencyEvents[event] = e
+	}
+	e.max = max(e.max, ms)
+
+	if e.count > 0 {
+		last := &e.samples[(e.next-1+latencyHistoryLen)%latencyHistoryLen]
+		if last.time == now {
+			last.latency = max(last.latency, ms)
+			return
+		}
+	}
+	e.samples[e.next] = latencySample{time: now, latency: ms}
+	e.next = (e.next + 1) % latencyHistoryLen
+	if e.count < latencyHistoryLen {
+		e.count++
+	}
+}
+
+// history returns the samples oldest first. Caller holds latencyMu.
+func (e *latencyEvent) history() []latencySample {
+	out := make([]latencySample, 0, e.count)
+	for i := e.count; i > 0; i-- {
+		out = append(out, e.samples[(e.next-i+latencyHistoryLen)%latencyHistoryLen])
+	}
+	return out
+}
+
+// latencyEventNames returns the recorded events in name order. Caller
+// holds latencyMu.
+func latencyEventNames() []string {
+	names := make([]string, 0, len(latencyEvents))
+	for name := range latencyEvents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resetLatency drops the given events, or all of them when none are
+// named, and returns how many were dropped
+func resetLatency(events ...string) int {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	if len(events) == 0 {
+		n := len(latencyEvents)
+		latencyEvents = map[string]*latencyEvent{}
+		return n
+	}
+	n := 0
+	for _, event := range events {
+		if _, ok := latencyEvents[event]; ok {
+			delete(latencyEvents, event)
+			n++
+		}
+	}
+	return n
+}
+
+// latencyAdvice explains what usually causes spikes of each event
+var latencyAdvice = map[string]string{
+	"expire-cycle":     "The janitor deletes every expired key in one pass while holding the keyspace lock. Many keys expiring at the same time make this pass long; consider spreading out expire times.",
+	"aof-fsync":        "The background fsync of the AOF is slow, which usually means the disk is busy or slow. appendfsync no leaves flushing to the kernel.",
+	"aof-fsync-always": "With appendfsync always every write waits for the disk. appendfsync everysec trades up to a second of writes for much lower latency.",
+	"flushall":         "FLUSHALL drops the whole keyspace while holding the keyspace lock. FLUSHALL ASYNC moves the work off the client's connection.",
+}
+
+// latencyDoctor writes a human readable report of the recorded events
+func latencyDoctor() string {
+	threshold := latencyMonitorThreshold.Load()
+	if threshold == 0 {
+		return "Latency monitoring is disabled. Enable it with CONFIG SET latency-monitor-threshold <milliseconds>.\n"
+	}
+
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	if len(latencyEvents) == 0 {
+		return fmt.Sprintf("No latency spikes of %d milliseconds or more have been recorded.\n", threshold)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Latency spikes of %d milliseconds or more were recorded for these events:\n\n", threshold)
+	for i, name := range latencyEventNames() {
+		e := latencyEvents[name]
+		samples := e.history()
+
+		var sum int64
+		for _, s := range samples {
+			sum += s.latency
+		}
+		avg := sum / int64(len(samples))
+		var dev int64
+		for _, s := range samples {
+			dev += abs64(s.latency - avg)
+		}
+		dev /= int64(len(samples))
+
+		fmt.Fprintf(&sb, "%d. %s: %d latency spikes (average %dms, mean deviation %dms", i+1, name, len(samples), avg, dev)
+		if len(samples) > 1 {
+			period := (samples[len(samples)-1].time - samples[0].time) / int64(len(samples)-1)
+			fmt.Fprintf(&sb, ", period %d sec", period)
+		}
+		fmt.Fprintf(&sb, "). Worst all time event %dms.\n", e.max)
+		if advice, ok := latencyAdvice[name]; ok {
+			sb.WriteString("   " + advice + "\n")
+		}
+	}
+	return sb.String()
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// LATENCY LATEST | HISTORY event | RESET [event ...] | DOCTOR | HELP
+func cmdLATENCY(args []string, selectedDB *int) (string, error) {
+	sub := strings.ToUpper(args[1])
+	wrongArgs := "-ERR wrong number of arguments for 'LATENCY|" + strings.ToLower(sub) + "' command\r\n"
+
+	switch sub {
+	case "LATEST":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		latencyMu.Lock()
+		defer latencyMu.Unlock()
+
+		var resp strings.Builder
+		resp.WriteString("*" + strconv.Itoa(len(latencyEvents)) + "\r\n")
+		for _, name := range latencyEventNames() {
+			e := latencyEvents[name]
+			last := e.samples[(e.next-1+latencyHistoryLen)%latencyHistoryLen]
+			resp.WriteString("*4\r\n")
+			resp.WriteString(bulkString(name))
+			resp.WriteString(":" + strconv.FormatInt(last.time, 10) + "\r\n")
+			resp.WriteString(":" + strconv.FormatInt(last.latency, 10) + "\r\n")
+			resp.WriteString(":" + strconv.FormatInt(e.max, 10) + "\r\n")
+		}
+		return resp.String(), nil
+
+	case "HISTORY":
+		if len(args) != 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		latencyMu.Lock()
+		defer latencyMu.Unlock()
+
+		e, ok := latencyEvents[args[2]]
+		if !ok {
+			return "*0\r\n", nil
+		}
+		samples := e.history()
+		var resp strings.Builder
+		resp.WriteString("*" + strconv.Itoa(len(samples)) + "\r\n")
+		for _, s := range samples {
+			resp.WriteString("*2\r\n")
+			resp.WriteString(":" + strconv.FormatInt(s.time, 10) + "\r\n")
+			resp.WriteString(":" + strconv.FormatInt(s.latency, 10) + "\r\n")
+		}
+		return resp.String(), nil
+
+	case "RESET":
+		return ":" + strconv.Itoa(resetLatency(args[2:]...)) + "\r\n", nil
+
+	case "DOCTOR":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		return bulkString(latencyDoctor()), nil
+
+	case "HELP":
+		return bulkArray([]string{
+			"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"LATEST",
+			"    Return the latest latency samples for all events.",
+			"HISTORY <event>",
+			"    Return timestamp-latency samples for the <event>.",
+			"RESET [<event> ...]",
+			"    Reset latency data of one or more <event> classes.",
+			"    (default: reset all data for all event classes)",
+			"DOCTOR",
+			"    Return a human readable latency analysis report.",
+			"HELP",
+			"    Print this help.",
+		}), nil
+
+	default:
+		return "-ERR unknown subcommand '" + args[1] + "'. Try LATENCY HELP.\r\n", fmt.Errorf("unknown subcommand")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// withLatencyMonitor records every event taking 1ms or more
+func withLatencyMonitor(t *testing.T) {
+	t.Helper()
+	saveConfig(t)
+	configByName["latency-monitor-threshold"].set("1")
+	resetLatency()
+	t.Cleanup(func() { resetLatency() })
+}
+
+func TestLatencyRecordsEvents(t *testing.T) {
+	withLatencyMonitor(t)
+
+	latencyRecord("expire-cycle", 500*time.Microsecond) // under the threshold
+	latencyRecord("expire-cycle", 5*time.Millisecond)
+	latencyRecord("expire-cycle", 9*time.Millisecond) // same second: keeps the worst
+	latencyRecord("flushall", 3*time.Millisecond)
+
+	latest, _ := cmdLATENCY(cmd("LATENCY LATEST"), nil)
+	if !strings.HasPrefix(latest, "*2\r\n*4\r\n$12\r\nexpire-cycle\r\n:") || !strings.Contains(latest, ":9\r\n:9\r\n*4\r\n$8\r\nflushall\r\n") {
+		t.Fatalf("LATENCY LATEST: %q", latest)
+	}
+
+	history, _ := cmdLATENCY(cmd("LATENCY HISTORY expire-cycle"), nil)
+	if !strings.HasPrefix(history, "*1\r\n*2\r\n:") || !strings.HasSuffix(history, ":9\r\n") {
+		t.Fatalf("LATENCY HISTORY: %q", history)
+	}
+
+	doctor := latencyDoctor()
+	for _, want := range []string{"1. expire-cycle: 1 latency spikes", "Worst all time event 9ms", "2. flushall:"} {
+		if !strings.Contains(doctor, want) {
+			t.Fatalf("DOCTOR missing %q:\n%s", want, doctor)
+		}
+	}
+
+	if got, _ := cmdLATENCY(cmd("LATENCY RESET flushall nope"), nil); got != ":1\r\n" {
+		t.Fatalf("LATENCY RESET: %q", got)
+	}
+	if got, _ := cmdLATENCY(cmd("LATENCY RESET"), nil); got != ":1\r\n" {
+		t.Fatalf("LATENCY RESET all: %q", got)
+	}
+}
+
+func TestLatencyDisabled(t *testing.T) {
+	saveConfig(t)
+	resetLatency()
+	configByName["latency-monitor-threshold"].set("0")
+
+	latencyRecord("flushall", time.Second)
+	if got, _ := cmdLATENCY(cmd("LATENCY LATEST"), nil); got != "*0\r\n" {
+		t.Fatalf("recorded while disabled: %q", got)
+	}
+	if !strings.Contains(latencyDoctor(), "disabled") {
+		t.Fatal("DOCTOR should say monitoring is disabled")
+	}
+}
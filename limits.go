@@ -0,0 +1,346 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Connection limits. They are read by every connection, so they are
+// atomics rather than plain ints.
+var (
+	clientTimeout = newAtomicInt(0)     // seconds a client may stay idle, 0 disables
+	tcpKeepAlive  = newAtomicInt(300)   // seconds between keepalive probes, 0 disables
+	maxClients    = newAtomicInt(10000) // connections beyond this are refused
+)
+
+func newAtomicInt(v int64) *atomic.Int64 {
+	n := new(atomic.Int64)
+	n.Store(v)
+	return n
+}
+
+func newAtomicBool(v bool) *atomic.Bool {
+	b := new(atomic.Bool)
+	b.Store(v)
+	return b
+}
+
+// outputLimit bounds the reply bytes a client has not read yet: past hard
+// it is disconnected at once, above soft for softSeconds as well. Zero
+// disables a limit.
+type outputLimit struct {
+	hard, soft  int64
+	softSeconds int
+}
+
+// outputLimitClasses follow the Redis client-output-buffer-limit classes.
+// Replicas are held to the replica class once they PSYNC.
+var outputLimitClasses = []string{"normal", "replica", "pubsub"}
+
+var (
+	outputLimitsMu sync.Mutex
+	outputLimits   = map[string]outputLimit{
+		"normal":  {},
+		"replica": {hard: 256 << 20, soft: 64 << 20, softSeconds: 60},
+		"pubsub":  {hard: 32 << 20, soft: 8 << 20, softSeconds: 60},
+	}
+)
+
+func getOutputLimit(class string) outputLimit {
+	outputLimitsMu.Lock()
+	defer outputLimitsMu.Unlock()
+	return outputLimits[class]
+}
+
+// outputLimitConfig handles "client-output-buffer-limit <class> <hard>
+// <soft> <seconds> [<class> ...]". Classes that aren't given keep their
+// value.
+func outputLimitConfig() *configParam {
+	return &configParam{
+		name: "client-output-buffer-limit",
+		get: func() string {
+			outputLimitsMu.Lock()
+			defer outputLimitsMu.Unlock()
+
+			var parts []string
+			for _, class := range outputLimitClasses {
+				l := outputLimits[class]
+				parts = append(parts, fmt.Sprintf("%s %d %d %d", class, l.hard, l.soft, l.softSeconds))
+			}
+			return strings.Join(parts, " ")
+		},
+		set: func(value string) error {
+			fields := strings.Fields(value)
+			if len(fields) == 0 || len(fields)%4 != 0 {
+				return fmt.Errorf("wrong number of arguments")
+			}
+
+			parsed := map[string]outputLimit{}
+			for i := 0; i < len(fields); i += 4 {
+				class := strings.ToLower(fields[i])
+				if class == "slave" {
+					class = "replica"
+				}
+				if _, ok := outputLimits[class]; !ok {
+					return fmt.Errorf("invalid client class '%s'", fields[i])
+				}
+
+				hard, err1 := parseMemory(fields[i+1])
+				soft, err2 := parseMemory(fields[i+2])
+				seconds, err3 := strconv.Atoi(fields[i+3])
+				if err1 != nil || err2 != nil || err3 != nil || hard < 0 || soft < 0 || seconds < 0 {
+					return fmt.Errorf("invalid limits for class '%s'", fields[i])
+				}
+				parsed[class] = outputLimit{hard: hard, soft: soft, softSeconds: seconds}
+			}
+
+			outputLimitsMu.Lock()
+			for class, l := range parsed {
+				outputLimits[class] = l
+			}
+			outputLimitsMu.Unlock()
+			return nil
+		},
+	}
+}
+
+var errOutputLimit = errors.New("client output buffer limit reached")
+
+// How often a blocked write re-checks the output limits, and the size of
+// the pieces the queue is written in so progress can be seen.
+const (
+	outputCheckInterval = 100 * time.Millisecond
+	outputChunkSize     = 16 * 1024
+)
+
+// outputWriter sits between a client's reply buffer and its socket. Like
+// a replica feed, it queues what it is given and a goroutine of its own
+// writes it out, so a client that pipelines commands without reading the
+// replies keeps being served and its unread output grows here. The total
+// queued is checked against the output limits on every write, and by a
+// watchdog while the socket is blocked; past them the connection is
+// closed.
+type outputWriter struct {
+	conn net.Conn
+	wake chan struct{}
+	done chan struct{}
+
+	mu            sync.Mutex
+	drained       sync.Cond // signaled when queued drops to 0 or err is set
+	buf           []byte    // not yet picked up by run
+	queued        int64     // bytes in buf and in the write in progress
+	overSoftSince time.Time
+	closed        bool
+	err           error // the first write error, or errOutputLimit
+}
+
+func newOutputWriter(conn net.Conn) *outputWriter {
+	w := &outputWriter{
+		conn: conn,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	w.drained.L = &w.mu
+	go w.run()
+	return w
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	return w.queue(len(p), func(buf []byte) []byte { return append(buf, p...) })
+}
+
+// WriteString lets bufio hand large replies over in one piece, so the
+// whole reply is measured before it is copied
+func (w *outputWriter) WriteString(s string) (int, error) {
+	return w.queue(len(s), func(buf []byte) []byte { return append(buf, s...) })
+}
+
+// queue adds size bytes to the queue through add, unless that takes the
+// client over its output limits
+func (w *outputWriter) queue(size int, add func([]byte) []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.queued += int64(size)
+	if w.overLimitLocked(time.Now()) {
+		w.queued -= int64(size)
+		w.disconnectLocked()
+		return 0, errOutputLimit
+	}
+	w.buf = add(w.buf)
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return size, nil
+}
+
+// pending is the output queued but not written yet
+func (w *outputWriter) pending() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.queued
+}
+
+// drain waits until everything queued has been written, for callers
+// about to write to the socket themselves
+func (w *outputWriter) drain() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.queued > 0 && w.err == nil {
+		w.drained.Wait()
+	}
+	return w.err
+}
+
+// close writes out what is queued, then stops the writer
+func (w *outputWriter) close() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	<-w.done
+}
+
+func (w *outputWriter) run() {
+	defer close(w.done)
+
+	for range w.wake {
+		w.mu.Lock()
+		b, closed := w.buf, w.closed
+		w.buf = nil
+		w.mu.Unlock()
+
+		if len(b) > 0 && !w.send(b) {
+			return
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// send writes b in chunks, watching the output limits while the client
+// doesn't read. It reports whether the connection is still usable.
+func (w *outputWriter) send(b []byte) bool {
+	if limit := getOutputLimit("normal"); limit.hard != 0 || limit.soft != 0 {
+		done := make(chan struct{})
+		watchdog := time.AfterFunc(outputCheckInterval, func() { w.watch(done) })
+		defer func() {
+			close(done)
+			watchdog.Stop()
+		}()
+	}
+
+	for written := 0; written < len(b); {
+		n, err := w.conn.Write(b[written:min(written+outputChunkSize, len(b))])
+		written += n
+
+		w.mu.Lock()
+		w.queued -= int64(n)
+		if err != nil && w.err == nil {
+			w.err = err
+		}
+		if w.queued == 0 || w.err != nil {
+			w.drained.Broadcast()
+		}
+		failed := w.err != nil
+		w.mu.Unlock()
+
+		if failed {
+			w.conn.Close()
+			return false
+		}
+	}
+	return true
+}
+
+// watch runs while a write is blocked, until done is closed
+func (w *outputWriter) watch(done chan struct{}) {
+	ticker := time.NewTicker(outputCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		w.mu.Lock()
+		if w.err == nil && w.overLimitLocked(time.Now()) {
+			w.disconnectLocked()
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// overLimitLocked compares the queued output with the limits of the
+// normal class. Caller holds w.mu.
+func (w *outputWriter) overLimitLocked(now time.Time) bool {
+	limit := getOutputLimit("normal")
+
+	switch {
+	case limit.hard > 0 && w.queued >= limit.hard:
+		return true
+	case limit.soft > 0 && w.queued >= limit.soft:
+		if w.overSoftSince.IsZero() {
+			w.overSoftSince = now
+			return false
+		}
+		return now.Sub(w.overSoftSince) >= time.Duration(limit.softSeconds)*time.Second
+	default:
+		w.overSoftSince = time.Time{}
+		return false
+	}
+}
+
+// disconnectLocked closes the connection of a client over its limits.
+// Caller holds w.mu.
+func (w *outputWriter) disconnectLocked() {
+	w.err = errOutputLimit
+	w.drained.Broadcast()
+	statOutputLimitDisconnects.Add(1)
+	w.conn.Close()
+}
+
+// setKeepAlive applies tcp-keepalive to a TCP connection, also underneath
+// TLS. Unix sockets are left alone.
+func setKeepAlive(conn net.Conn) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	period := tcpKeepAlive.Load()
+	if period == 0 {
+		tcp.SetKeepAlive(false)
+		return
+	}
+	tcp.SetKeepAlive(true)
+	tcp.SetKeepAlivePeriod(time.Duration(period) * time.Second)
+}
+
+// isTimeout reports whether err is a read deadline expiring
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
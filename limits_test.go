@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdleClientTimeout(t *testing.T) {
+	saveConfig(t)
+	addr := startTestServer(t)
+	configByName["timeout"].set("1")
+
+	idle := dialTestClient(t, addr)
+	busy := dialTestClient(t, addr)
+	idle.do("PING")
+
+	deadline := time.Now().Add(1500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if got := busy.do("PING"); got != "+PONG\r\n" {
+			t.Fatalf("active client: %q", got)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	if reply, err := readReply(idle.reader); err == nil {
+		t.Fatalf("idle client still connected: %q", reply)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	saveConfig(t)
+	addr := startTestServer(t)
+
+	first := dialTestClient(t, addr)
+	first.do("PING")
+	// Let connections from earlier tests finish closing
+	time.Sleep(50 * time.Millisecond)
+	maxClients.Store(connectedClients.Load())
+	rejectedBefore := statRejectedConnections.Load()
+
+	second := dialTestClient(t, addr)
+	if got := second.read(); got != "-ERR max number of clients reached\r\n" {
+		t.Fatalf("over the limit: %q", got)
+	}
+	if statRejectedConnections.Load() != rejectedBefore+1 {
+		t.Fatal("rejected_connections not incremented")
+	}
+	if got := first.do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("existing client: %q", got)
+	}
+
+	maxClients.Add(1)
+	third := dialTestClient(t, addr)
+	if got := third.do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("after raising maxclients: %q", got)
+	}
+}
+
+func TestOutputBufferHardLimit(t *testing.T) {
+	saveConfig(t)
+	addr := startTestServer(t)
+	if err := configByName["client-output-buffer-limit"].set("normal 1mb 0 0"); err != nil {
+		t.Fatal(err)
+	}
+
+	big := strings.Repeat("x", 64<<20)
+	writer := dialTestClient(t, addr)
+	writer.do("SET", "big", big)
+
+	// A client that asks for the value and never reads it
+	slow := dialTestClient(t, addr)
+	slow.conn.(*net.TCPConn).SetReadBuffer(4096)
+	before := statOutputLimitDisconnects.Load()
+	slow.send("GET", "big")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for statOutputLimitDisconnects.Load() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("slow reader was not disconnected")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if got := writer.do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("other client affected: %q", got)
+	}
+}
+
+func TestOutputBufferLimitConfig(t *testing.T) {
+	saveConfig(t)
+	p := configByName["client-output-buffer-limit"]
+
+	if err := p.set("normal 10mb 5mb 30 slave 1gb 512mb 120"); err != nil {
+		t.Fatal(err)
+	}
+	if l := getOutputLimit("normal"); l != (outputLimit{hard: 10 << 20, soft: 5 << 20, softSeconds: 30}) {
+		t.Fatalf("normal: %+v", l)
+	}
+	want := "normal 10485760 5242880 30 replica 1073741824 536870912 120 pubsub 33554432 8388608 60"
+	if got := p.get(); got != want {
+		t.Fatalf("get: %q, want %q", got, want)
+	}
+
+	for _, bad := range []string{"normal 1 2", "bogus 0 0 0", "normal -1 0 0", "normal 0 0 x"} {
+		if err := p.set(bad); err == nil {
+			t.Fatalf("accepted %q", bad)
+		}
+	}
+}
+
+func TestOutputBufferHardLimitPipelined(t *testing.T) {
+	saveConfig(t)
+	addr := startTestServer(t)
+	if err := configByName["client-output-buffer-limit"].set("normal 1mb 0 0"); err != nil {
+		t.Fatal(err)
+	}
+
+	writer := dialTestClient(t, addr)
+	writer.do("SET", "k", strings.Repeat("x", 100))
+
+	// Small replies to a client that pipelines and never reads add up
+	slow := dialTestClient(t, addr)
+	slow.conn.(*net.TCPConn).SetReadBuffer(4096)
+	before := statOutputLimitDisconnects.Load()
+	go func() {
+		batch := strings.Repeat(buildRESPCommand("GET", []string{"k"}), 1000)
+		for i := 0; i < 200; i++ {
+			if _, err := io.WriteString(slow.conn, batch); err != nil {
+				return
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for statOutputLimitDisconnects.Load() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("pipelining client that doesn't read was not disconnected")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if got := writer.do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("other client affected: %q", got)
+	}
+}
@@ -1,45 +1,164 @@
 package main
 
 import (
-    "bufio"
+    "errors"
     "fmt"
     "io"
     "net"
+    "os"
+    "os/signal"
     "strconv"
     "strings"
+    "sync"
+    "syscall"
     "time"
 )
 
 func main() {
 
-    // Initialize AOF
-    err := InitAOF()
-    if err != nil {
-        fmt.Println("Error initializing AOF:", err)
-        return
+    // Config file and command line overrides come first, everything
+    // below depends on them
+    if err := LoadConfig(os.Args[1:]); err != nil {
+        fmt.Println("Error loading config:", err)
+        os.Exit(1)
+    }
+    initDatabases()
+
+    if aclFile != "" {
+        if err := aclLoadFile(); err != nil {
+            fmt.Println("Error loading ACL file:", err)
+            os.Exit(1)
+        }
     }
 
-    // Replay AOF BEFORE accepting clients
-    ReplayAOF()
+    if sentinelMode {
+        // A sentinel keeps no data, it watches the masters it was given
+        startSentinel()
+    } else if appendOnly {
+        // Initialize AOF
+        err := InitAOF()
+        if err != nil {
+            fmt.Println("Error initializing AOF:", err)
+            return
+        }
 
-    // Start periodic fsync
-    go BackgroundAOFFsync()
+        // Replay AOF BEFORE accepting clients
+        ReplayAOF()
 
-    // Start TCP server
-    listener, err := net.Listen("tcp", ":6379")
-    if err != nil {
-        fmt.Println("-Error starting server:", err)
-        return
+        // Start periodic fsync
+        go BackgroundAOFFsync()
+    } else {
+        // Without the AOF, a snapshot left by SHUTDOWN SAVE is the data
+        loadSnapshot()
     }
 
-    fmt.Println("Server(Mini-Redis) is listening on port 6379 ...")
+    if clusterEnabled && !sentinelMode {
+        if err := startCluster(); err != nil {
+            fmt.Println("Error starting cluster:", err)
+            os.Exit(1)
+        }
+    }
+
+    if serverPort == 0 && tlsPort == 0 && unixSocket == "" {
+        fmt.Println("Error: port, tls-port and unixsocket are all disabled")
+        os.Exit(1)
+    }
+
+    // Plaintext, TLS and unix socket listeners run side by side
+    var listeners []net.Listener
+
+    if serverPort != 0 {
+        addr := net.JoinHostPort(serverBind, strconv.Itoa(serverPort))
+        listener, err := net.Listen("tcp", addr)
+        if err != nil {
+            fmt.Println("-Error starting server:", err)
+            return
+        }
+        fmt.Printf("Server(Mini-Redis) is listening on %s ...\n", addr)
+        listeners = append(listeners, listener)
+    }
+
+    if tlsPort != 0 {
+        addr := net.JoinHostPort(serverBind, strconv.Itoa(tlsPort))
+        listener, err := listenTLS(addr)
+        if err != nil {
+            fmt.Println("-Error starting TLS server:", err)
+            return
+        }
+        fmt.Printf("Server(Mini-Redis) is listening for TLS on %s ...\n", addr)
+        listeners = append(listeners, listener)
+
+        go reloadTLSOnSIGHUP()
+    }
+
+    if unixSocket != "" {
+        listener, err := listenUnix(unixSocket, os.FileMode(unixSocketPerm))
+        if err != nil {
+            fmt.Println("-Error opening unix socket:", err)
+            return
+        }
+        fmt.Printf("Server(Mini-Redis) is listening on unix socket %s ...\n", unixSocket)
+        listeners = append(listeners, listener)
+    }
+
+    if metricsPort != 0 {
+        addr := net.JoinHostPort(serverBind, strconv.Itoa(metricsPort))
+        listener, err := net.Listen("tcp", addr)
+        if err != nil {
+            fmt.Println("-Error starting metrics server:", err)
+            return
+        }
+        fmt.Printf("Serving metrics on http://%s/metrics ...\n", addr)
+        go serveMetrics(listener)
+    }
 
     // Start expiration janitor
     go startJanitor()
 
+    go replicationCron()
+    if replicaOfHost != "" && !sentinelMode {
+        startReplication(replicaOfHost, replicaOfPort)
+    }
+
+    go shutdownOnSignal()
+
+    var wg sync.WaitGroup
+    for _, listener := range listeners {
+        wg.Add(1)
+        go func(l net.Listener) {
+            defer wg.Done()
+            serve(l)
+        }(listener)
+    }
+    wg.Wait()
+}
+
+// reloadTLSOnSIGHUP re-reads the TLS certificates whenever the process
+// gets SIGHUP, so rotated certificates apply without a restart
+func reloadTLSOnSIGHUP() {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+
+    for range hup {
+        if err := reloadTLS(); err != nil {
+            fmt.Println("TLS reload failed, keeping previous certificates:", err)
+            continue
+        }
+        fmt.Println("TLS certificates reloaded")
+    }
+}
+
+// serve accepts clients until the listener is closed
+func serve(listener net.Listener) {
+    trackListener(listener)
+    defer untrackListener(listener)
+
     for {
         conn, err := listener.Accept()
         if err != nil {
+            if errors.Is(err, net.ErrClosed) {
+                return
+            }
             continue
         }
         go handleConnection(conn)
@@ -49,90 +168,248 @@ func main() {
 func handleConnection(conn net.Conn) {
     defer conn.Close()
 
+    if connectedClients.Add(1) > maxClients.Load() {
+        connectedClients.Add(-1)
+        statRejectedConnections.Add(1)
+        conn.SetWriteDeadline(time.Now().Add(time.Second))
+        io.WriteString(conn, "-ERR max number of clients reached\r\n")
+        return
+    }
+    defer connectedClients.Add(-1)
+
     fmt.Println("New client connected:", conn.RemoteAddr())
+    statTotalConnections.Add(1)
+    setKeepAlive(conn)
 
-    selectedDB := 0
-    reader := bufio.NewReader(conn)
+    c := newClient(conn)
+    // Runs before conn.Close: what is queued still goes out
+    defer c.out.close()
+    registerClient(c)
+    defer unregisterClient(c)
+    defer stopMonitor(c)
+    defer stopReplicaFeed(c)
 
     for {
-        args, err := parseResp(reader)
+        // timeout is re-read for every command so CONFIG SET applies live.
+        // Monitors and replicas are expected to sit silent.
+        if timeout := clientTimeout.Load(); timeout > 0 && c.monitor == nil && c.replica == nil {
+            conn.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+        } else {
+            conn.SetReadDeadline(time.Time{})
+        }
+
+        args, err := parseResp(c.reader)
         if err != nil {
 
             // Client disconnected normally
             if err == io.EOF {
+                c.writer.Flush()
                 fmt.Println("Client disconnected:", conn.RemoteAddr())
                 return
             }
 
-            // Other protocol errors
-            conn.Write([]byte("-ERR protocol error: " + err.Error() + "\r\n"))
+            // Malformed or oversized request: tell the client, then drop it
+            if perr, ok := err.(*protocolError); ok {
+                c.writer.WriteString("-ERR Protocol error: " + perr.msg + "\r\n")
+                c.writer.Flush()
+                fmt.Println("Protocol error from client:", conn.RemoteAddr(), perr.msg)
+                return
+            }
+
+            // Closed right away: replies it never read are dropped
+            if isTimeout(err) {
+                fmt.Println("Closing idle client:", conn.RemoteAddr())
+                conn.Close()
+                return
+            }
+
+            // Connection reset or closed mid-request
+            c.writer.Flush()
+            fmt.Println("Client disconnected:", conn.RemoteAddr(), err)
+            return
+        }
+
+        // Blank inline lines are silently ignored, like Redis does
+        if len(args) > 0 {
+            command := strings.ToUpper(args[0])
+            c.beginCommand(command)
+            handleCommand(c, command, args)
+        }
+
+        if c.closeAfter {
+            c.writer.Flush()
+            fmt.Println("Client disconnected:", conn.RemoteAddr())
             return
         }
 
-        if len(args) == 0 {
-            conn.Write([]byte("-ERR empty command\r\n"))
+        // More complete commands already buffered: keep batching replies
+        if hasCompleteCommand(c.reader) {
             continue
         }
 
-        command := strings.ToUpper(args[0])
-        handleCommand(conn, command, args, &selectedDB)
+        if err := c.writer.Flush(); err != nil {
+            fmt.Println("Client write error:", conn.RemoteAddr(), err)
+            return
+        }
+        c.publishInfo()
     }
 }
 
 func startJanitor() {
-    ticker := time.NewTicker(10 * time.Second)
-    for range ticker.C {
+    // janitor-interval is re-read every cycle so CONFIG SET applies live
+    for {
+        time.Sleep(time.Duration(janitorInterval.Load()) * time.Second)
         cleanExpiredEntries()
+        sweepAuthFailures()
     }
 }
 
 func cleanExpiredEntries() {
-    now := time.Now().Unix()
+    // Replicas leave expiring to their master, which sends a DEL
+    if isReplica() {
+        return
+    }
 
-    mu.Lock()
-    defer mu.Unlock()
+    propagateMu.Lock()
+    defer propagateMu.Unlock()
 
-    totalDeleted := 0
+    start := time.Now()
+    now := start.Unix()
+
+    type expiredKey struct {
+        db  int
+        key string
+    }
+    var deleted []expiredKey
 
+    mu.Lock()
     for dbIndex := 0; dbIndex < NumDatabases; dbIndex++ {
         for key, entry := range databases[dbIndex] {
             if entry.ExpireAt != 0 && entry.ExpireAt <= now {
-                delete(databases[dbIndex], key)
-                totalDeleted++
+                removeEntry(dbIndex, key)
+                deleted = append(deleted, expiredKey{dbIndex, key})
             }
         }
     }
+    mu.Unlock()
+
+    statExpiredKeys.Add(int64(len(deleted)))
+    latencyRecord("expire-cycle", time.Since(start))
+
+    for _, k := range deleted {
+        replicate(k.db, []string{"DEL", k.key})
+    }
 
-    if totalDeleted > 0 {
-        fmt.Printf("[Janitor] Cleaned %d expired keys\n", totalDeleted)
+    if len(deleted) > 0 {
+        fmt.Printf("[Janitor] Cleaned %d expired keys\n", len(deleted))
     }
 }
 
 
-func handleCommand(conn net.Conn, command string, args []string, selectedDB *int) {
-    resp, err := execCommand(args, selectedDB)
+func handleCommand(c *Client, command string, args []string) {
+    statTotalCommands.Add(1)
 
-    if err == nil && !isReplayingAOF {
-        upper := strings.ToUpper(command)
-        switch upper {
-        case 
-            "SET", 
-            "DEL",
-            "INCR",
-            "DECR",
-            "MSET", 
-            "FLUSHALL",  
-            "EXPIRE", 
-            "PERSIST",
-            "HSET", 
-            "HDEL", 
-            "ZADD", 
-            "ZREM":
-            LogCommand(upper, args[1:])
+    // The user was removed by ACL DELUSER / ACL LOAD: drop the connection
+    if c.user != nil && c.user.isDeleted() {
+        c.closeAfter = true
+        return
+    }
+
+    // A monitor gets no replies, the feed owns its socket. QUIT turns
+    // it back into a normal client so the +OK can be sent before closing.
+    if c.monitor != nil {
+        if command != "QUIT" {
+            return
         }
+        stopMonitor(c)
+    }
+
+    // A replica only talks back with REPLCONF ACK, which gets no reply
+    if c.replica != nil {
+        if command == "REPLCONF" && len(args) >= 3 {
+            cmdREPLCONF(c, args)
+        }
+        return
+    }
+
+    def, ok := commandTable[command]
+    if !ok {
+        c.writer.WriteString("-ERR unknown command '" + command + "'\r\n")
+        return
+    }
+
+    if errReply := arityError(command, def, args); errReply != "" {
+        c.writer.WriteString(errReply)
+        return
     }
 
-    conn.Write([]byte(resp))
+    if c.user == nil && !def.hasFlag("no_auth") {
+        c.writer.WriteString("-NOAUTH Authentication required.\r\n")
+        return
+    }
+
+    if c.user != nil {
+        if errReply := aclCheckCommand(c, command, def, args); errReply != "" {
+            c.writer.WriteString(errReply)
+            return
+        }
+    }
+
+    // Keys served by another cluster node are redirected there
+    if clusterEnabled {
+        if errReply := clusterRedirect(c, def, args); errReply != "" {
+            c.writer.WriteString(errReply)
+            return
+        }
+    }
+
+    // CLIENT PAUSE holds commands here until it ends
+    waitWhilePaused(command, def)
+
+    feedMonitors(c, command, args)
+
+    // Connection level commands never touch the keyspace or the AOF
+    if def.ClientFunc != nil {
+        start := time.Now()
+        resp, _ := def.ClientFunc(c, args)
+        def.latency.observe(time.Since(start))
+        c.writer.WriteString(resp)
+        return
+    }
+
+    write := def.hasFlag("write")
+    if write && isReplica() && replicaReadOnly.Load() {
+        c.writer.WriteString("-READONLY You can't write against a read only replica.\r\n")
+        return
+    }
+
+    // Shutdown waits on the gate for in-flight commands to finish
+    commandGate.RLock()
+    defer commandGate.RUnlock()
+
+    // Writes reach the AOF and the replicas in the order they were applied.
+    // MIGRATE waits on another instance, it propagates what it deleted
+    // itself.
+    propagates := write && !isReplayingAOF && command != "MIGRATE"
+    if propagates {
+        propagateMu.Lock()
+        defer propagateMu.Unlock()
+    }
+
+    start := time.Now()
+    resp, err := execCommand(args, &c.db)
+    elapsed := time.Since(start)
+    slowlogRecord(c, args, elapsed)
+    def.latency.observe(elapsed)
+
+    if err == nil && !isReplayingAOF && write {
+        if propagates {
+            propagate(c.db, args, resp)
+        }
+        recordWriteOffsets(c)
+    }
+
+    c.writer.WriteString(resp)
 }
 
 func execCommand(args []string, selectedDB *int) (string, error) {
@@ -142,22 +419,15 @@ func execCommand(args []string, selectedDB *int) (string, error) {
 
     cmd := strings.ToUpper(args[0])
 
-    if cmd == "SELECT" {
-        if len(args) != 2 {
-            return "-ERR wrong number of arguments for 'SELECT' command\r\n", fmt.Errorf("wrong args")
-        }
-        dbIndex, err := strconv.Atoi(args[1])
-        if err != nil || dbIndex < 0 || dbIndex >= NumDatabases {
-            return "-ERR invalid database index\r\n", fmt.Errorf("invalid db index")
-        }
-        *selectedDB = dbIndex
-        return "+OK\r\n", nil
+    c, ok := commandTable[cmd]
+    if !ok || c.Func == nil {
+        return "-ERR unknown command '" + cmd + "'\r\n", fmt.Errorf("unknown command")
     }
 
-    fn, ok := commandTable[cmd]
-    if !ok {
-        return "-ERR unknown command '" + cmd + "'\r\n", fmt.Errorf("unknown command")
+    // Replay and tests come here without going through handleCommand
+    if errReply := arityError(cmd, c, args); errReply != "" {
+        return errReply, fmt.Errorf("wrong args")
     }
 
-    return fn(args, selectedDB)
+    return c.Func(args, selectedDB)
 }
\ No newline at end of file
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// metricsPort serves /metrics and /healthz over HTTP; 0 disables it
+var metricsPort = 0
+
+// histogramBounds are the upper bounds of the latency buckets, in seconds
+var histogramBounds = [...]float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// latencyHistogram counts durations in histogramBounds buckets. Each bucket
+// only counts its own range; write makes them cumulative for Prometheus.
+type latencyHistogram struct {
+	buckets   [len(histogramBounds) + 1]atomic.Int64 // the last one is +Inf
+	count     atomic.Int64
+	sumMicros atomic.Int64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(histogramBounds[:], seconds)
+	h.buckets[i].Add(1)
+	h.count.Add(1)
+	h.sumMicros.Add(d.Microseconds())
+}
+
+// write renders the histogram as name_bucket, name_sum and name_count
+// series. labels is either empty or ends with a comma.
+func (h *latencyHistogram) write(w io.Writer, name, labels string) {
+	var cumulative int64
+	for i, bound := range histogramBounds {
+		cumulative += h.buckets[i].Load()
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	cumulative += h.buckets[len(histogramBounds)].Load()
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, cumulative)
+
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, float64(h.sumMicros.Load())/1e6)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count.Load())
+}
+
+// aofFsyncLatency times every fsync of the AOF
+var aofFsyncLatency latencyHistogram
+
+func metricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeMetrics renders the Prometheus text exposition format
+func writeMetrics(w io.Writer) {
+	metricHeader(w, "miniredis_uptime_seconds", "gauge", "Seconds since the server started.")
+	fmt.Fprintf(w, "miniredis_uptime_seconds %d\n", int64(time.Since(serverStartTime).Seconds()))
+
+	metricHeader(w, "miniredis_connected_clients", "gauge", "Client connections currently open.")
+	fmt.Fprintf(w, "miniredis_connected_clients %d\n", connectedClients.Load())
+	metricHeader(w, "miniredis_connections_received_total", "counter", "Client connections accepted.")
+	fmt.Fprintf(w, "miniredis_connections_received_total %d\n", statTotalConnections.Load())
+	metricHeader(w, "miniredis_rejected_connections_total", "counter", "Connections refused because of maxclients.")
+	fmt.Fprintf(w, "miniredis_rejected_connections_total %d\n", statRejectedConnections.Load())
+
+	names := make([]string, 0, len(commandTable))
+	for name := range commandTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metricHeader(w, "miniredis_commands_total", "counter", "Commands processed, per command.")
+	for _, name := range names {
+		fmt.Fprintf(w, "miniredis_commands_total{cmd=%q} %d\n", strings.ToLower(name), commandTable[name].latency.count.Load())
+	}
+	metricHeader(w, "miniredis_command_duration_seconds", "histogram", "Time spent executing commands, per command.")
+	for _, name := range names {
+		commandTable[name].latency.write(w, "miniredis_command_duration_seconds", fmt.Sprintf("cmd=%q,", strings.ToLower(name)))
+	}
+
+	// Counted under the lock, written without it: a slow scraper must not
+	// hold up the writers
+	type dbCounts struct{ db, keys, expires int }
+	var counts []dbCounts
+	mu.RLock()
+	for i := 0; i < NumDatabases; i++ {
+		if len(databases[i]) == 0 {
+			continue
+		}
+		counts = append(counts, dbCounts{i, len(databases[i]), expiringKeys[i]})
+	}
+	used := usedMemory
+	mu.RUnlock()
+
+	metricHeader(w, "miniredis_db_keys", "gauge", "Keys per non-empty database.")
+	metricHeader(w, "miniredis_db_keys_expiring", "gauge", "Keys with a TTL per non-empty database.")
+	for _, c := range counts {
+		fmt.Fprintf(w, "miniredis_db_keys{db=\"%d\"} %d\n", c.db, c.keys)
+		fmt.Fprintf(w, "miniredis_db_keys_expiring{db=\"%d\"} %d\n", c.db, c.expires)
+	}
+
+	metricHeader(w, "miniredis_expired_keys_total", "counter", "Keys removed because their TTL passed.")
+	fmt.Fprintf(w, "miniredis_expired_keys_total %d\n", statExpiredKeys.Load())
+	metricHeader(w, "miniredis_evicted_keys_total", "counter", "Keys evicted to free memory. The server never evicts, so this stays 0.")
+	fmt.Fprintf(w, "miniredis_evicted_keys_total 0\n")
+	metricHeader(w, "miniredis_keyspace_hits_total", "counter", "Read lookups that found the key.")
+	fmt.Fprintf(w, "miniredis_keyspace_hits_total %d\n", statKeyspaceHits.Load())
+	metricHeader(w, "miniredis_keyspace_misses_total", "counter", "Read lookups that didn't find the key.")
+	fmt.Fprintf(w, "miniredis_keyspace_misses_total %d\n", statKeyspaceMisses.Load())
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	metricHeader(w, "miniredis_memory_used_bytes", "gauge", "Estimated size of the keyspace.")
+	fmt.Fprintf(w, "miniredis_memory_used_bytes %d\n", used)
+	metricHeader(w, "miniredis_memory_rss_bytes", "gauge", "Resident set size of the process.")
+	fmt.Fprintf(w, "miniredis_memory_rss_bytes %d\n", residentMemory(&ms))
+	metricHeader(w, "miniredis_memory_heap_bytes", "gauge", "Bytes of allocated heap objects.")
+	fmt.Fprintf(w, "miniredis_memory_heap_bytes %d\n", ms.HeapAlloc)
+
+	if appendOnly {
+		if fi, err := os.Stat(AOFFileName); err == nil {
+			metricHeader(w, "miniredis_aof_size_bytes", "gauge", "Size of the append only file.")
+			fmt.Fprintf(w, "miniredis_aof_size_bytes %d\n", fi.Size())
+		}
+	}
+	metricHeader(w, "miniredis_aof_fsync_duration_seconds", "histogram", "Time spent in fsync of the append only file.")
+	aofFsyncLatency.write(w, "miniredis_aof_fsync_duration_seconds", "")
+}
+
+// aofHealth returns the latest AOF write or fsync error, if any
+func aofHealth() error {
+	aofMu.Lock()
+	defer aofMu.Unlock()
+
+	if aofLastWriteErr != nil {
+		return fmt.Errorf("aof write error: %v", aofLastWriteErr)
+	}
+	if aofLastFsyncErr != nil {
+		return fmt.Errorf("aof fsync error: %v", aofLastFsyncErr)
+	}
+	return nil
+}
+
+func metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := aofHealth(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	})
+	return mux
+}
+
+// serveMetrics runs the HTTP endpoint until shutdown closes the listener
+func serveMetrics(listener net.Listener) {
+	trackListener(listener)
+	defer untrackListener(listener)
+
+	srv := &http.Server{Handler: metricsHandler(), ReadHeaderTimeout: 10 * time.Second}
+	if err := srv.Serve(listener); err != nil && !shuttingDown.Load() {
+		fmt.Println("Metrics server stopped:", err)
+	}
+}
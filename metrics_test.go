@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func getMetrics(t *testing.T, srv *httptest.Server, path string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	resetKeyspace()
+	c := dialTestClient(t, startTestServer(t))
+	srv := httptest.NewServer(metricsHandler())
+	defer srv.Close()
+
+	_, before := getMetrics(t, srv, "/metrics")
+	c.do("SET", "k", "v")
+	c.do("SELECT", "2")
+	c.do("SET", "k2", "v")
+	c.do("EXPIRE", "k2", "100")
+
+	status, body := getMetrics(t, srv, "/metrics")
+	if status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	for _, want := range []string{
+		"# TYPE miniredis_command_duration_seconds histogram\n",
+		`miniredis_command_duration_seconds_bucket{cmd="set",le="+Inf"} `,
+		`miniredis_command_duration_seconds_count{cmd="set"} `,
+		`miniredis_db_keys{db="0"} 1`,
+		`miniredis_db_keys{db="2"} 1`,
+		`miniredis_db_keys_expiring{db="2"} 1`,
+		"miniredis_connected_clients ",
+		"miniredis_evicted_keys_total 0\n",
+		"miniredis_aof_fsync_duration_seconds_count ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("/metrics missing %q", want)
+		}
+	}
+
+	// The SETs were counted, and the buckets add up to the call count
+	count := func(body string) string {
+		for _, line := range strings.Split(body, "\n") {
+			if strings.HasPrefix(line, `miniredis_commands_total{cmd="set"} `) {
+				return strings.Fields(line)[1]
+			}
+		}
+		return ""
+	}
+	if b, a := count(before), count(body); b == "" || a == "" || a == b {
+		t.Fatalf("set counter went from %s to %s", b, a)
+	}
+	if !strings.Contains(body, `miniredis_command_duration_seconds_bucket{cmd="set",le="+Inf"} `+count(body)+"\n") {
+		t.Fatal("+Inf bucket doesn't match the call count")
+	}
+}
+
+func TestHealthzReportsAOFErrors(t *testing.T) {
+	srv := httptest.NewServer(metricsHandler())
+	defer srv.Close()
+
+	if status, body := getMetrics(t, srv, "/healthz"); status != http.StatusOK || body != "OK\n" {
+		t.Fatalf("healthy: %d %q", status, body)
+	}
+
+	aofMu.Lock()
+	aofLastWriteErr = errors.New("disk full")
+	aofMu.Unlock()
+	t.Cleanup(func() {
+		aofMu.Lock()
+		aofLastWriteErr = nil
+		aofMu.Unlock()
+	})
+
+	status, body := getMetrics(t, srv, "/healthz")
+	if status != http.StatusServiceUnavailable || !strings.Contains(body, "disk full") {
+		t.Fatalf("after a write error: %d %q", status, body)
+	}
+}
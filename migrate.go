@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrateOptions is a parsed MIGRATE command line
+type migrateOptions struct {
+	addr    string
+	db      int
+	timeout time.Duration
+	copy    bool
+	replace bool
+	user    string // AUTH2 username
+	pass    string
+	keys    []string
+}
+
+// parseMigrate reads MIGRATE host port key|"" destination-db timeout
+// [COPY] [REPLACE] [AUTH password] [AUTH2 username password]
+// [KEYS key [key ...]]
+func parseMigrate(args []string) (*migrateOptions, string) {
+	port, err := strconv.Atoi(args[2])
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, "-ERR value is not an integer or out of range\r\n"
+	}
+	db, err1 := strconv.Atoi(args[4])
+	timeout, err2 := strconv.ParseInt(args[5], 10, 64)
+	if err1 != nil || err2 != nil || db < 0 {
+		return nil, "-ERR value is not an integer or out of range\r\n"
+	}
+	if timeout <= 0 {
+		timeout = 1000
+	}
+
+	opts := &migrateOptions{
+		addr:    net.JoinHostPort(args[1], args[2]),
+		db:      db,
+		timeout: time.Duration(timeout) * time.Millisecond,
+	}
+	for i := 6; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COPY":
+			opts.copy = true
+		case "REPLACE":
+			opts.replace = true
+		case "AUTH":
+			if i+1 >= len(args) {
+				return nil, "-ERR syntax error\r\n"
+			}
+			opts.pass = args[i+1]
+			i++
+		case "AUTH2":
+			if i+2 >= len(args) {
+				return nil, "-ERR syntax error\r\n"
+			}
+			opts.user, opts.pass = args[i+1], args[i+2]
+			i += 2
+		case "KEYS":
+			if args[3] != "" {
+				return nil, "-ERR When using MIGRATE KEYS option, the key argument must be set to the empty string\r\n"
+			}
+			opts.keys = args[i+1:]
+			i = len(args)
+		default:
+			return nil, "-ERR syntax error\r\n"
+		}
+	}
+	if opts.keys == nil {
+		opts.keys = []string{args[3]}
+	}
+	return opts, ""
+}
+
+// migrateKeys finds the keys of a MIGRATE call: the key argument, or
+// what follows KEYS when it is empty
+func migrateKeys(args []string) []string {
+	if len(args) < 6 {
+		return nil
+	}
+	if args[3] != "" {
+		return []string{args[3]}
+	}
+	for i := 6; i < len(args); i++ {
+		if strings.EqualFold(args[i], "KEYS") {
+			return args[i+1:]
+		}
+	}
+	return nil
+}
+
+// containsFold tells whether args holds option, in any case
+func containsFold(args []string, option string) bool {
+	for _, arg := range args {
+		if strings.EqualFold(arg, option) {
+			return true
+		}
+	}
+	return false
+}
+
+// cmdMIGRATE sends keys to another instance as DUMP payloads, restored
+// there with RESTORE, which refuses existing keys unless REPLACE is
+// given. The keys are deleted here once the target accepted them all,
+// unless COPY is given; on any error they all stay. In cluster mode
+// RESTORE-ASKING is used, the slot being imported on the target.
+//
+// It runs without propagateMu, so other writes go on while it waits for
+// the target, and propagates the deletions itself, see deleteMigrated.
+func cmdMIGRATE(args []string, selectedDB *int) (string, error) {
+	opts, errReply := parseMigrate(args)
+	if errReply != "" {
+		return errReply, fmt.Errorf("invalid arguments")
+	}
+
+	restore := "RESTORE"
+	if clusterEnabled {
+		restore = "RESTORE-ASKING"
+	}
+
+	// Keys are serialized under the lock, their values may change later
+	var out bytes.Buffer
+	var moved []migratedKey
+	nowMs := time.Now().UnixMilli()
+	mu.RLock()
+	for _, key := range opts.keys {
+		entry, exists := databases[*selectedDB][key]
+		if !exists || (entry.ExpireAt != 0 && entry.ExpireAt*1000 <= nowMs) {
+			continue
+		}
+		payload, err := dumpEntry(entry)
+		if err != nil {
+			mu.RUnlock()
+			return "-ERR " + err.Error() + "\r\n", err
+		}
+		ttl := int64(0)
+		if entry.ExpireAt != 0 {
+			ttl = entry.ExpireAt*1000 - nowMs
+		}
+
+		restoreArgs := []string{key, strconv.FormatInt(ttl, 10), string(payload)}
+		if opts.replace {
+			restoreArgs = append(restoreArgs, "REPLACE")
+		}
+		out.WriteString(buildRESPCommand(restore, restoreArgs))
+		moved = append(moved, migratedKey{key: key, payload: payload, expireAt: entry.ExpireAt})
+	}
+	mu.RUnlock()
+
+	if len(moved) == 0 {
+		return "+NOKEY\r\n", nil
+	}
+
+	conn, err := net.DialTimeout("tcp", opts.addr, opts.timeout)
+	if err != nil {
+		return "-IOERR error or timeout connecting to the client\r\n", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(opts.timeout))
+
+	var header bytes.Buffer
+	replies := len(moved) + 1
+	if opts.pass != "" {
+		if opts.user != "" {
+			header.WriteString(buildRESPCommand("AUTH", []string{opts.user, opts.pass}))
+		} else {
+			header.WriteString(buildRESPCommand("AUTH", []string{opts.pass}))
+		}
+		replies++
+	}
+	header.WriteString(buildRESPCommand("SELECT", []string{strconv.Itoa(opts.db)}))
+
+	if _, err := conn.Write(append(header.Bytes(), out.Bytes()...)); err != nil {
+		return "-IOERR error or timeout writing to target instance\r\n", err
+	}
+
+	// Every reply is read, the first error is what the client gets
+	reader := bufio.NewReader(conn)
+	var targetErr error
+	for ; replies > 0; replies-- {
+		reply, err := readReplyValue(reader)
+		if err != nil {
+			return "-IOERR error or timeout reading to target instance\r\n", err
+		}
+		if e, ok := reply.(error); ok && targetErr == nil {
+			targetErr = e
+		}
+	}
+	if targetErr != nil {
+		return "-ERR Target instance replied with error: " + targetErr.Error() + "\r\n", targetErr
+	}
+
+	if !opts.copy {
+		deleteMigrated(*selectedDB, moved)
+	}
+	return "+OK\r\n", nil
+}
+
+// migratedKey is a key as MIGRATE sent it
+type migratedKey struct {
+	key      string
+	payload  []byte
+	expireAt int64
+}
+
+// deleteMigrated removes the keys MIGRATE moved and sends their DELs to
+// the AOF and the replicas. A key written while MIGRATE talked to the
+// target no longer matches what was sent, and stays.
+func deleteMigrated(db int, moved []migratedKey) {
+	propagateMu.Lock()
+	defer propagateMu.Unlock()
+
+	var deleted []string
+	mu.Lock()
+	for _, m := range moved {
+		entry, exists := databases[db][m.key]
+		if !exists || entry.ExpireAt != m.expireAt {
+			continue
+		}
+		if payload, err := dumpEntry(entry); err != nil || !bytes.Equal(payload, m.payload) {
+			continue
+		}
+		removeEntry(db, m.key)
+		deleted = append(deleted, m.key)
+	}
+	mu.Unlock()
+
+	for _, key := range deleted {
+		LogCommand("DEL", []string{key})
+		replicate(db, []string{"DEL", key})
+	}
+}
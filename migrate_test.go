@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTarget records what MIGRATE sends it. RESTORE is recorded without
+// its payload, which is kept aside, and a TTL only as whether there is
+// one; it fails with BUSYKEY on a key in existing unless REPLACE is
+// given. Everything else gets +OK. While gate is set, replies to RESTORE
+// wait for it to be closed.
+type fakeTarget struct {
+	mu       sync.Mutex
+	received []string
+	payloads map[string]string
+	existing map[string]bool
+	gate     chan struct{}
+}
+
+func startFakeTarget(t *testing.T) (*fakeTarget, string, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	target := &fakeTarget{payloads: map[string]string{}, existing: map[string]bool{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go target.serve(conn)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return target, host, port
+}
+
+func (f *fakeTarget) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := parseResp(reader)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		reply := "+OK\r\n"
+		if strings.HasPrefix(args[0], "RESTORE") && len(args) >= 4 {
+			ttl := "ttl"
+			if args[2] == "0" {
+				ttl = "0"
+			}
+			options := args[4:]
+			f.received = append(f.received, strings.Join(append([]string{args[0], args[1], ttl}, options...), " "))
+			f.payloads[args[1]] = args[3]
+			if f.existing[args[1]] && !containsFold(options, "REPLACE") {
+				reply = "-BUSYKEY Target key name already exists.\r\n"
+			}
+		} else {
+			f.received = append(f.received, strings.Join(args, " "))
+		}
+		gate := f.gate
+		f.mu.Unlock()
+		if gate != nil && strings.HasPrefix(args[0], "RESTORE") {
+			<-gate
+		}
+		conn.Write([]byte(reply))
+	}
+}
+
+func (f *fakeTarget) commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	commands := f.received
+	f.received = nil
+	return commands
+}
+
+func TestMigrate(t *testing.T) {
+	c := dialTestClient(t, startTestServer(t))
+	target, host, port := startFakeTarget(t)
+
+	c.do("SET", "k", "v")
+	c.do("EXPIRE", "k", "100")
+	c.do("HSET", "h", "f", "v")
+
+	if got := c.do("MIGRATE", host, port, "", "3", "1000", "AUTH", "secret", "KEYS", "k", "h", "missing"); got != "+OK\r\n" {
+		t.Fatalf("MIGRATE: %q", got)
+	}
+	got := strings.Join(target.commands(), "\n")
+	want := strings.Join([]string{"AUTH secret", "SELECT 3", "RESTORE k ttl", "RESTORE h 0"}, "\n")
+	if got != want {
+		t.Errorf("target received:\n%s\nwant:\n%s", got, want)
+	}
+	target.mu.Lock()
+	entry, err := restoreEntry([]byte(target.payloads["h"]))
+	target.mu.Unlock()
+	if err != nil || entry.Type != TypeHash || entry.Value.(Hash).Fields["f"] != "v" {
+		t.Errorf("payload of h restores to %+v, %v", entry, err)
+	}
+	if got := c.do("EXISTS", "k", "h"); got != ":0\r\n" {
+		t.Fatalf("keys left behind: %q", got)
+	}
+	if got := c.do("MIGRATE", host, port, "k", "0", "1000"); got != "+NOKEY\r\n" {
+		t.Fatalf("MIGRATE of a missing key: %q", got)
+	}
+
+	// An existing key is only overwritten with REPLACE, and stays here
+	// when the target refuses it
+	c.do("SET", "k", "v")
+	target.mu.Lock()
+	target.existing["k"] = true
+	target.mu.Unlock()
+	if got := c.do("MIGRATE", host, port, "k", "0", "1000"); got != "-ERR Target instance replied with error: BUSYKEY Target key name already exists.\r\n" {
+		t.Fatalf("MIGRATE onto an existing key: %q", got)
+	}
+	if got := c.do("EXISTS", "k"); got != ":1\r\n" {
+		t.Fatalf("refused key was deleted: %q", got)
+	}
+	target.commands()
+	if got := c.do("MIGRATE", host, port, "k", "0", "1000", "COPY", "REPLACE"); got != "+OK\r\n" {
+		t.Fatalf("MIGRATE COPY REPLACE: %q", got)
+	}
+	if got := strings.Join(target.commands(), ","); got != "SELECT 0,RESTORE k 0 REPLACE" {
+		t.Errorf("target received %q", got)
+	}
+	if got := c.do("GET", "k"); got != "$1\r\nv\r\n" {
+		t.Errorf("COPY removed the local key: %q", got)
+	}
+}
+
+func TestMigrateKeepsKeysWrittenMeanwhile(t *testing.T) {
+	addr := startTestServer(t)
+	c := dialTestClient(t, addr)
+	other := dialTestClient(t, addr)
+	target, host, port := startFakeTarget(t)
+
+	c.do("SET", "changed", "v")
+	c.do("SET", "moved", "v")
+	gate := make(chan struct{})
+	target.mu.Lock()
+	target.gate = gate
+	target.mu.Unlock()
+
+	// SELECT and the first RESTORE reached the target: both keys are
+	// serialized, and MIGRATE waits for the reply
+	c.send("MIGRATE", host, port, "", "0", "5000", "KEYS", "changed", "moved")
+	for {
+		target.mu.Lock()
+		n := len(target.received)
+		target.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Writes aren't held up while the target is slow to answer
+	if got := other.do("SET", "changed", "v2"); got != "+OK\r\n" {
+		t.Fatalf("SET during MIGRATE: %q", got)
+	}
+	close(gate)
+	if got := c.read(); got != "+OK\r\n" {
+		t.Fatalf("MIGRATE: %q", got)
+	}
+
+	if got := c.do("GET", "changed"); got != "$2\r\nv2\r\n" {
+		t.Errorf("key written during MIGRATE: %q", got)
+	}
+	if got := c.do("EXISTS", "moved"); got != ":0\r\n" {
+		t.Errorf("unchanged key left behind: %q", got)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// monitorBacklog is how many lines a monitor may fall behind before it is
+// disconnected. Commands never wait for a monitor.
+const monitorBacklog = 4096
+
+// monitorFeed streams command lines to one MONITOR connection. Its
+// goroutine is the only writer on the connection while it runs.
+type monitorFeed struct {
+	c     *Client
+	lines chan string
+	done  chan struct{} // closed once the writer goroutine has exited
+}
+
+var (
+	monitorsMu   sync.Mutex
+	monitors     = map[*Client]*monitorFeed{}
+	monitorCount atomic.Int32 // lets the command path skip formatting
+)
+
+// redactedArg replaces secrets in the lines monitors get
+const redactedArg = "(redacted)"
+
+// redactMonitorArgs hides the passwords a command carries, the way Redis
+// redacts arguments before feeding monitors. args is left untouched.
+func redactMonitorArgs(command string, args []string) []string {
+	var hide []int
+	switch command {
+	case "AUTH":
+		for i := 1; i < len(args); i++ {
+			hide = append(hide, i)
+		}
+	case "HELLO":
+		// HELLO protover AUTH username password
+		for i := 2; i < len(args); i++ {
+			if strings.EqualFold(args[i], "AUTH") {
+				hide = append(hide, i+1, i+2)
+				i += 2
+			}
+		}
+	case "ACL":
+		// Rules that add or remove a password, in clear or hashed
+		if len(args) > 1 && strings.EqualFold(args[1], "SETUSER") {
+			for i := 3; i < len(args); i++ {
+				if args[i] != "" && strings.ContainsRune("><#!", rune(args[i][0])) {
+					hide = append(hide, i)
+				}
+			}
+		}
+	case "CONFIG":
+		if len(args) > 1 && strings.EqualFold(args[1], "SET") {
+			for i := 2; i+1 < len(args); i += 2 {
+				if p := configByName[strings.ToLower(args[i])]; p != nil && p.sensitive {
+					hide = append(hide, i+1)
+				}
+			}
+		}
+	case "MIGRATE":
+		// Options come after the timeout, the keys after KEYS
+	options:
+		for i := 6; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "AUTH":
+				hide = append(hide, i+1)
+				i++
+			case "AUTH2":
+				hide = append(hide, i+1, i+2)
+				i += 2
+			case "KEYS":
+				break options
+			}
+		}
+	}
+	if len(hide) == 0 {
+		return args
+	}
+
+	redacted := append([]string(nil), args...)
+	for _, i := range hide {
+		if i < len(redacted) {
+			redacted[i] = redactedArg
+		}
+	}
+	return redacted
+}
+
+func startMonitor(c *Client) {
+	f := &monitorFeed{
+		c:     c,
+		lines: make(chan string, monitorBacklog),
+		done:  make(chan struct{}),
+	}
+	c.monitor = f
+	c.publishInfo()
+
+	monitorsMu.Lock()
+	monitors[c] = f
+	monitorsMu.Unlock()
+	monitorCount.Add(1)
+
+	go f.run()
+}
+
+// stopMonitor turns c back into a normal client once every queued line
+// has been written. Called by the client's own goroutine.
+func stopMonitor(c *Client) {
+	f := c.monitor
+	if f == nil {
+		return
+	}
+
+	monitorsMu.Lock()
+	if monitors[c] == f {
+		removeMonitorLocked(f)
+	}
+	monitorsMu.Unlock()
+
+	<-f.done
+	c.monitor = nil
+	c.publishInfo()
+}
+
+// removeMonitorLocked stops feeding f. Caller holds monitorsMu.
+func removeMonitorLocked(f *monitorFeed) {
+	delete(monitors, f.c)
+	monitorCount.Add(-1)
+	close(f.lines)
+}
+
+func (f *monitorFeed) run() {
+	defer close(f.done)
+
+	w := bufio.NewWriter(f.c.conn)
+	broken := false
+	for line := range f.lines {
+		if broken {
+			continue // drain until the feed is removed
+		}
+		w.WriteString(line)
+
+		// Batch whatever is already queued into one write
+		if len(f.lines) == 0 {
+			if err := w.Flush(); err != nil {
+				broken = true
+				f.c.conn.Close()
+			}
+		}
+	}
+	if !broken {
+		w.Flush()
+	}
+}
+
+// feedMonitors sends the command to every monitor. A monitor whose backlog
+// is full is disconnected rather than slowing the command path down.
+func feedMonitors(c *Client, command string, args []string) {
+	if monitorCount.Load() == 0 {
+		return
+	}
+	line := formatMonitorLine(time.Now(), c.db, monitorAddr(c), redactMonitorArgs(command, args))
+
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+
+	for _, f := range monitors {
+		select {
+		case f.lines <- line:
+		default:
+			fmt.Println("Disconnecting slow MONITOR client:", f.c.conn.RemoteAddr())
+			removeMonitorLocked(f)
+			f.c.conn.Close()
+		}
+	}
+}
+
+// formatMonitorLine renders a line the way Redis does:
+// +1339518083.107412 [0 127.0.0.1:60866] "set" "k" "v"
+func formatMonitorLine(t time.Time, db int, addr string, args []string) string {
+	var sb strings.Builder
+	sb.WriteString("+")
+	sb.WriteString(strconv.FormatInt(t.Unix(), 10))
+	sb.WriteString(fmt.Sprintf(".%06d", t.Nanosecond()/1000))
+	sb.WriteString(" [" + strconv.Itoa(db) + " " + addr + "]")
+	for _, arg := range args {
+		sb.WriteString(" ")
+		sb.WriteString(quoteMonitorArg(arg))
+	}
+	sb.WriteString("\r\n")
+	return sb.String()
+}
+
+// quoteMonitorArg quotes s with the escapes of Redis's sdscatrepr
+func quoteMonitorArg(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(ch)
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		case '\a':
+			sb.WriteString("\\a")
+		case '\b':
+			sb.WriteString("\\b")
+		default:
+			if ch < 0x20 || ch > 0x7e {
+				sb.WriteString(fmt.Sprintf("\\x%02x", ch))
+			} else {
+				sb.WriteByte(ch)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// monitorAddr is the client address as Redis prints it in MONITOR
+func monitorAddr(c *Client) string {
+	if c.conn.LocalAddr().Network() == "unix" {
+		return "unix:" + c.conn.LocalAddr().String()
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+// cmdMONITOR replies +OK and hands the connection to the feed. From then
+// on the client gets no replies; QUIT ends the session.
+func cmdMONITOR(c *Client, args []string) (string, error) {
+	if c.monitor != nil {
+		return "", nil
+	}
+
+	c.writer.WriteString("+OK\r\n")
+	if err := c.flushOutput(); err != nil {
+		return "", err
+	}
+	startMonitor(c)
+	return "", nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitorFeed(t *testing.T) {
+	addr := startTestServer(t)
+	mon := dialTestClient(t, addr)
+	c := dialTestClient(t, addr)
+
+	if got := mon.do("MONITOR"); got != "+OK\r\n" {
+		t.Fatalf("MONITOR: %q", got)
+	}
+	if list := c.do("CLIENT", "LIST"); !strings.Contains(list, "flags=O ") {
+		t.Fatalf("CLIENT LIST doesn't flag the monitor: %q", list)
+	}
+
+	c.do("SELECT", "3")
+	c.do("SET", "k", "say \"hi\"\n")
+	c.do("AUTH", "secret")
+
+	line := regexp.MustCompile(`^\+\d+\.\d{6} \[(\d+) 127\.0\.0\.1:\d+\] (.*)\r\n$`)
+	want := []struct{ db, args string }{
+		{"0", `"CLIENT" "LIST"`},
+		{"0", `"SELECT" "3"`},
+		{"3", `"SET" "k" "say \"hi\"\n"`},
+		{"3", `"AUTH" "(redacted)"`},
+	}
+	for _, w := range want {
+		got := mon.read()
+		m := line.FindStringSubmatch(got)
+		if m == nil || m[1] != w.db || m[2] != w.args {
+			t.Fatalf("monitor line %q, want db %s and %s", got, w.db, w.args)
+		}
+	}
+
+	// QUIT gets its reply once the feed is drained
+	mon.send("QUIT")
+	c.do("PING")
+	for {
+		got := mon.read()
+		if got == "+OK\r\n" {
+			break
+		}
+		if !strings.Contains(got, `"PING"`) {
+			t.Fatalf("QUIT from monitor: %q", got)
+		}
+	}
+}
+
+func TestMonitorSlowClientDropped(t *testing.T) {
+	addr := startTestServer(t)
+	mon := dialTestClient(t, addr)
+	mon.conn.(*net.TCPConn).SetReadBuffer(4096)
+	mon.do("MONITOR")
+
+	// The monitor never reads; commands must keep flowing regardless
+	c := dialTestClient(t, addr)
+	value := strings.Repeat("v", 16<<10)
+	for i := 0; i < 2*monitorBacklog; i++ {
+		if got := c.do("SET", "k", value); got != "+OK\r\n" {
+			t.Fatalf("SET %d: %q", i, got)
+		}
+	}
+
+	if n := monitorCount.Load(); n != 0 {
+		t.Fatalf("slow monitor still fed: %d monitors", n)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for strings.Contains(c.do("CLIENT", "LIST"), "flags=O ") {
+		if time.Now().After(deadline) {
+			t.Fatal("slow monitor still connected")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestQuoteMonitorArg(t *testing.T) {
+	for in, want := range map[string]string{
+		"plain":    `"plain"`,
+		`a"b\c`:    `"a\"b\\c"`,
+		"\r\n\t":   `"\r\n\t"`,
+		"\x00\xff": `"\x00\xff"`,
+		"":         `""`,
+	} {
+		if got := quoteMonitorArg(in); got != want {
+			t.Errorf("quoteMonitorArg(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestRedactMonitorArgs(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"AUTH pw", "AUTH (redacted)"},
+		{"AUTH user pw", "AUTH (redacted) (redacted)"},
+		{"HELLO 3 AUTH user pw SETNAME app", "HELLO 3 AUTH (redacted) (redacted) SETNAME app"},
+		{"HELLO 3", "HELLO 3"},
+		{"ACL SETUSER bob on >pw ~* +@all", "ACL SETUSER bob on (redacted) ~* +@all"},
+		{"ACL SETUSER bob #abcd <old !abcd", "ACL SETUSER bob (redacted) (redacted) (redacted)"},
+		{"ACL GETUSER >bob", "ACL GETUSER >bob"},
+		{"CONFIG SET requirepass pw maxmemory 10", "CONFIG SET requirepass (redacted) maxmemory 10"},
+		{"CONFIG SET MASTERAUTH pw", "CONFIG SET MASTERAUTH (redacted)"},
+		{"CONFIG SET masteruser bob", "CONFIG SET masteruser (redacted)"},
+		{"CONFIG GET requirepass", "CONFIG GET requirepass"},
+		{"MIGRATE h 1 k 0 100 AUTH pw", "MIGRATE h 1 k 0 100 AUTH (redacted)"},
+		{"MIGRATE h 1 \"\" 0 100 COPY AUTH2 u pw KEYS a b", "MIGRATE h 1 \"\" 0 100 COPY AUTH2 (redacted) (redacted) KEYS a b"},
+		{"MIGRATE h 1 \"\" 0 100 KEYS auth pw", "MIGRATE h 1 \"\" 0 100 KEYS auth pw"},
+		{"SET auth pw", "SET auth pw"},
+	} {
+		args := strings.Fields(tc.in)
+		orig := strings.Join(args, " ")
+		got := strings.Join(redactMonitorArgs(strings.ToUpper(args[0]), args), " ")
+		if got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.in, got, tc.want)
+		}
+		if strings.Join(args, " ") != orig {
+			t.Errorf("%s: args changed to %s", tc.in, strings.Join(args, " "))
+		}
+	}
+}
@@ -2,61 +2,347 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
 )
 
+// Protocol limits. A request exceeding any of them is rejected with a
+// protocol error and the connection is closed. CONFIG SET changes them
+// while connections parse, hence the atomics.
+var (
+	protoMaxBulkLen        = newAtomicInt(512 * 1024 * 1024)  // largest accepted bulk string
+	protoMaxMultibulkLen   = newAtomicInt(1024 * 1024)        // largest accepted argument count
+	clientQueryBufferLimit = newAtomicInt(1024 * 1024 * 1024) // largest accepted request as a whole
+)
+
+const (
+	protoInlineMaxSize = 64 * 1024 // longest inline request / header line
+	protoPreallocLen   = 32 * 1024 // bulks above this are grown as data arrives
+)
+
+// protocolError is a malformed or oversized request. Unlike I/O errors it is
+// reported back to the client before the connection is dropped.
+type protocolError struct {
+	msg string
+}
+
+func (e *protocolError) Error() string {
+	return e.msg
+}
+
+func newProtocolError(msg string) error {
+	return &protocolError{msg: msg}
+}
+
+// protoLen parses the count after '*' or the length after '$', ok only
+// when it lies within [min, max]. Both parseResp and respFrameComplete go
+// through it, so the look-ahead never trusts a number the parser refuses.
+func protoLen(digits string, min, max int64) (int64, bool) {
+	n, err := strconv.ParseInt(digits, 10, 64)
+	return n, err == nil && n >= min && n <= max
+}
+
 func parseResp(reader *bufio.Reader) ([]string, error) {
-	line, err := reader.ReadString('\n')
+	line, err := readLine(reader)
+	if err == errLineTooLong {
+		return nil, newProtocolError("too big inline request")
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	line = strings.TrimSpace(line)
 	if len(line) == 0 || line[0] != '*' {
-		return nil, fmt.Errorf("-ERR invalid RESP format.(* expected)")
+		// Not a multibulk request: telnet/nc style inline command
+		return parseInline(line)
 	}
 
-	numArgs, err := strconv.Atoi(line[1:])  // ASCII to int
-	if( err != nil || numArgs <= 0 ){
-		return nil, fmt.Errorf("-ERR invalid RESP length")
+	numArgs, ok := protoLen(line[1:], 1, protoMaxMultibulkLen.Load())
+	if !ok {
+		return nil, newProtocolError("invalid multibulk length")
 	}
 
-	args := make([]string, numArgs)
+	// Don't trust the announced count for the allocation
+	args := make([]string, 0, min(numArgs, 1024))
+	queryLen := int64(len(line))
+	maxBulkLen, queryLimit := protoMaxBulkLen.Load(), clientQueryBufferLimit.Load()
 
-	for i := 0 ; i<numArgs ; i++ {
-		lenline, err := reader.ReadString('\n')
+	for i := int64(0); i < numArgs; i++ {
+		lenline, err := readLine(reader)
+		if err == errLineTooLong {
+			return nil, newProtocolError("too big bulk count string")
+		}
 		if err != nil {
-			return nil, fmt.Errorf("-ERR io error reading bulk header")
+			return nil, fmt.Errorf("reading bulk header: %w", err)
 		}
 
 		lenline = strings.TrimSpace(lenline)
 		if len(lenline) == 0 || lenline[0] != '$' {
-			return nil, fmt.Errorf("-ERR invalid RESP format. $ expected")
+			return nil, newProtocolError("expected '$', got '" + firstChar(lenline) + "'")
 		}
 
-		length, err := strconv.Atoi(lenline[1:])
-		if err != nil {
-			return nil, fmt.Errorf("-ERR invalid bulk length")
+		length, ok := protoLen(lenline[1:], 0, maxBulkLen)
+		if !ok {
+			return nil, newProtocolError("invalid bulk length")
 		}
 
-		buff := make([]byte, length)
-		if _ , err := io.ReadFull(reader, buff); err != nil {
-			return nil, fmt.Errorf("-ERR io error reading bulk data")
+		queryLen += int64(len(lenline)) + length
+		if queryLen > queryLimit {
+			return nil, newProtocolError("query buffer limit exceeded")
 		}
 
-		crlf, err := reader.ReadString('\n')
+		buff, err := readBulk(reader, length)
 		if err != nil {
-			return nil, fmt.Errorf("-ERR protocol error: missing terminating CRLF")
+			return nil, fmt.Errorf("reading bulk data: %w", err)
 		}
-		if !strings.HasSuffix(crlf, "\r\n") {
-			return nil, fmt.Errorf("-ERR protocol error: invalid terminating CRLF")
+
+		crlf := make([]byte, 2)
+		if _, err := io.ReadFull(reader, crlf); err != nil {
+			return nil, fmt.Errorf("reading bulk terminator: %w", err)
+		}
+		if crlf[0] != '\r' || crlf[1] != '\n' {
+			return nil, newProtocolError("invalid terminating CRLF")
 		}
 
-		args[i] = string(buff)
+		args = append(args, string(buff))
 	}
 
 	return args, nil
 }
+
+var errLineTooLong = fmt.Errorf("line too long")
+
+// readLine reads up to and including '\n' without letting a client that
+// never sends a newline grow the line past protoInlineMaxSize.
+func readLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > protoInlineMaxSize {
+			return "", errLineTooLong
+		}
+		line = append(line, chunk...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(line), nil
+	}
+}
+
+// readBulk reads a bulk payload of the announced length. Small payloads are
+// read in one go; large ones grow as the bytes actually arrive so a bogus
+// length can't make us allocate memory up front.
+func readBulk(reader *bufio.Reader, length int64) ([]byte, error) {
+	if length <= protoPreallocLen {
+		buff := make([]byte, length)
+		if _, err := io.ReadFull(reader, buff); err != nil {
+			return nil, err
+		}
+		return buff, nil
+	}
+
+	var buff bytes.Buffer
+	buff.Grow(protoPreallocLen)
+	if _, err := io.CopyN(&buff, reader, length); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func firstChar(s string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return s[:1]
+}
+
+// parseInline splits an inline command (e.g. "SET key \"hello world\"")
+// into arguments. Tokens are separated by whitespace; double quoted tokens
+// support escapes like \n and \x41, single quoted tokens only support \'.
+// An empty line yields no arguments.
+func parseInline(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+
+	for {
+		// skip blanks
+		for i < len(line) && isInlineSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var token strings.Builder
+		inDouble := false
+		inSingle := false
+		done := false
+
+		for !done {
+			if inDouble {
+				if i >= len(line) {
+					return nil, newProtocolError("unbalanced quotes in request")
+				}
+				c := line[i]
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' &&
+					isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					token.WriteByte(hexValue(line[i+2])<<4 | hexValue(line[i+3]))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						token.WriteByte('\n')
+					case 'r':
+						token.WriteByte('\r')
+					case 't':
+						token.WriteByte('\t')
+					case 'b':
+						token.WriteByte('\b')
+					case 'a':
+						token.WriteByte('\a')
+					default:
+						token.WriteByte(line[i])
+					}
+				} else if c == '"' {
+					// closing quote must be followed by a blank or the end
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, newProtocolError("unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					token.WriteByte(c)
+				}
+			} else if inSingle {
+				if i >= len(line) {
+					return nil, newProtocolError("unbalanced quotes in request")
+				}
+				c := line[i]
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					token.WriteByte('\'')
+				} else if c == '\'' {
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, newProtocolError("unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					token.WriteByte(c)
+				}
+			} else {
+				if i >= len(line) {
+					break
+				}
+				switch c := line[i]; {
+				case isInlineSpace(c):
+					done = true
+				case c == '"':
+					inDouble = true
+				case c == '\'':
+					inSingle = true
+				default:
+					token.WriteByte(c)
+				}
+			}
+			i++
+		}
+
+		args = append(args, token.String())
+	}
+}
+
+func isInlineSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// hasCompleteCommand reports whether the reader already holds at least one
+// full request (multibulk or inline), i.e. whether the next parseResp call can be served
+// without touching the socket. Malformed input counts as complete so the
+// parser gets to report the error.
+func hasCompleteCommand(reader *bufio.Reader) bool {
+	n := reader.Buffered()
+	if n == 0 {
+		return false
+	}
+
+	buf, err := reader.Peek(n)
+	if err != nil {
+		return false
+	}
+
+	return respFrameComplete(buf)
+}
+
+// respFrameComplete checks whether buf starts with a complete multibulk frame
+func respFrameComplete(buf []byte) bool {
+	line, rest, ok := cutLine(buf)
+	if !ok {
+		return false
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return true
+	}
+
+	numArgs, ok := protoLen(string(line[1:]), 1, protoMaxMultibulkLen.Load())
+	if !ok {
+		return true
+	}
+
+	maxBulkLen := protoMaxBulkLen.Load()
+	for i := int64(0); i < numArgs; i++ {
+		line, rest, ok = cutLine(rest)
+		if !ok {
+			return false
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return true
+		}
+
+		length, ok := protoLen(string(line[1:]), 0, maxBulkLen)
+		if !ok {
+			return true
+		}
+
+		// payload + CRLF, without adding to a length that may be huge
+		if int64(len(rest)-2) < length {
+			return false
+		}
+		rest = rest[length+2:]
+	}
+
+	return true
+}
+
+// cutLine splits buf after the first '\n', trimming the line terminator
+func cutLine(buf []byte) ([]byte, []byte, bool) {
+	idx := bytes.IndexByte(buf, '\n')
+	if idx < 0 {
+		return nil, buf, false
+	}
+	return bytes.TrimSpace(buf[:idx]), buf[idx+1:], true
+}
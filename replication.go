@@ -0,0 +1,920 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replication settings
+var (
+	masterAuth      = newAtomicString("")   // password used to authenticate with the master
+	masterUser      = newAtomicString("")   // user for masterauth, "" means the default user
+	replicaReadOnly = newAtomicBool(true)   // refuse writes from clients while a replica
+	replBacklogSize = newAtomicInt(1 << 20) // bytes of stream kept for partial resyncs
+	replPingPeriod  = newAtomicInt(10)      // seconds between PINGs sent to replicas
+	replTimeout     = newAtomicInt(60)      // seconds without traffic before a link is dropped
+)
+
+// propagateMu orders write commands with what they send to the AOF and
+// the replicas, so both see writes in the order they were applied. It is
+// taken before mu.
+var propagateMu sync.Mutex
+
+// replLastDB is the database the replication stream is in: the last
+// SELECT sent to, or received from, the stream. Guarded by propagateMu.
+var replLastDB = -1
+
+// Replication state, guarded by replMu. The stream is identified by
+// replID; replID2 is the id of the master this server was promoted from,
+// valid for offsets up to replSecondOffset, so its replicas can continue.
+var (
+	replMu           sync.Mutex
+	replID                 = newRunID()
+	replID2                = strings.Repeat("0", 40)
+	replSecondOffset int64 = -1
+	replOffset       int64 // bytes of stream produced, or received from the master
+	replicas         = map[*Client]*replicaFeed{}
+
+	// The backlog is a ring holding the last replBacklogHistLen bytes of
+	// the stream; replBacklogIdx is where the next byte goes. It is only
+	// created once a replica needs it.
+	replBacklog        []byte
+	replBacklogIdx     int
+	replBacklogHistLen int64
+)
+
+// currentMaster is the link to our master, nil while this server is a
+// master itself
+var currentMaster atomic.Pointer[masterLink]
+
+func isReplica() bool {
+	return currentMaster.Load() != nil
+}
+
+// ensureBacklogLocked creates the backlog if needed. Caller holds replMu.
+func ensureBacklogLocked() {
+	if replBacklog == nil {
+		replBacklog = make([]byte, replBacklogSize.Load())
+		replBacklogIdx = 0
+		replBacklogHistLen = 0
+	}
+}
+
+// replFeedLocked appends b to the stream: the offset, the backlog and
+// every replica. Caller holds replMu.
+func replFeedLocked(b []byte) {
+	replOffset += int64(len(b))
+
+	if replBacklog != nil {
+		size := len(replBacklog)
+		for p := b; len(p) > 0; {
+			n := copy(replBacklog[replBacklogIdx:], p)
+			replBacklogIdx = (replBacklogIdx + n) % size
+			p = p[n:]
+		}
+		replBacklogHistLen = min(replBacklogHistLen+int64(len(b)), int64(size))
+	}
+
+	for c, f := range replicas {
+		if !f.send(b) {
+			fmt.Println("Disconnecting replica over its output buffer limit:", c.conn.RemoteAddr())
+			removeReplicaLocked(f)
+			c.conn.Close()
+		}
+	}
+}
+
+// backlogFrom returns the stream from offset to the end. Caller holds
+// replMu and has checked the offset is in the backlog.
+func backlogFrom(offset int64) []byte {
+	n := int(replOffset + 1 - offset)
+	size := len(replBacklog)
+	start := (replBacklogIdx - n + size) % size
+
+	out := make([]byte, 0, n)
+	if start+n <= size {
+		return append(out, replBacklog[start:start+n]...)
+	}
+	out = append(out, replBacklog[start:]...)
+	return append(out, replBacklog[:n-(size-start)]...)
+}
+
+// canPartialSync reports whether a replica that has the stream id up to
+// offset-1 can continue from the backlog. Caller holds replMu.
+func canPartialSync(id string, offset int64) bool {
+	if id != replID && !(id == replID2 && offset <= replSecondOffset) {
+		return false
+	}
+	if replBacklog == nil {
+		return false
+	}
+	first := replOffset - replBacklogHistLen + 1
+	return offset >= first && offset <= replOffset+1
+}
+
+// propagate sends a write that succeeded on db to the AOF and the
+// replicas. Caller holds propagateMu.
+func propagate(db int, args []string, resp string) {
+	command := strings.ToUpper(args[0])
+	LogCommand(command, args[1:])
+
+	if args = replicationArgs(db, command, args, resp); args != nil {
+		replicate(db, args)
+	}
+}
+
+// replicate appends a command run on db to the replication stream.
+// Caller holds propagateMu.
+func replicate(db int, args []string) {
+	// Replicas only relay their master's stream
+	if isReplica() {
+		return
+	}
+
+	replMu.Lock()
+	defer replMu.Unlock()
+
+	// Nothing is kept until the first replica connects
+	if replBacklog == nil {
+		return
+	}
+
+	var b []byte
+	if db != replLastDB {
+		b = append(b, buildRESPCommand("SELECT", []string{strconv.Itoa(db)})...)
+		replLastDB = db
+	}
+	b = append(b, buildRESPCommand(args[0], args[1:])...)
+	replFeedLocked(b)
+}
+
+// replicationArgs rewrites a command so replicas end up with the same
+// data whenever they apply it: relative expires and RESTORE TTLs become
+// absolute ones and FLUSHALL ASYNC becomes synchronous. nil means nothing
+// to send.
+func replicationArgs(db int, command string, args []string, resp string) []string {
+	switch command {
+	case "EXPIRE":
+		if resp != ":1\r\n" {
+			return nil
+		}
+		mu.RLock()
+		entry, exists := databases[db][args[1]]
+		mu.RUnlock()
+		if !exists {
+			// A past expire time deletes the key right away
+			return []string{"DEL", args[1]}
+		}
+		return []string{"EXPIREAT", args[1], strconv.FormatInt(entry.ExpireAt, 10)}
+
+	case "FLUSHALL":
+		return []string{"FLUSHALL"}
+
+	case "RESTORE", "RESTORE-ASKING":
+		restored := append([]string{"RESTORE"}, args[1:]...)
+		if args[2] == "0" || containsFold(args[4:], "ABSTTL") {
+			return restored
+		}
+		mu.RLock()
+		entry, exists := databases[db][args[1]]
+		mu.RUnlock()
+		if !exists {
+			return []string{"DEL", args[1]}
+		}
+		restored[2] = strconv.FormatInt(entry.ExpireAt*1000, 10)
+		return append(restored, "ABSTTL")
+	}
+	return append([]string{command}, args[1:]...)
+}
+
+// replicaFeed streams replication data to one replica. Pending bytes are
+// buffered up to the replica client-output-buffer-limit; the goroutine
+// in run is the only writer on the connection.
+type replicaFeed struct {
+	c    *Client
+	wake chan struct{}
+	done chan struct{} // closed once run has exited
+
+	mu     sync.Mutex
+	buf    []byte
+	closed bool
+
+	ackOffset    atomic.Int64
+	ackAOFOffset atomic.Int64 // stream offset the replica has fsynced to its AOF
+	ackTime      atomic.Int64 // unix seconds of the latest REPLCONF ACK
+}
+
+func newReplicaFeed(c *Client) *replicaFeed {
+	f := &replicaFeed{
+		c:    c,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	f.ackTime.Store(time.Now().Unix())
+	return f
+}
+
+// send queues b, or returns false if the replica is too far behind
+func (f *replicaFeed) send(b []byte) bool {
+	f.mu.Lock()
+	if limit := getOutputLimit("replica").hard; limit > 0 && int64(len(f.buf)+len(b)) > limit {
+		f.mu.Unlock()
+		return false
+	}
+	f.buf = append(f.buf, b...)
+	f.mu.Unlock()
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (f *replicaFeed) close() {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (f *replicaFeed) run() {
+	defer close(f.done)
+
+	broken := false
+	for range f.wake {
+		f.mu.Lock()
+		b, closed := f.buf, f.closed
+		f.buf = nil
+		f.mu.Unlock()
+
+		if len(b) > 0 && !broken {
+			if _, err := f.c.conn.Write(b); err != nil {
+				broken = true
+				f.c.conn.Close()
+			}
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// removeReplicaLocked stops feeding f. Caller holds replMu.
+func removeReplicaLocked(f *replicaFeed) {
+	delete(replicas, f.c)
+	f.close()
+}
+
+// stopReplicaFeed turns c back into a normal client once the queued
+// stream is written. Called by the client's own goroutine.
+func stopReplicaFeed(c *Client) {
+	f := c.replica
+	if f == nil {
+		return
+	}
+
+	replMu.Lock()
+	if replicas[c] == f {
+		removeReplicaLocked(f)
+	}
+	replMu.Unlock()
+
+	<-f.done
+	c.replica = nil
+}
+
+// dropReplicasLocked disconnects every replica so they sync again. Caller
+// holds replMu.
+func dropReplicasLocked() {
+	for c, f := range replicas {
+		removeReplicaLocked(f)
+		c.conn.Close()
+	}
+}
+
+// cmdPSYNC turns the connection into a replica: it continues from the
+// backlog when it can, otherwise it gets a snapshot first
+func cmdPSYNC(c *Client, args []string) (string, error) {
+	if c.replica != nil {
+		return "", nil
+	}
+	if m := currentMaster.Load(); m != nil && !m.isUp() {
+		return "-NOMASTERLINK Can't SYNC while not connected with my master\r\n", fmt.Errorf("no master link")
+	}
+	offset, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		offset = -1
+	}
+
+	// Replies pipelined before PSYNC go out before the stream
+	if err := c.flushOutput(); err != nil {
+		return "", err
+	}
+
+	propagateMu.Lock()
+	defer propagateMu.Unlock()
+	replMu.Lock()
+	defer replMu.Unlock()
+
+	f := newReplicaFeed(c)
+	if canPartialSync(args[1], offset) {
+		fmt.Printf("Partial resync of replica %s from offset %d\n", c.conn.RemoteAddr(), offset)
+		f.send([]byte("+CONTINUE " + replID + "\r\n"))
+		f.send(backlogFrom(offset))
+	} else {
+		fmt.Printf("Full resync of replica %s at offset %d\n", c.conn.RemoteAddr(), replOffset)
+		ensureBacklogLocked()
+
+		// The snapshot ends on the database the stream is in
+		var payload bytes.Buffer
+		w := bufio.NewWriter(&payload)
+		dumpDatabases(w)
+		w.WriteString(buildRESPCommand("SELECT", []string{strconv.Itoa(max(replLastDB, 0))}))
+		w.Flush()
+
+		f.send([]byte(fmt.Sprintf("+FULLRESYNC %s %d\r\n$%d\r\n", replID, replOffset, payload.Len())))
+		f.send(payload.Bytes())
+	}
+
+	replicas[c] = f
+	c.replica = f
+	c.publishInfo()
+	go f.run()
+	return "", nil
+}
+
+// REPLCONF option value [option value ...]
+func cmdREPLCONF(c *Client, args []string) (string, error) {
+	if len(args)%2 == 0 {
+		return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+	}
+
+	for i := 1; i < len(args); i += 2 {
+		option, value := strings.ToLower(args[i]), args[i+1]
+		switch option {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 0 || port > 65535 {
+				return "-ERR value is not an integer or out of range\r\n", fmt.Errorf("bad port")
+			}
+			c.replListeningPort = port
+		case "ip-address", "capa":
+		case "ack":
+			// ACK <offset> [FACK <aofoffset>], which never gets a reply
+			if c.replica != nil {
+				if offset, err := strconv.ParseInt(value, 10, 64); err == nil {
+					c.replica.ackOffset.Store(offset)
+					c.replica.ackTime.Store(time.Now().Unix())
+				}
+				if len(args) == i+4 && strings.EqualFold(args[i+2], "fack") {
+					if offset, err := strconv.ParseInt(args[i+3], 10, 64); err == nil {
+						c.replica.ackAOFOffset.Store(offset)
+					}
+				}
+				notifyWaiters()
+			}
+			return "", nil
+		case "getack":
+			return "", nil
+		default:
+			return "-ERR Unrecognized REPLCONF option: " + args[i] + "\r\n", fmt.Errorf("unknown option")
+		}
+	}
+	return "+OK\r\n", nil
+}
+
+// REPLICAOF host port | REPLICAOF NO ONE
+func cmdREPLICAOF(args []string, selectedDB *int) (string, error) {
+	if strings.EqualFold(args[1], "NO") && strings.EqualFold(args[2], "ONE") {
+		if isReplica() {
+			stopReplication()
+			fmt.Println("MASTER MODE enabled")
+		}
+		return "+OK\r\n", nil
+	}
+
+	port, err := strconv.Atoi(args[2])
+	if err != nil || port < 0 || port > 65535 {
+		return "-ERR Invalid master port\r\n", fmt.Errorf("bad port")
+	}
+	if m := currentMaster.Load(); m != nil && m.host == args[1] && m.port == port {
+		return "+OK Already connected to specified master\r\n", nil
+	}
+
+	startReplication(args[1], port)
+	return "+OK\r\n", nil
+}
+
+// startReplication makes this server a replica of host:port, replacing
+// any previous master
+func startReplication(host string, port int) {
+	replMu.Lock()
+	defer replMu.Unlock()
+
+	if old := currentMaster.Load(); old != nil {
+		old.stop()
+	}
+	m := &masterLink{host: host, port: port, done: make(chan struct{})}
+	currentMaster.Store(m)
+	fmt.Printf("Connecting to MASTER %s:%d\n", host, port)
+	go m.run()
+}
+
+// stopReplication promotes this server to master. The old stream id is
+// kept as replID2 so other replicas of the old master can continue with
+// us.
+func stopReplication() {
+	replMu.Lock()
+	defer replMu.Unlock()
+
+	m := currentMaster.Load()
+	if m == nil {
+		return
+	}
+	m.stop()
+	currentMaster.Store(nil)
+
+	replID2 = replID
+	replSecondOffset = replOffset + 1
+	replID = newRunID()
+}
+
+// masterLink is a replica's connection to its master
+type masterLink struct {
+	host string
+	port int
+
+	stopped atomic.Bool
+	done    chan struct{} // closed by stop
+
+	mu      sync.Mutex // guards conn and writes to it
+	conn    net.Conn
+	up      bool
+	syncing bool
+	lastIO  time.Time
+}
+
+func (m *masterLink) stop() {
+	if m.stopped.Swap(true) {
+		return
+	}
+	close(m.done)
+
+	m.mu.Lock()
+	if m.conn != nil {
+		m.conn.Close()
+	}
+	m.mu.Unlock()
+}
+
+func (m *masterLink) isUp() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.up
+}
+
+// status returns the link state for INFO
+func (m *masterLink) status() (up, syncing bool, lastIO time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.up, m.syncing, m.lastIO
+}
+
+// run keeps the link to the master up, reconnecting every second
+func (m *masterLink) run() {
+	for !m.stopped.Load() {
+		if err := m.sync(); err != nil && !m.stopped.Load() {
+			fmt.Printf("Lost connection with MASTER %s:%d: %v\n", m.host, m.port, err)
+		}
+
+		m.mu.Lock()
+		if m.conn != nil {
+			m.conn.Close()
+			m.conn = nil
+		}
+		m.up, m.syncing = false, false
+		m.mu.Unlock()
+
+		select {
+		case <-m.done:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// send writes a command to the master
+func (m *masterLink) send(args ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == nil {
+		return net.ErrClosed
+	}
+	_, err := io.WriteString(m.conn, buildRESPCommand(args[0], args[1:]))
+	return err
+}
+
+// exchange sends a handshake command and returns the reply line
+func (m *masterLink) exchange(r *bufio.Reader, args ...string) (string, error) {
+	if err := m.send(args...); err != nil {
+		return "", err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// sync connects, runs the handshake and then applies the stream until
+// the connection breaks
+func (m *masterLink) sync() error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(m.host, strconv.Itoa(m.port)), 5*time.Second)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	if m.stopped.Load() {
+		m.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	m.conn = conn
+	m.syncing = true
+	m.mu.Unlock()
+
+	conn.SetDeadline(time.Now().Add(time.Duration(replTimeout.Load()) * time.Second))
+	r := bufio.NewReader(conn)
+
+	if reply, err := m.exchange(r, "PING"); err != nil {
+		return err
+	} else if strings.HasPrefix(reply, "-") && !strings.HasPrefix(reply, "-NOAUTH") {
+		return fmt.Errorf("error reply to PING: %s", reply)
+	}
+
+	if auth := masterAuth.Load(); auth != "" {
+		args := []string{"AUTH", auth}
+		if user := masterUser.Load(); user != "" {
+			args = []string{"AUTH", user, auth}
+		}
+		if reply, err := m.exchange(r, args...); err != nil {
+			return err
+		} else if reply != "+OK" {
+			return fmt.Errorf("unable to AUTH to MASTER: %s", reply)
+		}
+	}
+
+	if reply, err := m.exchange(r, "REPLCONF", "listening-port", strconv.Itoa(serverPort), "capa", "psync2"); err != nil {
+		return err
+	} else if reply != "+OK" {
+		return fmt.Errorf("REPLCONF refused: %s", reply)
+	}
+
+	replMu.Lock()
+	id, offset := replID, replOffset
+	replMu.Unlock()
+
+	reply, err := m.exchange(r, "PSYNC", id, strconv.FormatInt(offset+1, 10))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.HasPrefix(reply, "+FULLRESYNC "):
+		fields := strings.Fields(reply)
+		if len(fields) != 3 {
+			return fmt.Errorf("bad FULLRESYNC reply: %s", reply)
+		}
+		masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad FULLRESYNC reply: %s", reply)
+		}
+		if err := m.loadSnapshot(r, fields[1], masterOffset); err != nil {
+			return err
+		}
+
+	case strings.HasPrefix(reply, "+CONTINUE"):
+		fmt.Println("Successful partial resynchronization with master")
+		if fields := strings.Fields(reply); len(fields) == 2 && fields[1] != id {
+			replMu.Lock()
+			replID2, replSecondOffset = replID, replOffset+1
+			replID = fields[1]
+			replMu.Unlock()
+		}
+
+	default:
+		return fmt.Errorf("unexpected reply to PSYNC: %s", reply)
+	}
+
+	// From here on only reads are bounded by repl-timeout
+	conn.SetWriteDeadline(time.Time{})
+
+	m.mu.Lock()
+	m.up, m.syncing = true, false
+	m.lastIO = time.Now()
+	m.mu.Unlock()
+	fmt.Println("MASTER <-> REPLICA sync: Finished with success")
+
+	go m.ackLoop()
+	return m.stream(r)
+}
+
+// loadSnapshot replaces the dataset with the snapshot the master sends
+// after +FULLRESYNC
+func (m *masterLink) loadSnapshot(r *bufio.Reader, id string, offset int64) error {
+	var header string
+	for header == "" {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		header = strings.TrimRight(line, "\r\n") // bare newlines keep the link alive
+	}
+	size, err := strconv.ParseInt(strings.TrimPrefix(header, "$"), 10, 64)
+	if !strings.HasPrefix(header, "$") || err != nil || size < 0 {
+		return fmt.Errorf("bad snapshot header: %q", header)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+
+	propagateMu.Lock()
+	defer propagateMu.Unlock()
+
+	if m.stopped.Load() {
+		return nil
+	}
+
+	mu.Lock()
+	flushAllDatabases()
+	mu.Unlock()
+
+	count, db := replayStream(bufio.NewReader(bytes.NewReader(payload)))
+	logSnapshot(payload)
+	fmt.Printf("MASTER <-> REPLICA sync: Loaded %d commands (%d bytes)\n", count, size)
+
+	replMu.Lock()
+	defer replMu.Unlock()
+
+	replID, replOffset = id, offset
+	replID2, replSecondOffset = strings.Repeat("0", 40), -1
+	replBacklog = nil
+	ensureBacklogLocked()
+	replLastDB = db
+
+	// Our own replicas hold the old dataset
+	dropReplicasLocked()
+	return nil
+}
+
+// stream applies the commands the master sends, relaying them to our
+// own replicas
+func (m *masterLink) stream(r *bufio.Reader) error {
+	for {
+		m.conn.SetReadDeadline(time.Now().Add(time.Duration(replTimeout.Load()) * time.Second))
+		args, err := parseResp(r)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		m.mu.Lock()
+		m.lastIO = time.Now()
+		m.mu.Unlock()
+
+		if err := m.apply(args); err != nil {
+			return err
+		}
+	}
+}
+
+// apply runs one command of the stream and advances the offset
+func (m *masterLink) apply(args []string) error {
+	propagateMu.Lock()
+	defer propagateMu.Unlock()
+
+	if m.stopped.Load() {
+		return net.ErrClosed
+	}
+
+	command := strings.ToUpper(args[0])
+	getAck := false
+
+	switch command {
+	case "PING":
+	case "REPLCONF":
+		getAck = len(args) >= 2 && strings.EqualFold(args[1], "GETACK")
+	case "SELECT":
+		replLastDB = replayCommand(args, max(replLastDB, 0))
+	case "EXPIREAT":
+		replayCommand(args, max(replLastDB, 0))
+		LogCommand(command, args[1:])
+	default:
+		db := max(replLastDB, 0)
+		if _, err := execCommand(args, &db); err == nil {
+			if def := commandTable[command]; def != nil && def.hasFlag("write") {
+				LogCommand(command, args[1:])
+			}
+		}
+	}
+
+	// Like Redis, the answer to GETACK doesn't count the GETACK itself
+	replMu.Lock()
+	offset := replOffset
+	replFeedLocked([]byte(buildRESPCommand(args[0], args[1:])))
+	replMu.Unlock()
+
+	aofMu.Lock()
+	aofWrittenReplOffset = offset
+	aofMu.Unlock()
+
+	if getAck {
+		// The master is waiting on WAIT or WAITAOF: make the AOF
+		// durable first so the answer is as recent as possible
+		if appendOnly {
+			FlushAOF()
+		}
+		return m.sendAck(offset)
+	}
+	return nil
+}
+
+// sendAck reports the processed offset to the master. With the AOF on,
+// FACK tells how much of the stream has been fsynced as well.
+func (m *masterLink) sendAck(offset int64) error {
+	if !appendOnly {
+		return m.send("REPLCONF", "ACK", strconv.FormatInt(offset, 10))
+	}
+	aofMu.Lock()
+	fsynced := aofFsyncedReplOffset
+	aofMu.Unlock()
+	return m.send("REPLCONF", "ACK", strconv.FormatInt(offset, 10), "FACK", strconv.FormatInt(fsynced, 10))
+}
+
+// ackLoop reports the processed offset to the master every second
+func (m *masterLink) ackLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+		if !m.isUp() {
+			return
+		}
+
+		replMu.Lock()
+		offset := replOffset
+		replMu.Unlock()
+		if m.sendAck(offset) != nil {
+			return
+		}
+	}
+}
+
+// replicationCron pings the replicas so they can tell the link is alive
+func replicationCron() {
+	for {
+		time.Sleep(time.Duration(max(replPingPeriod.Load(), 1)) * time.Second)
+		if isReplica() {
+			continue
+		}
+
+		propagateMu.Lock()
+		replMu.Lock()
+		if len(replicas) > 0 {
+			replFeedLocked([]byte(buildRESPCommand("PING", nil)))
+		}
+		replMu.Unlock()
+		propagateMu.Unlock()
+	}
+}
+
+// replicaOfHost and replicaOfPort come from the "replicaof" directive;
+// main starts replicating once the local data is loaded
+var (
+	replicaOfHost = ""
+	replicaOfPort = 0
+)
+
+// replicaOfConfig handles "replicaof <host> <port>"
+func replicaOfConfig() *configParam {
+	return &configParam{
+		name:      "replicaof",
+		immutable: true,
+		get: func() string {
+			if m := currentMaster.Load(); m != nil {
+				return m.host + " " + strconv.Itoa(m.port)
+			}
+			if replicaOfHost != "" {
+				return replicaOfHost + " " + strconv.Itoa(replicaOfPort)
+			}
+			return ""
+		},
+		set: func(value string) error {
+			fields := strings.Fields(value)
+			if len(fields) == 2 && strings.EqualFold(fields[0], "no") && strings.EqualFold(fields[1], "one") {
+				replicaOfHost, replicaOfPort = "", 0
+				return nil
+			}
+			if len(fields) != 2 {
+				return fmt.Errorf("argument must be <host> <port>")
+			}
+			port, err := strconv.Atoi(fields[1])
+			if err != nil || port < 0 || port > 65535 {
+				return fmt.Errorf("invalid master port")
+			}
+			replicaOfHost, replicaOfPort = fields[0], port
+			return nil
+		},
+	}
+}
+
+// infoReplication renders the replication section of INFO
+func infoReplication() []string {
+	var lines []string
+
+	if m := currentMaster.Load(); m != nil {
+		up, syncing, lastIO := m.status()
+		linkStatus, lastIOAgo := "down", int64(-1)
+		if up {
+			linkStatus = "up"
+			lastIOAgo = int64(time.Since(lastIO).Seconds())
+		}
+		replMu.Lock()
+		offset := replOffset
+		replMu.Unlock()
+
+		lines = append(lines,
+			"role:slave",
+			"master_host:"+m.host,
+			"master_port:"+strconv.Itoa(m.port),
+			"master_link_status:"+linkStatus,
+			"master_last_io_seconds_ago:"+strconv.FormatInt(lastIOAgo, 10),
+			"master_sync_in_progress:"+boolToInfo(syncing),
+			"slave_repl_offset:"+strconv.FormatInt(offset, 10),
+			"slave_read_only:"+boolToInfo(replicaReadOnly.Load()),
+		)
+	} else {
+		lines = append(lines, "role:master")
+	}
+
+	replMu.Lock()
+	defer replMu.Unlock()
+
+	lines = append(lines, "connected_slaves:"+strconv.Itoa(len(replicas)))
+	i := 0
+	for _, c := range sortedReplicas() {
+		f := replicas[c]
+		host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+		lines = append(lines, fmt.Sprintf("slave%d:ip=%s,port=%d,state=online,offset=%d,lag=%d",
+			i, host, c.replListeningPort, f.ackOffset.Load(), time.Now().Unix()-f.ackTime.Load()))
+		i++
+	}
+
+	firstByte := int64(0)
+	if replBacklog != nil {
+		firstByte = replOffset - replBacklogHistLen + 1
+	}
+	lines = append(lines,
+		"master_replid:"+replID,
+		"master_replid2:"+replID2,
+		"master_repl_offset:"+strconv.FormatInt(replOffset, 10),
+		"second_repl_offset:"+strconv.FormatInt(replSecondOffset, 10),
+		"repl_backlog_active:"+boolToInfo(replBacklog != nil),
+		"repl_backlog_size:"+strconv.FormatInt(replBacklogSize.Load(), 10),
+		"repl_backlog_first_byte_offset:"+strconv.FormatInt(firstByte, 10),
+		"repl_backlog_histlen:"+strconv.FormatInt(replBacklogHistLen, 10),
+	)
+	return lines
+}
+
+// sortedReplicas returns the replicas by client id. Caller holds replMu.
+func sortedReplicas() []*Client {
+	out := make([]*Client, 0, len(replicas))
+	for c := range replicas {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].id < out[j].id })
+	return out
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetReplication makes the test server a master with an empty stream
+func resetReplication(t *testing.T) {
+	t.Helper()
+	stopReplication()
+
+	reset := func() {
+		propagateMu.Lock()
+		defer propagateMu.Unlock()
+		replMu.Lock()
+		defer replMu.Unlock()
+
+		dropReplicasLocked()
+		replID, replID2, replSecondOffset = newRunID(), strings.Repeat("0", 40), -1
+		replOffset = 0
+		replBacklog, replBacklogIdx, replBacklogHistLen = nil, 0, 0
+		replLastDB = -1
+	}
+	reset()
+	t.Cleanup(func() {
+		stopReplication()
+		reset()
+	})
+}
+
+// readStream reads commands from a replication stream until want has
+// been seen, skipping SELECT and PING
+func readStream(t *testing.T, r *bufio.Reader, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		for {
+			args, err := parseResp(r)
+			if err != nil {
+				t.Fatalf("reading stream, waiting for %q: %v", w, err)
+			}
+			line := strings.Join(args, " ")
+			if strings.EqualFold(args[0], "SELECT") || strings.EqualFold(args[0], "PING") {
+				continue
+			}
+			if line != w {
+				t.Fatalf("stream sent %q, want %q", line, w)
+			}
+			break
+		}
+	}
+}
+
+func TestReplicationFullThenPartialResync(t *testing.T) {
+	resetReplication(t)
+	addr := startTestServer(t)
+	c := dialTestClient(t, addr)
+	c.do("SET", "before", "1")
+
+	// A fresh replica gets a snapshot and then the live writes
+	replica := dialTestClient(t, addr)
+	if got := replica.do("REPLCONF", "listening-port", "7777"); got != "+OK\r\n" {
+		t.Fatalf("REPLCONF: %q", got)
+	}
+	replica.send("PSYNC", "?", "-1")
+	header, _ := replica.reader.ReadString('\n')
+	fields := strings.Fields(header)
+	if len(fields) != 3 || fields[0] != "+FULLRESYNC" {
+		t.Fatalf("PSYNC reply: %q", header)
+	}
+	id := fields[1]
+	size, _ := replica.reader.ReadString('\n')
+	n, _ := strconv.Atoi(strings.TrimSpace(size[1:]))
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(replica.reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(payload, []byte(buildRESPCommand("SET", []string{"before", "1"}))) {
+		t.Fatalf("snapshot misses the existing key: %q", payload)
+	}
+
+	c.do("SET", "k", "v")
+	c.do("EXPIRE", "k", "100")
+	c.do("EXPIRE", "missing", "100")
+	c.do("GET", "k")
+	c.do("DEL", "before")
+	mu.RLock()
+	expireAt := strconv.FormatInt(databases[0]["k"].ExpireAt, 10)
+	mu.RUnlock()
+	readStream(t, replica.reader, "SET k v", "EXPIREAT k "+expireAt, "DEL before")
+
+	if list := c.do("CLIENT", "LIST"); !strings.Contains(list, "flags=S ") {
+		t.Fatalf("CLIENT LIST doesn't flag the replica: %q", list)
+	}
+	info := c.do("INFO", "replication")
+	if !strings.Contains(info, "connected_slaves:1") || !strings.Contains(info, ",port=7777,") {
+		t.Fatalf("INFO replication: %q", info)
+	}
+
+	// Writes made while the replica is away come from the backlog
+	replMu.Lock()
+	offset := replOffset
+	replMu.Unlock()
+	replica.conn.Close()
+	c.do("SET", "missed", "1")
+
+	again := dialTestClient(t, addr)
+	again.send("PSYNC", id, strconv.FormatInt(offset+1, 10))
+	if got, _ := again.reader.ReadString('\n'); got != "+CONTINUE "+id+"\r\n" {
+		t.Fatalf("partial resync: %q", got)
+	}
+	readStream(t, again.reader, "SET missed 1")
+
+	// An unknown history means a full resync
+	other := dialTestClient(t, addr)
+	other.send("PSYNC", strings.Repeat("a", 40), "1")
+	if got, _ := other.reader.ReadString('\n'); !strings.HasPrefix(got, "+FULLRESYNC "+id+" ") {
+		t.Fatalf("PSYNC with unknown id: %q", got)
+	}
+}
+
+// fakeMaster accepts one replica and answers its handshake
+type fakeMaster struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (m *fakeMaster) expect(prefix string, reply string) {
+	m.t.Helper()
+	args, err := parseResp(m.reader)
+	if err != nil {
+		m.t.Fatalf("waiting for %s: %v", prefix, err)
+	}
+	if got := strings.Join(args, " "); !strings.HasPrefix(got, prefix) {
+		m.t.Fatalf("replica sent %q, want %s", got, prefix)
+	}
+	if reply != "" {
+		m.conn.Write([]byte(reply))
+	}
+}
+
+func TestReplicaFollowsMaster(t *testing.T) {
+	saveConfig(t)
+	resetReplication(t)
+	addr := startTestServer(t)
+	c := dialTestClient(t, addr)
+	c.do("SET", "stale", "1")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	if got := c.do("REPLICAOF", host, port); got != "+OK\r\n" {
+		t.Fatalf("REPLICAOF: %q", got)
+	}
+	if got := c.do("REPLICAOF", host, port); got != "+OK Already connected to specified master\r\n" {
+		t.Fatalf("REPLICAOF again: %q", got)
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	m := &fakeMaster{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	id := strings.Repeat("b", 40)
+	snapshot := buildRESPCommand("SELECT", []string{"2"}) +
+		buildRESPCommand("SET", []string{"a", "1"}) +
+		buildRESPCommand("SELECT", []string{"0"})
+	m.expect("PING", "+PONG\r\n")
+	m.expect("REPLCONF listening-port", "+OK\r\n")
+	m.expect("PSYNC", "+FULLRESYNC "+id+" 100\r\n$"+strconv.Itoa(len(snapshot))+"\r\n"+snapshot)
+
+	stream := buildRESPCommand("SET", []string{"b", "2"}) + buildRESPCommand("REPLCONF", []string{"GETACK", "*"})
+	conn.Write([]byte(stream))
+	want := 100 + len(buildRESPCommand("SET", []string{"b", "2"}))
+	for {
+		args, err := parseResp(m.reader)
+		if err != nil {
+			t.Fatalf("waiting for ACK: %v", err)
+		}
+		if len(args) >= 3 && strings.Join(args[:3], " ") == "REPLCONF ACK "+strconv.Itoa(want) {
+			break
+		}
+	}
+
+	// The snapshot replaced the old data, the stream was applied
+	if got := c.do("GET", "stale"); got != "$-1\r\n" {
+		t.Errorf("GET stale: %q", got)
+	}
+	if got := c.do("GET", "b"); got != "$1\r\n2\r\n" {
+		t.Errorf("GET b: %q", got)
+	}
+	c.do("SELECT", "2")
+	if got := c.do("GET", "a"); got != "$1\r\n1\r\n" {
+		t.Errorf("GET a from the snapshot: %q", got)
+	}
+
+	if got := c.do("SET", "x", "1"); got != "-READONLY You can't write against a read only replica.\r\n" {
+		t.Errorf("write on replica: %q", got)
+	}
+	// The link reads its settings while they are changed
+	if got := c.do("CONFIG", "SET", "replica-read-only", "no", "repl-timeout", "30", "masterauth", "secret"); got != "+OK\r\n" {
+		t.Fatalf("CONFIG SET on a replica: %q", got)
+	}
+	if got := c.do("SET", "x", "1"); got != "+OK\r\n" {
+		t.Errorf("write on a writable replica: %q", got)
+	}
+	c.do("CONFIG", "SET", "replica-read-only", "yes")
+	info := c.do("INFO", "replication")
+	for _, field := range []string{"role:slave", "master_link_status:up", "master_replid:" + id} {
+		if !strings.Contains(info, field) {
+			t.Errorf("INFO replication misses %s: %q", field, info)
+		}
+	}
+
+	// Promotion keeps the old history as replid2
+	if got := c.do("REPLICAOF", "NO", "ONE"); got != "+OK\r\n" {
+		t.Fatalf("REPLICAOF NO ONE: %q", got)
+	}
+	info = c.do("INFO", "replication")
+	if !strings.Contains(info, "role:master") || !strings.Contains(info, "master_replid2:"+id) {
+		t.Errorf("INFO after promotion: %q", info)
+	}
+	if got := c.do("SET", "x", "1"); got != "+OK\r\n" {
+		t.Errorf("write after promotion: %q", got)
+	}
+}
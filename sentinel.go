@@ -0,0 +1,1296 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sentinelMode is set by --sentinel: instead of serving data the process
+// watches masters, and fails them over to a replica once enough
+// sentinels agree they are down.
+var sentinelMode = false
+
+// Sentinel timings, as in Redis
+const (
+	sentinelTimerPeriod    = 100 * time.Millisecond
+	sentinelPingPeriod     = time.Second
+	sentinelInfoPeriod     = 10 * time.Second
+	sentinelHelloPeriod    = 2 * time.Second
+	sentinelAskPeriod      = time.Second
+	sentinelDownReportTTL  = 5 * time.Second // how long a peer's "down" answer counts
+	sentinelReconfGrace    = 8 * time.Second // wait before fixing a replica's master
+	sentinelMaxDesync      = time.Second     // random delay so sentinels don't all run for leader at once
+	sentinelDefaultPort    = 26379
+	sentinelDefaultDown    = 30 * time.Second
+	sentinelDefaultTimeout = 3 * time.Minute
+)
+
+// Kinds of sentinelInstance
+const (
+	instanceMaster = iota
+	instanceReplica
+	instanceSentinel
+)
+
+// Failover states of a sentinelMaster
+const (
+	failoverNone = iota
+	failoverWaitStart
+	failoverSelectReplica
+	failoverWaitPromotion
+	failoverReconfReplicas
+)
+
+var failoverStateNames = []string{"none", "wait_start", "select_slave", "wait_promotion", "reconf_slaves"}
+
+// sentinelMu guards every sentinel structure below, except the links,
+// which belong to the goroutine of their instance
+var (
+	sentinelMu           sync.Mutex
+	sentinelMasters      = map[string]*sentinelMaster{}
+	sentinelCurrentEpoch int64
+)
+
+// sentinelMaster is a monitored master with its replicas and the other
+// sentinels watching it
+type sentinelMaster struct {
+	name      string
+	inst      *sentinelInstance
+	replicas  map[string]*sentinelInstance // by host:port
+	sentinels map[string]*sentinelInstance // by run id
+
+	quorum          int
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+	authPass        string
+	configEpoch     int64
+
+	sdown, odown  bool
+	odownSince    time.Time
+	failoverDelay time.Duration
+
+	// Our vote in the leader election for this master
+	leader      string
+	leaderEpoch int64
+
+	failoverState       int
+	failoverEpoch       int64
+	failoverStart       time.Time
+	failoverStateChange time.Time
+	promoted            *sentinelInstance
+
+	stop chan struct{} // ends the timer
+}
+
+// sentinelInstance is a master, replica or sentinel we talk to. Its
+// fields are guarded by sentinelMu; link is only used by its goroutine.
+type sentinelInstance struct {
+	kind   int
+	master *sentinelMaster
+	host   string
+	port   int
+	runID  string
+
+	created  time.Time
+	pingSent time.Time // oldest PING still without a valid reply
+	lastPong time.Time // last valid PING reply
+	lastInfo time.Time
+
+	// From INFO, for masters and replicas
+	role           string
+	roleSince      time.Time
+	masterHost     string
+	masterPort     int
+	masterLinkUp   bool
+	replOffset     int64
+	lastReconf     time.Time
+	pendingCommand []string // REPLICAOF to send, set by the failover
+
+	// From IS-MASTER-DOWN-BY-ADDR answers, for sentinels
+	lastHello   time.Time
+	downReport  time.Time
+	leader      string
+	leaderEpoch int64
+
+	link *instanceLink
+	stop chan struct{}
+}
+
+func (si *sentinelInstance) addr() string {
+	return net.JoinHostPort(si.host, strconv.Itoa(si.port))
+}
+
+// down reports whether a PING has gone unanswered for longer than the
+// master's down-after-milliseconds. Caller holds sentinelMu.
+func (si *sentinelInstance) down(now time.Time) bool {
+	return !si.pingSent.IsZero() && now.Sub(si.pingSent) > si.master.downAfter
+}
+
+func newSentinelInstance(m *sentinelMaster, kind int, host string, port int) *sentinelInstance {
+	now := time.Now()
+	return &sentinelInstance{
+		kind:    kind,
+		master:  m,
+		host:    host,
+		port:    port,
+		created: now,
+		// Until the first reply the instance counts as pinged at creation
+		pingSent: now,
+		stop:     make(chan struct{}),
+	}
+}
+
+// sentinelDirective handles the "sentinel" config directive. Without
+// arguments (--sentinel) it turns sentinel mode on.
+func sentinelDirective(args []string) error {
+	if len(args) == 0 {
+		sentinelMode = true
+		if serverPort == 6379 {
+			serverPort = sentinelDefaultPort
+		}
+		return nil
+	}
+
+	sentinelMu.Lock()
+	defer sentinelMu.Unlock()
+
+	option := strings.ToLower(args[0])
+	if option == "monitor" {
+		if len(args) != 5 {
+			return fmt.Errorf("usage: sentinel monitor <name> <host> <port> <quorum>")
+		}
+		port, err := strconv.Atoi(args[3])
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port '%s'", args[3])
+		}
+		quorum, err := strconv.Atoi(args[4])
+		if err != nil || quorum <= 0 {
+			return fmt.Errorf("quorum must be 1 or greater")
+		}
+		if _, exists := sentinelMasters[args[1]]; exists {
+			return fmt.Errorf("duplicated master name '%s'", args[1])
+		}
+		m := &sentinelMaster{
+			name:            args[1],
+			replicas:        map[string]*sentinelInstance{},
+			sentinels:       map[string]*sentinelInstance{},
+			quorum:          quorum,
+			downAfter:       sentinelDefaultDown,
+			failoverTimeout: sentinelDefaultTimeout,
+			stop:            make(chan struct{}),
+		}
+		m.inst = newSentinelInstance(m, instanceMaster, args[2], port)
+		sentinelMasters[m.name] = m
+		return nil
+	}
+
+	if len(args) < 3 {
+		return fmt.Errorf("wrong number of arguments for 'sentinel %s'", option)
+	}
+	m, ok := sentinelMasters[args[1]]
+	if !ok {
+		return fmt.Errorf("no such master with specified name '%s'", args[1])
+	}
+
+	switch option {
+	case "down-after-milliseconds", "failover-timeout":
+		ms, err := strconv.Atoi(args[2])
+		if err != nil || ms <= 0 {
+			return fmt.Errorf("%s must be a positive number of milliseconds", option)
+		}
+		if option == "down-after-milliseconds" {
+			m.downAfter = time.Duration(ms) * time.Millisecond
+		} else {
+			m.failoverTimeout = time.Duration(ms) * time.Millisecond
+		}
+	case "auth-pass":
+		m.authPass = args[2]
+	case "config-epoch":
+		epoch, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil || epoch < 0 {
+			return fmt.Errorf("invalid config epoch '%s'", args[2])
+		}
+		m.configEpoch = epoch
+		sentinelCurrentEpoch = max(sentinelCurrentEpoch, epoch)
+	case "known-replica", "known-slave":
+		if len(args) != 4 {
+			return fmt.Errorf("usage: sentinel %s <name> <host> <port>", option)
+		}
+		port, err := strconv.Atoi(args[3])
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port '%s'", args[3])
+		}
+		r := newSentinelInstance(m, instanceReplica, args[2], port)
+		m.replicas[r.addr()] = r
+	case "known-sentinel":
+		if len(args) != 5 {
+			return fmt.Errorf("usage: sentinel known-sentinel <name> <host> <port> <runid>")
+		}
+		port, err := strconv.Atoi(args[3])
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port '%s'", args[3])
+		}
+		s := newSentinelInstance(m, instanceSentinel, args[2], port)
+		s.runID = args[4]
+		m.sentinels[s.runID] = s
+	default:
+		return fmt.Errorf("unknown sentinel option '%s'", args[0])
+	}
+	return nil
+}
+
+// sentinelCommands are the commands a sentinel serves
+var sentinelCommands = []string{"PING", "SENTINEL", "INFO", "AUTH", "HELLO", "QUIT", "CLIENT", "COMMAND", "SHUTDOWN", "ACL"}
+
+// startSentinel switches the command table and INFO to sentinel mode and
+// starts monitoring the configured masters
+func startSentinel() {
+	table := map[string]*Command{}
+	for _, name := range sentinelCommands {
+		if def, ok := commandTable[name]; ok {
+			table[name] = def
+		}
+	}
+	table["SENTINEL"] = &Command{Func: cmdSENTINEL, Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}, Categories: []string{"admin", "slow", "dangerous"}}
+	commandTable = table
+
+	infoSections = []infoSection{
+		{"server", "Server", infoServer},
+		{"clients", "Clients", infoClients},
+		{"sentinel", "Sentinel", infoSentinel},
+	}
+
+	sentinelMu.Lock()
+	defer sentinelMu.Unlock()
+
+	for _, m := range sentinelMasters {
+		fmt.Printf("+monitor master %s %s %d quorum %d\n", m.name, m.inst.host, m.inst.port, m.quorum)
+		startMasterMonitoring(m)
+	}
+}
+
+// startMasterMonitoring starts the goroutines of m and its instances.
+// Caller holds sentinelMu.
+func startMasterMonitoring(m *sentinelMaster) {
+	go monitorInstance(m.inst)
+	for _, r := range m.replicas {
+		go monitorInstance(r)
+	}
+	for _, s := range m.sentinels {
+		go monitorInstance(s)
+	}
+	go sentinelTimer(m)
+}
+
+// instanceLink is a plain RESP connection to an instance, reopened on the
+// next call after an error
+type instanceLink struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// call sends a command and returns its reply: a string for status and
+// bulk replies, int64 for integers, []any for arrays, nil for null and
+// error for error replies. Network problems close the link and come back
+// as err.
+func (si *sentinelInstance) call(timeout time.Duration, args ...string) (reply any, err error) {
+	if si.link == nil {
+		conn, err := net.DialTimeout("tcp", si.addr(), timeout)
+		if err != nil {
+			return nil, err
+		}
+		si.link = &instanceLink{conn: conn, reader: bufio.NewReader(conn)}
+
+		sentinelMu.Lock()
+		pass := si.master.authPass
+		sentinelMu.Unlock()
+		if pass != "" && si.kind != instanceSentinel {
+			if _, err := si.call(timeout, "AUTH", pass); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	defer func() {
+		if err != nil {
+			si.link.conn.Close()
+			si.link = nil
+		}
+	}()
+
+	si.link.conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := io.WriteString(si.link.conn, buildRESPCommand(args[0], args[1:])); err != nil {
+		return nil, err
+	}
+	return readReplyValue(si.link.reader)
+}
+
+// readReplyValue parses one RESP2 reply
+func readReplyValue(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply line")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return fmt.Errorf("%s", line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReplyValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+// monitorInstance talks to one instance until it is dropped: PING and
+// INFO for masters and replicas, hellos and down-state questions for
+// other sentinels
+func monitorInstance(si *sentinelInstance) {
+	ticker := time.NewTicker(sentinelTimerPeriod)
+	defer ticker.Stop()
+	defer func() {
+		if si.link != nil {
+			si.link.conn.Close()
+		}
+	}()
+
+	var lastPing, lastInfo, lastHello, lastAsk time.Time
+	var askedEpoch int64
+	for {
+		select {
+		case <-si.stop:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+
+		sentinelMu.Lock()
+		m := si.master
+		pingPeriod := min(sentinelPingPeriod, m.downAfter)
+		infoPeriod := sentinelInfoPeriod
+		if m.sdown || m.failoverState != failoverNone {
+			infoPeriod = time.Second
+		}
+		command := si.pendingCommand
+		si.pendingCommand = nil
+		askDown := si.kind == instanceSentinel && m.sdown
+		var voteEpoch int64
+		if m.failoverState == failoverWaitStart {
+			voteEpoch = m.failoverEpoch
+		}
+		sentinelMu.Unlock()
+
+		if command != nil {
+			sentinelSendCommand(si, command)
+		}
+		if now.Sub(lastPing) >= pingPeriod {
+			lastPing = now
+			sentinelPing(si)
+		}
+
+		switch si.kind {
+		case instanceMaster, instanceReplica:
+			if now.Sub(lastInfo) >= infoPeriod {
+				lastInfo = now
+				sentinelRefreshInfo(si)
+			}
+		case instanceSentinel:
+			if now.Sub(lastHello) >= sentinelHelloPeriod {
+				lastHello = now
+				sentinelSendHello(si)
+			}
+			// A vote request goes out as soon as we run for leader
+			if askDown && (now.Sub(lastAsk) >= sentinelAskPeriod || voteEpoch != askedEpoch) {
+				lastAsk, askedEpoch = now, voteEpoch
+				sentinelAskMasterState(si)
+			}
+		}
+	}
+}
+
+func sentinelPing(si *sentinelInstance) {
+	sentinelMu.Lock()
+	if si.pingSent.IsZero() {
+		si.pingSent = time.Now()
+	}
+	sentinelMu.Unlock()
+
+	reply, err := si.call(time.Second, "PING")
+	if err != nil {
+		return
+	}
+
+	// A loading or masterless replica is still alive
+	valid := reply == "PONG"
+	if e, ok := reply.(error); ok {
+		msg := e.Error()
+		valid = strings.HasPrefix(msg, "LOADING") || strings.HasPrefix(msg, "MASTERDOWN")
+	}
+	if valid {
+		sentinelMu.Lock()
+		si.lastPong = time.Now()
+		si.pingSent = time.Time{}
+		sentinelMu.Unlock()
+	}
+}
+
+func sentinelSendCommand(si *sentinelInstance, command []string) {
+	reply, err := si.call(time.Second, command...)
+	if err == nil {
+		if e, ok := reply.(error); ok {
+			err = e
+		}
+	}
+	if err != nil {
+		fmt.Printf("-failover-command %s %s: %v\n", si.addr(), strings.Join(command, " "), err)
+		return
+	}
+	fmt.Printf("+sent %s %s\n", si.addr(), strings.Join(command, " "))
+}
+
+// sentinelRefreshInfo reads role and replication state from INFO. The
+// master's INFO is also how its replicas are found.
+func sentinelRefreshInfo(si *sentinelInstance) {
+	reply, err := si.call(time.Second, "INFO", "server", "replication")
+	text, ok := reply.(string)
+	if err != nil || !ok {
+		return
+	}
+
+	fields := map[string]string{}
+	var replicaAddrs []string
+	for _, line := range strings.Split(text, "\r\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		fields[key] = value
+
+		// slave0:ip=127.0.0.1,port=6380,state=online,offset=42,lag=0
+		if strings.HasPrefix(key, "slave") && strings.Contains(value, "ip=") {
+			var ip, port string
+			for _, kv := range strings.Split(value, ",") {
+				k, v, _ := strings.Cut(kv, "=")
+				switch k {
+				case "ip":
+					ip = v
+				case "port":
+					port = v
+				}
+			}
+			if ip != "" && port != "" && port != "0" {
+				replicaAddrs = append(replicaAddrs, net.JoinHostPort(ip, port))
+			}
+		}
+	}
+
+	sentinelMu.Lock()
+	defer sentinelMu.Unlock()
+
+	m := si.master
+	now := time.Now()
+	si.lastInfo = now
+	si.runID = fields["run_id"]
+	if role := fields["role"]; role != si.role {
+		if si.role != "" {
+			fmt.Printf("+role-change %s %s new reported role is %s\n", kindName(si.kind), si.addr(), role)
+		}
+		si.role = role
+		si.roleSince = now
+	}
+	si.masterHost = fields["master_host"]
+	si.masterPort, _ = strconv.Atoi(fields["master_port"])
+	si.masterLinkUp = fields["master_link_status"] == "up"
+	si.replOffset, _ = strconv.ParseInt(fields["slave_repl_offset"], 10, 64)
+
+	if si.kind == instanceMaster && si == m.inst {
+		for _, addr := range replicaAddrs {
+			if _, known := m.replicas[addr]; known {
+				continue
+			}
+			host, portStr, _ := net.SplitHostPort(addr)
+			port, _ := strconv.Atoi(portStr)
+			r := newSentinelInstance(m, instanceReplica, host, port)
+			m.replicas[addr] = r
+			fmt.Printf("+slave slave %s @ %s %s %d\n", addr, m.name, m.inst.host, m.inst.port)
+			go monitorInstance(r)
+		}
+		return
+	}
+
+	// A replica following someone else, or one that came back as a
+	// master after being failed over, is pointed at the current master.
+	// Not while the master is down or a failover is running: that is
+	// the failover's job.
+	if si.kind != instanceReplica || m.sdown || m.failoverState != failoverNone || si == m.promoted {
+		return
+	}
+	wrongMaster := si.role == "slave" && (si.masterHost != m.inst.host || si.masterPort != m.inst.port)
+	if (si.role == "master" || wrongMaster) && now.Sub(si.roleSince) > sentinelReconfGrace && now.Sub(si.lastReconf) > sentinelReconfGrace {
+		si.lastReconf = now
+		si.pendingCommand = []string{"REPLICAOF", m.inst.host, strconv.Itoa(m.inst.port)}
+		fmt.Printf("+convert-to-slave slave %s @ %s %s %d\n", si.addr(), m.name, m.inst.host, m.inst.port)
+	}
+}
+
+func kindName(kind int) string {
+	switch kind {
+	case instanceMaster:
+		return "master"
+	case instanceReplica:
+		return "slave"
+	default:
+		return "sentinel"
+	}
+}
+
+// sentinelSendHello tells a peer who we are and which address and config
+// epoch we have for the master. Redis publishes hellos on a pub/sub
+// channel of the master; without pub/sub, sentinels send them directly,
+// and the reply lists the peer's own peers so everybody meets everybody.
+func sentinelSendHello(si *sentinelInstance) {
+	// The address the peer can reach us on is the one our link uses
+	if si.link == nil {
+		if _, err := si.call(time.Second, "PING"); err != nil {
+			return
+		}
+	}
+	ip, _, _ := net.SplitHostPort(si.link.conn.LocalAddr().String())
+
+	sentinelMu.Lock()
+	m := si.master
+	args := []string{"SENTINEL", "HELLO", ip, strconv.Itoa(serverPort), serverRunID,
+		strconv.FormatInt(sentinelCurrentEpoch, 10), m.name, m.inst.host,
+		strconv.Itoa(m.inst.port), strconv.FormatInt(m.configEpoch, 10)}
+	sentinelMu.Unlock()
+
+	reply, err := si.call(time.Second, args...)
+	peers, ok := reply.([]any)
+	if err != nil || !ok {
+		return
+	}
+
+	sentinelMu.Lock()
+	defer sentinelMu.Unlock()
+	for _, p := range peers {
+		line, _ := p.(string)
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		if port, err := strconv.Atoi(fields[1]); err == nil {
+			sentinelAddPeer(m, fields[0], port, fields[2])
+		}
+	}
+}
+
+// sentinelAskMasterState asks a peer whether it sees the master down
+// too, and for its vote while we are trying to lead a failover
+func sentinelAskMasterState(si *sentinelInstance) {
+	sentinelMu.Lock()
+	m := si.master
+	runID := "*"
+	if m.failoverState == failoverWaitStart {
+		runID = serverRunID
+	}
+	args := []string{"SENTINEL", "IS-MASTER-DOWN-BY-ADDR", m.inst.host, strconv.Itoa(m.inst.port),
+		strconv.FormatInt(sentinelCurrentEpoch, 10), runID}
+	sentinelMu.Unlock()
+
+	reply, err := si.call(time.Second, args...)
+	items, ok := reply.([]any)
+	if err != nil || !ok || len(items) != 3 {
+		return
+	}
+	down, _ := items[0].(int64)
+	leader, _ := items[1].(string)
+	leaderEpoch, _ := items[2].(int64)
+
+	sentinelMu.Lock()
+	defer sentinelMu.Unlock()
+
+	if down == 1 {
+		si.downReport = time.Now()
+	} else {
+		si.downReport = time.Time{}
+	}
+	if leader != "*" {
+		si.leader, si.leaderEpoch = leader, leaderEpoch
+	}
+}
+
+// sentinelTimer runs the state machine of a master every 100ms
+func sentinelTimer(m *sentinelMaster) {
+	ticker := time.NewTicker(sentinelTimerPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+		sentinelMu.Lock()
+		sentinelCheckMaster(m, time.Now())
+		sentinelMu.Unlock()
+	}
+}
+
+// sentinelCheckMaster updates the down states of m and advances its
+// failover. Caller holds sentinelMu.
+func sentinelCheckMaster(m *sentinelMaster, now time.Time) {
+	if sdown := m.inst.down(now); sdown != m.sdown {
+		m.sdown = sdown
+		if sdown {
+			fmt.Printf("+sdown master %s %s %d\n", m.name, m.inst.host, m.inst.port)
+		} else {
+			fmt.Printf("-sdown master %s %s %d\n", m.name, m.inst.host, m.inst.port)
+		}
+	}
+
+	odown := false
+	if m.sdown {
+		votes := 1
+		for _, s := range m.sentinels {
+			if !s.downReport.IsZero() && now.Sub(s.downReport) < sentinelDownReportTTL {
+				votes++
+			}
+		}
+		odown = votes >= m.quorum
+	}
+	if odown != m.odown {
+		m.odown = odown
+		if odown {
+			m.odownSince = now
+			m.failoverDelay = rand.N(sentinelMaxDesync)
+			fmt.Printf("+odown master %s %s %d #quorum %d\n", m.name, m.inst.host, m.inst.port, m.quorum)
+		} else {
+			fmt.Printf("-odown master %s %s %d\n", m.name, m.inst.host, m.inst.port)
+		}
+	}
+
+	if m.odown && m.failoverState == failoverNone && now.Sub(m.failoverStart) > 2*m.failoverTimeout && now.Sub(m.odownSince) >= m.failoverDelay {
+		sentinelStartFailover(m, now)
+	}
+	sentinelFailoverStep(m, now)
+}
+
+// sentinelStartFailover begins a failover attempt in a new epoch and
+// votes for ourselves. Caller holds sentinelMu.
+func sentinelStartFailover(m *sentinelMaster, now time.Time) {
+	sentinelCurrentEpoch++
+	m.failoverEpoch = sentinelCurrentEpoch
+	m.failoverState = failoverWaitStart
+	m.failoverStart = now.Add(rand.N(sentinelMaxDesync)) // retries don't line up either
+	m.failoverStateChange = now
+	m.leader, m.leaderEpoch = serverRunID, sentinelCurrentEpoch
+	fmt.Printf("+new-epoch %d\n+try-failover master %s %s %d\n", sentinelCurrentEpoch, m.name, m.inst.host, m.inst.port)
+}
+
+// sentinelLeader returns the sentinel that won the election for epoch:
+// it needs a majority of all known sentinels and at least the quorum.
+// Caller holds sentinelMu.
+func sentinelLeader(m *sentinelMaster, epoch int64) string {
+	votes := map[string]int{}
+	if m.leaderEpoch == epoch && m.leader != "" {
+		votes[m.leader]++
+	}
+	for _, s := range m.sentinels {
+		if s.leader != "" && s.leaderEpoch == epoch {
+			votes[s.leader]++
+		}
+	}
+
+	winner, best := "", 0
+	for runID, n := range votes {
+		if n > best || n == best && runID < winner {
+			winner, best = runID, n
+		}
+	}
+	voters := len(m.sentinels) + 1
+	if best < voters/2+1 || best < m.quorum {
+		return ""
+	}
+	return winner
+}
+
+// sentinelVote answers a vote request for epoch: the first candidate to
+// ask in an epoch gets our vote. Returns the leader we voted for in our
+// latest epoch. Caller holds sentinelMu.
+func sentinelVote(m *sentinelMaster, epoch int64, runID string) (string, int64) {
+	if epoch > sentinelCurrentEpoch {
+		sentinelCurrentEpoch = epoch
+		fmt.Printf("+new-epoch %d\n", epoch)
+	}
+	if m.leaderEpoch < epoch && sentinelCurrentEpoch <= epoch {
+		m.leader, m.leaderEpoch = runID, sentinelCurrentEpoch
+		fmt.Printf("+vote-for-leader %s %d\n", runID, m.leaderEpoch)
+		// Don't start a failover of our own right after voting for another
+		if runID != serverRunID {
+			m.failoverStart = time.Now()
+		}
+	}
+	return m.leader, m.leaderEpoch
+}
+
+// sentinelFailoverStep advances a running failover. Caller holds
+// sentinelMu.
+func sentinelFailoverStep(m *sentinelMaster, now time.Time) {
+	switch m.failoverState {
+	case failoverWaitStart:
+		if leader := sentinelLeader(m, m.failoverEpoch); leader != serverRunID {
+			if now.Sub(m.failoverStart) > m.failoverTimeout {
+				fmt.Printf("-failover-abort-not-elected master %s %s %d\n", m.name, m.inst.host, m.inst.port)
+				sentinelAbortFailover(m)
+			}
+			return
+		}
+		fmt.Printf("+elected-leader master %s %s %d\n", m.name, m.inst.host, m.inst.port)
+		sentinelSetFailoverState(m, failoverSelectReplica, now)
+
+	case failoverSelectReplica:
+		r := sentinelSelectReplica(m, now)
+		if r == nil {
+			fmt.Printf("-failover-abort-no-good-slave master %s %s %d\n", m.name, m.inst.host, m.inst.port)
+			sentinelAbortFailover(m)
+			return
+		}
+		fmt.Printf("+selected-slave slave %s @ %s %s %d\n", r.addr(), m.name, m.inst.host, m.inst.port)
+		m.promoted = r
+		r.pendingCommand = []string{"REPLICAOF", "NO", "ONE"}
+		sentinelSetFailoverState(m, failoverWaitPromotion, now)
+
+	case failoverWaitPromotion:
+		r := m.promoted
+		if r.role == "master" && r.roleSince.After(m.failoverStateChange) {
+			fmt.Printf("+promoted-slave slave %s @ %s %s %d\n", r.addr(), m.name, m.inst.host, m.inst.port)
+			m.configEpoch = m.failoverEpoch
+			for _, other := range m.replicas {
+				if other != r {
+					other.pendingCommand = []string{"REPLICAOF", r.host, strconv.Itoa(r.port)}
+				}
+			}
+			sentinelSetFailoverState(m, failoverReconfReplicas, now)
+			return
+		}
+		if now.Sub(m.failoverStateChange) > m.failoverTimeout {
+			fmt.Printf("-failover-abort-slave-timeout master %s %s %d\n", m.name, m.inst.host, m.inst.port)
+			sentinelAbortFailover(m)
+		}
+
+	case failoverReconfReplicas:
+		// Replicas that are down get fixed when they come back
+		for _, other := range m.replicas {
+			if other != m.promoted && other.pendingCommand != nil && !other.down(now) {
+				if now.Sub(m.failoverStateChange) < m.failoverTimeout {
+					return
+				}
+			}
+		}
+		r := m.promoted
+		fmt.Printf("+failover-end master %s %s %d\n", m.name, m.inst.host, m.inst.port)
+		sentinelSwitchMaster(m, r.host, r.port)
+	}
+}
+
+func sentinelSetFailoverState(m *sentinelMaster, state int, now time.Time) {
+	m.failoverState = state
+	m.failoverStateChange = now
+}
+
+// sentinelAbortFailover gives up; the next attempt waits for twice the
+// failover timeout since this one started. Caller holds sentinelMu.
+func sentinelAbortFailover(m *sentinelMaster) {
+	m.failoverState = failoverNone
+	m.promoted = nil
+}
+
+// sentinelSelectReplica picks the replica to promote: one that answers
+// and reported INFO recently, preferring the most data and then the
+// smallest run id. Caller holds sentinelMu.
+func sentinelSelectReplica(m *sentinelMaster, now time.Time) *sentinelInstance {
+	var candidates []*sentinelInstance
+	for _, r := range m.replicas {
+		if r.down(now) || r.lastInfo.IsZero() || now.Sub(r.lastInfo) > 3*time.Second {
+			continue
+		}
+		candidates = append(candidates, r)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.replOffset != b.replOffset {
+			return a.replOffset > b.replOffset
+		}
+		return a.runID < b.runID
+	})
+	return candidates[0]
+}
+
+// sentinelSwitchMaster points m at a new address: the old master and
+// every other replica become its replicas. Caller holds sentinelMu.
+func sentinelSwitchMaster(m *sentinelMaster, host string, port int) {
+	old := m.inst
+	fmt.Printf("+switch-master %s %s %d %s %d\n", m.name, old.host, old.port, host, port)
+
+	newAddr := net.JoinHostPort(host, strconv.Itoa(port))
+	addrs := []string{}
+	for addr := range m.replicas {
+		if addr != newAddr {
+			addrs = append(addrs, addr)
+		}
+	}
+	if old.addr() != newAddr {
+		addrs = append(addrs, old.addr())
+	}
+
+	close(old.stop)
+	for _, r := range m.replicas {
+		close(r.stop)
+	}
+
+	m.inst = newSentinelInstance(m, instanceMaster, host, port)
+	m.replicas = map[string]*sentinelInstance{}
+	for _, addr := range addrs {
+		h, p, _ := net.SplitHostPort(addr)
+		pn, _ := strconv.Atoi(p)
+		r := newSentinelInstance(m, instanceReplica, h, pn)
+		m.replicas[addr] = r
+		go monitorInstance(r)
+	}
+	go monitorInstance(m.inst)
+
+	m.sdown, m.odown = false, false
+	m.failoverState = failoverNone
+	m.promoted = nil
+}
+
+// sentinelHello handles a hello from a peer: it is added if unknown, and
+// a master address with a newer config epoch replaces ours. Caller holds
+// sentinelMu.
+func sentinelHello(host string, port int, runID string, currentEpoch int64, name, masterHost string, masterPort int, configEpoch int64) error {
+	m, ok := sentinelMasters[name]
+	if !ok {
+		return fmt.Errorf("No such master with that name")
+	}
+	if runID == serverRunID {
+		return nil
+	}
+
+	s := sentinelAddPeer(m, host, port, runID)
+	s.lastHello = time.Now()
+
+	if currentEpoch > sentinelCurrentEpoch {
+		sentinelCurrentEpoch = currentEpoch
+		fmt.Printf("+new-epoch %d\n", currentEpoch)
+	}
+	if configEpoch > m.configEpoch {
+		m.configEpoch = configEpoch
+		if masterHost != m.inst.host || masterPort != m.inst.port {
+			fmt.Printf("+config-update-from sentinel %s %s %d @ %s %s %d\n", runID, host, port, m.name, m.inst.host, m.inst.port)
+			if sentinelMode {
+				sentinelSwitchMaster(m, masterHost, masterPort)
+			} else {
+				m.inst.host, m.inst.port = masterHost, masterPort
+			}
+		}
+	}
+	return nil
+}
+
+// sentinelAddPeer returns the sentinel with runID, adding it if unknown.
+// Caller holds sentinelMu.
+func sentinelAddPeer(m *sentinelMaster, host string, port int, runID string) *sentinelInstance {
+	if s, known := m.sentinels[runID]; known || runID == serverRunID {
+		return s
+	}
+
+	// A restarted peer comes back with a new run id on the same address
+	for id, other := range m.sentinels {
+		if other.host == host && other.port == port {
+			close(other.stop)
+			delete(m.sentinels, id)
+		}
+	}
+	s := newSentinelInstance(m, instanceSentinel, host, port)
+	s.runID = runID
+	m.sentinels[runID] = s
+	fmt.Printf("+sentinel sentinel %s %s %d @ %s %s %d\n", runID, host, port, m.name, m.inst.host, m.inst.port)
+	if sentinelMode {
+		go monitorInstance(s)
+	}
+	return s
+}
+
+// sentinelMasterByAddr returns the master monitored at host:port. Caller
+// holds sentinelMu.
+func sentinelMasterByAddr(host string, port int) *sentinelMaster {
+	for _, m := range sentinelMasters {
+		if m.inst.host == host && m.inst.port == port {
+			return m
+		}
+	}
+	return nil
+}
+
+func masterFlags(m *sentinelMaster) string {
+	flags := []string{"master"}
+	if m.sdown {
+		flags = append(flags, "s_down")
+	}
+	if m.odown {
+		flags = append(flags, "o_down")
+	}
+	if m.failoverState != failoverNone {
+		flags = append(flags, "failover_in_progress")
+	}
+	return strings.Join(flags, ",")
+}
+
+func instanceFlags(si *sentinelInstance, now time.Time) string {
+	flags := []string{kindName(si.kind)}
+	if si.down(now) {
+		flags = append(flags, "s_down")
+	}
+	if si == si.master.promoted {
+		flags = append(flags, "promoted")
+	}
+	return strings.Join(flags, ",")
+}
+
+func millisSince(t, now time.Time) string {
+	if t.IsZero() {
+		return "-1"
+	}
+	return strconv.FormatInt(now.Sub(t).Milliseconds(), 10)
+}
+
+// masterState renders the SENTINEL MASTER fields of m. Caller holds
+// sentinelMu.
+func masterState(m *sentinelMaster, now time.Time) []string {
+	return []string{
+		"name", m.name,
+		"ip", m.inst.host,
+		"port", strconv.Itoa(m.inst.port),
+		"runid", m.inst.runID,
+		"flags", masterFlags(m),
+		"last-ok-ping-reply", millisSince(m.inst.lastPong, now),
+		"info-refresh", millisSince(m.inst.lastInfo, now),
+		"role-reported", m.inst.role,
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+		"quorum", strconv.Itoa(m.quorum),
+		"down-after-milliseconds", strconv.FormatInt(m.downAfter.Milliseconds(), 10),
+		"failover-timeout", strconv.FormatInt(m.failoverTimeout.Milliseconds(), 10),
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"failover-state", failoverStateNames[m.failoverState],
+	}
+}
+
+func replicaState(r *sentinelInstance, now time.Time) []string {
+	linkStatus := "err"
+	if r.masterLinkUp {
+		linkStatus = "ok"
+	}
+	return []string{
+		"name", r.addr(),
+		"ip", r.host,
+		"port", strconv.Itoa(r.port),
+		"runid", r.runID,
+		"flags", instanceFlags(r, now),
+		"last-ok-ping-reply", millisSince(r.lastPong, now),
+		"info-refresh", millisSince(r.lastInfo, now),
+		"role-reported", r.role,
+		"master-link-status", linkStatus,
+		"master-host", r.masterHost,
+		"master-port", strconv.Itoa(r.masterPort),
+		"slave-repl-offset", strconv.FormatInt(r.replOffset, 10),
+	}
+}
+
+func sentinelState(s *sentinelInstance, now time.Time) []string {
+	return []string{
+		"name", s.runID,
+		"ip", s.host,
+		"port", strconv.Itoa(s.port),
+		"runid", s.runID,
+		"flags", instanceFlags(s, now),
+		"last-ok-ping-reply", millisSince(s.lastPong, now),
+		"last-hello-message", millisSince(s.lastHello, now),
+		"voted-leader", s.leader,
+		"voted-leader-epoch", strconv.FormatInt(s.leaderEpoch, 10),
+	}
+}
+
+// arrayOfMaps renders a list of field-value lists as nested arrays
+func arrayOfMaps(items [][]string) string {
+	var sb strings.Builder
+	sb.WriteString("*" + strconv.Itoa(len(items)) + "\r\n")
+	for _, item := range items {
+		sb.WriteString(bulkArray(item))
+	}
+	return sb.String()
+}
+
+func sortedInstances(instances map[string]*sentinelInstance) []*sentinelInstance {
+	keys := make([]string, 0, len(instances))
+	for k := range instances {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]*sentinelInstance, len(keys))
+	for i, k := range keys {
+		out[i] = instances[k]
+	}
+	return out
+}
+
+// SENTINEL GET-MASTER-ADDR-BY-NAME name | MASTERS | MASTER name |
+// REPLICAS name | SENTINELS name | CKQUORUM name | FAILOVER name | MYID |
+// IS-MASTER-DOWN-BY-ADDR ip port epoch runid | HELLO ... | HELP
+func cmdSENTINEL(args []string, selectedDB *int) (string, error) {
+	sub := strings.ToUpper(args[1])
+	wrongArgs := "-ERR wrong number of arguments for 'SENTINEL|" + strings.ToLower(sub) + "' command\r\n"
+	noSuchMaster := "-ERR No such master with that name\r\n"
+
+	sentinelMu.Lock()
+	defer sentinelMu.Unlock()
+	now := time.Now()
+
+	// Every subcommand but a few takes a master name
+	var m *sentinelMaster
+	switch sub {
+	case "GET-MASTER-ADDR-BY-NAME", "MASTER", "REPLICAS", "SLAVES", "SENTINELS", "CKQUORUM", "FAILOVER":
+		if len(args) != 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		m = sentinelMasters[args[2]]
+		if m == nil && sub != "GET-MASTER-ADDR-BY-NAME" {
+			return noSuchMaster, fmt.Errorf("no such master")
+		}
+	}
+
+	switch sub {
+	case "GET-MASTER-ADDR-BY-NAME":
+		if m == nil {
+			return "*-1\r\n", nil
+		}
+		// Clients should switch as soon as the replica is promoted
+		inst := m.inst
+		if m.failoverState == failoverReconfReplicas && m.promoted != nil {
+			inst = m.promoted
+		}
+		return bulkArray([]string{inst.host, strconv.Itoa(inst.port)}), nil
+
+	case "MASTERS":
+		names := make([]string, 0, len(sentinelMasters))
+		for name := range sentinelMasters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		items := make([][]string, len(names))
+		for i, name := range names {
+			items[i] = masterState(sentinelMasters[name], now)
+		}
+		return arrayOfMaps(items), nil
+
+	case "MASTER":
+		return bulkArray(masterState(m, now)), nil
+
+	case "REPLICAS", "SLAVES":
+		var items [][]string
+		for _, r := range sortedInstances(m.replicas) {
+			items = append(items, replicaState(r, now))
+		}
+		return arrayOfMaps(items), nil
+
+	case "SENTINELS":
+		var items [][]string
+		for _, s := range sortedInstances(m.sentinels) {
+			items = append(items, sentinelState(s, now))
+		}
+		return arrayOfMaps(items), nil
+
+	case "CKQUORUM":
+		usable := 1
+		for _, s := range m.sentinels {
+			if !s.down(now) {
+				usable++
+			}
+		}
+		voters := len(m.sentinels) + 1
+		if usable < m.quorum {
+			return fmt.Sprintf("-NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the specified quorum for this master\r\n", usable), fmt.Errorf("no quorum")
+		}
+		if usable < voters/2+1 {
+			return fmt.Sprintf("-NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the majority and authorize a failover\r\n", usable), fmt.Errorf("no majority")
+		}
+		return fmt.Sprintf("+OK %d usable Sentinels. Quorum and failover authorization can be reached\r\n", usable), nil
+
+	case "FAILOVER":
+		// A forced failover needs no agreement: we lead it right away
+		if m.failoverState != failoverNone {
+			return "-INPROG Failover already in progress\r\n", fmt.Errorf("in progress")
+		}
+		if sentinelSelectReplica(m, now) == nil {
+			return "-NOGOODSLAVE No suitable replica to promote\r\n", fmt.Errorf("no replica")
+		}
+		sentinelStartFailover(m, now)
+		sentinelSetFailoverState(m, failoverSelectReplica, now)
+		return "+OK\r\n", nil
+
+	case "MYID":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		return bulkString(serverRunID), nil
+
+	case "IS-MASTER-DOWN-BY-ADDR":
+		if len(args) != 6 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		port, err1 := strconv.Atoi(args[3])
+		epoch, err2 := strconv.ParseInt(args[4], 10, 64)
+		if err1 != nil || err2 != nil {
+			return "-ERR value is not an integer or out of range\r\n", fmt.Errorf("not an integer")
+		}
+		down, leader, leaderEpoch := 0, "*", int64(0)
+		if m := sentinelMasterByAddr(args[2], port); m != nil {
+			if m.sdown {
+				down = 1
+			}
+			if args[5] != "*" {
+				leader, leaderEpoch = sentinelVote(m, epoch, args[5])
+			}
+		}
+		return "*3\r\n:" + strconv.Itoa(down) + "\r\n" + bulkString(leader) + ":" + strconv.FormatInt(leaderEpoch, 10) + "\r\n", nil
+
+	case "HELLO":
+		// HELLO ip port runid current-epoch master-name master-ip master-port master-config-epoch
+		if len(args) != 10 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		port, err1 := strconv.Atoi(args[3])
+		currentEpoch, err2 := strconv.ParseInt(args[5], 10, 64)
+		masterPort, err3 := strconv.Atoi(args[8])
+		configEpoch, err4 := strconv.ParseInt(args[9], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			return "-ERR value is not an integer or out of range\r\n", fmt.Errorf("not an integer")
+		}
+		if err := sentinelHello(args[2], port, args[4], currentEpoch, args[6], args[7], masterPort, configEpoch); err != nil {
+			return "-ERR " + err.Error() + "\r\n", err
+		}
+		var peers []string
+		for _, s := range sortedInstances(sentinelMasters[args[6]].sentinels) {
+			if s.runID != args[4] {
+				peers = append(peers, s.host+" "+strconv.Itoa(s.port)+" "+s.runID)
+			}
+		}
+		return bulkArray(peers), nil
+
+	case "HELP":
+		return bulkArray([]string{
+			"SENTINEL <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"GET-MASTER-ADDR-BY-NAME <master-name>",
+			"    Return the ip and port number of the master with that name.",
+			"MASTERS",
+			"    Show a list of monitored masters and their state.",
+			"MASTER <master-name>",
+			"    Show the state and info of the specified master.",
+			"REPLICAS <master-name>",
+			"    Show a list of replicas for this master and their state.",
+			"SENTINELS <master-name>",
+			"    Show a list of Sentinel instances for this master and their state.",
+			"CKQUORUM <master-name>",
+			"    Check if the current Sentinel configuration is able to reach the quorum",
+			"    needed to failover a master and the majority needed to authorize the",
+			"    failover.",
+			"FAILOVER <master-name>",
+			"    Manually failover a master node without asking for agreement from other",
+			"    Sentinels.",
+			"MYID",
+			"    Return the ID of the Sentinel instance.",
+			"IS-MASTER-DOWN-BY-ADDR <ip> <port> <current-epoch> <runid>",
+			"    Check if the master specified by ip:port is down from current Sentinel's",
+			"    point of view.",
+			"HELP",
+			"    Print this help.",
+		}), nil
+
+	default:
+		return "-ERR unknown subcommand '" + args[1] + "'. Try SENTINEL HELP.\r\n", fmt.Errorf("unknown subcommand")
+	}
+}
+
+// infoSentinel renders the sentinel section of INFO
+func infoSentinel() []string {
+	sentinelMu.Lock()
+	defer sentinelMu.Unlock()
+
+	names := make([]string, 0, len(sentinelMasters))
+	for name := range sentinelMasters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{
+		"sentinel_masters:" + strconv.Itoa(len(names)),
+		"sentinel_tilt:0",
+		"sentinel_current_epoch:" + strconv.FormatInt(sentinelCurrentEpoch, 10),
+	}
+	for i, name := range names {
+		m := sentinelMasters[name]
+		status := "ok"
+		if m.odown {
+			status = "odown"
+		} else if m.sdown {
+			status = "sdown"
+		}
+		lines = append(lines, fmt.Sprintf("master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d",
+			i, name, status, m.inst.addr(), len(m.replicas), len(m.sentinels)+1))
+	}
+	return lines
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupSentinel replaces the sentinel state with the given directives
+func setupSentinel(t *testing.T, directives ...string) {
+	t.Helper()
+	reset := func() {
+		sentinelMu.Lock()
+		sentinelMasters = map[string]*sentinelMaster{}
+		sentinelCurrentEpoch = 0
+		sentinelMu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+
+	for _, d := range directives {
+		if err := sentinelDirective(strings.Fields(d)); err != nil {
+			t.Fatalf("%s: %v", d, err)
+		}
+	}
+}
+
+func TestSentinelDirectivesAndAddress(t *testing.T) {
+	setupSentinel(t,
+		"monitor mymaster 127.0.0.1 6380 2",
+		"down-after-milliseconds mymaster 5000",
+		"known-replica mymaster 127.0.0.1 6381",
+	)
+
+	if got, _ := cmdSENTINEL(cmd("SENTINEL GET-MASTER-ADDR-BY-NAME mymaster"), nil); got != "*2\r\n$9\r\n127.0.0.1\r\n$4\r\n6380\r\n" {
+		t.Errorf("GET-MASTER-ADDR-BY-NAME: %q", got)
+	}
+	if got, _ := cmdSENTINEL(cmd("SENTINEL GET-MASTER-ADDR-BY-NAME nope"), nil); got != "*-1\r\n" {
+		t.Errorf("unknown master: %q", got)
+	}
+	if got, _ := cmdSENTINEL(cmd("SENTINEL MASTER nope"), nil); got != "-ERR No such master with that name\r\n" {
+		t.Errorf("MASTER of unknown name: %q", got)
+	}
+	master, _ := cmdSENTINEL(cmd("SENTINEL MASTER mymaster"), nil)
+	for _, field := range []string{"$23\r\ndown-after-milliseconds\r\n$4\r\n5000\r\n", "$10\r\nnum-slaves\r\n$1\r\n1\r\n", "$6\r\nquorum\r\n$1\r\n2\r\n"} {
+		if !strings.Contains(master, field) {
+			t.Errorf("SENTINEL MASTER misses %q: %q", field, master)
+		}
+	}
+
+	if err := sentinelDirective(cmd("monitor mymaster 127.0.0.1 6390 1")); err == nil {
+		t.Error("a second master with the same name was accepted")
+	}
+	if err := sentinelDirective(cmd("auth-pass nope secret")); err == nil {
+		t.Error("an option for an unknown master was accepted")
+	}
+}
+
+func TestSentinelVotesOncePerEpoch(t *testing.T) {
+	setupSentinel(t, "monitor mymaster 127.0.0.1 6380 2")
+
+	got, _ := cmdSENTINEL(cmd("SENTINEL IS-MASTER-DOWN-BY-ADDR 127.0.0.1 6380 1 aaaa"), nil)
+	if got != "*3\r\n:0\r\n$4\r\naaaa\r\n:1\r\n" {
+		t.Fatalf("first vote request: %q", got)
+	}
+	// Another candidate in the same epoch learns who got the vote
+	got, _ = cmdSENTINEL(cmd("SENTINEL IS-MASTER-DOWN-BY-ADDR 127.0.0.1 6380 1 bbbb"), nil)
+	if got != "*3\r\n:0\r\n$4\r\naaaa\r\n:1\r\n" {
+		t.Fatalf("second vote request in the same epoch: %q", got)
+	}
+	got, _ = cmdSENTINEL(cmd("SENTINEL IS-MASTER-DOWN-BY-ADDR 127.0.0.1 6380 2 bbbb"), nil)
+	if got != "*3\r\n:0\r\n$4\r\nbbbb\r\n:2\r\n" {
+		t.Fatalf("vote request in a newer epoch: %q", got)
+	}
+
+	// A plain down-state question doesn't vote
+	sentinelMu.Lock()
+	sentinelMasters["mymaster"].sdown = true
+	sentinelMu.Unlock()
+	if got, _ := cmdSENTINEL(cmd("SENTINEL IS-MASTER-DOWN-BY-ADDR 127.0.0.1 6380 2 *"), nil); got != "*3\r\n:1\r\n$1\r\n*\r\n:0\r\n" {
+		t.Fatalf("down-state question: %q", got)
+	}
+}
+
+func TestSentinelLeaderNeedsMajorityAndQuorum(t *testing.T) {
+	setupSentinel(t,
+		"monitor mymaster 127.0.0.1 6380 3",
+		"known-sentinel mymaster 127.0.0.1 26380 peer1",
+		"known-sentinel mymaster 127.0.0.1 26381 peer2",
+		"known-sentinel mymaster 127.0.0.1 26382 peer3",
+		"known-sentinel mymaster 127.0.0.1 26383 peer4",
+	)
+	sentinelMu.Lock()
+	defer sentinelMu.Unlock()
+	m := sentinelMasters["mymaster"]
+
+	m.leader, m.leaderEpoch = "peer1", 5
+	m.sentinels["peer1"].leader, m.sentinels["peer1"].leaderEpoch = "peer1", 5
+	m.sentinels["peer2"].leader, m.sentinels["peer2"].leaderEpoch = "peer1", 4 // an old vote
+	if leader := sentinelLeader(m, 5); leader != "" {
+		t.Fatalf("2 of 5 votes elected %q", leader)
+	}
+
+	m.sentinels["peer2"].leaderEpoch = 5
+	if leader := sentinelLeader(m, 5); leader != "peer1" {
+		t.Fatalf("3 of 5 votes: leader %q", leader)
+	}
+}
+
+func TestSentinelSelectsMostUpToDateReplica(t *testing.T) {
+	setupSentinel(t,
+		"monitor mymaster 127.0.0.1 6380 1",
+		"known-replica mymaster 127.0.0.1 6381",
+		"known-replica mymaster 127.0.0.1 6382",
+		"known-replica mymaster 127.0.0.1 6383",
+	)
+	sentinelMu.Lock()
+	defer sentinelMu.Unlock()
+	m := sentinelMasters["mymaster"]
+	now := time.Now()
+
+	offsets := map[string]int64{"127.0.0.1:6381": 100, "127.0.0.1:6382": 300, "127.0.0.1:6383": 500}
+	for addr, r := range m.replicas {
+		r.pingSent, r.lastInfo, r.replOffset = time.Time{}, now, offsets[addr]
+	}
+	// The most up to date one doesn't answer
+	m.replicas["127.0.0.1:6383"].pingSent = now.Add(-time.Hour)
+
+	if r := sentinelSelectReplica(m, now); r == nil || r.port != 6382 {
+		t.Fatalf("selected %+v, want the replica on 6382", r)
+	}
+}
+
+func TestSentinelHelloSpreadsNewerConfig(t *testing.T) {
+	setupSentinel(t, "monitor mymaster 127.0.0.1 6380 2")
+
+	hello := "SENTINEL HELLO 127.0.0.1 26380 peer1 3 mymaster 127.0.0.1 6381 3"
+	if got, _ := cmdSENTINEL(cmd(hello), nil); got != "*0\r\n" {
+		t.Fatalf("HELLO: %q", got)
+	}
+	if got, _ := cmdSENTINEL(cmd("SENTINEL GET-MASTER-ADDR-BY-NAME mymaster"), nil); got != "*2\r\n$9\r\n127.0.0.1\r\n$4\r\n6381\r\n" {
+		t.Fatalf("address after a newer config: %q", got)
+	}
+
+	// An older config epoch is ignored, and known peers are listed back
+	cmdSENTINEL(cmd("SENTINEL HELLO 127.0.0.1 26381 peer2 3 mymaster 127.0.0.1 6390 1"), nil)
+	if got, _ := cmdSENTINEL(cmd("SENTINEL GET-MASTER-ADDR-BY-NAME mymaster"), nil); !strings.Contains(got, "6381") {
+		t.Fatalf("older config replaced the address: %q", got)
+	}
+	if got, _ := cmdSENTINEL(cmd(hello), nil); got != "*1\r\n$21\r\n127.0.0.1 26381 peer2\r\n" {
+		t.Fatalf("HELLO doesn't list the other peer: %q", got)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestServer runs the accept loop on a random loopback port and
+// returns its address. The keyspace is reset first.
+func startTestServer(t testing.TB) string {
+	t.Helper()
+	resetKeyspace()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go serve(listener)
+	t.Cleanup(func() { listener.Close() })
+
+	return listener.Addr().String()
+}
+
+// testClient is a minimal RESP client used to drive the server in tests
+type testClient struct {
+	t      testing.TB
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTestClient(t testing.TB, addr string) *testClient {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// send writes a command without waiting for its reply
+func (c *testClient) send(args ...string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, buildRESPCommand(args[0], args[1:])); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+}
+
+// do sends a command and returns the raw RESP reply
+func (c *testClient) do(args ...string) string {
+	c.t.Helper()
+	c.send(args...)
+	return c.read()
+}
+
+func (c *testClient) read() string {
+	c.t.Helper()
+	reply, err := readReply(c.reader)
+	if err != nil {
+		c.t.Fatalf("read reply: %v", err)
+	}
+	return reply
+}
+
+// readReply reads one complete RESP reply and returns it verbatim
+func readReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 {
+		return "", fmt.Errorf("short reply line %q", line)
+	}
+
+	switch line[0] {
+	case '+', '-', ':':
+		return line, nil
+
+	case '$':
+		n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return "", err
+		}
+		if n < 0 {
+			return line, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return line + string(buf), nil
+
+	case '*':
+		n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return "", err
+		}
+		var sb strings.Builder
+		sb.WriteString(line)
+		for i := 0; i < n; i++ {
+			item, err := readReply(r)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(item)
+		}
+		return sb.String(), nil
+	}
+
+	return "", fmt.Errorf("unexpected reply type %q", line)
+}
+
+func TestServerRoundTrip(t *testing.T) {
+	c := dialTestClient(t, startTestServer(t))
+
+	if got := c.do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("PING: %q", got)
+	}
+	if got := c.do("SET", "k", "hello world"); got != "+OK\r\n" {
+		t.Fatalf("SET: %q", got)
+	}
+	if got := c.do("GET", "k"); got != "$11\r\nhello world\r\n" {
+		t.Fatalf("GET: %q", got)
+	}
+	if got := c.do("NOPE"); got != "-ERR unknown command 'NOPE'\r\n" {
+		t.Fatalf("unknown: %q", got)
+	}
+}
+
+func TestServerSelectIsPerConnection(t *testing.T) {
+	addr := startTestServer(t)
+	a := dialTestClient(t, addr)
+	b := dialTestClient(t, addr)
+
+	a.do("SELECT", "1")
+	a.do("SET", "k", "in-db1")
+
+	if got := b.do("GET", "k"); got != "$-1\r\n" {
+		t.Fatalf("db0 sees db1 key: %q", got)
+	}
+	b.do("SELECT", "1")
+	if got := b.do("GET", "k"); got != "$6\r\nin-db1\r\n" {
+		t.Fatalf("db1 key missing: %q", got)
+	}
+}
+
+func TestServerPipelining(t *testing.T) {
+	c := dialTestClient(t, startTestServer(t))
+
+	const n = 1000
+	var batch strings.Builder
+	for i := 0; i < n; i++ {
+		batch.WriteString(buildRESPCommand("INCR", []string{"counter"}))
+	}
+	io.WriteString(c.conn, batch.String())
+
+	for i := 1; i <= n; i++ {
+		if got, want := c.read(), ":"+strconv.Itoa(i)+"\r\n"; got != want {
+			t.Fatalf("reply %d: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestServerPartialCommandIsNotStalled(t *testing.T) {
+	c := dialTestClient(t, startTestServer(t))
+
+	// One full command followed by half of the next one: the first reply
+	// must be flushed even though more bytes are buffered.
+	io.WriteString(c.conn, "*1\r\n$4\r\nPING\r\n*2\r\n$4\r\nECHO\r\n")
+	if got := c.read(); got != "+PONG\r\n" {
+		t.Fatalf("got %q", got)
+	}
+
+	io.WriteString(c.conn, "$2\r\nhi\r\n")
+	if got := c.read(); got != "$2\r\nhi\r\n" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestServerInlineCommands(t *testing.T) {
+	c := dialTestClient(t, startTestServer(t))
+
+	io.WriteString(c.conn, "PING\r\n\r\nSET greeting \"hello\\tworld\"\nGET greeting\n")
+	for _, want := range []string{"+PONG\r\n", "+OK\r\n", "$11\r\nhello\tworld\r\n"} {
+		if got := c.read(); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestServerProtocolErrorClosesConnection(t *testing.T) {
+	addr := startTestServer(t)
+
+	for _, input := range []string{"*2147483647\r\n", "*1\r\n$-5\r\n", "ECHO \"open\n"} {
+		c := dialTestClient(t, addr)
+		io.WriteString(c.conn, input)
+
+		got := c.read()
+		if !strings.HasPrefix(got, "-ERR Protocol error: ") {
+			t.Fatalf("%q: got %q", input, got)
+		}
+		if _, err := c.reader.ReadByte(); err != io.EOF {
+			t.Fatalf("%q: connection left open after protocol error (%v)", input, err)
+		}
+	}
+}
+
+// startBenchServer serves commands the way handleConnection does, except
+// that with flushEach every reply is flushed on its own instead of once
+// the pipelined batch in the reader is consumed
+func startBenchServer(b *testing.B, flushEach bool) string {
+	b.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("listen: %v", err)
+	}
+	b.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader, writer := bufio.NewReader(conn), bufio.NewWriter(conn)
+				selectedDB := 0
+				for {
+					args, err := parseResp(reader)
+					if err != nil {
+						return
+					}
+					resp, _ := execCommand(args, &selectedDB)
+					writer.WriteString(resp)
+					if flushEach || !hasCompleteCommand(reader) {
+						if writer.Flush() != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// BenchmarkPipelinedGET sends GETs in batches of 16, like
+// redis-benchmark -P 16, to a server that flushes after every reply and
+// to one that flushes once per batch.
+func BenchmarkPipelinedGET(b *testing.B) {
+	resetKeyspace()
+	selectedDB := 0
+	execCommand([]string{"SET", "key", "value"}, &selectedDB)
+
+	const depth = 16
+	batch := strings.Repeat(buildRESPCommand("GET", []string{"key"}), depth)
+
+	for _, flushEach := range []bool{true, false} {
+		name := "FlushPerBatch"
+		if flushEach {
+			name = "FlushEachReply"
+		}
+		b.Run(name, func(b *testing.B) {
+			c := dialTestClient(b, startBenchServer(b, flushEach))
+			c.conn.SetDeadline(time.Time{})
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i += depth {
+				io.WriteString(c.conn, batch)
+				for j := 0; j < depth; j++ {
+					if _, err := readReply(c.reader); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestServerArityAndAOFFollowDescriptors(t *testing.T) {
+	oldFile := AOFFileName
+	AOFFileName = filepath.Join(t.TempDir(), "appendonly.aof")
+	if err := InitAOF(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		CloseAOF()
+		AOFFileName = oldFile
+	})
+
+	c := dialTestClient(t, startTestServer(t))
+	if got := c.do("CLIENT"); got != "-ERR wrong number of arguments for 'CLIENT' command\r\n" {
+		t.Fatalf("CLIENT arity: %q", got)
+	}
+	c.do("SET", "k", "1")
+	c.do("GET", "k")
+	c.do("INCR", "k")
+	c.do("INCR", "k", "extra")
+
+	FlushAOF()
+	data, err := os.ReadFile(AOFFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := buildRESPCommand("SET", []string{"k", "1"}) + buildRESPCommand("INCR", []string{"k"})
+	if string(data) != want {
+		t.Fatalf("AOF holds %q, want only the writes %q", data, want)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	dbFilename      = newAtomicString("dump.aof") // snapshot written by SHUTDOWN SAVE
+	shutdownTimeout = newAtomicInt(10)            // seconds to wait for in-flight commands
+)
+
+var (
+	shutdownMu   sync.Mutex // one shutdown attempt at a time
+	shuttingDown atomic.Bool
+
+	// commandGate is held shared while a keyspace command runs. Shutdown
+	// takes it exclusively to wait for in-flight commands and to keep new
+	// ones from starting.
+	commandGate sync.RWMutex
+)
+
+// activeListeners are the accept loops that shutdown closes
+var (
+	listenersMu     sync.Mutex
+	activeListeners = map[net.Listener]bool{}
+)
+
+func trackListener(l net.Listener) {
+	listenersMu.Lock()
+	activeListeners[l] = true
+	listenersMu.Unlock()
+}
+
+func untrackListener(l net.Listener) {
+	listenersMu.Lock()
+	delete(activeListeners, l)
+	listenersMu.Unlock()
+}
+
+// shutdownOptions mirror SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE]
+type shutdownOptions struct {
+	save   bool // write a snapshot to dbfilename
+	nosave bool // never write a snapshot
+	now    bool // don't wait for in-flight commands
+	force  bool // exit even if flushing the AOF or saving fails
+}
+
+// shutdownServer waits for in-flight commands, makes the data durable and
+// closes every client and listener, after which main returns. On error
+// the server keeps running, unless opts.force is set.
+func shutdownServer(opts shutdownOptions) error {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+
+	if shuttingDown.Load() {
+		return nil
+	}
+	fmt.Println("Shutting down...")
+
+	// Wait for in-flight commands; new ones block on the gate from now on
+	var gate chan struct{}
+	if !opts.now {
+		gate = make(chan struct{})
+		go func() {
+			commandGate.Lock()
+			close(gate)
+		}()
+
+		select {
+		case <-gate:
+		case <-time.After(time.Duration(shutdownTimeout.Load()) * time.Second):
+			fmt.Println("Timed out waiting for in-flight commands")
+		}
+	}
+
+	var errs []string
+	if err := FlushAOF(); err != nil {
+		errs = append(errs, "flushing AOF: "+err.Error())
+	}
+	if opts.save && !opts.nosave {
+		path := dbFilename.Load()
+		if err := writeSnapshot(path); err != nil {
+			errs = append(errs, "saving snapshot: "+err.Error())
+		} else {
+			fmt.Println("Snapshot saved to", path)
+		}
+	}
+
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Println("Error during shutdown:", e)
+		}
+		if !opts.force {
+			// Give the gate back once it's ours, even if that is late
+			if gate != nil {
+				go func() {
+					<-gate
+					commandGate.Unlock()
+				}()
+			}
+			return fmt.Errorf("%s", strings.Join(errs, "; "))
+		}
+	}
+
+	shuttingDown.Store(true)
+
+	clientsMu.Lock()
+	for _, c := range clients {
+		c.conn.Close()
+	}
+	clientsMu.Unlock()
+
+	CloseAOF()
+
+	// Closing the listeners lets the accept loops in main return
+	listenersMu.Lock()
+	for l := range activeListeners {
+		l.Close()
+	}
+	listenersMu.Unlock()
+
+	fmt.Println("Mini-Redis is now ready to exit, bye bye...")
+	return nil
+}
+
+// shutdownOnSignal shuts down on SIGTERM / SIGINT. If that fails the
+// server keeps running; a second signal tries again.
+func shutdownOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+	for sig := range sigs {
+		fmt.Println("Received", sig)
+		if err := shutdownServer(shutdownOptions{}); err != nil {
+			fmt.Println("Errors trying to shut down the server, check the logs")
+		}
+	}
+}
+
+// writeSnapshot dumps every database to path in AOF format: SELECT, then
+// one command rebuilding each key and EXPIREAT for keys with a TTL. The
+// file is replaced atomically.
+func writeSnapshot(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	dumpDatabases(w)
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// dumpDatabases writes the snapshot commands for every database to w
+func dumpDatabases(w *bufio.Writer) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for dbIndex := 0; dbIndex < NumDatabases; dbIndex++ {
+		if len(databases[dbIndex]) == 0 {
+			continue
+		}
+		w.WriteString(buildRESPCommand("SELECT", []string{strconv.Itoa(dbIndex)}))
+
+		for key, entry := range databases[dbIndex] {
+			writeSnapshotEntry(w, key, entry)
+		}
+	}
+}
+
+func writeSnapshotEntry(w *bufio.Writer, key string, entry Entry) {
+	switch entry.Type {
+	case TypeString:
+		w.WriteString(buildRESPCommand("SET", []string{key, entry.Value.(string)}))
+
+	case TypeHash:
+		hash := entry.Value.(Hash)
+		fields := make([]string, 0, hash.Len())
+		for field := range hash.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		args := []string{key}
+		for _, field := range fields {
+			args = append(args, field, hash.Fields[field])
+		}
+		w.WriteString(buildRESPCommand("HSET", args))
+
+	case TypeZSet:
+		args := []string{key}
+		for _, item := range entry.Value.(ZSet).Items() {
+			args = append(args, strconv.FormatFloat(item.Score, 'g', -1, 64), item.Member)
+		}
+		w.WriteString(buildRESPCommand("ZADD", args))
+
+	default:
+		return
+	}
+
+	if entry.ExpireAt != 0 {
+		w.WriteString(buildRESPCommand("EXPIREAT", []string{key, strconv.FormatInt(entry.ExpireAt, 10)}))
+	}
+}
+
+// loadSnapshot restores dbfilename at startup when the AOF is off
+func loadSnapshot() {
+	path := dbFilename.Load()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return
+	}
+
+	count, err := replayFile(path)
+	if err != nil {
+		fmt.Printf("[Snapshot] Error opening %s: %v\n", path, err)
+		return
+	}
+	fmt.Printf("[Snapshot] Loaded %d commands from %s\n", count, path)
+}
+
+// cmdSHUTDOWN only replies when the shutdown fails; on success the
+// connection is simply closed, like Redis does
+func cmdSHUTDOWN(c *Client, args []string) (string, error) {
+	var opts shutdownOptions
+	for _, arg := range args[1:] {
+		switch strings.ToUpper(arg) {
+		case "SAVE":
+			opts.save = true
+		case "NOSAVE":
+			opts.nosave = true
+		case "NOW":
+			opts.now = true
+		case "FORCE":
+			opts.force = true
+		default:
+			return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+		}
+	}
+	if opts.save && opts.nosave {
+		return "-ERR syntax error\r\n", fmt.Errorf("syntax error")
+	}
+
+	// Replies to commands pipelined before SHUTDOWN still go out
+	c.flushOutput()
+
+	if err := shutdownServer(opts); err != nil {
+		return "-ERR Errors trying to SHUTDOWN. Check logs.\r\n", err
+	}
+	c.closeAfter = true
+	return "", nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withShutdown points dbfilename into a temp dir and brings the server
+// back to a running state after the test shut it down
+func withShutdown(t *testing.T) string {
+	t.Helper()
+	oldFile := dbFilename.Load()
+	dbFilename.Store(filepath.Join(t.TempDir(), "dump.aof"))
+
+	t.Cleanup(func() {
+		shutdownMu.Lock()
+		if shuttingDown.Load() {
+			commandGate.Unlock()
+			shuttingDown.Store(false)
+		}
+		shutdownMu.Unlock()
+		dbFilename.Store(oldFile)
+	})
+	return dbFilename.Load()
+}
+
+func expectClosed(t *testing.T, c *testClient) {
+	t.Helper()
+	if reply, err := readReply(c.reader); err == nil {
+		t.Fatalf("connection still open, got %q", reply)
+	}
+}
+
+func TestShutdownSaveAndLoadSnapshot(t *testing.T) {
+	withShutdown(t)
+	addr := startTestServer(t)
+	c := dialTestClient(t, addr)
+	other := dialTestClient(t, addr)
+
+	c.do("SET", "str", "hello")
+	c.do("HSET", "hash", "a", "1", "b", "2")
+	c.do("ZADD", "zset", "1.5", "one")
+	c.do("ZADD", "zset", "2", "two")
+	c.do("SET", "ttl", "v")
+	c.do("EXPIRE", "ttl", "100")
+	c.do("SELECT", "3")
+	c.do("SET", "in-db3", "x")
+
+	c.send("SHUTDOWN", "SAVE")
+	expectClosed(t, c)
+	expectClosed(t, other)
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("listener still accepting after SHUTDOWN")
+	}
+
+	resetKeyspace()
+	loadSnapshot()
+
+	db := 0
+	checks := []struct {
+		args []string
+		want string
+	}{
+		{cmd("GET str"), "$5\r\nhello\r\n"},
+		{cmd("HGET hash b"), "$1\r\n2\r\n"},
+		{cmd("ZSCORE zset one"), "$3\r\n1.5\r\n"},
+		{cmd("ZCARD zset"), ":2\r\n"},
+		{cmd("TTL str"), ":-1\r\n"},
+	}
+	for _, tc := range checks {
+		if got, _ := execCommand(tc.args, &db); got != tc.want {
+			t.Fatalf("%v after load: got %q, want %q", tc.args, got, tc.want)
+		}
+	}
+	if got, _ := execCommand(cmd("TTL ttl"), &db); got != ":100\r\n" && got != ":99\r\n" {
+		t.Fatalf("TTL after load: %q", got)
+	}
+	db = 3
+	if got, _ := execCommand(cmd("GET in-db3"), &db); got != "$1\r\nx\r\n" {
+		t.Fatalf("db3 key after load: %q", got)
+	}
+}
+
+func TestShutdownWaitsForInFlightCommands(t *testing.T) {
+	withShutdown(t)
+	startTestServer(t)
+
+	// Stand in for a command that is still running
+	commandGate.RLock()
+
+	done := make(chan error)
+	go func() { done <- shutdownServer(shutdownOptions{}) }()
+
+	select {
+	case <-done:
+		t.Fatal("shutdown didn't wait for the running command")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	commandGate.RUnlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown didn't finish after the command completed")
+	}
+}
+
+func TestShutdownFailureKeepsServing(t *testing.T) {
+	withShutdown(t)
+	dbFilename.Store(filepath.Join(t.TempDir(), "missing", "dump.aof"))
+	c := dialTestClient(t, startTestServer(t))
+
+	if got := c.do("SHUTDOWN", "SAVE"); got != "-ERR Errors trying to SHUTDOWN. Check logs.\r\n" {
+		t.Fatalf("SHUTDOWN SAVE to a bad path: %q", got)
+	}
+	if got := c.do("SET", "k", "v"); got != "+OK\r\n" {
+		t.Fatalf("server stopped serving after a failed shutdown: %q", got)
+	}
+
+	c.send("SHUTDOWN", "SAVE", "FORCE")
+	expectClosed(t, c)
+}
+
+func TestShutdownSyntax(t *testing.T) {
+	c := dialTestClient(t, startTestServer(t))
+
+	for _, args := range [][]string{{"SHUTDOWN", "LATER"}, {"SHUTDOWN", "SAVE", "NOSAVE"}} {
+		if got := c.do(args...); got != "-ERR syntax error\r\n" {
+			t.Fatalf("%v: %q", args, got)
+		}
+	}
+	if _, err := io.WriteString(c.conn, buildRESPCommand("PING", nil)); err != nil || !strings.HasPrefix(c.read(), "+PONG") {
+		t.Fatal("server stopped after a bad SHUTDOWN")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Commands slower than slowlogSlowerThan microseconds are recorded; -1
+// disables the log and 0 records every command.
+var (
+	slowlogSlowerThan = newAtomicInt(10000)
+	slowlogMaxLen     = newAtomicInt(128)
+)
+
+// Arguments are truncated like Redis does so a huge MSET doesn't pin
+// its whole payload in the log
+const (
+	slowlogMaxArgc   = 32
+	slowlogMaxArgLen = 128
+)
+
+type slowlogEntry struct {
+	id         int64
+	timestamp  int64 // unix seconds
+	duration   int64 // microseconds
+	args       []string
+	clientAddr string
+	clientName string
+}
+
+// slowlog is a ring buffer: next is where the following entry goes and
+// count how many slots are in use
+var (
+	slowlogMu     sync.Mutex
+	slowlog       []slowlogEntry
+	slowlogNext   int
+	slowlogCount  int
+	slowlogNextID int64
+)
+
+// slowlogRecord logs the command if it took longer than the threshold
+func slowlogRecord(c *Client, args []string, d time.Duration) {
+	threshold := slowlogSlowerThan.Load()
+	if threshold < 0 || d.Microseconds() < threshold {
+		return
+	}
+
+	entry := slowlogEntry{
+		timestamp:  time.Now().Unix(),
+		duration:   d.Microseconds(),
+		args:       slowlogArgs(args),
+		clientAddr: c.conn.RemoteAddr().String(),
+		clientName: c.name,
+	}
+
+	slowlogMu.Lock()
+	defer slowlogMu.Unlock()
+
+	slowlogResize(int(slowlogMaxLen.Load()))
+	if len(slowlog) == 0 {
+		return
+	}
+
+	entry.id = slowlogNextID
+	slowlogNextID++
+
+	slowlog[slowlogNext] = entry
+	slowlogNext = (slowlogNext + 1) % len(slowlog)
+	if slowlogCount < len(slowlog) {
+		slowlogCount++
+	}
+}
+
+// slowlogArgs copies args, keeping at most slowlogMaxArgc of them and
+// slowlogMaxArgLen bytes of each
+func slowlogArgs(args []string) []string {
+	n := min(len(args), slowlogMaxArgc)
+	out := make([]string, n)
+	for i := 0; i < n; i++ {
+		if i == slowlogMaxArgc-1 && len(args) > slowlogMaxArgc {
+			out[i] = fmt.Sprintf("... (%d more arguments)", len(args)-slowlogMaxArgc+1)
+			break
+		}
+		arg := args[i]
+		if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		out[i] = arg
+	}
+	return out
+}
+
+// slowlogResize adapts the ring to slowlog-max-len, keeping the newest
+// entries. Caller holds slowlogMu.
+func slowlogResize(size int) {
+	if size == len(slowlog) {
+		return
+	}
+
+	kept := slowlogNewest(min(slowlogCount, size))
+	slowlog = make([]slowlogEntry, size)
+	slowlogCount = len(kept)
+	// kept is newest first; lay it back out oldest first
+	for i := range kept {
+		slowlog[i] = kept[len(kept)-1-i]
+	}
+	slowlogNext = 0
+	if size > 0 {
+		slowlogNext = slowlogCount % size
+	}
+}
+
+// slowlogNewest returns up to n entries, newest first. Caller holds
+// slowlogMu.
+func slowlogNewest(n int) []slowlogEntry {
+	n = min(n, slowlogCount)
+	out := make([]slowlogEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, slowlog[(slowlogNext-i+len(slowlog))%len(slowlog)])
+	}
+	return out
+}
+
+func resetSlowlog() {
+	slowlogMu.Lock()
+	defer slowlogMu.Unlock()
+
+	for i := range slowlog {
+		slowlog[i] = slowlogEntry{}
+	}
+	slowlogNext, slowlogCount = 0, 0
+}
+
+// SLOWLOG GET [count] | LEN | RESET
+func cmdSLOWLOG(args []string, selectedDB *int) (string, error) {
+	sub := strings.ToUpper(args[1])
+	wrongArgs := "-ERR wrong number of arguments for 'SLOWLOG|" + strings.ToLower(sub) + "' command\r\n"
+
+	switch sub {
+	case "GET":
+		if len(args) > 3 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		count := 10
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < -1 {
+				return "-ERR count should be greater than or equal to -1\r\n", fmt.Errorf("bad count")
+			}
+			count = n
+		}
+
+		slowlogMu.Lock()
+		if count == -1 {
+			count = slowlogCount
+		}
+		entries := slowlogNewest(count)
+		slowlogMu.Unlock()
+
+		var resp strings.Builder
+		resp.WriteString("*" + strconv.Itoa(len(entries)) + "\r\n")
+		for _, e := range entries {
+			resp.WriteString("*6\r\n")
+			resp.WriteString(":" + strconv.FormatInt(e.id, 10) + "\r\n")
+			resp.WriteString(":" + strconv.FormatInt(e.timestamp, 10) + "\r\n")
+			resp.WriteString(":" + strconv.FormatInt(e.duration, 10) + "\r\n")
+			resp.WriteString(bulkArray(e.args))
+			resp.WriteString(bulkString(e.clientAddr))
+			resp.WriteString(bulkString(e.clientName))
+		}
+		return resp.String(), nil
+
+	case "LEN":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		slowlogMu.Lock()
+		n := slowlogCount
+		slowlogMu.Unlock()
+		return ":" + strconv.Itoa(n) + "\r\n", nil
+
+	case "RESET":
+		if len(args) != 2 {
+			return wrongArgs, fmt.Errorf("wrong args")
+		}
+		resetSlowlog()
+		return "+OK\r\n", nil
+
+	default:
+		return "-ERR unknown subcommand '" + args[1] + "'. Try SLOWLOG GET, LEN, RESET.\r\n", fmt.Errorf("unknown subcommand")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// withSlowlog logs every command for the duration of the test
+func withSlowlog(t *testing.T) {
+	t.Helper()
+	saveConfig(t)
+	configByName["slowlog-log-slower-than"].set("0")
+	resetSlowlog()
+	t.Cleanup(resetSlowlog)
+}
+
+func TestSlowlogRecordsCommands(t *testing.T) {
+	withSlowlog(t)
+	c := dialTestClient(t, startTestServer(t))
+	c.do("CLIENT", "SETNAME", "slowpoke")
+
+	c.do("SET", "k", "v")
+	c.do("GET", "k")
+
+	if got := c.do("SLOWLOG", "LEN"); got != ":2\r\n" {
+		t.Fatalf("SLOWLOG LEN: %q", got)
+	}
+
+	// Newest first; the SLOWLOG LEN call above was recorded too
+	got := c.do("SLOWLOG", "GET", "2")
+	if !strings.HasPrefix(got, "*2\r\n*6\r\n") {
+		t.Fatalf("SLOWLOG GET: %q", got)
+	}
+	for _, want := range []string{
+		"*2\r\n$7\r\nSLOWLOG\r\n$3\r\nLEN\r\n",
+		"*2\r\n$3\r\nGET\r\n$1\r\nk\r\n",
+		bulkString(c.conn.LocalAddr().String()),
+		"$8\r\nslowpoke\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("SLOWLOG GET missing %q: %q", want, got)
+		}
+	}
+	if strings.Contains(got, "$3\r\nSET\r\n") {
+		t.Fatalf("SLOWLOG GET 2 returned more than 2 entries: %q", got)
+	}
+
+	if got := c.do("SLOWLOG", "RESET"); got != "+OK\r\n" {
+		t.Fatalf("SLOWLOG RESET: %q", got)
+	}
+	// Only the RESET itself, which is logged once it has run
+	if got := c.do("SLOWLOG", "LEN"); got != ":1\r\n" {
+		t.Fatalf("SLOWLOG LEN after reset: %q", got)
+	}
+}
+
+func TestSlowlogThreshold(t *testing.T) {
+	withSlowlog(t)
+	c := dialTestClient(t, startTestServer(t))
+
+	configByName["slowlog-log-slower-than"].set("-1")
+	c.do("SET", "k", "v")
+	configByName["slowlog-log-slower-than"].set("100000000")
+	c.do("SET", "k", "v")
+
+	slowlogMu.Lock()
+	n := slowlogCount
+	slowlogMu.Unlock()
+	if n != 0 {
+		t.Fatalf("%d fast commands logged", n)
+	}
+}
+
+func TestSlowlogIsBounded(t *testing.T) {
+	withSlowlog(t)
+	configByName["slowlog-max-len"].set("3")
+	c := dialTestClient(t, startTestServer(t))
+
+	for i := 0; i < 10; i++ {
+		c.do("SET", "k"+strconv.Itoa(i), "v")
+	}
+	got := c.do("SLOWLOG", "GET", "-1")
+	if !strings.HasPrefix(got, "*3\r\n") || !strings.Contains(got, "$2\r\nk9\r\n") || strings.Contains(got, "$2\r\nk6\r\n") {
+		t.Fatalf("SLOWLOG GET -1 with max-len 3: %q", got)
+	}
+
+	// Shrinking keeps the newest entries
+	configByName["slowlog-max-len"].set("1")
+	c.do("SET", "last", "v")
+	got = c.do("SLOWLOG", "GET", "-1")
+	if !strings.HasPrefix(got, "*1\r\n") || !strings.Contains(got, "$4\r\nlast\r\n") {
+		t.Fatalf("after shrinking: %q", got)
+	}
+}
+
+func TestSlowlogArgsTruncation(t *testing.T) {
+	args := []string{"MSET"}
+	for i := 0; i < 40; i++ {
+		args = append(args, strings.Repeat("x", 200))
+	}
+
+	got := slowlogArgs(args)
+	if len(got) != slowlogMaxArgc {
+		t.Fatalf("%d args kept", len(got))
+	}
+	if want := strings.Repeat("x", 128) + "... (72 more bytes)"; got[1] != want {
+		t.Fatalf("long arg: %q", got[1])
+	}
+	if want := "... (10 more arguments)"; got[31] != want {
+		t.Fatalf("last arg: %q, want %q", got[31], want)
+	}
+}
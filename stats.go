@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+)
+
+// Server wide counters, reset by CONFIG RESETSTAT
+var (
+	statTotalCommands    atomic.Int64 // commands processed
+	statTotalConnections atomic.Int64 // connections accepted
+	statKeyspaceHits     atomic.Int64 // read lookups that found the key
+	statKeyspaceMisses   atomic.Int64 // read lookups that didn't
+	statExpiredKeys      atomic.Int64 // keys removed because their TTL passed
+
+	statRejectedConnections    atomic.Int64 // refused because of maxclients
+	statOutputLimitDisconnects atomic.Int64 // dropped by client-output-buffer-limit
+)
+
+// Gauges and server identity, not affected by RESETSTAT
+var (
+	connectedClients atomic.Int64
+	serverStartTime  = time.Now()
+	serverRunID      = newRunID()
+)
+
+func resetStats() {
+	statTotalCommands.Store(0)
+	statTotalConnections.Store(0)
+	statKeyspaceHits.Store(0)
+	statKeyspaceMisses.Store(0)
+	statExpiredKeys.Store(0)
+	statRejectedConnections.Store(0)
+	statOutputLimitDisconnects.Store(0)
+}
+
+// newRunID returns a random 40 character hex identifier for this process
+func newRunID() string {
+	buf := make([]byte, 20)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -2,24 +2,59 @@ package main
 
 import (
 	"sync"
+	"time"
 )
 
-const NumDatabases = 16
+// NumDatabases is set by the "databases" config directive at startup
+var NumDatabases = 16
 
-var databases [NumDatabases]map[string]Entry
+var databases []map[string]Entry
 
 var db map[string]Entry
 
 func init() {
+	initDatabases()
+}
+
+// initDatabases (re)creates NumDatabases empty keyspaces
+func initDatabases() {
+	databases = make([]map[string]Entry, NumDatabases)
 	for i := 0; i < NumDatabases; i++ {
 		databases[i] = make(map[string]Entry)
 	}
 	db = databases[0]
+	usedMemory = 0
+	expiringKeys = make([]int, NumDatabases)
+	resetSlotKeys()
 }
 
+// usedMemory is the estimated size of all keyspaces, kept up to date by
+// storeEntry / removeEntry / flushAllDatabases. Guarded by mu.
 var usedMemory int64 = 0
+
+// expiringKeys counts the keys with an expiry in each database, kept up to
+// date the same way as usedMemory. Guarded by mu.
+var expiringKeys []int
+
 var lastAccess = map[string]int64{}
 
+// slotKeys indexes the keys of database 0 by hash slot in cluster mode,
+// for COUNTKEYSINSLOT and GETKEYSINSLOT. Guarded by mu.
+var slotKeys []map[string]struct{}
+
+// resetSlotKeys empties the slot index, or drops it outside cluster mode.
+// Caller holds mu.
+func resetSlotKeys() {
+	if !clusterEnabled {
+		slotKeys = nil
+		return
+	}
+	slotKeys = make([]map[string]struct{}, clusterSlots)
+	for i := range slotKeys {
+		slotKeys[i] = map[string]struct{}{}
+	}
+}
+
 var mu sync.RWMutex
 var aofMu sync.Mutex
 
@@ -40,14 +75,85 @@ type Entry struct {
 	Type     EntryType
 	Value    interface{}
 	ExpireAt int64
+
+	size int64 // accounted bytes, set by storeEntry
 }
 
-type ZSet struct {
-	Dict map[string]float64
-	List []ZItem
+// Rough per-key bookkeeping cost (map bucket, Entry header, interface box)
+const entryOverhead = 64
+
+// entrySize estimates how many bytes key and entry occupy
+func entrySize(key string, entry Entry) int64 {
+	size := int64(len(key)) + entryOverhead
+
+	switch v := entry.Value.(type) {
+	case string:
+		size += int64(len(v))
+	case Hash:
+		// kept up to date by the hash and the set themselves, summing
+		// them is O(n)
+		size += *v.size
+	case ZSet:
+		size += v.zsl.size
+	}
+
+	return size
 }
 
-type ZItem struct {
-	Member string
-	Score  float64
+// storeEntry writes entry and keeps usedMemory in sync. Caller holds mu.
+func storeEntry(dbIndex int, key string, entry Entry) {
+	if old, exists := databases[dbIndex][key]; exists {
+		usedMemory -= old.size
+		if old.ExpireAt != 0 {
+			expiringKeys[dbIndex]--
+		}
+	} else if dbIndex == 0 && slotKeys != nil {
+		slotKeys[keyHashSlot(key)][key] = struct{}{}
+	}
+	entry.size = entrySize(key, entry)
+	usedMemory += entry.size
+	if entry.ExpireAt != 0 {
+		expiringKeys[dbIndex]++
+	}
+	databases[dbIndex][key] = entry
+}
+
+// removeEntry deletes key and releases its accounted memory. Caller holds mu.
+func removeEntry(dbIndex int, key string) bool {
+	old, exists := databases[dbIndex][key]
+	if !exists {
+		return false
+	}
+	usedMemory -= old.size
+	if old.ExpireAt != 0 {
+		expiringKeys[dbIndex]--
+	}
+	delete(databases[dbIndex], key)
+	if dbIndex == 0 && slotKeys != nil {
+		delete(slotKeys[keyHashSlot(key)], key)
+	}
+	return true
+}
+
+// lookupEntryLocked is getEntry for callers that hold mu for writing,
+// so what they find can't change before they store it back
+func lookupEntryLocked(dbIndex int, key string) (Entry, bool) {
+	entry, exists := databases[dbIndex][key]
+	if exists && entry.ExpireAt != 0 && entry.ExpireAt <= time.Now().Unix() {
+		if removeEntry(dbIndex, key) {
+			statExpiredKeys.Add(1)
+		}
+		return Entry{}, false
+	}
+	return entry, exists
+}
+
+// flushAllDatabases empties every keyspace. Caller holds mu.
+func flushAllDatabases() {
+	for i := 0; i < NumDatabases; i++ {
+		databases[i] = make(map[string]Entry)
+		expiringKeys[i] = 0
+	}
+	usedMemory = 0
+	resetSlotKeys()
 }
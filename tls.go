@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// TLS settings. The plaintext port and tls-port can run side by side;
+// either one can be disabled with 0.
+var (
+	tlsPort        = 0
+	tlsCertFile    = ""
+	tlsKeyFile     = ""
+	tlsCACertFile  = ""
+	tlsAuthClients = newAtomicString("yes") // yes | no | optional
+)
+
+// tlsMaterial is the certificate, CA pool and client certificate policy
+// currently served. It is swapped as a whole on reload and on CONFIG SET
+// tls-auth-clients, so handshakes never see half of an update, nor a
+// policy that asks for client certificates without a CA to check them.
+type tlsMaterial struct {
+	cert        *tls.Certificate
+	caPool      *x509.CertPool // nil when no tls-ca-cert-file is configured
+	authClients string
+}
+
+var currentTLS atomic.Pointer[tlsMaterial]
+
+// loadTLSMaterial reads the configured certificate, key and CA files
+func loadTLSMaterial() (*tlsMaterial, error) {
+	if tlsCertFile == "" || tlsKeyFile == "" {
+		return nil, fmt.Errorf("tls-cert-file and tls-key-file must be set")
+	}
+
+	cert, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate: %v", err)
+	}
+
+	m := &tlsMaterial{cert: &cert, authClients: tlsAuthClients.Load()}
+	if tlsCACertFile != "" {
+		pem, err := os.ReadFile(tlsCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading CA certificates: %v", err)
+		}
+		m.caPool = x509.NewCertPool()
+		if !m.caPool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", tlsCACertFile)
+		}
+	}
+	if err := m.checkAuthClients(m.authClients); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// checkAuthClients tells whether m can serve the tls-auth-clients policy
+func (m *tlsMaterial) checkAuthClients(authClients string) error {
+	if authClients != "no" && m.caPool == nil {
+		return fmt.Errorf("tls-ca-cert-file is required when tls-auth-clients is '%s'", authClients)
+	}
+	return nil
+}
+
+// tlsAuthClientsConfig validates tls-auth-clients against the served CA
+// and swaps it in with the material. configMu orders it with reloads.
+func tlsAuthClientsConfig() *configParam {
+	values := []string{"yes", "no", "optional"}
+	return &configParam{
+		name: "tls-auth-clients",
+		get:  tlsAuthClients.Load,
+		set: func(value string) error {
+			value = strings.ToLower(value)
+			valid := false
+			for _, v := range values {
+				valid = valid || v == value
+			}
+			if !valid {
+				return fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(values, ", "))
+			}
+
+			if m := currentTLS.Load(); m != nil {
+				if err := m.checkAuthClients(value); err != nil {
+					return err
+				}
+				next := *m
+				next.authClients = value
+				currentTLS.Store(&next)
+			}
+			tlsAuthClients.Store(value)
+			return nil
+		},
+	}
+}
+
+// reloadTLS re-reads the certificate files. On error the previous
+// material stays in use.
+func reloadTLS() error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	m, err := loadTLSMaterial()
+	if err != nil {
+		return err
+	}
+	currentTLS.Store(m)
+	return nil
+}
+
+// tlsServerConfig builds each handshake's config from the current
+// material, so reloads and CONFIG SET apply to new connections without
+// restarting the listener.
+func tlsServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			m := currentTLS.Load()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*m.cert},
+				ClientCAs:    m.caPool,
+			}
+			switch m.authClients {
+			case "yes":
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			case "optional":
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			default:
+				cfg.ClientAuth = tls.NoClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// listenTLS loads the certificates and opens the TLS listener on addr
+func listenTLS(addr string) (net.Listener, error) {
+	if err := reloadTLS(); err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", addr, tlsServerConfig())
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a generated certificate with its key, also written to disk
+// as PEM files
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA
+// when parent is nil
+func newTestCert(t *testing.T, dir, name string, serial int64, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signerCert, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	writePEM(t, tc.certFile, "CERTIFICATE", der)
+	writePEM(t, tc.keyFile, "EC PRIVATE KEY", keyDER)
+	return tc
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (tc *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{tc.cert.Raw}, PrivateKey: tc.key}
+}
+
+// startTestTLSServer configures TLS with a fresh CA and server certificate
+// and runs the accept loop on a random loopback port
+func startTestTLSServer(t *testing.T, authClients string) (addr string, ca *testCert, dir string) {
+	t.Helper()
+	resetKeyspace()
+
+	dir = t.TempDir()
+	ca = newTestCert(t, dir, "ca", 1, nil)
+	server := newTestCert(t, dir, "server", 2, ca)
+
+	old := []string{tlsCertFile, tlsKeyFile, tlsCACertFile, tlsAuthClients.Load()}
+	t.Cleanup(func() {
+		tlsCertFile, tlsKeyFile, tlsCACertFile = old[0], old[1], old[2]
+		tlsAuthClients.Store(old[3])
+		currentTLS.Store(nil)
+	})
+	tlsCertFile, tlsKeyFile, tlsCACertFile = server.certFile, server.keyFile, ca.certFile
+	tlsAuthClients.Store(authClients)
+
+	listener, err := listenTLS("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listenTLS: %v", err)
+	}
+	go serve(listener)
+	t.Cleanup(func() { listener.Close() })
+
+	return listener.Addr().String(), ca, dir
+}
+
+// dialTestTLSClient connects over TLS, trusting ca and presenting
+// clientCert when it isn't nil
+func dialTestTLSClient(t *testing.T, addr string, ca *testCert, clientCert *testCert) (*testClient, error) {
+	t.Helper()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	cfg := &tls.Config{RootCAs: pool}
+	if clientCert != nil {
+		cfg.Certificates = []tls.Certificate{clientCert.tlsCertificate()}
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 2 * time.Second}, "tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func TestTLSRoundTrip(t *testing.T) {
+	addr, ca, _ := startTestTLSServer(t, "no")
+
+	c, err := dialTestTLSClient(t, addr, ca, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if got := c.do("SET", "k", "over tls"); got != "+OK\r\n" {
+		t.Fatalf("SET: %q", got)
+	}
+	if got := c.do("GET", "k"); got != "$8\r\nover tls\r\n" {
+		t.Fatalf("GET: %q", got)
+	}
+}
+
+func TestTLSPlaintextSideBySide(t *testing.T) {
+	tlsAddr, ca, _ := startTestTLSServer(t, "no")
+	plain := dialTestClient(t, startTestServer(t))
+
+	secure, err := dialTestTLSClient(t, tlsAddr, ca, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	plain.do("SET", "shared", "v")
+	if got := secure.do("GET", "shared"); got != "$1\r\nv\r\n" {
+		t.Fatalf("TLS client doesn't see plaintext write: %q", got)
+	}
+}
+
+func TestTLSClientCertificates(t *testing.T) {
+	addr, ca, dir := startTestTLSServer(t, "yes")
+	client := newTestCert(t, dir, "client", 3, ca)
+
+	// Without a certificate the handshake fails; with TLS 1.3 that can
+	// surface on the first read rather than on dial
+	if c, err := dialTestTLSClient(t, addr, ca, nil); err == nil {
+		c.send("PING")
+		if _, err := readReply(c.reader); err == nil {
+			t.Fatal("client without certificate was accepted")
+		}
+	}
+
+	// A certificate from another CA is rejected too
+	other := newTestCert(t, t.TempDir(), "other-ca", 4, nil)
+	stranger := newTestCert(t, dir, "stranger", 5, other)
+	if c, err := dialTestTLSClient(t, addr, ca, stranger); err == nil {
+		c.send("PING")
+		if _, err := readReply(c.reader); err == nil {
+			t.Fatal("client with untrusted certificate was accepted")
+		}
+	}
+
+	c, err := dialTestTLSClient(t, addr, ca, client)
+	if err != nil {
+		t.Fatalf("dial with certificate: %v", err)
+	}
+	if got := c.do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("PING: %q", got)
+	}
+
+	// optional lets clients without a certificate in
+	if err := configByName["tls-auth-clients"].set("optional"); err != nil {
+		t.Fatalf("CONFIG SET tls-auth-clients optional: %v", err)
+	}
+	c, err = dialTestTLSClient(t, addr, ca, nil)
+	if err != nil {
+		t.Fatalf("dial with optional: %v", err)
+	}
+	if got := c.do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("PING with optional: %q", got)
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	addr, ca, dir := startTestTLSServer(t, "no")
+
+	serial := func() int64 {
+		c, err := dialTestTLSClient(t, addr, ca, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		c.do("PING")
+		return c.conn.(*tls.Conn).ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	if got := serial(); got != 2 {
+		t.Fatalf("serial before reload: %d", got)
+	}
+
+	// Rotate the files in place, as a certificate renewal would
+	rotated := newTestCert(t, dir, "server", 42, ca)
+	if rotated.certFile != tlsCertFile {
+		t.Fatalf("rotated certificate written to %s, want %s", rotated.certFile, tlsCertFile)
+	}
+	if got := serial(); got != 2 {
+		t.Fatalf("certificate changed before reload: %d", got)
+	}
+	if err := reloadTLS(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := serial(); got != 42 {
+		t.Fatalf("serial after reload: %d", got)
+	}
+
+	// A broken file doesn't replace the working certificate
+	os.WriteFile(tlsKeyFile, []byte("garbage"), 0600)
+	if err := reloadTLS(); err == nil {
+		t.Fatal("reload of a broken key succeeded")
+	}
+	if got := serial(); got != 42 {
+		t.Fatalf("serial after failed reload: %d", got)
+	}
+}
+
+func TestTLSConfigValidation(t *testing.T) {
+	old := []string{tlsCertFile, tlsKeyFile, tlsCACertFile, tlsAuthClients.Load()}
+	t.Cleanup(func() {
+		tlsCertFile, tlsKeyFile, tlsCACertFile = old[0], old[1], old[2]
+		tlsAuthClients.Store(old[3])
+	})
+
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", 1, nil)
+	server := newTestCert(t, dir, "server", 2, ca)
+
+	tlsCertFile, tlsKeyFile, tlsCACertFile = "", "", ""
+	if _, err := loadTLSMaterial(); err == nil {
+		t.Fatal("missing certificate accepted")
+	}
+
+	tlsCertFile, tlsKeyFile = server.certFile, server.keyFile
+	tlsAuthClients.Store("yes")
+	if _, err := loadTLSMaterial(); err == nil {
+		t.Fatal("tls-auth-clients yes without a CA accepted")
+	}
+
+	tlsAuthClients.Store("no")
+	m, err := loadTLSMaterial()
+	if err != nil {
+		t.Fatalf("server certificate only: %v", err)
+	}
+
+	// Without a CA, CONFIG SET can't start asking for client certificates
+	currentTLS.Store(m)
+	t.Cleanup(func() { currentTLS.Store(nil) })
+	param := configByName["tls-auth-clients"]
+	if err := param.set("yes"); err == nil {
+		t.Fatal("CONFIG SET tls-auth-clients yes without a CA accepted")
+	}
+	if param.get() != "no" || currentTLS.Load().authClients != "no" {
+		t.Fatalf("refused CONFIG SET changed tls-auth-clients to %q", param.get())
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// Unix socket settings. The socket is served in addition to the TCP
+// ports; set port 0 to serve it alone.
+var (
+	unixSocket     = ""
+	unixSocketPerm = 0 // file mode of the socket, 0 keeps the umask default
+)
+
+// listenUnix opens the unix socket listener at path. A stale socket left
+// behind by a previous run is removed first.
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixSocket(t *testing.T) {
+	resetKeyspace()
+	path := filepath.Join(t.TempDir(), "redis.sock")
+
+	// A stale socket from a previous run doesn't block startup
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := listenUnix(path, 0700)
+	if err != nil {
+		t.Fatalf("listenUnix: %v", err)
+	}
+	go serve(listener)
+	t.Cleanup(func() { listener.Close() })
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0700 {
+		t.Fatalf("socket permissions: %o", perm)
+	}
+
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	c := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	if got := c.do("SET", "k", "via socket"); got != "+OK\r\n" {
+		t.Fatalf("SET: %q", got)
+	}
+	if got := c.do("GET", "k"); got != "$10\r\nvia socket\r\n" {
+		t.Fatalf("GET: %q", got)
+	}
+}
+
+func TestUnixSocketRefusesRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not-a-socket")
+	os.WriteFile(path, []byte("keep me"), 0600)
+
+	if _, err := listenUnix(path, 0); err == nil {
+		t.Fatal("listenUnix replaced a regular file")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "keep me" {
+		t.Fatalf("file was modified: %q", data)
+	}
+}
+
+func TestUnixSocketPermConfig(t *testing.T) {
+	saveConfig(t)
+
+	p := configByName["unixsocketperm"]
+	if err := p.set("770"); err != nil {
+		t.Fatal(err)
+	}
+	if unixSocketPerm != 0770 || p.get() != "770" {
+		t.Fatalf("unixsocketperm: %o / %q", unixSocketPerm, p.get())
+	}
+	for _, bad := range []string{"800", "abc", "1777"} {
+		if err := p.set(bad); err == nil {
+			t.Fatalf("accepted %q", bad)
+		}
+	}
+}
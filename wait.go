@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// waitersWake is closed, and replaced, whenever a replica acknowledges an
+// offset or the AOF is fsynced, waking every WAIT and WAITAOF to recheck
+var (
+	waitersMu   sync.Mutex
+	waitersWake = make(chan struct{})
+)
+
+func notifyWaiters() {
+	waitersMu.Lock()
+	close(waitersWake)
+	waitersWake = make(chan struct{})
+	waitersMu.Unlock()
+}
+
+func waitersChannel() <-chan struct{} {
+	waitersMu.Lock()
+	defer waitersMu.Unlock()
+	return waitersWake
+}
+
+// recordWriteOffsets remembers where the client's latest write ended in
+// the replication stream and in the AOF, which is what WAIT and WAITAOF
+// wait for
+func recordWriteOffsets(c *Client) {
+	replMu.Lock()
+	c.woff = replOffset
+	replMu.Unlock()
+	c.aofWoff, _ = aofOffsets()
+}
+
+// countReplicaAcks returns how many replicas have acknowledged offset,
+// or fsynced it to their AOF when fsynced is set
+func countReplicaAcks(offset int64, fsynced bool) int {
+	replMu.Lock()
+	defer replMu.Unlock()
+
+	n := 0
+	for _, f := range replicas {
+		acked := f.ackOffset.Load()
+		if fsynced {
+			acked = f.ackAOFOffset.Load()
+		}
+		if acked >= offset {
+			n++
+		}
+	}
+	return n
+}
+
+// requestAcks asks every replica for its offset right away rather than
+// at its next periodic ACK
+func requestAcks() {
+	propagateMu.Lock()
+	defer propagateMu.Unlock()
+	replMu.Lock()
+	defer replMu.Unlock()
+
+	if len(replicas) > 0 {
+		replFeedLocked([]byte(buildRESPCommand("REPLCONF", []string{"GETACK", "*"})))
+	}
+}
+
+// parseWaitTimeout reads a timeout in milliseconds, 0 meaning forever
+func parseWaitTimeout(arg string) (time.Duration, string) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, "-ERR timeout is not an integer or out of range\r\n"
+	}
+	if ms < 0 {
+		return 0, "-ERR timeout is negative\r\n"
+	}
+	return time.Duration(ms) * time.Millisecond, ""
+}
+
+// waitFor blocks until done returns true or timeout passes, rechecking
+// whenever waiters are notified
+func waitFor(timeout time.Duration, done func() bool) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		wake := waitersChannel()
+		if done() {
+			return
+		}
+		select {
+		case <-wake:
+		case <-expired:
+			return
+		}
+	}
+}
+
+// WAIT numreplicas timeout
+func cmdWAIT(c *Client, args []string) (string, error) {
+	if isReplica() {
+		return "-ERR WAIT cannot be used with replica instances.\r\n", fmt.Errorf("replica")
+	}
+	numReplicas, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "-ERR value is not an integer or out of range\r\n", fmt.Errorf("not an integer")
+	}
+	timeout, errReply := parseWaitTimeout(args[2])
+	if errReply != "" {
+		return errReply, fmt.Errorf("bad timeout")
+	}
+
+	acked := countReplicaAcks(c.woff, false)
+	if acked < numReplicas {
+		requestAcks()
+		waitFor(timeout, func() bool {
+			acked = countReplicaAcks(c.woff, false)
+			return acked >= numReplicas
+		})
+	}
+	return ":" + strconv.Itoa(acked) + "\r\n", nil
+}
+
+// WAITAOF numlocal numreplicas timeout replies with how many of the local
+// AOF and the replicas' AOFs hold the client's latest write on disk
+func cmdWAITAOF(c *Client, args []string) (string, error) {
+	if isReplica() {
+		return "-ERR WAITAOF cannot be used with replica instances.\r\n", fmt.Errorf("replica")
+	}
+	numLocal, err1 := strconv.Atoi(args[1])
+	numReplicas, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		return "-ERR value is not an integer or out of range\r\n", fmt.Errorf("not an integer")
+	}
+	timeout, errReply := parseWaitTimeout(args[3])
+	if errReply != "" {
+		return errReply, fmt.Errorf("bad timeout")
+	}
+	if numLocal > 0 && !appendOnly {
+		return "-ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.\r\n", fmt.Errorf("aof disabled")
+	}
+
+	local := func() int {
+		if !appendOnly {
+			return 0
+		}
+		if _, fsynced := aofOffsets(); fsynced >= c.aofWoff {
+			return 1
+		}
+		return 0
+	}
+
+	// The local fsync doesn't wait for the next background one
+	if numLocal > 0 && local() == 0 {
+		FlushAOF()
+	}
+
+	gotLocal, acked := local(), countReplicaAcks(c.woff, true)
+	if gotLocal < numLocal || acked < numReplicas {
+		if numReplicas > 0 {
+			requestAcks()
+		}
+		waitFor(timeout, func() bool {
+			gotLocal, acked = local(), countReplicaAcks(c.woff, true)
+			return gotLocal >= numLocal && acked >= numReplicas
+		})
+	}
+	return "*2\r\n:" + strconv.Itoa(gotLocal) + "\r\n:" + strconv.Itoa(acked) + "\r\n", nil
+}
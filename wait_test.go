@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitForReplicaAck(t *testing.T) {
+	resetReplication(t)
+	addr := startTestServer(t)
+	c := dialTestClient(t, addr)
+
+	// With nobody to acknowledge, WAIT runs into its timeout
+	start := time.Now()
+	if got := c.do("WAIT", "1", "50"); got != ":0\r\n" {
+		t.Fatalf("WAIT without replicas: %q", got)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("WAIT returned before its timeout")
+	}
+
+	replica := dialTestClient(t, addr)
+	replica.send("PSYNC", "?", "-1")
+	header, _ := replica.reader.ReadString('\n')
+	offset, _ := strconv.ParseInt(strings.Fields(header)[2], 10, 64)
+	size, _ := replica.reader.ReadString('\n')
+	n, _ := strconv.Atoi(strings.TrimSpace(size[1:]))
+	if _, err := io.ReadFull(replica.reader, make([]byte, n)); err != nil {
+		t.Fatal(err)
+	}
+
+	c.do("SET", "k", "v")
+	c.send("WAIT", "1", "0")
+
+	// WAIT asks for an ACK; the GETACK itself isn't part of the answer
+	for {
+		args, err := parseResp(replica.reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.EqualFold(args[0], "REPLCONF") {
+			break
+		}
+		offset += int64(len(buildRESPCommand(args[0], args[1:])))
+	}
+	replica.send("REPLCONF", "ACK", strconv.FormatInt(offset, 10), "FACK", "0")
+	if got := c.read(); got != ":1\r\n" {
+		t.Fatalf("WAIT after the ACK: %q", got)
+	}
+
+	// The replica hasn't fsynced anything
+	if got := c.do("WAITAOF", "0", "1", "50"); !strings.HasSuffix(got, "\r\n:0\r\n") {
+		t.Fatalf("WAITAOF without replica fsync: %q", got)
+	}
+
+	if got := c.do("WAIT", "1", "-1"); got != "-ERR timeout is negative\r\n" {
+		t.Errorf("negative timeout: %q", got)
+	}
+}
+
+func TestWaitAOFLocalFsync(t *testing.T) {
+	oldFile := AOFFileName
+	AOFFileName = filepath.Join(t.TempDir(), "appendonly.aof")
+	if err := InitAOF(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		CloseAOF()
+		AOFFileName = oldFile
+	})
+
+	c := dialTestClient(t, startTestServer(t))
+	c.do("SET", "k", "v")
+	if written, fsynced := aofOffsets(); fsynced >= written {
+		t.Fatalf("the write is already fsynced (%d/%d), nothing to test", fsynced, written)
+	}
+	if got := c.do("WAITAOF", "1", "0", "0"); got != "*2\r\n:1\r\n:0\r\n" {
+		t.Fatalf("WAITAOF: %q", got)
+	}
+	if written, fsynced := aofOffsets(); fsynced != written {
+		t.Fatalf("WAITAOF returned before the fsync: %d/%d", fsynced, written)
+	}
+
+	appendOnly = false
+	defer func() { appendOnly = true }()
+	if got := c.do("WAITAOF", "1", "0", "0"); got != "-ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.\r\n" {
+		t.Fatalf("WAITAOF without AOF: %q", got)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import "math/rand/v2"
+
+// A sorted set keeps every member twice: in Dict for score lookups, and in
+// a skiplist ordered by score, then member, for ranges and ranks. Each
+// link of the skiplist records how many nodes it jumps over, so the rank
+// of a node is the sum of the spans followed to reach it. Adds, removals
+// and rank queries are O(log n).
+//
+// ZSet is stored by value in an Entry but, like a map, its copies share
+// the same members. It is changed in place with mu held, and read with
+// mu read-locked.
+type ZSet struct {
+	Dict map[string]float64
+	zsl  *zskiplist
+}
+
+type ZItem struct {
+	Member string
+	Score  float64
+}
+
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25 // chance of a node reaching the next level
+)
+
+type zskiplistNode struct {
+	ZItem
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int // nodes between this one and forward, forward included
+}
+
+type zskiplist struct {
+	header *zskiplistNode // holds no item, starts every level
+	tail   *zskiplistNode
+	length int
+	level  int
+	size   int64 // accounted bytes of the members, see zsetMemberSize
+}
+
+// zsetMemberSize estimates what a member costs: its dict slot and its
+// skiplist node
+func zsetMemberSize(member string) int64 {
+	return int64(2*len(member)) + 80
+}
+
+func newZSet() ZSet {
+	return ZSet{
+		Dict: make(map[string]float64),
+		zsl: &zskiplist{
+			header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)},
+			level:  1,
+		},
+	}
+}
+
+// less orders items by score, ties by member
+func (a ZItem) less(b ZItem) bool {
+	return a.Score < b.Score || (a.Score == b.Score && a.Member < b.Member)
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+func (zsl *zskiplist) insert(item ZItem) {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+
+	// Find the last node before item on every level, and its rank
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.less(item) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &zskiplistNode{ZItem: item, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+
+		// rank[0]-rank[i] nodes lie between update[i] and the new node
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	// Levels above the new node now jump over one more
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+}
+
+// delete unlinks item, which must be in the list
+func (zsl *zskiplist) delete(item ZItem) {
+	var update [zskiplistMaxLevel]*zskiplistNode
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(item) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+// rank returns the 0-based position of item, which must be in the list
+func (zsl *zskiplist) rank(item ZItem) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !item.less(x.level[i].forward.ZItem) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.Member == item.Member {
+			return rank - 1
+		}
+	}
+	return -1
+}
+
+// byRank returns the node at 0-based position rank, nil when out of range
+func (zsl *zskiplist) byRank(rank int) *zskiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank+1 {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstFrom returns the first node with a score of at least min
+func (zsl *zskiplist) firstFrom(min float64) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.Score < min {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+// Len is the number of members
+func (z ZSet) Len() int {
+	return len(z.Dict)
+}
+
+// Add sets the score of member, reporting whether it is a new one
+func (z ZSet) Add(member string, score float64) bool {
+	old, exists := z.Dict[member]
+	if exists {
+		if old == score {
+			return false
+		}
+		z.zsl.delete(ZItem{Member: member, Score: old})
+	} else {
+		z.zsl.size += zsetMemberSize(member)
+	}
+	z.Dict[member] = score
+	z.zsl.insert(ZItem{Member: member, Score: score})
+	return !exists
+}
+
+// Remove deletes member, reporting whether it was there
+func (z ZSet) Remove(member string) bool {
+	score, exists := z.Dict[member]
+	if !exists {
+		return false
+	}
+	delete(z.Dict, member)
+	z.zsl.delete(ZItem{Member: member, Score: score})
+	z.zsl.size -= zsetMemberSize(member)
+	return true
+}
+
+// Rank returns the 0-based position of member, lowest score first
+func (z ZSet) Rank(member string) (int, bool) {
+	score, exists := z.Dict[member]
+	if !exists {
+		return 0, false
+	}
+	return z.zsl.rank(ZItem{Member: member, Score: score}), true
+}
+
+// Range returns the items from position start to end, both included
+// and within bounds
+func (z ZSet) Range(start, end int) []ZItem {
+	if start > end {
+		return nil
+	}
+	items := make([]ZItem, 0, end-start+1)
+	for x := z.zsl.byRank(start); x != nil && len(items) < cap(items); x = x.level[0].forward {
+		items = append(items, x.ZItem)
+	}
+	return items
+}
+
+// RangeByScore returns the items scored between min and max, both included
+func (z ZSet) RangeByScore(min, max float64) []ZItem {
+	var items []ZItem
+	for x := z.zsl.firstFrom(min); x != nil && x.Score <= max; x = x.level[0].forward {
+		items = append(items, x.ZItem)
+	}
+	return items
+}
+
+// Items returns every item in order
+func (z ZSet) Items() []ZItem {
+	return z.Range(0, z.Len()-1)
+}
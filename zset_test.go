@@ -0,0 +1,215 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// checkZSet compares z against want, sorted the way a sorted set is, and
+// checks the spans of every level add up to the ranks they claim
+func checkZSet(t *testing.T, z ZSet, want map[string]float64) {
+	t.Helper()
+	items := make([]ZItem, 0, len(want))
+	for member, score := range want {
+		items = append(items, ZItem{Member: member, Score: score})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].less(items[j]) })
+
+	if z.Len() != len(items) || z.zsl.length != len(items) {
+		t.Fatalf("length %d/%d, want %d", z.Len(), z.zsl.length, len(items))
+	}
+	got := z.Items()
+	for i, item := range items {
+		if got[i] != item {
+			t.Fatalf("item %d is %+v, want %+v", i, got[i], item)
+		}
+		if rank, ok := z.Rank(item.Member); !ok || rank != i {
+			t.Fatalf("rank of %s is %d, want %d", item.Member, rank, i)
+		}
+	}
+	for level := 0; level < z.zsl.level; level++ {
+		rank := 0
+		for x := z.zsl.header; x.level[level].forward != nil; x = x.level[level].forward {
+			rank += x.level[level].span
+			if x.level[level].forward.Member != items[rank-1].Member {
+				t.Fatalf("level %d: span leads to rank %d, %s is not there", level, rank-1, x.level[level].forward.Member)
+			}
+		}
+	}
+	var size int64
+	for member := range want {
+		size += zsetMemberSize(member)
+	}
+	if z.zsl.size != size {
+		t.Fatalf("size %d, want %d", z.zsl.size, size)
+	}
+	if len(items) > 0 && z.zsl.tail.ZItem != items[len(items)-1] {
+		t.Fatalf("tail is %+v", z.zsl.tail.ZItem)
+	}
+}
+
+func TestZSetMatchesSortedSlice(t *testing.T) {
+	z := newZSet()
+	want := map[string]float64{}
+
+	for i := 0; i < 5000; i++ {
+		member := "m" + strconv.Itoa(rand.N(500))
+		// few distinct scores, so ties are common
+		score := float64(rand.N(20))
+		if rand.N(3) == 0 {
+			_, exists := want[member]
+			if z.Remove(member) != exists {
+				t.Fatalf("Remove(%s) disagrees on existence", member)
+			}
+			delete(want, member)
+		} else {
+			_, exists := want[member]
+			if z.Add(member, score) == exists {
+				t.Fatalf("Add(%s) disagrees on existence", member)
+			}
+			want[member] = score
+		}
+		if i%250 == 0 {
+			checkZSet(t, z, want)
+		}
+	}
+	checkZSet(t, z, want)
+
+	for _, member := range sortedKeys(want) {
+		z.Remove(member)
+	}
+	checkZSet(t, z, map[string]float64{})
+	if z.zsl.level != 1 {
+		t.Fatalf("empty list kept %d levels", z.zsl.level)
+	}
+}
+
+func TestZSetRanges(t *testing.T) {
+	z := newZSet()
+	for i := 0; i < 10; i++ {
+		z.Add("m"+strconv.Itoa(i), float64(i))
+	}
+
+	members := func(items []ZItem) string {
+		s := ""
+		for _, item := range items {
+			s += item.Member
+		}
+		return s
+	}
+	if got := members(z.Range(3, 5)); got != "m3m4m5" {
+		t.Errorf("Range(3, 5) = %s", got)
+	}
+	if got := members(z.Range(8, 9)); got != "m8m9" {
+		t.Errorf("Range(8, 9) = %s", got)
+	}
+	if got := z.Range(5, 4); len(got) != 0 {
+		t.Errorf("Range(5, 4) = %v", got)
+	}
+	if got := members(z.RangeByScore(2.5, 4)); got != "m3m4" {
+		t.Errorf("RangeByScore(2.5, 4) = %s", got)
+	}
+	if got := z.RangeByScore(10, 20); len(got) != 0 {
+		t.Errorf("RangeByScore(10, 20) = %v", got)
+	}
+}
+
+// zsetSizes are the sorted set sizes benchmarks run at: with O(log n)
+// operations the time per operation barely moves between them
+var zsetSizes = []int{1_000, 100_000, 1_000_000}
+
+func filledZSet(n int) ZSet {
+	z := newZSet()
+	for i := 0; i < n; i++ {
+		z.Add("member:"+strconv.Itoa(i), float64(rand.N(n)))
+	}
+	return z
+}
+
+func BenchmarkZSetAdd(b *testing.B) {
+	for _, n := range zsetSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			z := filledZSet(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// rescores an existing member: a removal and an insertion
+				z.Add("member:"+strconv.Itoa(i%n), float64(rand.N(n)))
+			}
+		})
+	}
+}
+
+func BenchmarkZSetRemove(b *testing.B) {
+	for _, n := range zsetSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			z := filledZSet(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				member := "member:" + strconv.Itoa(i%n)
+				score := z.Dict[member]
+				z.Remove(member)
+				z.Add(member, score)
+			}
+		})
+	}
+}
+
+func BenchmarkZSetRank(b *testing.B) {
+	for _, n := range zsetSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			z := filledZSet(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				z.Rank("member:" + strconv.Itoa(i%n))
+			}
+		})
+	}
+}
+
+// BenchmarkSortedSliceAdd is how ZADD used to work, for comparison: the
+// member is removed by a scan, appended and the whole slice sorted again
+func BenchmarkSortedSliceAdd(b *testing.B) {
+	for _, n := range zsetSizes[:2] {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			list := filledZSet(n).Items()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				member := "member:" + strconv.Itoa(i%n)
+				for j, item := range list {
+					if item.Member == member {
+						list = append(list[:j], list[j+1:]...)
+						break
+					}
+				}
+				list = append(list, ZItem{Member: member, Score: float64(rand.N(n))})
+				sort.Slice(list, func(i, j int) bool { return list[i].Score < list[j].Score })
+			}
+		})
+	}
+}
+
+// Run with -race: readers walk a set while writers change it
+func TestZSetConcurrentAccess(t *testing.T) {
+	resetKeyspace()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		selectedDB := 0
+		for i := 0; i < 500; i++ {
+			execCommand(cmd("ZADD z "+strconv.Itoa(i)+" m"+strconv.Itoa(i%50)), &selectedDB)
+			execCommand(cmd("ZREM z m"+strconv.Itoa((i+25)%50)), &selectedDB)
+		}
+	}()
+
+	selectedDB := 0
+	for i := 0; i < 500; i++ {
+		for _, line := range []string{"ZRANGE z 0 -1", "ZRANGEBYSCORE z 0 1000", "ZRANK z m1", "ZSCORE z m1", "ZCARD z"} {
+			if got, err := execCommand(cmd(line), &selectedDB); err != nil {
+				t.Fatalf("%s: %q", line, got)
+			}
+		}
+	}
+	<-done
+}